@@ -29,8 +29,19 @@ import (
 const (
 	// CheckpointPath is the location of the file containing the log checkpoint.
 	CheckpointPath = "checkpoint"
+
+	// CheckpointArchiveIndexPath is the location of the file listing the sizes
+	// of the checkpoints held in the log's checkpoint archive, if it has one.
+	// Sizes are listed in increasing order, one per line, in hex.
+	CheckpointArchiveIndexPath = "checkpoints/index"
 )
 
+// CheckpointArchivePath returns the location of the archived checkpoint for
+// the given tree size.
+func CheckpointArchivePath(size uint64) string {
+	return fmt.Sprintf("checkpoints/%016x", size)
+}
+
 // SeqPath builds the directory path and relative filename for the entry at the given
 // sequence number.
 func SeqPath(root string, seq uint64) (string, string) {
@@ -47,6 +58,30 @@ func SeqPath(root string, seq uint64) (string, string) {
 	return d, frag[6]
 }
 
+// BundlePath returns the path, relative to the root of the log, of the leaf
+// bundle which holds the leaf at index in a tree of size logSize, where each
+// bundle holds bundleSize leaves. Logs which don't bundle leaves have a
+// bundleSize of 1.
+//
+// The final bundle of a tree whose size isn't a multiple of bundleSize is
+// partial, and its path is suffixed with the number of leaves it holds.
+func BundlePath(index, bundleSize, logSize uint64) string {
+	bi := index / bundleSize
+	p := filepath.Join(SeqPath("", bi))
+	if bi == logSize/bundleSize {
+		if n := logSize % bundleSize; n > 0 {
+			p += fmt.Sprintf(".%d", n)
+		}
+	}
+	return p
+}
+
+// LeafOffsetInBundle returns the position of the leaf at index within the leaf
+// bundle which holds it, where each bundle holds bundleSize leaves.
+func LeafOffsetInBundle(index, bundleSize uint64) uint64 {
+	return index % bundleSize
+}
+
 // SeqFromPath recovers a sequence number from the specified path.
 // The path must have been generated with the SeqPath method in this package.
 func SeqFromPath(root, seqPath string) (uint64, error) {
@@ -83,7 +118,8 @@ func LeafPath(root string, leafhash []byte) (string, string) {
 // TilePath builds the directory path and relative filename for the subtree tile with the
 // given level and index.
 // partialTileSize should be set to a non-zero number if the path to a partial tile
-// is required.
+// is required. It's encoded in hex with at least two digits, so the partial
+// tiles of logs with tiles wider than TileWidth have longer suffixes.
 func TilePath(root string, level, index, partialTileSize uint64) (string, string) {
 	suffix := ""
 	if partialTileSize > 0 {
@@ -199,6 +199,14 @@ func TestTilePath(t *testing.T) {
 			index:    0x455667,
 			wantDir:  "/a/different/root/path/tile/15/0000/45/56",
 			wantFile: "67",
+		}, {
+			// Partial tiles of logs with wider tiles.
+			root:     "/root/path",
+			level:    0,
+			index:    0x1,
+			tileSize: 0x1ff,
+			wantDir:  "/root/path/tile/00/0000/00/00",
+			wantFile: "01.1ff",
 		},
 	} {
 		desc := fmt.Sprintf("root %q level %x index %x", test.root, test.level, test.index)
@@ -213,3 +221,60 @@ func TestTilePath(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckpointArchivePath(t *testing.T) {
+	for _, test := range []struct {
+		size uint64
+		want string
+	}{
+		{size: 0, want: "checkpoints/0000000000000000"},
+		{size: 0x1234, want: "checkpoints/0000000000001234"},
+		{size: 0xffffffffffffffff, want: "checkpoints/ffffffffffffffff"},
+	} {
+		if got := CheckpointArchivePath(test.size); got != test.want {
+			t.Errorf("CheckpointArchivePath(%d) = %q, want %q", test.size, got, test.want)
+		}
+	}
+}
+
+func TestBundlePath(t *testing.T) {
+	for _, test := range []struct {
+		index, bundleSize, logSize uint64
+		want                       string
+	}{
+		{index: 0, bundleSize: 1, logSize: 1, want: "seq/00/00/00/00/00"},
+		{index: 0x105, bundleSize: 1, logSize: 0x200, want: "seq/00/00/00/01/05"},
+		{index: 0, bundleSize: 256, logSize: 256, want: "seq/00/00/00/00/00"},
+		{index: 255, bundleSize: 256, logSize: 300, want: "seq/00/00/00/00/00"},
+		{index: 256, bundleSize: 256, logSize: 300, want: "seq/00/00/00/00/01.44"},
+		{index: 299, bundleSize: 256, logSize: 300, want: "seq/00/00/00/00/01.44"},
+		{index: 256, bundleSize: 256, logSize: 512, want: "seq/00/00/00/00/01"},
+		{index: 10, bundleSize: 16, logSize: 15, want: "seq/00/00/00/00/00.15"},
+		{index: 33, bundleSize: 16, logSize: 1000, want: "seq/00/00/00/00/02"},
+	} {
+		t.Run(fmt.Sprintf("index %d bundle %d size %d", test.index, test.bundleSize, test.logSize), func(t *testing.T) {
+			if got := BundlePath(test.index, test.bundleSize, test.logSize); got != test.want {
+				t.Errorf("BundlePath = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLeafOffsetInBundle(t *testing.T) {
+	for _, test := range []struct {
+		index, bundleSize uint64
+		want              uint64
+	}{
+		{index: 0, bundleSize: 1, want: 0},
+		{index: 7, bundleSize: 1, want: 0},
+		{index: 7, bundleSize: 16, want: 7},
+		{index: 16, bundleSize: 16, want: 0},
+		{index: 300, bundleSize: 256, want: 44},
+	} {
+		t.Run(fmt.Sprintf("index %d bundle %d", test.index, test.bundleSize), func(t *testing.T) {
+			if got := LeafOffsetInBundle(test.index, test.bundleSize); got != test.want {
+				t.Errorf("LeafOffsetInBundle = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package layout
+
+// Scheme describes how the objects which make up a log are named.
+//
+// Storage implementations and clients can be parameterised with a Scheme in
+// order to work with logs whose objects are laid out differently to the
+// default scheme provided by this package.
+type Scheme interface {
+	// TilePath returns the directory path and relative filename for the subtree
+	// tile with the given level and index.
+	// partialTileSize should be set to a non-zero number if the path to a partial
+	// tile is required.
+	TilePath(root string, level, index, partialTileSize uint64) (string, string)
+
+	// SeqPath returns the directory path and relative filename for the entry at
+	// the given sequence number.
+	SeqPath(root string, seq uint64) (string, string)
+
+	// LeafPath returns the directory path and relative filename for the entry
+	// data with the given leafhash.
+	LeafPath(root string, leafhash []byte) (string, string)
+
+	// CheckpointPath returns the location of the log checkpoint.
+	CheckpointPath() string
+}
+
+// DefaultScheme is the Scheme implemented by the functions in this package.
+var DefaultScheme Scheme = defaultScheme{}
+
+type defaultScheme struct{}
+
+func (defaultScheme) TilePath(root string, level, index, partialTileSize uint64) (string, string) {
+	return TilePath(root, level, index, partialTileSize)
+}
+
+func (defaultScheme) SeqPath(root string, seq uint64) (string, string) {
+	return SeqPath(root, seq)
+}
+
+func (defaultScheme) LeafPath(root string, leafhash []byte) (string, string) {
+	return LeafPath(root, leafhash)
+}
+
+func (defaultScheme) CheckpointPath() string {
+	return CheckpointPath
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package layout
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultScheme(t *testing.T) {
+	s := DefaultScheme
+	for _, test := range []struct {
+		desc string
+		got  string
+		want string
+	}{
+		{
+			desc: "tile",
+			got:  filepath.Join(s.TilePath("/root", 1, 0x1234, 0x12)),
+			want: "/root/tile/01/0000/00/12/34.12",
+		}, {
+			desc: "seq",
+			got:  filepath.Join(s.SeqPath("/root", 0x1234)),
+			want: "/root/seq/00/00/00/12/34",
+		}, {
+			desc: "leaf",
+			got:  filepath.Join(s.LeafPath("/root", []byte{0x12, 0x34, 0x56, 0x78, 0x9a})),
+			want: "/root/leaves/12/34/56/789a",
+		}, {
+			desc: "checkpoint",
+			got:  s.CheckpointPath(),
+			want: "checkpoint",
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if test.got != test.want {
+				t.Errorf("got %q, want %q", test.got, test.want)
+			}
+		})
+	}
+}
@@ -14,24 +14,117 @@
 
 package layout
 
+import (
+	"fmt"
+	"math/bits"
+)
+
+// TileWidth is the number of leaves covered by a level-0 tile, and the
+// number of nodes covered by a tile at any other level.
+// This is the default width; logs may be configured with wider tiles, see
+// ValidateTileWidth.
+const TileWidth = 256
+
+// ValidateTileWidth returns an error unless width is a valid tile width, i.e.
+// a power of two which is at least 2. Each tile of such a width covers
+// log2(width) levels of the tree.
+func ValidateTileWidth(width uint64) error {
+	if width < 2 || width&(width-1) != 0 {
+		return fmt.Errorf("tile width %d must be a power of two, and at least 2", width)
+	}
+	return nil
+}
+
+// tileHeight returns the number of tree levels covered by tiles of the given
+// width, which must be valid.
+func tileHeight(width uint64) uint64 {
+	return uint64(bits.TrailingZeros64(width))
+}
+
+// ValidateLeafBundleSize returns an error if leaf bundles of bundleSize leaves
+// would not align with tile boundaries, i.e. unless bundleSize divides
+// TileWidth or is a multiple of it. Aligned bundles never straddle a tile, so
+// the leaves of a bundle can be located, and checked, using the tile which
+// covers them.
+func ValidateLeafBundleSize(bundleSize uint64) error {
+	return ValidateLeafBundleSizeForWidth(bundleSize, TileWidth)
+}
+
+// ValidateLeafBundleSizeForWidth is like ValidateLeafBundleSize, for logs
+// whose tiles have the given width.
+func ValidateLeafBundleSizeForWidth(bundleSize, width uint64) error {
+	if bundleSize == 0 {
+		return fmt.Errorf("leaf bundle size must be > 0")
+	}
+	if width%bundleSize != 0 && bundleSize%width != 0 {
+		return fmt.Errorf("leaf bundle size %d doesn't align with the tile width of %d: it must divide, or be a multiple of, the tile width", bundleSize, width)
+	}
+	return nil
+}
+
 // PartialTileSize returns the expected number of leaves in a tile at the given location within
 // a tree of the specified logSize, or 0 if the tile is expected to be fully populated.
 func PartialTileSize(level, index, logSize uint64) uint64 {
-	sizeAtLevel := logSize >> (level * 8)
-	fullTiles := sizeAtLevel / 256
+	return PartialTileSizeForWidth(level, index, logSize, TileWidth)
+}
+
+// PartialTileSizeForWidth is like PartialTileSize, for logs whose tiles have
+// the given width.
+func PartialTileSizeForWidth(level, index, logSize, width uint64) uint64 {
+	sizeAtLevel := logSize >> (level * tileHeight(width))
+	fullTiles := sizeAtLevel / width
 	if index < fullTiles {
 		return 0
 	}
-	return sizeAtLevel % 256
+	return sizeAtLevel % width
+}
+
+// TileCoords identifies a tile within a tree of a particular size.
+type TileCoords struct {
+	Level, Index uint64
+	// PartialSize is the number of nodes in the tile if it's partial, or 0 if
+	// it's fully populated, as returned by PartialTileSize.
+	PartialSize uint64
+}
+
+// TilesForLeaf returns the tiles, in order of increasing level, which hold
+// the leaf at index or one of its ancestors, in a tree of size logSize. Only
+// tiles which hold a node of the tree are returned, so tiles above the level
+// at which the leaf's ancestors are incomplete are omitted.
+// Returns nil if index is not less than logSize.
+func TilesForLeaf(index, logSize uint64) []TileCoords {
+	var tiles []TileCoords
+	for level := uint64(0); level*8 < 64; level++ {
+		// The ancestor of the leaf at the bottom of tiles at this level only
+		// exists once the subtree beneath it is complete.
+		node := index >> (level * 8)
+		if node >= logSize>>(level*8) {
+			break
+		}
+		tileIndex := node / TileWidth
+		tiles = append(tiles, TileCoords{
+			Level:       level,
+			Index:       tileIndex,
+			PartialSize: PartialTileSize(level, tileIndex, logSize),
+		})
+	}
+	return tiles
 }
 
 // NodeCoordsToTileAddress returns the (TileLevel, TileIndex) in tile-space, and the
 // (NodeLevel, NodeIndex) address within that tile of the specified tree node co-ordinates.
 func NodeCoordsToTileAddress(treeLevel, treeIndex uint64) (uint64, uint64, uint, uint64) {
-	tileRowWidth := uint64(1 << (8 - treeLevel%8))
-	tileLevel := treeLevel / 8
+	return NodeCoordsToTileAddressForWidth(treeLevel, treeIndex, TileWidth)
+}
+
+// NodeCoordsToTileAddressForWidth is like NodeCoordsToTileAddress, for logs
+// whose tiles have the given width.
+func NodeCoordsToTileAddressForWidth(treeLevel, treeIndex, width uint64) (uint64, uint64, uint, uint64) {
+	h := tileHeight(width)
+	tileRowWidth := uint64(1 << (h - treeLevel%h))
+	tileLevel := treeLevel / h
 	tileIndex := treeIndex / tileRowWidth
-	nodeLevel := uint(treeLevel % 8)
+	nodeLevel := uint(treeLevel % h)
 	nodeIndex := uint64(treeIndex % tileRowWidth)
 
 	return tileLevel, tileIndex, nodeLevel, nodeIndex
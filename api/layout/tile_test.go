@@ -17,8 +17,84 @@ package layout
 import (
 	"fmt"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
+func TestPartialTileSize(t *testing.T) {
+	for _, test := range []struct {
+		level, index, logSize uint64
+		want                  uint64
+	}{
+		{level: 0, index: 0, logSize: 1, want: 1},
+		{level: 0, index: 0, logSize: 255, want: 255},
+		{level: 0, index: 0, logSize: 256, want: 0},
+		{level: 0, index: 0, logSize: 257, want: 0},
+		{level: 0, index: 1, logSize: 257, want: 1},
+		{level: 0, index: 1, logSize: 512, want: 0},
+		{level: 0, index: 2, logSize: 512, want: 0},
+		{level: 1, index: 0, logSize: 257, want: 1},
+		{level: 1, index: 0, logSize: 256 * 255, want: 255},
+		{level: 1, index: 0, logSize: 256 * 256, want: 0},
+		{level: 1, index: 1, logSize: 256*256 + 256, want: 1},
+	} {
+		t.Run(fmt.Sprintf("level %d index %d size %d", test.level, test.index, test.logSize), func(t *testing.T) {
+			if got := PartialTileSize(test.level, test.index, test.logSize); got != test.want {
+				t.Errorf("PartialTileSize = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateLeafBundleSize(t *testing.T) {
+	for _, test := range []struct {
+		bundleSize uint64
+		wantErr    bool
+	}{
+		{bundleSize: 0, wantErr: true},
+		{bundleSize: 1},
+		{bundleSize: 2},
+		{bundleSize: 64},
+		{bundleSize: 256},
+		{bundleSize: 512},
+		{bundleSize: 3, wantErr: true},
+		{bundleSize: 100, wantErr: true},
+		{bundleSize: 255, wantErr: true},
+		{bundleSize: 257, wantErr: true},
+		{bundleSize: 384, wantErr: true},
+	} {
+		t.Run(fmt.Sprintf("%d", test.bundleSize), func(t *testing.T) {
+			if err := ValidateLeafBundleSize(test.bundleSize); (err != nil) != test.wantErr {
+				t.Errorf("ValidateLeafBundleSize(%d) = %v, want err %t", test.bundleSize, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateLeafBundleSizeForWidth(t *testing.T) {
+	for _, test := range []struct {
+		bundleSize, width uint64
+		wantErr           bool
+	}{
+		{bundleSize: 0, width: 512, wantErr: true},
+		{bundleSize: 256, width: 512},
+		{bundleSize: 512, width: 512},
+		{bundleSize: 1024, width: 512},
+		{bundleSize: 16, width: 16},
+		{bundleSize: 32, width: 16},
+		// Aligned with 256 wide tiles, but straddles 512 wide ones.
+		{bundleSize: 768, width: 512, wantErr: true},
+		{bundleSize: 256, width: 16},
+		{bundleSize: 24, width: 16, wantErr: true},
+	} {
+		t.Run(fmt.Sprintf("%d/%d", test.bundleSize, test.width), func(t *testing.T) {
+			if err := ValidateLeafBundleSizeForWidth(test.bundleSize, test.width); (err != nil) != test.wantErr {
+				t.Errorf("ValidateLeafBundleSizeForWidth(%d, %d) = %v, want err %t", test.bundleSize, test.width, err, test.wantErr)
+			}
+		})
+	}
+}
+
 func TestNodeCoordsToTileAddress(t *testing.T) {
 	for _, test := range []struct {
 		treeLevel     uint64
@@ -82,3 +158,97 @@ func TestNodeCoordsToTileAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestTilesForLeaf(t *testing.T) {
+	for _, test := range []struct {
+		index, logSize uint64
+		want           []TileCoords
+	}{
+		{index: 0, logSize: 0},
+		{index: 10, logSize: 10},
+		{index: 0, logSize: 1, want: []TileCoords{{Level: 0, Index: 0, PartialSize: 1}}},
+		{index: 255, logSize: 256, want: []TileCoords{{Level: 0, Index: 0}, {Level: 1, Index: 0, PartialSize: 1}}},
+		{index: 256, logSize: 300, want: []TileCoords{{Level: 0, Index: 1, PartialSize: 44}}},
+		{index: 256, logSize: 512, want: []TileCoords{{Level: 0, Index: 1}, {Level: 1, Index: 0, PartialSize: 2}}},
+		{index: 10, logSize: 600, want: []TileCoords{{Level: 0, Index: 0}, {Level: 1, Index: 0, PartialSize: 2}}},
+		{
+			index: 256*256 + 5, logSize: 256*256*2 + 1,
+			want: []TileCoords{{Level: 0, Index: 256}, {Level: 1, Index: 1}, {Level: 2, Index: 0, PartialSize: 2}},
+		},
+	} {
+		t.Run(fmt.Sprintf("index %d size %d", test.index, test.logSize), func(t *testing.T) {
+			if diff := cmp.Diff(test.want, TilesForLeaf(test.index, test.logSize)); diff != "" {
+				t.Errorf("TilesForLeaf diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateTileWidth(t *testing.T) {
+	for _, test := range []struct {
+		width   uint64
+		wantErr bool
+	}{
+		{width: 0, wantErr: true},
+		{width: 1, wantErr: true},
+		{width: 2},
+		{width: 256},
+		{width: 512},
+		{width: 1024},
+		{width: 300, wantErr: true},
+		{width: 511, wantErr: true},
+	} {
+		t.Run(fmt.Sprintf("%d", test.width), func(t *testing.T) {
+			if err := ValidateTileWidth(test.width); (err != nil) != test.wantErr {
+				t.Errorf("ValidateTileWidth(%d) = %v, want err %t", test.width, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPartialTileSizeForWidth(t *testing.T) {
+	for _, test := range []struct {
+		level, index, logSize, width uint64
+		want                         uint64
+	}{
+		{level: 0, index: 0, logSize: 256, width: 256, want: 0},
+		{level: 0, index: 0, logSize: 256, width: 512, want: 256},
+		{level: 0, index: 0, logSize: 511, width: 512, want: 511},
+		{level: 0, index: 0, logSize: 512, width: 512, want: 0},
+		{level: 0, index: 1, logSize: 600, width: 512, want: 88},
+		{level: 1, index: 0, logSize: 1024, width: 512, want: 2},
+		{level: 1, index: 0, logSize: 512 * 512, width: 512, want: 0},
+		{level: 0, index: 3, logSize: 15, width: 4, want: 3},
+		{level: 1, index: 0, logSize: 15, width: 4, want: 3},
+	} {
+		t.Run(fmt.Sprintf("level %d index %d size %d width %d", test.level, test.index, test.logSize, test.width), func(t *testing.T) {
+			if got := PartialTileSizeForWidth(test.level, test.index, test.logSize, test.width); got != test.want {
+				t.Errorf("PartialTileSizeForWidth = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNodeCoordsToTileAddressForWidth(t *testing.T) {
+	for _, test := range []struct {
+		treeLevel, treeIndex, width uint64
+		wantTileLevel               uint64
+		wantTileIndex               uint64
+		wantNodeLevel               uint
+		wantNodeIndex               uint64
+	}{
+		{treeLevel: 0, treeIndex: 511, width: 512, wantTileLevel: 0, wantTileIndex: 0, wantNodeLevel: 0, wantNodeIndex: 511},
+		{treeLevel: 0, treeIndex: 512, width: 512, wantTileLevel: 0, wantTileIndex: 1, wantNodeLevel: 0, wantNodeIndex: 0},
+		{treeLevel: 8, treeIndex: 1, width: 512, wantTileLevel: 0, wantTileIndex: 0, wantNodeLevel: 8, wantNodeIndex: 1},
+		{treeLevel: 9, treeIndex: 3, width: 512, wantTileLevel: 1, wantTileIndex: 0, wantNodeLevel: 0, wantNodeIndex: 3},
+		{treeLevel: 10, treeIndex: 300, width: 512, wantTileLevel: 1, wantTileIndex: 1, wantNodeLevel: 1, wantNodeIndex: 44},
+		{treeLevel: 8, treeIndex: 0, width: 256, wantTileLevel: 1, wantTileIndex: 0, wantNodeLevel: 0, wantNodeIndex: 0},
+	} {
+		t.Run(fmt.Sprintf("%d-%d-%d", test.treeLevel, test.treeIndex, test.width), func(t *testing.T) {
+			tl, ti, nl, ni := NodeCoordsToTileAddressForWidth(test.treeLevel, test.treeIndex, test.width)
+			if tl != test.wantTileLevel || ti != test.wantTileIndex || nl != test.wantNodeLevel || ni != test.wantNodeIndex {
+				t.Errorf("NodeCoordsToTileAddressForWidth = (%d, %d, %d, %d), want (%d, %d, %d, %d)", tl, ti, nl, ni, test.wantTileLevel, test.wantTileIndex, test.wantNodeLevel, test.wantNodeIndex)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit provides support for recording the checkpoints seen by a
+// log monitor, e.g. for later forensic analysis of split-view attacks.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FileStore is an append-only store of raw checkpoints, backed by a directory
+// on the local filesystem.
+//
+// Each distinct checkpoint is stored in its own file, named after the SHA256
+// hash of its contents. Files are never modified or deleted once written.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore which stores checkpoints in the given
+// directory, creating it if necessary.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %q: %w", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Observe records the raw checkpoint in the store, if it is not already present.
+// This method has the signature of a client.CheckpointObserver.
+func (s *FileStore) Observe(_ context.Context, cpRaw []byte) error {
+	p := filepath.Join(s.dir, fmt.Sprintf("%x", sha256.Sum256(cpRaw)))
+	tmp := fmt.Sprintf("%s.tmp", p)
+	if err := os.WriteFile(tmp, cpRaw, 0644); err != nil {
+		return fmt.Errorf("failed to write temporary file %q: %w", tmp, err)
+	}
+	defer func() {
+		_ = os.Remove(tmp)
+	}()
+	// Link will refuse to overwrite an existing file, in which case we already
+	// have this checkpoint.
+	if err := os.Link(tmp, p); err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to link %q to %q: %w", tmp, p, err)
+	}
+	return nil
+}
+
+// List returns the raw contents of all checkpoints in the store, in no
+// particular order.
+func (s *FileStore) List() ([][]byte, error) {
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %q: %w", s.dir, err)
+	}
+	names := make([]string, 0, len(ents))
+	for _, e := range ents {
+		if e.IsDir() || filepath.Ext(e.Name()) == ".tmp" {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	r := make([][]byte, 0, len(names))
+	for _, n := range names {
+		b, err := os.ReadFile(filepath.Join(s.dir, n))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", n, err)
+		}
+		r = append(r, b)
+	}
+	return r, nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewFileStore(filepath.Join(t.TempDir(), "audit"))
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	for _, cp := range []string{"one", "two", "one", "three", "two"} {
+		if err := s.Observe(ctx, []byte(cp)); err != nil {
+			t.Fatalf("Observe(%q): %v", cp, err)
+		}
+	}
+
+	got, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	gotStr := make([]string, 0, len(got))
+	for _, g := range got {
+		gotStr = append(gotStr, string(g))
+	}
+	sort.Strings(gotStr)
+	if diff := cmp.Diff([]string{"one", "three", "two"}, gotStr); diff != "" {
+		t.Errorf("List diff (-want +got):\n%s", diff)
+	}
+}
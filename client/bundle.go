@@ -0,0 +1,77 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/transparency-dev/merkle"
+	"github.com/transparency-dev/serverless-log/api"
+)
+
+// VerifyBundle checks that the leaves in bundle are committed to by tile.
+//
+// The bundle must contain the leaves at the start of the tile, i.e. the first
+// leaf in the bundle must be the leaf committed to by the tile's first level-0
+// node. The tile's internal nodes are also checked to be consistent with its
+// level-0 nodes, so that a tile which has been tampered with is detected too.
+func VerifyBundle(bundle [][]byte, tile *api.Tile, h merkle.LogHasher) error {
+	if l := uint(len(bundle)); l > tile.NumLeaves {
+		return fmt.Errorf("bundle has %d leaves, but tile only has %d", l, tile.NumLeaves)
+	}
+	for i, leaf := range bundle {
+		want := tileNode(tile, 0, uint64(i))
+		if len(want) == 0 {
+			return fmt.Errorf("tile is missing leaf hash for bundle entry %d", i)
+		}
+		if got := h.HashLeaf(leaf); !bytes.Equal(got, want) {
+			return fmt.Errorf("bundle entry %d has leaf hash %x, but tile has %x", i, got, want)
+		}
+	}
+	return VerifyTile(tile, h)
+}
+
+// VerifyTile checks that a tile is internally consistent, i.e. that each of
+// its internal nodes is the hash of its two children.
+func VerifyTile(tile *api.Tile, h merkle.LogHasher) error {
+	// Tiles may be wider than the default, so check every level present.
+	for level := uint(1); api.TileNodeKey(level, 0) < uint(len(tile.Nodes)); level++ {
+		for index := uint64(0); api.TileNodeKey(level, index) < uint(len(tile.Nodes)); index++ {
+			n := tileNode(tile, level, index)
+			if len(n) == 0 {
+				continue
+			}
+			l, r := tileNode(tile, level-1, index*2), tileNode(tile, level-1, index*2+1)
+			if len(l) == 0 || len(r) == 0 {
+				return fmt.Errorf("tile node at level %d index %d is missing children", level, index)
+			}
+			if got := h.HashChildren(l, r); !bytes.Equal(got, n) {
+				return fmt.Errorf("tile node at level %d index %d has hash %x, but its children hash to %x", level, index, n, got)
+			}
+		}
+	}
+	return nil
+}
+
+// tileNode returns the hash of the node at level and index within tile, or nil
+// if the tile doesn't contain it.
+func tileNode(tile *api.Tile, level uint, index uint64) []byte {
+	k := api.TileNodeKey(level, index)
+	if k >= uint(len(tile.Nodes)) {
+		return nil
+	}
+	return tile.Nodes[k]
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/transparency-dev/merkle/compact"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api"
+)
+
+// tileHeight is the number of tree levels stored in a tile of the default
+// width.
+const tileHeight = 8
+
+// mustBuildTile returns the leaves and the level-0 tile for a tree of n leaves.
+func mustBuildTile(t *testing.T, n int) ([][]byte, *api.Tile) {
+	t.Helper()
+	h := rfc6962.DefaultHasher
+	tile := &api.Tile{NumLeaves: uint(n)}
+	visit := func(id compact.NodeID, hash []byte) {
+		if id.Level >= tileHeight {
+			return
+		}
+		k := api.TileNodeKey(id.Level, id.Index)
+		if l := uint(len(tile.Nodes)); k >= l {
+			tile.Nodes = append(tile.Nodes, make([][]byte, k-l+1)...)
+		}
+		tile.Nodes[k] = hash
+	}
+	r := (&compact.RangeFactory{Hash: h.HashChildren}).NewEmptyRange(0)
+	leaves := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		leaf := []byte(fmt.Sprintf("leaf %d", i))
+		leaves = append(leaves, leaf)
+		if err := r.Append(h.HashLeaf(leaf), visit); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	return leaves, tile
+}
+
+func TestVerifyBundle(t *testing.T) {
+	h := rfc6962.DefaultHasher
+	for _, test := range []struct {
+		desc    string
+		n       int
+		bundle  func([][]byte) [][]byte
+		tamper  func(*api.Tile)
+		wantErr bool
+	}{
+		{
+			desc:   "full tile",
+			n:      256,
+			bundle: func(l [][]byte) [][]byte { return l },
+		}, {
+			desc:   "partial tile",
+			n:      37,
+			bundle: func(l [][]byte) [][]byte { return l },
+		}, {
+			desc:   "bundle prefix",
+			n:      37,
+			bundle: func(l [][]byte) [][]byte { return l[:10] },
+		}, {
+			desc: "tampered leaf",
+			n:    37,
+			bundle: func(l [][]byte) [][]byte {
+				l[3] = []byte("evil")
+				return l
+			},
+			wantErr: true,
+		}, {
+			desc:    "bundle too long",
+			n:       37,
+			bundle:  func(l [][]byte) [][]byte { return append(l, []byte("extra")) },
+			wantErr: true,
+		}, {
+			desc:   "tampered internal node",
+			n:      37,
+			bundle: func(l [][]byte) [][]byte { return l },
+			tamper: func(t *api.Tile) {
+				t.Nodes[api.TileNodeKey(2, 1)] = make([]byte, 32)
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			leaves, tile := mustBuildTile(t, test.n)
+			if test.tamper != nil {
+				test.tamper(tile)
+			}
+			err := VerifyBundle(test.bundle(leaves), tile, h)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("VerifyBundle: got err %v, want err %t", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"k8s.io/klog/v2"
+)
+
+// NewCachingFetcher returns a Fetcher which caches objects fetched via f in the
+// local directory dir, keyed by their path, and serves subsequent requests for
+// the same path from disk.
+//
+// All objects other than the checkpoint and the checkpoint archive index are
+// immutable, so are cached forever. Those two are served from the cache only
+// while they are younger than checkpointTTL; a zero checkpointTTL means they
+// are never cached.
+func NewCachingFetcher(f Fetcher, dir string, checkpointTTL time.Duration) Fetcher {
+	return func(ctx context.Context, p string) ([]byte, error) {
+		// Cleaning the path relative to a root ensures that it can't escape dir.
+		cp := path.Clean("/" + p)[1:]
+		if cp == "" {
+			return nil, fmt.Errorf("invalid path %q", p)
+		}
+		mutable := cp == layout.CheckpointPath || cp == layout.CheckpointArchiveIndexPath
+		if mutable && checkpointTTL <= 0 {
+			return f(ctx, p)
+		}
+		lp := filepath.Join(dir, filepath.FromSlash(cp))
+
+		if fi, err := os.Stat(lp); err == nil {
+			if !mutable || time.Since(fi.ModTime()) < checkpointTTL {
+				if b, err := os.ReadFile(lp); err == nil {
+					return b, nil
+				}
+			}
+		}
+
+		b, err := f(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeCacheFile(lp, b); err != nil {
+			// Failing to cache shouldn't stop us returning the fetched data.
+			klog.Warningf("Failed to cache %q: %v", p, err)
+		}
+		return b, nil
+	}
+}
+
+// writeCacheFile atomically writes b to the file at path p, creating any
+// necessary parent directories.
+func writeCacheFile(p string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(b); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
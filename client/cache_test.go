@@ -0,0 +1,133 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/transparency-dev/serverless-log/api/layout"
+)
+
+func TestCachingFetcher(t *testing.T) {
+	ctx := context.Background()
+	const tilePath = "tile/00/0000/00.03"
+
+	for _, test := range []struct {
+		desc          string
+		path          string
+		checkpointTTL time.Duration
+		wantFetches   int
+	}{
+		{
+			desc:        "immutable object cached",
+			path:        tilePath,
+			wantFetches: 1,
+		}, {
+			desc:        "checkpoint not cached with zero TTL",
+			path:        layout.CheckpointPath,
+			wantFetches: 3,
+		}, {
+			desc:          "checkpoint cached within TTL",
+			path:          layout.CheckpointPath,
+			checkpointTTL: time.Hour,
+			wantFetches:   1,
+		}, {
+			desc:          "checkpoint expires",
+			path:          layout.CheckpointPath,
+			checkpointTTL: time.Nanosecond,
+			wantFetches:   3,
+		}, {
+			desc:        "archive index not cached with zero TTL",
+			path:        layout.CheckpointArchiveIndexPath,
+			wantFetches: 3,
+		}, {
+			desc:          "archive index cached within TTL",
+			path:          layout.CheckpointArchiveIndexPath,
+			checkpointTTL: time.Hour,
+			wantFetches:   1,
+		}, {
+			desc:          "archive index expires",
+			path:          layout.CheckpointArchiveIndexPath,
+			checkpointTTL: time.Nanosecond,
+			wantFetches:   3,
+		}, {
+			desc:        "archived checkpoint cached",
+			path:        layout.CheckpointArchivePath(10),
+			wantFetches: 1,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			fetches := 0
+			f := func(_ context.Context, p string) ([]byte, error) {
+				fetches++
+				return []byte(p), nil
+			}
+			cf := NewCachingFetcher(f, t.TempDir(), test.checkpointTTL)
+			for i := 0; i < 3; i++ {
+				if test.checkpointTTL == time.Nanosecond {
+					time.Sleep(time.Millisecond)
+				}
+				got, err := cf(ctx, test.path)
+				if err != nil {
+					t.Fatalf("fetch: %v", err)
+				}
+				if !bytes.Equal(got, []byte(test.path)) {
+					t.Fatalf("Got %q, want %q", got, test.path)
+				}
+			}
+			if fetches != test.wantFetches {
+				t.Errorf("Got %d fetches, want %d", fetches, test.wantFetches)
+			}
+		})
+	}
+}
+
+func TestCachingFetcherDoesNotCacheErrors(t *testing.T) {
+	ctx := context.Background()
+	fail := true
+	f := func(_ context.Context, p string) ([]byte, error) {
+		if fail {
+			return nil, os.ErrNotExist
+		}
+		return []byte("ok"), nil
+	}
+	cf := NewCachingFetcher(f, t.TempDir(), 0)
+	if _, err := cf(ctx, "seq/00/00/00/00/00"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Got err %v, want ErrNotExist", err)
+	}
+	fail = false
+	if got, err := cf(ctx, "seq/00/00/00/00/00"); err != nil || string(got) != "ok" {
+		t.Fatalf("Got (%q, %v), want (\"ok\", nil)", got, err)
+	}
+}
+
+func TestCachingFetcherConfinesPaths(t *testing.T) {
+	f := func(_ context.Context, p string) ([]byte, error) {
+		return []byte(p), nil
+	}
+	dir := t.TempDir()
+	cf := NewCachingFetcher(f, dir, 0)
+	if _, err := cf(context.Background(), "../../etc/passwd"); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if _, err := os.Stat(dir + "/etc/passwd"); err != nil {
+		t.Errorf("Expected escaping path to be cached within cache dir: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/transparency-dev/formats/log"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// ErrMalformedCheckpoint is returned by ParseCheckpoint when a checkpoint
+// can't be parsed, e.g. because the object is empty, truncated, or corrupt.
+type ErrMalformedCheckpoint struct {
+	// Raw is the offending checkpoint.
+	Raw []byte
+	Err error
+}
+
+func (e ErrMalformedCheckpoint) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrMalformedCheckpoint) Error() string {
+	return fmt.Sprintf("malformed checkpoint: %v", e.Err)
+}
+
+// ErrUnverifiedCheckpoint is returned by ParseCheckpoint when a checkpoint is
+// well-formed, but does not carry a valid signature from the log. This
+// usually indicates that the wrong public key for the log is configured.
+type ErrUnverifiedCheckpoint struct {
+	// Raw is the offending checkpoint.
+	Raw []byte
+	// Signers lists the keys which the checkpoint carries signatures from,
+	// so that the key the log is actually using can be reported.
+	Signers []CheckpointSigner
+	Err     error
+}
+
+func (e ErrUnverifiedCheckpoint) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrUnverifiedCheckpoint) Error() string {
+	if len(e.Signers) == 0 {
+		return fmt.Sprintf("failed to verify checkpoint signature: %v", e.Err)
+	}
+	return fmt.Sprintf("failed to verify checkpoint signature: %v (checkpoint is signed by %s)", e.Err, signerList(e.Signers))
+}
+
+// CheckpointSigner identifies the key used to make a signature on a checkpoint.
+type CheckpointSigner struct {
+	// Name is the name of the key.
+	Name string
+	// KeyHash is the hash of the key, as used in note signatures.
+	KeyHash uint32
+}
+
+// String returns the signer in the <name>+<hash> form used as the prefix of
+// note verifier keys.
+func (s CheckpointSigner) String() string {
+	return fmt.Sprintf("%s+%08x", s.Name, s.KeyHash)
+}
+
+func signerList(signers []CheckpointSigner) string {
+	ss := make([]string, 0, len(signers))
+	for _, s := range signers {
+		ss = append(ss, s.String())
+	}
+	return strings.Join(ss, ", ")
+}
+
+// InspectCheckpoint returns the origin of a raw checkpoint note, along with
+// the keys it carries signatures from, WITHOUT verifying any of them.
+//
+// This is intended for tooling which has been given a checkpoint but not
+// told which log it's from, so that it can tell the user which origin and
+// key to configure. Nothing returned should be trusted until the checkpoint
+// has been verified, e.g. using ParseCheckpoint.
+func InspectCheckpoint(raw []byte) (string, []CheckpointSigner, error) {
+	_, err := note.Open(raw, note.VerifierList())
+	var unverified *note.UnverifiedNoteError
+	if !errors.As(err, &unverified) {
+		// Opening a note without any verifiers only succeeds in returning
+		// the note's content when it's well-formed.
+		return "", nil, ErrMalformedCheckpoint{Raw: raw, Err: err}
+	}
+	n := unverified.Note
+	origin, _, _ := strings.Cut(n.Text, "\n")
+	if origin == "" {
+		return "", nil, ErrMalformedCheckpoint{Raw: raw, Err: errors.New("empty origin")}
+	}
+	signers := make([]CheckpointSigner, 0, len(n.UnverifiedSigs))
+	for _, s := range n.UnverifiedSigs {
+		signers = append(signers, CheckpointSigner{Name: s.Name, KeyHash: s.Hash})
+	}
+	return origin, signers, nil
+}
+
+// ParseCheckpoint verifies the log's signature on a raw checkpoint note, and
+// returns the parsed checkpoint along with any extension lines and the note.
+// Signatures from otherVerifiers, e.g. witnesses, are also verified if present.
+// Signatures from any other keys, e.g. witnesses the caller doesn't yet know
+// about, are ignored, so only the log's signature is required to verify.
+//
+// Unlike log.ParseCheckpoint, failures are reported using typed errors so
+// that callers can tell them apart: ErrMalformedCheckpoint if the note or
+// checkpoint body can't be parsed, ErrUnverifiedCheckpoint if the log's
+// signature is missing or invalid, and ErrOriginMismatch if the checkpoint is
+// for a different origin.
+func ParseCheckpoint(raw []byte, origin string, logSigV note.Verifier, otherVerifiers ...note.Verifier) (*log.Checkpoint, []byte, *note.Note, error) {
+	vs := append([]note.Verifier{logSigV}, otherVerifiers...)
+	n, err := note.Open(raw, note.VerifierList(vs...))
+	if err != nil {
+		var unverified *note.UnverifiedNoteError
+		var invalid *note.InvalidSignatureError
+		if errors.As(err, &unverified) || errors.As(err, &invalid) {
+			_, signers, _ := InspectCheckpoint(raw)
+			return nil, nil, nil, ErrUnverifiedCheckpoint{Raw: raw, Signers: signers, Err: err}
+		}
+		return nil, nil, nil, ErrMalformedCheckpoint{Raw: raw, Err: err}
+	}
+	signed := false
+	for _, s := range n.Sigs {
+		if s.Hash == logSigV.KeyHash() && s.Name == logSigV.Name() {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		_, signers, _ := InspectCheckpoint(raw)
+		return nil, nil, n, ErrUnverifiedCheckpoint{Raw: raw, Signers: signers, Err: fmt.Errorf("no signature from log key %s", CheckpointSigner{Name: logSigV.Name(), KeyHash: logSigV.KeyHash()})}
+	}
+
+	cp := &log.Checkpoint{}
+	ext, err := cp.Unmarshal([]byte(n.Text))
+	if err != nil {
+		return nil, nil, n, ErrMalformedCheckpoint{Raw: raw, Err: err}
+	}
+	if cp.Origin != origin {
+		return nil, nil, n, ErrOriginMismatch{Want: origin, Got: cp.Origin, Raw: raw}
+	}
+	return cp, ext, n, nil
+}
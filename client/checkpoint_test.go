@@ -0,0 +1,192 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestParseCheckpoint(t *testing.T) {
+	skey, vkey, err := note.GenerateKey(rand.Reader, "other")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	otherS, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	otherV, err := note.NewVerifier(vkey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	badBody, err := note.Sign(&note.Note{Text: testOrigin + "\nnot a size\n"}, otherS)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	good := testRawCheckpoints[0]
+	tampered := bytes.Replace(good, []byte(testOrigin+"\n"), []byte(testOrigin+"\n1"), 1)
+
+	// Checkpoints may carry signatures from keys the client doesn't know, e.g.
+	// new witnesses, including ones with the same name as the log's key.
+	logS, err := note.NewSigner("PRIVATE+KEY+astra+cad5a3d2+ASgwwenlc0uuYcdy7kI44pQvuz1fw8cS5NqS8RkZBXoy")
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	skey, _, err = note.GenerateKey(rand.Reader, logS.Name())
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	impostorS, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	cosign := func(signers ...note.Signer) []byte {
+		t.Helper()
+		r, err := note.Sign(&note.Note{Text: string(testCheckpoints[0].Marshal())}, signers...)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return r
+	}
+
+	for _, test := range []struct {
+		desc    string
+		raw     []byte
+		origin  string
+		v       note.Verifier
+		wantErr any
+	}{
+		{
+			desc:   "valid",
+			raw:    good,
+			origin: testOrigin,
+			v:      testLogVerifier,
+		}, {
+			desc:   "unknown cosignature",
+			raw:    cosign(logS, otherS),
+			origin: testOrigin,
+			v:      testLogVerifier,
+		}, {
+			desc:   "unknown cosignature first",
+			raw:    cosign(otherS, logS),
+			origin: testOrigin,
+			v:      testLogVerifier,
+		}, {
+			desc:   "unknown cosignature with log's name",
+			raw:    cosign(logS, impostorS),
+			origin: testOrigin,
+			v:      testLogVerifier,
+		}, {
+			desc:    "only unknown signatures",
+			raw:     cosign(otherS, impostorS),
+			origin:  testOrigin,
+			v:       testLogVerifier,
+			wantErr: &ErrUnverifiedCheckpoint{},
+		}, {
+			desc:    "empty",
+			origin:  testOrigin,
+			v:       testLogVerifier,
+			wantErr: &ErrMalformedCheckpoint{},
+		}, {
+			desc:    "garbage",
+			raw:     []byte("<html>Not found</html>"),
+			origin:  testOrigin,
+			v:       testLogVerifier,
+			wantErr: &ErrMalformedCheckpoint{},
+		}, {
+			desc:    "signed malformed body",
+			raw:     badBody,
+			origin:  testOrigin,
+			v:       otherV,
+			wantErr: &ErrMalformedCheckpoint{},
+		}, {
+			desc:    "wrong key",
+			raw:     good,
+			origin:  testOrigin,
+			v:       otherV,
+			wantErr: &ErrUnverifiedCheckpoint{},
+		}, {
+			desc:    "bad signature",
+			raw:     tampered,
+			origin:  testOrigin,
+			v:       testLogVerifier,
+			wantErr: &ErrUnverifiedCheckpoint{},
+		}, {
+			desc:    "wrong origin",
+			raw:     good,
+			origin:  "example.com/other",
+			v:       testLogVerifier,
+			wantErr: &ErrOriginMismatch{},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			_, _, _, err := ParseCheckpoint(test.raw, test.origin, test.v)
+			if test.wantErr == nil {
+				if err != nil {
+					t.Fatalf("ParseCheckpoint: %v", err)
+				}
+				return
+			}
+			if !errors.As(err, test.wantErr) {
+				t.Fatalf("ParseCheckpoint: got %v, want %T", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestInspectCheckpoint(t *testing.T) {
+	want := []CheckpointSigner{{Name: testLogVerifier.Name(), KeyHash: testLogVerifier.KeyHash()}}
+	origin, signers, err := InspectCheckpoint(testRawCheckpoints[0])
+	if err != nil {
+		t.Fatalf("InspectCheckpoint: %v", err)
+	}
+	if origin != testOrigin {
+		t.Errorf("got origin %q, want %q", origin, testOrigin)
+	}
+	if diff := cmp.Diff(want, signers); diff != "" {
+		t.Errorf("signers diff (-want +got):\n%s", diff)
+	}
+	if got, want := signers[0].String(), "astra+cad5a3d2"; got != want {
+		t.Errorf("got signer %q, want %q", got, want)
+	}
+
+	if _, _, err := InspectCheckpoint([]byte("<html>Not found</html>")); !errors.As(err, &ErrMalformedCheckpoint{}) {
+		t.Errorf("InspectCheckpoint(garbage): got %v, want ErrMalformedCheckpoint", err)
+	}
+
+	// Verifying with the wrong key should report the key which was used.
+	_, vkey, err := note.GenerateKey(rand.Reader, "other")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	otherV, err := note.NewVerifier(vkey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	_, _, _, err = ParseCheckpoint(testRawCheckpoints[0], testOrigin, otherV)
+	unverified := ErrUnverifiedCheckpoint{}
+	if !errors.As(err, &unverified) {
+		t.Fatalf("ParseCheckpoint: got %v, want ErrUnverifiedCheckpoint", err)
+	}
+	if diff := cmp.Diff(want, unverified.Signers); diff != "" {
+		t.Errorf("ErrUnverifiedCheckpoint signers diff (-want +got):\n%s", diff)
+	}
+}
@@ -21,13 +21,18 @@ package client
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"sync"
+	"time"
 
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/transparency-dev/formats/log"
 	"github.com/transparency-dev/merkle"
 	"github.com/transparency-dev/merkle/compact"
@@ -35,6 +40,8 @@ import (
 	"github.com/transparency-dev/serverless-log/api"
 	"github.com/transparency-dev/serverless-log/api/layout"
 	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/klog/v2"
 )
 
 // Fetcher is the signature of a function which can retrieve arbitrary files from
@@ -46,6 +53,38 @@ import (
 // based implementation MUST return this error when it receives a 404 StatusCode.
 type Fetcher func(ctx context.Context, path string) ([]byte, error)
 
+// DefaultMaxResponseSize is the default limit on the size of objects which
+// fetchers read from a log. It's far larger than any tile, checkpoint, or
+// plausible leaf bundle, but prevents a malicious or buggy server from
+// exhausting the client's memory.
+const DefaultMaxResponseSize = 64 << 20
+
+// ErrResponseTooLarge is returned by ReadAllLimited when a response is larger
+// than the limit.
+type ErrResponseTooLarge struct {
+	// Limit is the maximum number of bytes which may be read.
+	Limit int64
+}
+
+func (e ErrResponseTooLarge) Error() string {
+	return fmt.Sprintf("response is larger than the limit of %d bytes", e.Limit)
+}
+
+// ReadAllLimited reads from r until EOF, like io.ReadAll, but returns an
+// ErrResponseTooLarge without reading further if r holds more than limit
+// bytes. Fetchers should use this to read response bodies, with
+// DefaultMaxResponseSize unless configured otherwise.
+func ReadAllLimited(r io.Reader, limit int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, ErrResponseTooLarge{Limit: limit}
+	}
+	return b, nil
+}
+
 // ConsensusCheckpointFunc is a function which returns the largest checkpoint known which is
 // signed by logSigV and satisfies some consensus algorithm.
 //
@@ -66,39 +105,124 @@ func FetchCheckpoint(ctx context.Context, f Fetcher, v note.Verifier, origin str
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	cp, _, n, err := log.ParseCheckpoint(cpRaw, origin, v)
+	cp, _, n, err := ParseCheckpoint(cpRaw, origin, v)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to parse Checkpoint: %v", err)
+		return nil, nil, nil, fmt.Errorf("failed to parse Checkpoint: %w", err)
 	}
 	return cp, cpRaw, n, nil
 }
 
+// Size fetches and verifies the log's current checkpoint, and returns its size.
+// This is useful for tools which only need to know how large the log is, e.g.
+// in order to bound the range of leaves they query.
+func Size(ctx context.Context, f Fetcher, v note.Verifier, origin string) (uint64, error) {
+	cp, _, _, err := FetchCheckpoint(ctx, f, v, origin)
+	if err != nil {
+		return 0, err
+	}
+	return cp.Size, nil
+}
+
 // ProofBuilder knows how to build inclusion and consistency proofs from tiles.
 // Since the tiles commit only to immutable nodes, the job of building proofs is slightly
 // more complex as proofs can touch "ephemeral" nodes, so these need to be synthesized.
 type ProofBuilder struct {
-	cp        log.Checkpoint
-	nodeCache nodeCache
-	h         compact.HashFn
+	cp          log.Checkpoint
+	nodeCache   *nodeCache
+	bundleCache bundleCache
+	h           compact.HashFn
+}
+
+// ProofBuilderOption configures optional behaviour of a ProofBuilder.
+type ProofBuilderOption func(*proofBuilderOpts)
+
+type proofBuilderOpts struct {
+	// tileCacheSize, if > 0, is the maximum number of tiles the builder will cache.
+	tileCacheSize int
+	// bundleSize is the number of leaves in each of the log's leaf bundles.
+	bundleSize uint64
+	// tileWidth is the width of the log's tiles.
+	tileWidth uint64
+	// maxConcurrentFetches is the maximum number of tiles fetched at once.
+	maxConcurrentFetches int
+}
+
+// defaultMaxConcurrentFetches is the default number of tiles which a
+// ProofBuilder fetches concurrently.
+const defaultMaxConcurrentFetches = 8
+
+// WithTileCacheSize bounds the number of tiles a ProofBuilder caches to n, evicting
+// the least recently used tiles once the limit is reached.
+//
+// By default all fetched tiles are cached for the lifetime of the ProofBuilder, which
+// for a long-lived builder over a large tree can use an unbounded amount of memory.
+// Since the upper tiles of the tree are needed by most proofs, these tend to stay
+// cached even with a small limit. Values <= 0 leave the cache unbounded.
+func WithTileCacheSize(n int) ProofBuilderOption {
+	return func(o *proofBuilderOpts) {
+		o.tileCacheSize = n
+	}
+}
+
+// WithLeafBundleSize tells the ProofBuilder that the log stores its leaves in
+// bundles of n leaves, for use by ProofBuilder.Leaf.
+// The default is 1, i.e. each leaf is stored individually. NewProofBuilder
+// fails unless n is aligned with the log's tiles, according to
+// layout.ValidateLeafBundleSizeForWidth.
+func WithLeafBundleSize(n uint64) ProofBuilderOption {
+	return func(o *proofBuilderOpts) {
+		o.bundleSize = n
+	}
+}
+
+// WithTileWidth tells the ProofBuilder that the log's tiles have the given
+// width, rather than layout.TileWidth.
+func WithTileWidth(width uint64) ProofBuilderOption {
+	return func(o *proofBuilderOpts) {
+		o.tileWidth = width
+	}
+}
+
+// WithMaxConcurrentFetches bounds the number of tiles which the ProofBuilder
+// fetches concurrently to n, when a proof needs nodes from several tiles which
+// aren't cached. The default is 8, and values <= 1 cause tiles to be fetched
+// one at a time.
+func WithMaxConcurrentFetches(n int) ProofBuilderOption {
+	return func(o *proofBuilderOpts) {
+		o.maxConcurrentFetches = n
+	}
 }
 
 // NewProofBuilder creates a new ProofBuilder object for a given tree size.
 // The returned ProofBuilder can be re-used for proofs related to a given tree size, but
 // it is not thread-safe and should not be accessed concurrently.
-func NewProofBuilder(ctx context.Context, cp log.Checkpoint, h compact.HashFn, f Fetcher) (*ProofBuilder, error) {
-	tf := newTileFetcher(f, cp.Size)
+func NewProofBuilder(ctx context.Context, cp log.Checkpoint, h compact.HashFn, f Fetcher, opts ...ProofBuilderOption) (*ProofBuilder, error) {
+	o := &proofBuilderOpts{bundleSize: 1, tileWidth: layout.TileWidth, maxConcurrentFetches: defaultMaxConcurrentFetches}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := layout.ValidateTileWidth(o.tileWidth); err != nil {
+		return nil, err
+	}
+	if err := layout.ValidateLeafBundleSizeForWidth(o.bundleSize, o.tileWidth); err != nil {
+		return nil, err
+	}
+	tf := newTileFetcher(f, cp.Size, o.tileWidth)
 	pb := &ProofBuilder{
-		cp:        cp,
-		nodeCache: newNodeCache(tf, cp.Size),
-		h:         h,
+		cp:          cp,
+		nodeCache:   newNodeCache(tf, cp.Size, o.tileCacheSize),
+		bundleCache: newBundleCache(f, o.bundleSize, cp.Size, o.tileCacheSize),
+		h:           h,
 	}
+	pb.nodeCache.tileWidth = o.tileWidth
+	pb.nodeCache.maxConcurrentFetches = max(o.maxConcurrentFetches, 1)
 	// Can't re-create the root of a zero size checkpoint other than by convention,
 	// so return early here in that case.
 	if cp.Size == 0 {
 		return pb, nil
 	}
 
-	hashes, err := FetchRangeNodes(ctx, cp.Size, tf)
+	hashes, err := pb.nodeCache.rangeNodes(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch range nodes: %w", err)
 	}
@@ -126,6 +250,8 @@ func NewProofBuilder(ctx context.Context, cp log.Checkpoint, h compact.HashFn, f
 // the given size.
 // This function uses the passed-in function to retrieve tiles containing any log tree
 // nodes necessary to build the proof.
+// If ctx is cancelled part way through, no further tiles are fetched and ctx's
+// error is returned.
 func (pb *ProofBuilder) InclusionProof(ctx context.Context, index uint64) ([][]byte, error) {
 	nodes, err := proof.Inclusion(index, pb.cp.Size)
 	if err != nil {
@@ -134,10 +260,49 @@ func (pb *ProofBuilder) InclusionProof(ctx context.Context, index uint64) ([][]b
 	return pb.fetchNodes(ctx, nodes)
 }
 
+// InclusionProofs constructs inclusion proofs for each of the leaves at the given
+// indices in a tree of the given size.
+// The set of tiles needed to build all of the proofs is determined up-front, and
+// any which aren't already cached are fetched concurrently, once, before the
+// proofs are built.
+// Returns a map of leaf index to inclusion proof.
+func (pb *ProofBuilder) InclusionProofs(ctx context.Context, indices []uint64) (map[uint64][][]byte, error) {
+	nodes := make(map[uint64]proof.Nodes, len(indices))
+	ids := make([]compact.NodeID, 0)
+	for _, i := range indices {
+		if _, ok := nodes[i]; ok {
+			continue
+		}
+		n, err := proof.Inclusion(i, pb.cp.Size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate inclusion proof node list for index %d: %w", i, err)
+		}
+		nodes[i] = n
+		ids = append(ids, n.IDs...)
+	}
+	if err := pb.nodeCache.prefetch(ctx, ids); err != nil {
+		return nil, err
+	}
+
+	ret := make(map[uint64][][]byte, len(nodes))
+	for i, n := range nodes {
+		p, err := pb.fetchNodes(ctx, n)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build inclusion proof for index %d: %w", i, err)
+		}
+		ret[i] = p
+	}
+	return ret, nil
+}
+
 // ConsistencyProof constructs a consistency proof between the two passed in tree sizes.
 // This function uses the passed-in function to retrieve tiles containing any log tree
-// nodes necessary to build the proof.
+// nodes necessary to build the proof, reusing any tiles already fetched by pb.
+// Both sizes must be no larger than the size of pb's checkpoint.
 func (pb *ProofBuilder) ConsistencyProof(ctx context.Context, smaller, larger uint64) ([][]byte, error) {
+	if larger > pb.cp.Size {
+		return nil, fmt.Errorf("tree size %d is larger than the proof builder's tree size %d", larger, pb.cp.Size)
+	}
 	nodes, err := proof.Consistency(smaller, larger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to calculate consistency proof node list: %w", err)
@@ -145,10 +310,88 @@ func (pb *ProofBuilder) ConsistencyProof(ctx context.Context, smaller, larger ui
 	return pb.fetchNodes(ctx, nodes)
 }
 
+// Leaf returns the contents of the leaf at index.
+//
+// The leaf is read from the leaf bundle containing it, which is cached by the
+// ProofBuilder so that reading further leaves from the same bundle doesn't
+// require another fetch. Together with the tile cache used for building proofs,
+// this allows verify-and-read workflows over a range of leaves to fetch each
+// tile and bundle only once.
+// If the ProofBuilder was created with WithTileCacheSize, the same limit applies
+// to the number of cached bundles.
+// The returned leaf is not verified; callers should check its inclusion using
+// a proof built by this ProofBuilder.
+func (pb *ProofBuilder) Leaf(ctx context.Context, index uint64) ([]byte, error) {
+	if index >= pb.cp.Size {
+		return nil, fmt.Errorf("index %d is outside of tree size %d", index, pb.cp.Size)
+	}
+	return pb.bundleCache.getLeaf(ctx, index)
+}
+
+// DiagnoseInclusion looks for the reason why an inclusion proof for the leaf
+// with hash leafHash at index failed to verify against pb's checkpoint.
+//
+// It re-walks the proof from the leaf towards the root, comparing each node on
+// the path, and each of their siblings, with the hash of its stored children,
+// and returns an ErrCorruptNode describing the first node which doesn't chain.
+// Only nodes within complete subtrees are checked, since the remaining nodes
+// are derived from the compact range which NewProofBuilder has already
+// verified against the checkpoint root hash.
+// Returns nil if no inconsistency is found in the tiles.
+func (pb *ProofBuilder) DiagnoseInclusion(ctx context.Context, index uint64, leafHash []byte) error {
+	if index >= pb.cp.Size {
+		return fmt.Errorf("index %d is outside of tree size %d", index, pb.cp.Size)
+	}
+	got, err := pb.nodeCache.GetNode(ctx, compact.NewNodeID(0, index))
+	if err != nil {
+		return fmt.Errorf("failed to get leaf hash %d: %w", index, err)
+	}
+	if !bytes.Equal(got, leafHash) {
+		return newErrCorruptNode(0, index, leafHash, got)
+	}
+	// complete returns true if the node at level, i is the root of a complete
+	// subtree, and is therefore stored in a tile.
+	complete := func(level uint, i uint64) bool {
+		return (i+1)<<level <= pb.cp.Size
+	}
+	for level, i := uint(1), index>>1; complete(level, i); level, i = level+1, i>>1 {
+		for _, n := range []uint64{i ^ 1, i} {
+			if !complete(level, n) {
+				continue
+			}
+			if err := pb.checkNode(ctx, level, n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkNode returns an ErrCorruptNode if the stored hash of the node at the
+// given coordinates doesn't match the hash of its stored children.
+func (pb *ProofBuilder) checkNode(ctx context.Context, level uint, index uint64) error {
+	ids := []compact.NodeID{compact.NewNodeID(level-1, index*2), compact.NewNodeID(level-1, index*2+1), compact.NewNodeID(level, index)}
+	hs := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		h, err := pb.nodeCache.GetNode(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get node at level %d index %d: %w", id.Level, id.Index, err)
+		}
+		hs = append(hs, h)
+	}
+	if want := pb.h(hs[0], hs[1]); !bytes.Equal(hs[2], want) {
+		return newErrCorruptNode(level, index, want, hs[2])
+	}
+	return nil
+}
+
 // fetchNodes retrieves the specified proof nodes via pb's nodeCache.
+// Any tiles needed which aren't cached are fetched concurrently first.
 func (pb *ProofBuilder) fetchNodes(ctx context.Context, nodes proof.Nodes) ([][]byte, error) {
+	if err := pb.nodeCache.prefetch(ctx, nodes.IDs); err != nil {
+		return nil, err
+	}
 	hashes := make([][]byte, 0)
-	// TODO(al) parallelise this.
 	for _, id := range nodes.IDs {
 		h, err := pb.nodeCache.GetNode(ctx, id)
 		if err != nil {
@@ -166,22 +409,20 @@ func (pb *ProofBuilder) fetchNodes(ctx context.Context, nodes proof.Nodes) ([][]
 // FetchRangeNodes returns the set of nodes representing the compact range covering
 // a log of size s.
 func FetchRangeNodes(ctx context.Context, s uint64, gt GetTileFunc) ([][]byte, error) {
-	nc := newNodeCache(gt, s)
-	nIDs := compact.RangeNodes(0, s, nil)
-	ret := make([][]byte, len(nIDs))
-	for i, n := range nIDs {
-		h, err := nc.GetNode(ctx, n)
-		if err != nil {
-			return nil, err
-		}
-		ret[i] = h
-	}
-	return ret, nil
+	return FetchRangeNodesForWidth(ctx, s, layout.TileWidth, gt)
+}
+
+// FetchRangeNodesForWidth is like FetchRangeNodes, for logs whose tiles have
+// the given width.
+func FetchRangeNodesForWidth(ctx context.Context, s, width uint64, gt GetTileFunc) ([][]byte, error) {
+	nc := newNodeCache(gt, s, 0)
+	nc.tileWidth = width
+	return nc.rangeNodes(ctx)
 }
 
 // FetchLeafHashes fetches N consecutive leaf hashes starting with the leaf at index first.
 func FetchLeafHashes(ctx context.Context, f Fetcher, first, N, logSize uint64) ([][]byte, error) {
-	nc := newNodeCache(newTileFetcher(f, logSize), logSize)
+	nc := newNodeCache(newTileFetcher(f, logSize, layout.TileWidth), logSize, 0)
 	ret := make([][]byte, 0, N)
 	for i, seq := uint64(0), first; i < N; i, seq = i+1, seq+1 {
 		nID := compact.NodeID{Level: 0, Index: seq}
@@ -194,15 +435,113 @@ func FetchLeafHashes(ctx context.Context, f Fetcher, first, N, logSize uint64) (
 	return ret, nil
 }
 
+// FindLeafIndex searches the level-0 tiles of a tree of size logSize for the
+// leaf hash lh, and returns the index of its first occurrence.
+//
+// Unlike LookupIndex, this only reads the log's tiles, so it works with logs
+// which don't publish leafhash->seq objects, at the cost of fetching up to
+// every level-0 tile of the tree. Tiles are searched in order, and the search
+// stops as soon as lh is found.
+// An error wrapping os.ErrNotExist is returned if lh isn't in the tree.
+func FindLeafIndex(ctx context.Context, f Fetcher, logSize uint64, lh []byte) (uint64, error) {
+	getTile := newTileFetcher(f, logSize, layout.TileWidth)
+	for ti := uint64(0); ti*layout.TileWidth < logSize; ti++ {
+		t, err := getTile(ctx, 0, ti)
+		if err != nil {
+			return 0, fmt.Errorf("failed to fetch level 0 tile %d: %w", ti, err)
+		}
+		n := min(uint64(t.NumLeaves), logSize-ti*layout.TileWidth)
+		for j := uint64(0); j < n; j++ {
+			if bytes.Equal(t.Nodes[api.TileNodeKey(0, j)], lh) {
+				return ti*layout.TileWidth + j, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("leafhash unknown in tree of size %d: %w", logSize, os.ErrNotExist)
+}
+
 // nodeCache hides the tiles abstraction away, and improves
 // performance by caching tiles it's seen.
-// Not threadsafe, and intended to be only used throughout the course
-// of a single request.
+// Intended to be only used throughout the course of a single request.
+// Nodes may be looked up concurrently, and concurrent requests for the same
+// tile share a single fetch, but SetEphemeralNode must not be called
+// concurrently with any other method.
 type nodeCache struct {
 	logSize   uint64
 	ephemeral map[compact.NodeID][]byte
-	tiles     map[tileKey]api.Tile
 	getTile   GetTileFunc
+	// tileWidth is the width of the log's tiles.
+	tileWidth uint64
+	// maxConcurrentFetches is the maximum number of tiles prefetch fetches at once.
+	maxConcurrentFetches int
+
+	// mu guards tiles and inflight.
+	mu sync.Mutex
+	// Only one of tiles and lruTiles is set, depending on whether the cache is bounded.
+	tiles    map[tileKey]api.Tile
+	lruTiles *lru.Cache[tileKey, api.Tile]
+	// inflight holds the fetches which are in progress.
+	inflight map[tileKey]*tileFetch
+}
+
+// tileFetch is an in-progress fetch of a tile, which all concurrent requests
+// for the tile wait on.
+type tileFetch struct {
+	// done is closed once tile and err are set.
+	done chan struct{}
+	tile *api.Tile
+	err  error
+}
+
+// prefetch ensures that all tiles needed to look up the given node IDs are
+// present in the cache. Missing tiles are fetched concurrently, at most
+// maxConcurrentFetches at a time.
+func (n *nodeCache) prefetch(ctx context.Context, ids []compact.NodeID) error {
+	missing := make(map[tileKey]bool)
+	n.mu.Lock()
+	for _, id := range ids {
+		if e := n.ephemeral[id]; len(e) != 0 {
+			continue
+		}
+		tileLevel, tileIndex, _, _ := layout.NodeCoordsToTileAddressForWidth(uint64(id.Level), uint64(id.Index), n.tileWidth)
+		tKey := tileKey{tileLevel, tileIndex}
+		if _, ok := n.cachedTile(tKey); !ok {
+			missing[tKey] = true
+		}
+	}
+	n.mu.Unlock()
+	if len(missing) < 2 {
+		// Nothing to be gained from fetching concurrently.
+		return nil
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(n.maxConcurrentFetches)
+	for k := range missing {
+		k := k
+		eg.Go(func() error {
+			_, err := n.tile(ctx, k)
+			return err
+		})
+	}
+	return eg.Wait()
+}
+
+// rangeNodes returns the nodes of the compact range which covers the whole log.
+func (n *nodeCache) rangeNodes(ctx context.Context) ([][]byte, error) {
+	nIDs := compact.RangeNodes(0, n.logSize, nil)
+	if err := n.prefetch(ctx, nIDs); err != nil {
+		return nil, err
+	}
+	ret := make([][]byte, len(nIDs))
+	for i, id := range nIDs {
+		h, err := n.GetNode(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = h
+	}
+	return ret, nil
 }
 
 // GetTileFunc is the signature of a function which knows how to fetch a
@@ -216,13 +555,114 @@ type tileKey struct {
 }
 
 // newNodeCache creates a new nodeCache instance for a given log size.
-func newNodeCache(f GetTileFunc, logSize uint64) nodeCache {
-	return nodeCache{
-		logSize:   logSize,
-		ephemeral: make(map[compact.NodeID][]byte),
-		tiles:     make(map[tileKey]api.Tile),
-		getTile:   f,
+// If maxTiles is > 0, at most that many tiles are cached.
+// Tiles are fetched one at a time unless maxConcurrentFetches is raised.
+func newNodeCache(f GetTileFunc, logSize uint64, maxTiles int) *nodeCache {
+	n := &nodeCache{
+		logSize:              logSize,
+		ephemeral:            make(map[compact.NodeID][]byte),
+		getTile:              f,
+		tileWidth:            layout.TileWidth,
+		maxConcurrentFetches: 1,
+		inflight:             make(map[tileKey]*tileFetch),
+	}
+	if maxTiles <= 0 {
+		n.tiles = make(map[tileKey]api.Tile)
+		return n
+	}
+	c, err := lru.New[tileKey, api.Tile](maxTiles)
+	if err != nil {
+		panic(err)
+	}
+	n.lruTiles = c
+	return n
+}
+
+// cachedTile returns the tile with key k, if it's present in the cache.
+// n.mu must be held.
+func (n *nodeCache) cachedTile(k tileKey) (api.Tile, bool) {
+	if n.lruTiles != nil {
+		return n.lruTiles.Get(k)
+	}
+	t, ok := n.tiles[k]
+	return t, ok
+}
+
+// cacheTile stores the tile with key k in the cache, evicting the least recently
+// used tile if the cache is bounded and full.
+// n.mu must be held.
+func (n *nodeCache) cacheTile(k tileKey, t api.Tile) {
+	if n.lruTiles != nil {
+		n.lruTiles.Add(k, t)
+		return
+	}
+	n.tiles[k] = t
+}
+
+// bundleCache fetches and caches the leaf bundles of a log of a given size.
+type bundleCache struct {
+	f          Fetcher
+	bundleSize uint64
+	logSize    uint64
+	// Only one of bundles and lruBundles is set, depending on whether the cache is bounded.
+	bundles    map[uint64][][]byte
+	lruBundles *lru.Cache[uint64, [][]byte]
+}
+
+// newBundleCache creates a new bundleCache instance for a given log size.
+// If maxBundles is > 0, at most that many bundles are cached.
+func newBundleCache(f Fetcher, bundleSize, logSize uint64, maxBundles int) bundleCache {
+	b := bundleCache{
+		f:          f,
+		bundleSize: max(bundleSize, 1),
+		logSize:    logSize,
+	}
+	if maxBundles <= 0 {
+		b.bundles = make(map[uint64][][]byte)
+		return b
+	}
+	c, err := lru.New[uint64, [][]byte](maxBundles)
+	if err != nil {
+		panic(err)
+	}
+	b.lruBundles = c
+	return b
+}
+
+// getLeaf returns the leaf at index i, fetching and caching the bundle which
+// contains it if necessary.
+func (b *bundleCache) getLeaf(ctx context.Context, i uint64) ([]byte, error) {
+	bi := i / b.bundleSize
+	var bundle [][]byte
+	var ok bool
+	if b.lruBundles != nil {
+		bundle, ok = b.lruBundles.Get(bi)
+	} else {
+		bundle, ok = b.bundles[bi]
+	}
+	if !ok {
+		var err error
+		if b.bundleSize == 1 {
+			var leaf []byte
+			leaf, err = GetLeaf(ctx, b.f, i)
+			bundle = [][]byte{leaf}
+		} else {
+			bundle, err = fetchLeafBundle(ctx, b.f, b.bundleSize, b.logSize, bi)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b.lruBundles != nil {
+			b.lruBundles.Add(bi, bundle)
+		} else {
+			b.bundles[bi] = bundle
+		}
+	}
+	o := i % b.bundleSize
+	if o >= uint64(len(bundle)) {
+		return nil, fmt.Errorf("leaf bundle %d has %d entries, want at least %d", bi, len(bundle), o+1)
 	}
+	return bundle[o], nil
 }
 
 // SetEphemeralNode stored a derived "ephemeral" tree node.
@@ -234,22 +674,17 @@ func (n *nodeCache) SetEphemeralNode(id compact.NodeID, h []byte) {
 // A previously set ephemeral node will be returned if id matches, otherwise
 // the tile containing the requested node will be fetched and cached, and the
 // node hash returned.
+// No tile will be fetched if ctx is already done.
 func (n *nodeCache) GetNode(ctx context.Context, id compact.NodeID) ([]byte, error) {
 	// First check for ephemeral nodes:
 	if e := n.ephemeral[id]; len(e) != 0 {
 		return e, nil
 	}
 	// Otherwise look in fetched tiles:
-	tileLevel, tileIndex, nodeLevel, nodeIndex := layout.NodeCoordsToTileAddress(uint64(id.Level), uint64(id.Index))
-	tKey := tileKey{tileLevel, tileIndex}
-	t, ok := n.tiles[tKey]
-	if !ok {
-		tile, err := n.getTile(ctx, tileLevel, tileIndex)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch tile: %w", err)
-		}
-		t = *tile
-		n.tiles[tKey] = *tile
+	tileLevel, tileIndex, nodeLevel, nodeIndex := layout.NodeCoordsToTileAddressForWidth(uint64(id.Level), uint64(id.Index), n.tileWidth)
+	t, err := n.tile(ctx, tileKey{tileLevel, tileIndex})
+	if err != nil {
+		return nil, err
 	}
 	nodeKey := int(api.TileNodeKey(nodeLevel, nodeIndex))
 	if l := len(t.Nodes); nodeKey >= l {
@@ -262,10 +697,60 @@ func (n *nodeCache) GetNode(ctx context.Context, id compact.NodeID) ([]byte, err
 	return node, nil
 }
 
-// newTileFetcher returns a GetTileFunc based on the passed in Fetcher and log size.
-func newTileFetcher(f Fetcher, logSize uint64) GetTileFunc {
+// tile returns the tile with key k, fetching and caching it if necessary.
+// If the tile is already being fetched, the result of that fetch is awaited
+// rather than fetching the tile again.
+// No tile will be fetched if ctx is already done.
+func (n *nodeCache) tile(ctx context.Context, k tileKey) (api.Tile, error) {
+	n.mu.Lock()
+	if t, ok := n.cachedTile(k); ok {
+		n.mu.Unlock()
+		return t, nil
+	}
+	if f, ok := n.inflight[k]; ok {
+		n.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return api.Tile{}, ctx.Err()
+		case <-f.done:
+		}
+		if f.err != nil {
+			return api.Tile{}, f.err
+		}
+		return *f.tile, nil
+	}
+	// Don't start any new fetches once the caller has given up.
+	if err := ctx.Err(); err != nil {
+		n.mu.Unlock()
+		return api.Tile{}, err
+	}
+	f := &tileFetch{done: make(chan struct{})}
+	n.inflight[k] = f
+	n.mu.Unlock()
+
+	f.tile, f.err = n.getTile(ctx, k.tileLevel, k.tileIndex)
+	if f.err != nil {
+		f.err = fmt.Errorf("failed to fetch tile: %w", f.err)
+	}
+	n.mu.Lock()
+	delete(n.inflight, k)
+	if f.err == nil {
+		n.cacheTile(k, *f.tile)
+	}
+	n.mu.Unlock()
+	close(f.done)
+
+	if f.err != nil {
+		return api.Tile{}, f.err
+	}
+	return *f.tile, nil
+}
+
+// newTileFetcher returns a GetTileFunc based on the passed in Fetcher, log
+// size, and tile width.
+func newTileFetcher(f Fetcher, logSize, width uint64) GetTileFunc {
 	return func(ctx context.Context, level, index uint64) (*api.Tile, error) {
-		tileSize := layout.PartialTileSize(level, index, logSize)
+		tileSize := layout.PartialTileSizeForWidth(level, index, logSize, width)
 		p := filepath.Join(layout.TilePath("", level, index, tileSize))
 		t, err := f(ctx, p)
 		if err != nil {
@@ -279,10 +764,61 @@ func newTileFetcher(f Fetcher, logSize uint64) GetTileFunc {
 		if err := tile.UnmarshalText(t); err != nil {
 			return nil, fmt.Errorf("failed to parse tile: %w", err)
 		}
+		// A tileSize of zero means that we requested a full tile.
+		want := uint(tileSize)
+		if want == 0 {
+			want = uint(width)
+		}
+		// Note that we may legitimately receive a wider tile than we asked for if
+		// the tile has since been filled and the storage serves the full tile in
+		// place of the partial one, so only narrower tiles are rejected.
+		if tile.NumLeaves < want {
+			return nil, ErrShortTile{Level: level, Index: index, Want: want, Got: tile.NumLeaves}
+		}
 		return &tile, nil
 	}
 }
 
+// ErrShortTile is returned when a fetched tile contains fewer leaves than are
+// required by the tree size for which it was requested.
+// This can happen if a storage layer, or cache in front of it, serves a stale
+// version of a partial tile.
+type ErrShortTile struct {
+	Level, Index uint64
+	// Want is the number of leaves which the tile was expected to contain.
+	Want uint
+	// Got is the number of leaves the tile actually contained.
+	Got uint
+}
+
+func (e ErrShortTile) Error() string {
+	return fmt.Sprintf("tile at level %d index %d has %d leaves, want at least %d", e.Level, e.Index, e.Got, e.Want)
+}
+
+// ErrCorruptNode is returned by ProofBuilder.DiagnoseInclusion when a node
+// stored in the log's tiles has a hash which doesn't chain with the nodes
+// below it.
+type ErrCorruptNode struct {
+	// Level and Index are the coordinates of the node in the tree.
+	Level, Index uint64
+	// TileLevel and TileIndex are the coordinates of the tile storing the node.
+	TileLevel, TileIndex uint64
+	// Want is the hash computed from the node's children, or the leaf hash
+	// being verified for nodes at level 0.
+	Want []byte
+	// Got is the hash stored in the tile.
+	Got []byte
+}
+
+func newErrCorruptNode(level uint, index uint64, want, got []byte) ErrCorruptNode {
+	tl, ti, _, _ := layout.NodeCoordsToTileAddress(uint64(level), index)
+	return ErrCorruptNode{Level: uint64(level), Index: index, TileLevel: tl, TileIndex: ti, Want: want, Got: got}
+}
+
+func (e ErrCorruptNode) Error() string {
+	return fmt.Sprintf("node at level %d index %d in tile at level %d index %d has hash %x, want %x", e.Level, e.Index, e.TileLevel, e.TileIndex, e.Got, e.Want)
+}
+
 // LookupIndex fetches the leafhash->seq mapping file from the log, and returns
 // its parsed contents.
 func LookupIndex(ctx context.Context, f Fetcher, lh []byte) (uint64, error) {
@@ -310,14 +846,413 @@ func GetLeaf(ctx context.Context, f Fetcher, i uint64) ([]byte, error) {
 	return sRaw, nil
 }
 
+// ErrLeafHashMismatch is returned by GetLeafByHash when the leaf fetched from
+// the log does not hash to the requested leaf hash.
+type ErrLeafHashMismatch struct {
+	// Index is the index of the fetched leaf.
+	Index uint64
+	// Want is the requested leaf hash.
+	Want []byte
+	// Got is the hash of the fetched leaf.
+	Got []byte
+}
+
+func (e ErrLeafHashMismatch) Error() string {
+	return fmt.Sprintf("leaf at index %d has hash %x, want %x", e.Index, e.Got, e.Want)
+}
+
+// GetLeafByHash looks up the index of the leaf with the leaf hash lh, and then
+// fetches the leaf itself, checking that it hashes to lh.
+//
+// bundleSize is the log's configured number of leaves per leaf bundle; logs
+// which don't bundle leaves have a bundleSize of 1. Since the final bundle of a
+// log may be partial, logSize must be the size of a tree which contains the leaf.
+func GetLeafByHash(ctx context.Context, f Fetcher, h merkle.LogHasher, bundleSize, logSize uint64, lh []byte) (uint64, []byte, error) {
+	i, err := LookupIndex(ctx, f, lh)
+	if err != nil {
+		return 0, nil, err
+	}
+	if i >= logSize {
+		return 0, nil, fmt.Errorf("leaf index %d is not in tree of size %d", i, logSize)
+	}
+	var leaf []byte
+	if bundleSize <= 1 {
+		leaf, err = GetLeaf(ctx, f, i)
+	} else {
+		leaf, err = getBundledLeaf(ctx, f, bundleSize, logSize, i)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if got := h.HashLeaf(leaf); !bytes.Equal(got, lh) {
+		return 0, nil, ErrLeafHashMismatch{Index: i, Want: lh, Got: got}
+	}
+	return i, leaf, nil
+}
+
+// ErrLeavesMissing is returned by VerifyLeavesPresent when some of the leaf
+// hashes it was given are not committed to by the ProofBuilder's checkpoint.
+type ErrLeavesMissing struct {
+	// Missing holds the positions, in the slice of hashes passed to
+	// VerifyLeavesPresent, of the leaf hashes which were not found.
+	Missing []int
+}
+
+func (e ErrLeavesMissing) Error() string {
+	return fmt.Sprintf("%d leaves not found in log", len(e.Missing))
+}
+
+// VerifyLeavesPresent checks that each of the leaf hashes in hashes is
+// committed to by pb's checkpoint, by looking up its index in the log and
+// verifying an inclusion proof for it.
+// Returns the index of each leaf, in the same order as hashes.
+//
+// If any of the hashes aren't known to the log, or are at an index outside of
+// pb's checkpoint, ErrLeavesMissing is returned listing them, along with the
+// indices of the others; the indices returned for the missing leaves are 0.
+// Any other error, including an inclusion proof failing to verify, is returned
+// as-is since it indicates that the log is unavailable or misbehaving.
+func VerifyLeavesPresent(ctx context.Context, f Fetcher, pb *ProofBuilder, hashes [][]byte) ([]uint64, error) {
+	indices := make([]uint64, len(hashes))
+	present := make([]bool, len(hashes))
+	var missing []int
+	found := make([]uint64, 0, len(hashes))
+	for i, lh := range hashes {
+		idx, err := LookupIndex(ctx, f, lh)
+		if errors.Is(err, os.ErrNotExist) {
+			missing = append(missing, i)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up index of leaf hash %x: %w", lh, err)
+		}
+		if idx >= pb.cp.Size {
+			// Sequenced, but not yet integrated into the tree pb was built for.
+			missing = append(missing, i)
+			continue
+		}
+		indices[i], present[i] = idx, true
+		found = append(found, idx)
+	}
+
+	proofs, err := pb.InclusionProofs(ctx, found)
+	if err != nil {
+		return nil, err
+	}
+	h := childHasher{hashChildren: pb.h, size: len(pb.cp.Hash)}
+	for i, lh := range hashes {
+		if !present[i] {
+			continue
+		}
+		if err := proof.VerifyInclusion(h, indices[i], pb.cp.Size, lh, proofs[indices[i]], pb.cp.Hash); err != nil {
+			return nil, fmt.Errorf("failed to verify inclusion of leaf hash %x at index %d: %w", lh, indices[i], err)
+		}
+	}
+	if len(missing) > 0 {
+		return indices, ErrLeavesMissing{Missing: missing}
+	}
+	return indices, nil
+}
+
+// childHasher adapts the compact.HashFn used by a ProofBuilder for use when
+// verifying inclusion proofs, which only require interior nodes to be hashed.
+type childHasher struct {
+	hashChildren compact.HashFn
+	size         int
+}
+
+func (c childHasher) HashChildren(l, r []byte) []byte { return c.hashChildren(l, r) }
+func (c childHasher) Size() int                       { return c.size }
+func (c childHasher) EmptyRoot() []byte               { panic("EmptyRoot not supported") }
+func (c childHasher) HashLeaf([]byte) []byte          { panic("HashLeaf not supported") }
+
+// getBundledLeaf fetches the leaf at index i from the leaf bundle containing it.
+func getBundledLeaf(ctx context.Context, f Fetcher, bundleSize, logSize, i uint64) ([]byte, error) {
+	bi := i / bundleSize
+	bs, err := fetchLeafBundle(ctx, f, bundleSize, logSize, bi)
+	if err != nil {
+		return nil, err
+	}
+	o := layout.LeafOffsetInBundle(i, bundleSize)
+	if o >= uint64(len(bs)) {
+		return nil, fmt.Errorf("leaf bundle %d has %d entries, want at least %d", bi, len(bs), o+1)
+	}
+	return bs[o], nil
+}
+
+// fetchLeafBundle fetches and decodes all of the leaves in the leaf bundle
+// with index bi.
+func fetchLeafBundle(ctx context.Context, f Fetcher, bundleSize, logSize, bi uint64) ([][]byte, error) {
+	bRaw, err := f(ctx, layout.BundlePath(bi*bundleSize, bundleSize, logSize))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("leaf bundle %d not found: %w", bi, err)
+		}
+		return nil, fmt.Errorf("failed to fetch leaf bundle %d: %w", bi, err)
+	}
+	bs := bytes.Split(bRaw, []byte("\n"))
+	leaves := make([][]byte, 0, len(bs))
+	for j, b := range bs {
+		if len(b) == 0 && j == len(bs)-1 {
+			// Trailing newline.
+			break
+		}
+		l, err := base64.StdEncoding.DecodeString(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode leaf %d in bundle %d: %w", j, bi, err)
+		}
+		leaves = append(leaves, l)
+	}
+	return leaves, nil
+}
+
+// DownloadOption configures optional behaviour of DownloadAllLeaves.
+type DownloadOption func(*downloadOpts)
+
+type downloadOpts struct {
+	// parallelism is the maximum number of concurrent fetches.
+	parallelism int
+	// bundleSize is the number of leaves in each of the log's leaf bundles.
+	bundleSize uint64
+	// h and root, if h is set, are used to verify the downloaded tree.
+	h    merkle.LogHasher
+	root []byte
+}
+
+// WithDownloadParallelism allows up to n leaves, or leaf bundles, to be fetched
+// concurrently. Leaves are still passed to the callback in order, so at most n
+// fetched leaves or bundles are buffered while waiting for earlier fetches to
+// complete. Values <= 1 fetch sequentially, which is the default.
+func WithDownloadParallelism(n int) DownloadOption {
+	return func(o *downloadOpts) {
+		o.parallelism = n
+	}
+}
+
+// WithDownloadBundleSize tells DownloadAllLeaves that the log stores its
+// leaves in bundles of n leaves, so that each bundle is fetched only once.
+// The default is 1, i.e. each leaf is stored individually.
+func WithDownloadBundleSize(n uint64) DownloadOption {
+	return func(o *downloadOpts) {
+		o.bundleSize = n
+	}
+}
+
+// WithDownloadVerification enables a strict mode, intended for high-assurance
+// audits, in which DownloadAllLeaves checks that the tree it downloads has the
+// root hash root, i.e. the root hash of a signed checkpoint of the tree.
+//
+// Before any leaves are downloaded, the root is recomputed from the log's
+// tiles, and once all have been downloaded it's recomputed again from the
+// leaves themselves, hashed with h. This catches storage which serves tiles or
+// leaves that look valid but are inconsistent with the signed root. Either
+// mismatch is reported with an ErrRootMismatch.
+//
+// Since the leaves can only be verified once all of them have been
+// downloaded, callers must not trust the leaves passed to the callback unless
+// DownloadAllLeaves returns nil.
+func WithDownloadVerification(h merkle.LogHasher, root []byte) DownloadOption {
+	return func(o *downloadOpts) {
+		o.h = h
+		o.root = root
+	}
+}
+
+// ErrRootMismatch is returned when a root hash recomputed from the contents of
+// a log doesn't match that of its checkpoint.
+type ErrRootMismatch struct {
+	// Source describes what the root hash was recomputed from, e.g. "tiles".
+	Source string
+	// Size is the size of the tree.
+	Size uint64
+	// Want is the root hash of the checkpoint.
+	Want []byte
+	// Got is the recomputed root hash.
+	Got []byte
+}
+
+func (e ErrRootMismatch) Error() string {
+	return fmt.Sprintf("root hash of tree of size %d recomputed from %s is %x, want %x", e.Size, e.Source, e.Got, e.Want)
+}
+
+// DownloadAllLeaves fetches, in order, each of the leaves in a tree of size
+// treeSize, and calls fn with its index and contents.
+// Downloading stops at the first error, either fetching a leaf or returned
+// by fn.
+func DownloadAllLeaves(ctx context.Context, f Fetcher, treeSize uint64, fn func(i uint64, leaf []byte) error, opts ...DownloadOption) error {
+	o := &downloadOpts{parallelism: 1, bundleSize: 1}
+	for _, opt := range opts {
+		opt(o)
+	}
+	var cr *compact.Range
+	if o.h != nil {
+		if err := verifyTileRoot(ctx, f, o.h, treeSize, o.root); err != nil {
+			return err
+		}
+		cr = (&compact.RangeFactory{Hash: o.h.HashChildren}).NewEmptyRange(0)
+	}
+	bundleSize := max(o.bundleSize, 1)
+	numBundles := (treeSize + bundleSize - 1) / bundleSize
+	fetch := func(ctx context.Context, bi uint64) ([][]byte, error) {
+		if bundleSize == 1 {
+			leaf, err := GetLeaf(ctx, f, bi)
+			return [][]byte{leaf}, err
+		}
+		return fetchLeafBundle(ctx, f, bundleSize, treeSize, bi)
+	}
+	err := fetchOrdered(ctx, max(o.parallelism, 1), numBundles, fetch, func(bi uint64, bundle [][]byte) error {
+		first := bi * bundleSize
+		n := min(bundleSize, treeSize-first)
+		if uint64(len(bundle)) < n {
+			return fmt.Errorf("leaf bundle %d has %d entries, want %d", bi, len(bundle), n)
+		}
+		for j, leaf := range bundle[:n] {
+			if cr != nil {
+				if err := cr.Append(o.h.HashLeaf(leaf), nil); err != nil {
+					return err
+				}
+			}
+			if err := fn(first+uint64(j), leaf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || cr == nil || treeSize == 0 {
+		return err
+	}
+	root, err := cr.GetRootHash(nil)
+	if err != nil {
+		return fmt.Errorf("failed to compute root hash from leaves: %w", err)
+	}
+	if !bytes.Equal(root, o.root) {
+		return ErrRootMismatch{Source: "leaves", Size: treeSize, Want: o.root, Got: root}
+	}
+	return nil
+}
+
+// verifyTileRoot recomputes the root hash of the tree of size treeSize from
+// the log's tiles, and checks that it's root.
+func verifyTileRoot(ctx context.Context, f Fetcher, h merkle.LogHasher, treeSize uint64, root []byte) error {
+	got := h.EmptyRoot()
+	if treeSize > 0 {
+		hashes, err := FetchRangeNodes(ctx, treeSize, newTileFetcher(f, treeSize, layout.TileWidth))
+		if err != nil {
+			return fmt.Errorf("failed to fetch range nodes: %w", err)
+		}
+		r, err := (&compact.RangeFactory{Hash: h.HashChildren}).NewRange(0, treeSize, hashes)
+		if err != nil {
+			return err
+		}
+		if got, err = r.GetRootHash(nil); err != nil {
+			return fmt.Errorf("failed to compute root hash from tiles: %w", err)
+		}
+	}
+	if !bytes.Equal(got, root) {
+		return ErrRootMismatch{Source: "tiles", Size: treeSize, Want: root, Got: got}
+	}
+	return nil
+}
+
+// GetRecentLeaves fetches the last n leaves of a tree of size treeSize, and
+// returns them newest first, i.e. the leaf at index treeSize-1 is returned
+// first. If the tree has fewer than n leaves, all of them are returned.
+//
+// bundleSize is the log's configured number of leaves per leaf bundle; logs
+// which don't bundle leaves have a bundleSize of 1. The final bundle of the
+// tree may be partial, and is fetched as such.
+func GetRecentLeaves(ctx context.Context, f Fetcher, treeSize, bundleSize, n uint64) ([][]byte, error) {
+	n = min(n, treeSize)
+	bundleSize = max(bundleSize, 1)
+	leaves := make([][]byte, 0, n)
+	first := treeSize - n
+	for i := treeSize; i > first; {
+		bi := (i - 1) / bundleSize
+		var bundle [][]byte
+		if bundleSize == 1 {
+			leaf, err := GetLeaf(ctx, f, bi)
+			if err != nil {
+				return nil, err
+			}
+			bundle = [][]byte{leaf}
+		} else {
+			var err error
+			if bundle, err = fetchLeafBundle(ctx, f, bundleSize, treeSize, bi); err != nil {
+				return nil, err
+			}
+		}
+		start := bi * bundleSize
+		if want := i - start; uint64(len(bundle)) < want {
+			return nil, fmt.Errorf("leaf bundle %d has %d entries, want at least %d", bi, len(bundle), want)
+		}
+		for ; i > max(start, first); i-- {
+			leaves = append(leaves, bundle[i-1-start])
+		}
+	}
+	return leaves, nil
+}
+
+// fetchOrdered calls fetch for each of the indices [0, n), running up to
+// parallelism fetches concurrently, and calls deliver with each result in index
+// order. Results which complete out of order are held in a reorder buffer of at
+// most parallelism entries until they can be delivered.
+// Stops at the first error returned by fetch or deliver, and cancels any
+// outstanding fetches.
+func fetchOrdered[T any](ctx context.Context, parallelism int, n uint64, fetch func(context.Context, uint64) (T, error), deliver func(uint64, T) error) error {
+	type result struct {
+		v   T
+		err error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// pending holds a channel for each in-flight fetch in index order; its
+	// capacity bounds the number of fetches which are running or buffered.
+	pending := make(chan chan result, parallelism)
+	go func() {
+		defer close(pending)
+		for i := uint64(0); i < n; i++ {
+			c := make(chan result, 1)
+			select {
+			case pending <- c:
+			case <-ctx.Done():
+				return
+			}
+			go func(i uint64) {
+				v, err := fetch(ctx, i)
+				c <- result{v: v, err: err}
+			}(i)
+		}
+	}()
+
+	i := uint64(0)
+	for c := range pending {
+		r := <-c
+		if r.err != nil {
+			return r.err
+		}
+		if err := deliver(i, r.v); err != nil {
+			return err
+		}
+		i++
+	}
+	if i < n {
+		return ctx.Err()
+	}
+	return nil
+}
+
 // LogStateTracker represents a client-side view of a target log's state.
 // This tracker handles verification that updates to the tracked log state are
 // consistent with previously seen states.
 type LogStateTracker struct {
-	Hasher  merkle.LogHasher
+	Hasher merkle.LogHasher
+	// Fetcher is used to retrieve all log resources, i.e. tiles and, unless a
+	// different ConsensusCheckpoint is configured, the checkpoint itself.
 	Fetcher Fetcher
 	// Origin is the expected first line of checkpoints from the log.
-	Origin              string
+	Origin string
+	// ConsensusCheckpoint is used to discover new checkpoints for the log.
 	ConsensusCheckpoint ConsensusCheckpointFunc
 
 	// LatestConsistentRaw holds the raw bytes of the latest proven-consistent
@@ -331,12 +1266,65 @@ type LogStateTracker struct {
 	ProofBuilder *ProofBuilder
 
 	CpSigVerifier note.Verifier
+
+	// CheckpointObserver, if set, is called by Update with the raw bytes of
+	// each newly fetched checkpoint which differs from the latest consistent
+	// checkpoint. This happens before consistency is checked, so observers
+	// see inconsistent checkpoints too.
+	CheckpointObserver CheckpointObserver
+
+	// FreshnessPolicies, if set, are evaluated by Update against each
+	// checkpoint it fetches, including those which are no larger than the
+	// latest consistent checkpoint.
+	FreshnessPolicies []FreshnessPolicy
+	// EnforceFreshness causes Update to fail with ErrStaleCheckpoint, without
+	// updating the tracker's state, if a checkpoint violates any of the
+	// FreshnessPolicies. Otherwise, violations are logged as warnings.
+	EnforceFreshness bool
+
+	// pbOpts are passed to each ProofBuilder created by the tracker.
+	pbOpts []ProofBuilderOption
+	// latestSeen is the time at which LatestConsistent was first fetched.
+	latestSeen time.Time
+	// now returns the current time, and is overridden by tests.
+	now func() time.Time
+}
+
+// IsFrozen returns true if the latest consistent checkpoint seen by the
+// tracker marks the log as frozen, in which case the log will not grow any
+// further.
+func (lst *LogStateTracker) IsFrozen() bool {
+	if lst.CheckpointNote == nil {
+		return false
+	}
+	ext, err := (&log.Checkpoint{}).Unmarshal([]byte(lst.CheckpointNote.Text))
+	if err != nil {
+		return false
+	}
+	return CheckpointFrozen(ext)
 }
 
+// CheckpointObserver is the signature of a function which is informed of
+// checkpoints seen by a LogStateTracker, e.g. to record them for later
+// forensic analysis.
+// If an error is returned, the tracker's Update call will fail.
+type CheckpointObserver func(ctx context.Context, cpRaw []byte) error
+
 // NewLogStateTracker creates a newly initialised tracker.
 // If a serialised LogState representation is provided then this is used as the
 // initial tracked state, otherwise a log state is fetched from the target log.
-func NewLogStateTracker(ctx context.Context, f Fetcher, h merkle.LogHasher, checkpointRaw []byte, nV note.Verifier, origin string, cc ConsensusCheckpointFunc) (LogStateTracker, error) {
+//
+// If cc is nil, UnilateralConsensus will be used with the provided Fetcher, so
+// the checkpoint will be read from layout.CheckpointPath via the same Fetcher
+// as is used for tiles. This allows a single Fetcher to provide caching,
+// retries, authentication, etc. uniformly for all reads made by the tracker.
+//
+// opts are passed to each ProofBuilder the tracker creates, e.g. WithTileWidth
+// for logs whose tiles aren't of the default width.
+func NewLogStateTracker(ctx context.Context, f Fetcher, h merkle.LogHasher, checkpointRaw []byte, nV note.Verifier, origin string, cc ConsensusCheckpointFunc, opts ...ProofBuilderOption) (LogStateTracker, error) {
+	if cc == nil {
+		cc = UnilateralConsensus(f)
+	}
 	ret := LogStateTracker{
 		ConsensusCheckpoint: cc,
 		Fetcher:             f,
@@ -345,15 +1333,17 @@ func NewLogStateTracker(ctx context.Context, f Fetcher, h merkle.LogHasher, chec
 		CheckpointNote:      nil,
 		CpSigVerifier:       nV,
 		Origin:              origin,
+		pbOpts:              opts,
 	}
 	if len(checkpointRaw) > 0 {
 		ret.LatestConsistentRaw = checkpointRaw
-		cp, _, _, err := log.ParseCheckpoint(checkpointRaw, origin, nV)
+		cp, _, cn, err := ParseCheckpoint(checkpointRaw, origin, nV)
 		if err != nil {
 			return ret, err
 		}
-		ret.LatestConsistent = *cp
-		ret.ProofBuilder, err = NewProofBuilder(ctx, ret.LatestConsistent, ret.Hasher.HashChildren, ret.Fetcher)
+		ret.LatestConsistent, ret.CheckpointNote = *cp, cn
+		ret.latestSeen = ret.clock()
+		ret.ProofBuilder, err = NewProofBuilder(ctx, ret.LatestConsistent, ret.Hasher.HashChildren, ret.Fetcher, ret.pbOpts...)
 		if err != nil {
 			return ret, fmt.Errorf("NewProofBuilder: %v", err)
 		}
@@ -384,6 +1374,24 @@ func (e ErrInconsistency) Error() string {
 	return fmt.Sprintf("log consistency check failed: %s", e.Wrapped)
 }
 
+// ErrOriginMismatch is returned when a log presents a checkpoint whose origin
+// differs from the expected origin, or from the origin of checkpoints
+// previously seen from it.
+// This may indicate that requests are being misrouted, or that a different
+// log has been substituted for the expected one.
+type ErrOriginMismatch struct {
+	// Want is the origin pinned by the tracker.
+	Want string
+	// Got is the origin of the offending checkpoint.
+	Got string
+	// Raw is the offending checkpoint as returned by the log.
+	Raw []byte
+}
+
+func (e ErrOriginMismatch) Error() string {
+	return fmt.Sprintf("checkpoint origin %q does not match expected origin %q", e.Got, e.Want)
+}
+
 // Update attempts to update the local view of the target log's state.
 // If a more recent logstate is found, this method will attempt to prove
 // that it is consistent with the local state before updating the tracker's
@@ -391,12 +1399,31 @@ func (e ErrInconsistency) Error() string {
 // Returns the old checkpoint, consistency proof, and newer checkpoint used to update.
 // If the LatestConsistent checkpoint is 0 sized, no consistency proof will be returned
 // since it would be meaningless to do so.
+//
+// The origin of the first checkpoint seen by the tracker is pinned, and an
+// ErrOriginMismatch is returned if any subsequent checkpoint has a different origin.
 func (lst *LogStateTracker) Update(ctx context.Context) ([]byte, [][]byte, []byte, error) {
 	c, cRaw, cn, err := lst.ConsensusCheckpoint(ctx, lst.CpSigVerifier, lst.Origin)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	builder, err := NewProofBuilder(ctx, *c, lst.Hasher.HashChildren, lst.Fetcher)
+	if lst.CheckpointObserver != nil && !bytes.Equal(cRaw, lst.LatestConsistentRaw) {
+		if err := lst.CheckpointObserver(ctx, cRaw); err != nil {
+			return nil, nil, nil, fmt.Errorf("checkpoint observer failed: %w", err)
+		}
+	}
+	wantOrigin := lst.Origin
+	if len(lst.LatestConsistentRaw) > 0 {
+		wantOrigin = lst.LatestConsistent.Origin
+	}
+	if c.Origin != wantOrigin {
+		return nil, nil, nil, ErrOriginMismatch{Want: wantOrigin, Got: c.Origin, Raw: cRaw}
+	}
+	seen := lst.clock()
+	if err := lst.checkFreshness(*c, cRaw, cn, seen); err != nil {
+		return nil, nil, nil, err
+	}
+	builder, err := NewProofBuilder(ctx, *c, lst.Hasher.HashChildren, lst.Fetcher, lst.pbOpts...)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to create proof builder: %w", err)
 	}
@@ -405,58 +1432,132 @@ func (lst *LogStateTracker) Update(ctx context.Context) ([]byte, [][]byte, []byt
 		if c.Size <= lst.LatestConsistent.Size {
 			return lst.LatestConsistentRaw, p, lst.LatestConsistentRaw, nil
 		}
-		p, err = builder.ConsistencyProof(ctx, lst.LatestConsistent.Size, c.Size)
+		p, err = proveConsistency(ctx, lst.Hasher, builder, lst.LatestConsistent, lst.LatestConsistentRaw, *c, cRaw)
 		if err != nil {
 			return nil, nil, nil, err
 		}
-		if err := proof.VerifyConsistency(lst.Hasher, lst.LatestConsistent.Size, c.Size, p, lst.LatestConsistent.Hash, c.Hash); err != nil {
-			return nil, nil, nil, ErrInconsistency{
-				SmallerRaw: lst.LatestConsistentRaw,
-				LargerRaw:  cRaw,
-				Proof:      p,
-				Wrapped:    err,
-			}
-		}
 		// Update is consistent,
 
 	}
 	oldRaw := lst.LatestConsistentRaw
 	lst.LatestConsistentRaw, lst.LatestConsistent, lst.CheckpointNote = cRaw, *c, cn
 	lst.ProofBuilder = builder
+	lst.latestSeen = seen
 	return oldRaw, p, lst.LatestConsistentRaw, nil
 }
 
-// CheckConsistency is a wapper function which simplifies verifying consistency between two or more checkpoints.
-func CheckConsistency(ctx context.Context, h merkle.LogHasher, f Fetcher, cp []log.Checkpoint) error {
-	if l := len(cp); l < 2 {
-		return fmt.Errorf("passed %d checkpoints, need at least 2", l)
+// checkFreshness evaluates the tracker's FreshnessPolicies against the newly
+// fetched checkpoint c, and returns ErrStaleCheckpoint for the first policy
+// violated if freshness is enforced.
+func (lst *LogStateTracker) checkFreshness(c log.Checkpoint, cRaw []byte, cn *note.Note, seen time.Time) error {
+	if len(lst.FreshnessPolicies) == 0 {
+		return nil
 	}
-	sort.Slice(cp, func(i, j int) bool {
-		return cp[i].Size < cp[j].Size
-	})
-	pb, err := NewProofBuilder(ctx, cp[len(cp)-1], h.HashChildren, f)
+	var prev FreshnessState
+	if len(lst.LatestConsistentRaw) > 0 {
+		prev = freshnessState(lst.LatestConsistent, lst.CheckpointNote, lst.latestSeen)
+	}
+	cur := freshnessState(c, cn, seen)
+	for _, p := range lst.FreshnessPolicies {
+		err := p.CheckFreshness(prev, cur)
+		if err == nil {
+			continue
+		}
+		if lst.EnforceFreshness {
+			return ErrStaleCheckpoint{Raw: cRaw, Err: err}
+		}
+		klog.Warningf("Checkpoint for %q violates freshness policy: %v", lst.Origin, err)
+	}
+	return nil
+}
+
+// clock returns the current time.
+func (lst *LogStateTracker) clock() time.Time {
+	if lst.now != nil {
+		return lst.now()
+	}
+	return time.Now()
+}
+
+// proveConsistency fetches and verifies a consistency proof between the
+// smaller and larger checkpoints, using pb which must be a ProofBuilder for
+// the larger checkpoint. Returns ErrInconsistency if the proof doesn't verify.
+func proveConsistency(ctx context.Context, h merkle.LogHasher, pb *ProofBuilder, smaller log.Checkpoint, smallerRaw []byte, larger log.Checkpoint, largerRaw []byte) ([][]byte, error) {
+	p, err := pb.ConsistencyProof(ctx, smaller.Size, larger.Size)
 	if err != nil {
-		return fmt.Errorf("failed to create proofbuilder: %v", err)
+		return nil, err
 	}
+	if err := proof.VerifyConsistency(h, smaller.Size, larger.Size, p, smaller.Hash, larger.Hash); err != nil {
+		return nil, ErrInconsistency{
+			SmallerRaw: smallerRaw,
+			LargerRaw:  largerRaw,
+			Proof:      p,
+			Wrapped:    err,
+		}
+	}
+	return p, nil
+}
 
-	// Go through list of checkpoints pairwise, checking consistency.
-	a, b := cp[0], cp[1]
-	for i := 0; i < len(cp)-1; i, a, b = i+1, cp[i], cp[i+1] {
-		if a.Size == b.Size {
-			if bytes.Equal(a.Hash, b.Hash) {
-				continue
+// VerifyUpdate checks that newRaw is a checkpoint from the log which is
+// validly signed, and consistent with the trusted checkpoint oldRaw, and
+// returns the parsed new checkpoint.
+//
+// This is a stateless equivalent of LogStateTracker.Update, for monitors
+// which hold on to a trusted checkpoint themselves. The new checkpoint must
+// not be smaller than the old one, and a checkpoint of the same size must
+// have the same root hash; ErrInconsistency is returned if the checkpoints
+// are provably inconsistent.
+func VerifyUpdate(ctx context.Context, f Fetcher, h merkle.LogHasher, v note.Verifier, origin string, oldRaw, newRaw []byte) (*log.Checkpoint, error) {
+	oldCP, _, _, err := ParseCheckpoint(oldRaw, origin, v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse old checkpoint: %w", err)
+	}
+	newCP, _, _, err := ParseCheckpoint(newRaw, origin, v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse new checkpoint: %w", err)
+	}
+	switch {
+	case newCP.Size < oldCP.Size:
+		return nil, fmt.Errorf("new checkpoint size %d is smaller than old checkpoint size %d", newCP.Size, oldCP.Size)
+	case newCP.Size == oldCP.Size:
+		if !bytes.Equal(newCP.Hash, oldCP.Hash) {
+			return nil, ErrInconsistency{
+				SmallerRaw: oldRaw,
+				LargerRaw:  newRaw,
+				Wrapped:    fmt.Errorf("checkpoints of size %d have different root hashes", newCP.Size),
 			}
-			return fmt.Errorf("two checkpoints with same size (%d) but different hashes (%x vs %x)", a.Size, a.Hash, b.Hash)
 		}
-		if a.Size > 0 {
-			cp, err := pb.ConsistencyProof(ctx, a.Size, b.Size)
-			if err != nil {
-				return fmt.Errorf("failed to fetch consistency between sizes %d, %d: %v", a.Size, b.Size, err)
-			}
-			if err := proof.VerifyConsistency(h, a.Size, b.Size, cp, a.Hash, b.Hash); err != nil {
-				return fmt.Errorf("invalid consistency proof between sizes %d, %d: %v", a.Size, b.Size, err)
-			}
+		return newCP, nil
+	case oldCP.Size == 0 && !bytes.Equal(oldCP.Hash, h.EmptyRoot()):
+		return nil, ErrInconsistency{
+			SmallerRaw: oldRaw,
+			LargerRaw:  newRaw,
+			Wrapped:    fmt.Errorf("checkpoint of size 0 has hash %x, want the empty root hash %x", oldCP.Hash, h.EmptyRoot()),
 		}
 	}
-	return nil
+	// Creating the proof builder checks that newCP's root hash matches the
+	// log's tiles.
+	pb, err := NewProofBuilder(ctx, *newCP, h.HashChildren, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proof builder: %w", err)
+	}
+	if oldCP.Size == 0 {
+		// Every tree is consistent with the empty tree, so there's no proof to fetch.
+		return newCP, nil
+	}
+	if _, err := proveConsistency(ctx, h, pb, *oldCP, oldRaw, *newCP, newRaw); err != nil {
+		return nil, err
+	}
+	return newCP, nil
+}
+
+// CheckConsistency is a wapper function which simplifies verifying consistency between two or more checkpoints.
+func CheckConsistency(ctx context.Context, h merkle.LogHasher, f Fetcher, cp []log.Checkpoint) error {
+	if l := len(cp); l < 2 {
+		return fmt.Errorf("passed %d checkpoints, need at least 2", l)
+	}
+	sort.Slice(cp, func(i, j int) bool {
+		return cp[i].Size < cp[j].Size
+	})
+	return CheckConsistencyRange(ctx, h, f, cp)
 }
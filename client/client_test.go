@@ -17,17 +17,25 @@ package client
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/transparency-dev/formats/log"
 	"github.com/transparency-dev/merkle/compact"
+	"github.com/transparency-dev/merkle/proof"
 	"github.com/transparency-dev/merkle/rfc6962"
 	"github.com/transparency-dev/serverless-log/api"
+	"github.com/transparency-dev/serverless-log/api/layout"
 	"golang.org/x/mod/sumdb/note"
 )
 
@@ -200,6 +208,857 @@ func TestCheckLogStateTracker(t *testing.T) {
 	}
 }
 
+func TestLogStateTrackerFetchesCheckpointViaFetcher(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+
+	shim := fetchCheckpointShim{Checkpoints: testRawCheckpoints}
+	counts := make(map[string]int)
+	deleg := shim.Fetcher(testLogFetcher)
+	f := func(ctx context.Context, p string) ([]byte, error) {
+		counts[p]++
+		return deleg(ctx, p)
+	}
+
+	// Don't provide an initial checkpoint or consensus func, so the tracker
+	// must fetch the checkpoint itself.
+	lst, err := NewLogStateTracker(ctx, f, h, nil, testLogVerifier, testOrigin, nil)
+	if err != nil {
+		t.Fatalf("NewLogStateTracker: %v", err)
+	}
+	if got, want := counts[layout.CheckpointPath], 1; got != want {
+		t.Errorf("Got %d fetches of %q after NewLogStateTracker, want %d", got, layout.CheckpointPath, want)
+	}
+
+	shim.Advance()
+	if _, _, _, err := lst.Update(ctx); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, want := counts[layout.CheckpointPath], 2; got != want {
+		t.Errorf("Got %d fetches of %q after Update, want %d", got, layout.CheckpointPath, want)
+	}
+	if got, want := lst.LatestConsistentRaw, testRawCheckpoints[1]; !bytes.Equal(got, want) {
+		t.Errorf("Got LatestConsistentRaw %q, want %q", got, want)
+	}
+	if len(counts) < 2 {
+		t.Errorf("Got fetches for %v, expected tile fetches via the same fetcher too", counts)
+	}
+}
+
+func TestLogStateTrackerCheckpointObserver(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+
+	shim := fetchCheckpointShim{Checkpoints: testRawCheckpoints}
+	f := shim.Fetcher(testLogFetcher)
+	var seen [][]byte
+	lst, err := NewLogStateTracker(ctx, f, h, testRawCheckpoints[0], testLogVerifier, testOrigin, UnilateralConsensus(f))
+	if err != nil {
+		t.Fatalf("NewLogStateTracker: %v", err)
+	}
+	lst.CheckpointObserver = func(_ context.Context, cpRaw []byte) error {
+		seen = append(seen, cpRaw)
+		return nil
+	}
+
+	// The first update returns the same checkpoint we started with, so
+	// shouldn't be observed.
+	for i := 0; i < 3; i++ {
+		if _, _, _, err := lst.Update(ctx); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		shim.Advance()
+	}
+	if diff := cmp.Diff(testRawCheckpoints[1:3], seen); diff != "" {
+		t.Errorf("Observed checkpoints diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestLogStateTrackerRejectsOriginChange(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+
+	substituted := testCheckpoints[1]
+	substituted.Origin = "example.com/substituted"
+	cc := func(_ context.Context, _ note.Verifier, _ string) (*log.Checkpoint, []byte, *note.Note, error) {
+		return &substituted, []byte("substituted"), nil, nil
+	}
+	lst, err := NewLogStateTracker(ctx, testLogFetcher, h, testRawCheckpoints[0], testLogVerifier, testOrigin, cc)
+	if err != nil {
+		t.Fatalf("NewLogStateTracker: %v", err)
+	}
+	_, _, _, err = lst.Update(ctx)
+	var e ErrOriginMismatch
+	if !errors.As(err, &e) {
+		t.Fatalf("Update: got err %v, want ErrOriginMismatch", err)
+	}
+	if e.Want != testOrigin || e.Got != substituted.Origin {
+		t.Errorf("Got ErrOriginMismatch{Want: %q, Got: %q}, want {%q, %q}", e.Want, e.Got, testOrigin, substituted.Origin)
+	}
+	if got, want := lst.LatestConsistentRaw, testRawCheckpoints[0]; !bytes.Equal(got, want) {
+		t.Errorf("Tracker state changed after origin mismatch")
+	}
+}
+
+func TestLogStateTrackerFreshness(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	now := time.Unix(1700000000, 0)
+
+	// next is the checkpoint, and its publication time, returned by cc.
+	var next log.Checkpoint
+	var published time.Time
+	cc := func(_ context.Context, _ note.Verifier, _ string) (*log.Checkpoint, []byte, *note.Note, error) {
+		text := string(next.Marshal()) + TimestampExtension(published)
+		cp := next
+		return &cp, []byte(text), &note.Note{Text: text}, nil
+	}
+	lst, err := NewLogStateTracker(ctx, testLogFetcher, h, testRawCheckpoints[1], testLogVerifier, testOrigin, cc)
+	if err != nil {
+		t.Fatalf("NewLogStateTracker: %v", err)
+	}
+	lst.now = func() time.Time { return now }
+	lst.latestSeen = now
+	lst.FreshnessPolicies = []FreshnessPolicy{MaxCheckpointAge(time.Hour)}
+	lst.EnforceFreshness = true
+
+	next, published = testCheckpoints[2], now.Add(-10*time.Minute)
+	if _, _, _, err := lst.Update(ctx); err != nil {
+		t.Fatalf("Update with fresh checkpoint: %v", err)
+	}
+	if got, want := lst.LatestConsistent.Size, testCheckpoints[2].Size; got != want {
+		t.Fatalf("Got size %d after fresh checkpoint, want %d", got, want)
+	}
+
+	next, published = testCheckpoints[3], now.Add(-2*time.Hour)
+	if _, _, _, err := lst.Update(ctx); !errors.As(err, &ErrStaleCheckpoint{}) {
+		t.Fatalf("Update with stale checkpoint: got err %v, want ErrStaleCheckpoint", err)
+	}
+	if got, want := lst.LatestConsistent.Size, testCheckpoints[2].Size; got != want {
+		t.Errorf("Got size %d after enforced stale checkpoint, want unchanged %d", got, want)
+	}
+
+	// Without enforcement, violations are only logged.
+	lst.EnforceFreshness = false
+	if _, _, _, err := lst.Update(ctx); err != nil {
+		t.Fatalf("Update with unenforced stale checkpoint: %v", err)
+	}
+	if got, want := lst.LatestConsistent.Size, testCheckpoints[3].Size; got != want {
+		t.Errorf("Got size %d after unenforced stale checkpoint, want %d", got, want)
+	}
+}
+
+func TestLogStateTrackerIsFrozen(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+
+	cc := func(_ context.Context, _ note.Verifier, _ string) (*log.Checkpoint, []byte, *note.Note, error) {
+		text := string(testCheckpoints[2].Marshal()) + FrozenExtension()
+		cp := testCheckpoints[2]
+		return &cp, []byte(text), &note.Note{Text: text}, nil
+	}
+	lst, err := NewLogStateTracker(ctx, testLogFetcher, h, testRawCheckpoints[1], testLogVerifier, testOrigin, cc)
+	if err != nil {
+		t.Fatalf("NewLogStateTracker: %v", err)
+	}
+	if lst.IsFrozen() {
+		t.Error("IsFrozen = true before seeing frozen checkpoint, want false")
+	}
+	if _, _, _, err := lst.Update(ctx); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if !lst.IsFrozen() {
+		t.Error("IsFrozen = false after seeing frozen checkpoint, want true")
+	}
+}
+
+func TestFreshnessPolicies(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	state := func(size uint64, ts, seen time.Time) FreshnessState {
+		return FreshnessState{Checkpoint: log.Checkpoint{Size: size}, Timestamp: ts, Seen: seen}
+	}
+	for _, test := range []struct {
+		desc      string
+		policy    FreshnessPolicy
+		prev, cur FreshnessState
+		wantErr   bool
+	}{
+		{
+			desc:   "max age: fresh",
+			policy: MaxCheckpointAge(time.Hour),
+			cur:    state(10, start, start.Add(time.Hour)),
+		}, {
+			desc:    "max age: stale",
+			policy:  MaxCheckpointAge(time.Hour),
+			cur:     state(10, start, start.Add(time.Hour+time.Second)),
+			wantErr: true,
+		}, {
+			desc:    "max age: no timestamp",
+			policy:  MaxCheckpointAge(time.Hour),
+			cur:     state(10, time.Time{}, start),
+			wantErr: true,
+		}, {
+			desc:   "max age: frozen",
+			policy: MaxCheckpointAge(time.Hour),
+			cur:    FreshnessState{Checkpoint: log.Checkpoint{Size: 10}, Timestamp: start, Seen: start.Add(48 * time.Hour), Frozen: true},
+		}, {
+			desc:   "growth: no previous checkpoint",
+			policy: MinGrowthRate(10, time.Minute),
+			cur:    state(10, time.Time{}, start),
+		}, {
+			desc:   "growth: within first period",
+			policy: MinGrowthRate(10, time.Minute),
+			prev:   state(10, time.Time{}, start),
+			cur:    state(10, time.Time{}, start.Add(59*time.Second)),
+		}, {
+			desc:   "growth: fast enough",
+			policy: MinGrowthRate(10, time.Minute),
+			prev:   state(10, time.Time{}, start),
+			cur:    state(30, time.Time{}, start.Add(2*time.Minute)),
+		}, {
+			desc:    "growth: too slow",
+			policy:  MinGrowthRate(10, time.Minute),
+			prev:    state(10, time.Time{}, start),
+			cur:     state(29, time.Time{}, start.Add(2*time.Minute)),
+			wantErr: true,
+		}, {
+			desc:    "growth: stalled",
+			policy:  MinGrowthRate(1, time.Minute),
+			prev:    state(10, time.Time{}, start),
+			cur:     state(10, time.Time{}, start.Add(time.Minute)),
+			wantErr: true,
+		}, {
+			desc:   "growth: frozen",
+			policy: MinGrowthRate(1, time.Minute),
+			prev:   FreshnessState{Checkpoint: log.Checkpoint{Size: 10}, Seen: start, Frozen: true},
+			cur:    FreshnessState{Checkpoint: log.Checkpoint{Size: 10}, Seen: start.Add(time.Hour), Frozen: true},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			err := test.policy.CheckFreshness(test.prev, test.cur)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("CheckFreshness: got err %v, wantErr %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSize(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		desc     string
+		cp       []byte
+		origin   string
+		wantSize uint64
+		wantErr  bool
+	}{
+		{
+			desc:     "ok",
+			cp:       testRawCheckpoints[5],
+			origin:   testOrigin,
+			wantSize: testCheckpoints[5].Size,
+		}, {
+			desc:    "wrong origin",
+			cp:      testRawCheckpoints[5],
+			origin:  "example.com/other",
+			wantErr: true,
+		}, {
+			desc:    "missing checkpoint",
+			origin:  testOrigin,
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			shim := fetchCheckpointShim{}
+			if test.cp != nil {
+				shim.Checkpoints = [][]byte{test.cp}
+			}
+			got, err := Size(ctx, shim.Fetcher(testLogFetcher), testLogVerifier, test.origin)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("Size: got err %v, want err %t", err, test.wantErr)
+			}
+			if got != test.wantSize {
+				t.Errorf("Size: got %d, want %d", got, test.wantSize)
+			}
+		})
+	}
+}
+
+func TestDownloadAllLeaves(t *testing.T) {
+	ctx := context.Background()
+	cp := testCheckpoints[len(testCheckpoints)-1]
+
+	var got []uint64
+	err := DownloadAllLeaves(ctx, testLogFetcher, cp.Size, func(i uint64, leaf []byte) error {
+		want, err := GetLeaf(ctx, testLogFetcher, i)
+		if err != nil {
+			t.Fatalf("GetLeaf(%d): %v", i, err)
+		}
+		if !bytes.Equal(leaf, want) {
+			t.Errorf("Leaf %d: got %x, want %x", i, leaf, want)
+		}
+		got = append(got, i)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DownloadAllLeaves: %v", err)
+	}
+	if l := uint64(len(got)); l != cp.Size {
+		t.Fatalf("Got %d leaves, want %d", l, cp.Size)
+	}
+	for i, idx := range got {
+		if idx != uint64(i) {
+			t.Fatalf("Got leaf %d at position %d, want leaves in order", idx, i)
+		}
+	}
+
+	// Errors from the callback should stop the download.
+	wantErr := errors.New("stop")
+	calls := 0
+	err = DownloadAllLeaves(ctx, testLogFetcher, cp.Size, func(uint64, []byte) error {
+		calls++
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) || calls != 1 {
+		t.Errorf("DownloadAllLeaves with failing callback: got %v after %d calls, want %v after 1 call", err, calls, wantErr)
+	}
+}
+
+func TestDownloadAllLeavesParallel(t *testing.T) {
+	ctx := context.Background()
+	cp := testCheckpoints[len(testCheckpoints)-1]
+	const bundleSize = 4
+	bundledF, want := bundledTestLogFetcher(t, bundleSize)
+
+	// slow delays fetches of earlier leaves and bundles for longer, so that
+	// concurrent fetches complete out of order.
+	slow := func(f Fetcher) Fetcher {
+		return func(ctx context.Context, p string) ([]byte, error) {
+			if strings.HasPrefix(p, "seq/") {
+				i, err := layout.SeqFromPath("", p)
+				if err == nil {
+					time.Sleep(time.Duration(cp.Size-i) * time.Millisecond)
+				}
+			}
+			return f(ctx, p)
+		}
+	}
+
+	for _, test := range []struct {
+		desc string
+		f    Fetcher
+		opts []DownloadOption
+	}{
+		{
+			desc: "unbundled",
+			f:    slow(testLogFetcher),
+			opts: []DownloadOption{WithDownloadParallelism(4)},
+		}, {
+			desc: "bundled",
+			f:    slow(bundledF),
+			opts: []DownloadOption{WithDownloadParallelism(3), WithDownloadBundleSize(bundleSize)},
+		}, {
+			desc: "bundled sequential",
+			f:    bundledF,
+			opts: []DownloadOption{WithDownloadBundleSize(bundleSize)},
+		}, {
+			desc: "more parallelism than leaves",
+			f:    slow(testLogFetcher),
+			opts: []DownloadOption{WithDownloadParallelism(100)},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			var got [][]byte
+			err := DownloadAllLeaves(ctx, test.f, cp.Size, func(i uint64, leaf []byte) error {
+				if i != uint64(len(got)) {
+					t.Fatalf("Got leaf %d, want leaf %d", i, len(got))
+				}
+				got = append(got, leaf)
+				return nil
+			}, test.opts...)
+			if err != nil {
+				t.Fatalf("DownloadAllLeaves: %v", err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Leaves diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// A failed fetch should stop the download, after delivering all earlier leaves.
+	const failAt = 7
+	failing := func(ctx context.Context, p string) ([]byte, error) {
+		if p == filepath.Join(layout.SeqPath("", failAt)) {
+			return nil, os.ErrNotExist
+		}
+		return testLogFetcher(ctx, p)
+	}
+	calls := 0
+	err := DownloadAllLeaves(ctx, failing, cp.Size, func(uint64, []byte) error {
+		calls++
+		return nil
+	}, WithDownloadParallelism(4))
+	if !errors.Is(err, os.ErrNotExist) || calls != failAt {
+		t.Errorf("DownloadAllLeaves with failing fetch: got %v after %d calls, want %v after %d calls", err, calls, os.ErrNotExist, failAt)
+	}
+}
+
+func TestDownloadAllLeavesVerification(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	cp := testCheckpoints[len(testCheckpoints)-1]
+	const bundleSize = 4
+	bundledF, _ := bundledTestLogFetcher(t, bundleSize)
+	tamperedF := func(ctx context.Context, p string) ([]byte, error) {
+		if p == filepath.Join(layout.SeqPath("", 3)) {
+			return []byte("tampered"), nil
+		}
+		return testLogFetcher(ctx, p)
+	}
+
+	for _, test := range []struct {
+		desc       string
+		f          Fetcher
+		size       uint64
+		root       []byte
+		opts       []DownloadOption
+		wantSource string
+	}{
+		{
+			desc: "valid",
+			f:    testLogFetcher,
+			size: cp.Size,
+			root: cp.Hash,
+		}, {
+			desc: "valid bundled",
+			f:    bundledF,
+			size: cp.Size,
+			root: cp.Hash,
+			opts: []DownloadOption{WithDownloadBundleSize(bundleSize), WithDownloadParallelism(3)},
+		}, {
+			desc: "empty tree",
+			f:    testLogFetcher,
+			size: 0,
+			root: h.EmptyRoot(),
+		}, {
+			desc:       "wrong root",
+			f:          testLogFetcher,
+			size:       cp.Size,
+			root:       h.HashLeaf([]byte("wrong")),
+			wantSource: "tiles",
+		}, {
+			desc:       "tampered leaf",
+			f:          tamperedF,
+			size:       cp.Size,
+			root:       cp.Hash,
+			wantSource: "leaves",
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			opts := append(test.opts, WithDownloadVerification(h, test.root))
+			err := DownloadAllLeaves(ctx, test.f, test.size, func(uint64, []byte) error { return nil }, opts...)
+			if test.wantSource == "" {
+				if err != nil {
+					t.Fatalf("DownloadAllLeaves: %v", err)
+				}
+				return
+			}
+			var e ErrRootMismatch
+			if !errors.As(err, &e) {
+				t.Fatalf("DownloadAllLeaves: got %v, want ErrRootMismatch", err)
+			}
+			if e.Source != test.wantSource {
+				t.Errorf("Got mismatch from %q, want %q", e.Source, test.wantSource)
+			}
+		})
+	}
+}
+
+func TestReadAllLimited(t *testing.T) {
+	for _, test := range []struct {
+		desc    string
+		size    int
+		limit   int64
+		wantErr bool
+	}{
+		{desc: "empty", size: 0, limit: 10},
+		{desc: "under limit", size: 9, limit: 10},
+		{desc: "at limit", size: 10, limit: 10},
+		{desc: "over limit", size: 11, limit: 10, wantErr: true},
+		{desc: "far over limit", size: 1 << 20, limit: 10, wantErr: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			want := bytes.Repeat([]byte("a"), test.size)
+			got, err := ReadAllLimited(bytes.NewReader(want), test.limit)
+			if test.wantErr {
+				var e ErrResponseTooLarge
+				if !errors.As(err, &e) || e.Limit != test.limit {
+					t.Fatalf("ReadAllLimited: got %v, want ErrResponseTooLarge{%d}", err, test.limit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadAllLimited: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Got %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestGetRecentLeaves(t *testing.T) {
+	ctx := context.Background()
+	size := testCheckpoints[len(testCheckpoints)-1].Size
+	const bundleSize = 4
+	bundledF, all := bundledTestLogFetcher(t, bundleSize)
+	newestFirst := func(n uint64) [][]byte {
+		var r [][]byte
+		for i := size; i > size-n; i-- {
+			r = append(r, all[i-1])
+		}
+		return r
+	}
+
+	for _, test := range []struct {
+		desc       string
+		f          Fetcher
+		treeSize   uint64
+		bundleSize uint64
+		n          uint64
+		want       [][]byte
+	}{
+		{
+			desc:       "unbundled",
+			f:          testLogFetcher,
+			treeSize:   size,
+			bundleSize: 1,
+			n:          5,
+			want:       newestFirst(5),
+		}, {
+			desc:       "within partial bundle",
+			f:          bundledF,
+			treeSize:   size,
+			bundleSize: bundleSize,
+			n:          size % bundleSize,
+			want:       newestFirst(size % bundleSize),
+		}, {
+			desc:       "spanning bundles",
+			f:          bundledF,
+			treeSize:   size,
+			bundleSize: bundleSize,
+			n:          2*bundleSize + 1,
+			want:       newestFirst(2*bundleSize + 1),
+		}, {
+			desc:       "more than tree size",
+			f:          bundledF,
+			treeSize:   size,
+			bundleSize: bundleSize,
+			n:          size + 10,
+			want:       newestFirst(size),
+		}, {
+			desc:       "none",
+			f:          bundledF,
+			treeSize:   size,
+			bundleSize: bundleSize,
+			n:          0,
+			want:       [][]byte{},
+		}, {
+			desc:       "empty tree",
+			f:          bundledF,
+			treeSize:   0,
+			bundleSize: bundleSize,
+			n:          3,
+			want:       [][]byte{},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := GetRecentLeaves(ctx, test.f, test.treeSize, test.bundleSize, test.n)
+			if err != nil {
+				t.Fatalf("GetRecentLeaves: %v", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Leaves diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetLeafByHash(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	cp := testCheckpoints[len(testCheckpoints)-1]
+
+	for _, i := range []uint64{0, cp.Size / 2, cp.Size - 1} {
+		want, err := GetLeaf(ctx, testLogFetcher, i)
+		if err != nil {
+			t.Fatalf("GetLeaf(%d): %v", i, err)
+		}
+		gotIdx, got, err := GetLeafByHash(ctx, testLogFetcher, h, 1, cp.Size, h.HashLeaf(want))
+		if err != nil {
+			t.Fatalf("GetLeafByHash(%d): %v", i, err)
+		}
+		if gotIdx != i || !bytes.Equal(got, want) {
+			t.Errorf("GetLeafByHash = %d, %x, want %d, %x", gotIdx, got, i, want)
+		}
+	}
+
+	if _, _, err := GetLeafByHash(ctx, testLogFetcher, h, 1, cp.Size, h.HashLeaf([]byte("unknown"))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetLeafByHash(unknown) = %v, want os.ErrNotExist", err)
+	}
+
+	// A leaf served with the wrong content should be detected.
+	leaf0, err := GetLeaf(ctx, testLogFetcher, 0)
+	if err != nil {
+		t.Fatalf("GetLeaf(0): %v", err)
+	}
+	seq0 := filepath.Join(layout.SeqPath("", 0))
+	tampered := func(ctx context.Context, p string) ([]byte, error) {
+		if p == seq0 {
+			return []byte("tampered"), nil
+		}
+		return testLogFetcher(ctx, p)
+	}
+	if _, _, err := GetLeafByHash(ctx, tampered, h, 1, cp.Size, h.HashLeaf(leaf0)); !errors.As(err, &ErrLeafHashMismatch{}) {
+		t.Errorf("GetLeafByHash(tampered) = %v, want ErrLeafHashMismatch", err)
+	}
+}
+
+func TestVerifyLeavesPresent(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	cp := testCheckpoints[len(testCheckpoints)-1]
+	pb, err := NewProofBuilder(ctx, cp, h.HashChildren, testLogFetcher)
+	if err != nil {
+		t.Fatalf("NewProofBuilder: %v", err)
+	}
+	var hashes [][]byte
+	var want []uint64
+	for _, i := range []uint64{cp.Size - 1, 0, cp.Size / 2} {
+		leaf, err := GetLeaf(ctx, testLogFetcher, i)
+		if err != nil {
+			t.Fatalf("GetLeaf(%d): %v", i, err)
+		}
+		hashes = append(hashes, h.HashLeaf(leaf))
+		want = append(want, i)
+	}
+
+	got, err := VerifyLeavesPresent(ctx, testLogFetcher, pb, hashes)
+	if err != nil {
+		t.Fatalf("VerifyLeavesPresent: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("VerifyLeavesPresent diff (-want +got):\n%s", diff)
+	}
+
+	// An unknown leaf should be reported as missing, alongside the others.
+	withUnknown := [][]byte{hashes[0], h.HashLeaf([]byte("unknown")), hashes[1]}
+	got, err = VerifyLeavesPresent(ctx, testLogFetcher, pb, withUnknown)
+	var errMissing ErrLeavesMissing
+	if !errors.As(err, &errMissing) || !cmp.Equal(errMissing.Missing, []int{1}) {
+		t.Fatalf("VerifyLeavesPresent(unknown) = %v, want ErrLeavesMissing for position 1", err)
+	}
+	if diff := cmp.Diff([]uint64{want[0], 0, want[1]}, got); diff != "" {
+		t.Errorf("VerifyLeavesPresent(unknown) diff (-want +got):\n%s", diff)
+	}
+
+	// Leaves beyond the ProofBuilder's checkpoint are missing from that tree.
+	smallCP := testCheckpoints[1]
+	smallPB, err := NewProofBuilder(ctx, smallCP, h.HashChildren, testLogFetcher)
+	if err != nil {
+		t.Fatalf("NewProofBuilder: %v", err)
+	}
+	if _, err := VerifyLeavesPresent(ctx, testLogFetcher, smallPB, hashes[:1]); !errors.As(err, &errMissing) || !cmp.Equal(errMissing.Missing, []int{0}) {
+		t.Errorf("VerifyLeavesPresent(outside tree) = %v, want ErrLeavesMissing for position 0", err)
+	}
+
+	// A leaf hash mapped to the wrong index must fail to verify.
+	leafPath := filepath.Join(layout.LeafPath("", hashes[1]))
+	misindexed := func(ctx context.Context, p string) ([]byte, error) {
+		if p == leafPath {
+			return []byte(strconv.FormatUint(want[2], 16)), nil
+		}
+		return testLogFetcher(ctx, p)
+	}
+	if _, err := VerifyLeavesPresent(ctx, misindexed, pb, hashes); err == nil || errors.As(err, &errMissing) {
+		t.Errorf("VerifyLeavesPresent(misindexed) = %v, want inclusion failure", err)
+	}
+}
+
+func TestFindLeafIndex(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	cp := testCheckpoints[len(testCheckpoints)-1]
+	// Only serve tiles and checkpoints, as a tile-only log would.
+	tilesOnly := func(ctx context.Context, p string) ([]byte, error) {
+		if strings.HasPrefix(p, "leaves/") || strings.HasPrefix(p, "seq/") {
+			return nil, os.ErrNotExist
+		}
+		return testLogFetcher(ctx, p)
+	}
+	pb, err := NewProofBuilder(ctx, cp, h.HashChildren, tilesOnly)
+	if err != nil {
+		t.Fatalf("NewProofBuilder: %v", err)
+	}
+
+	for _, i := range []uint64{0, cp.Size / 2, cp.Size - 1} {
+		leaf, err := GetLeaf(ctx, testLogFetcher, i)
+		if err != nil {
+			t.Fatalf("GetLeaf(%d): %v", i, err)
+		}
+		lh := h.HashLeaf(leaf)
+		got, err := FindLeafIndex(ctx, tilesOnly, cp.Size, lh)
+		if err != nil {
+			t.Fatalf("FindLeafIndex(%d): %v", i, err)
+		}
+		if got != i {
+			t.Errorf("FindLeafIndex = %d, want %d", got, i)
+		}
+		p, err := pb.InclusionProof(ctx, got)
+		if err != nil {
+			t.Fatalf("InclusionProof(%d): %v", got, err)
+		}
+		if err := proof.VerifyInclusion(h, got, cp.Size, lh, p, cp.Hash); err != nil {
+			t.Errorf("VerifyInclusion(%d): %v", got, err)
+		}
+	}
+
+	if _, err := FindLeafIndex(ctx, tilesOnly, cp.Size, h.HashLeaf([]byte("unknown"))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("FindLeafIndex(unknown) = %v, want os.ErrNotExist", err)
+	}
+	// Leaves beyond logSize must not be found.
+	last, err := GetLeaf(ctx, testLogFetcher, cp.Size-1)
+	if err != nil {
+		t.Fatalf("GetLeaf: %v", err)
+	}
+	if _, err := FindLeafIndex(ctx, tilesOnly, cp.Size-1, h.HashLeaf(last)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("FindLeafIndex(beyond logSize) = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestGetLeafByHashBundled(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	leaves := [][]byte{[]byte("zero"), []byte("one"), []byte("two"), []byte("three"), []byte("four")}
+	const bundleSize, logSize = 2, 5
+
+	objects := make(map[string][]byte)
+	for i := 0; i < len(leaves); i += bundleSize {
+		var bs []string
+		for _, l := range leaves[i:min(i+bundleSize, len(leaves))] {
+			bs = append(bs, base64.StdEncoding.EncodeToString(l))
+		}
+		p := filepath.Join(layout.SeqPath("", uint64(i/bundleSize)))
+		if n := len(bs); n < bundleSize {
+			p += fmt.Sprintf(".%d", n)
+		}
+		objects[p] = []byte(strings.Join(bs, "\n"))
+	}
+	for i, l := range leaves {
+		objects[filepath.Join(layout.LeafPath("", h.HashLeaf(l)))] = []byte(strconv.FormatUint(uint64(i), 16))
+	}
+	f := func(_ context.Context, p string) ([]byte, error) {
+		b, ok := objects[p]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return b, nil
+	}
+
+	for i, want := range leaves {
+		gotIdx, got, err := GetLeafByHash(ctx, f, h, bundleSize, logSize, h.HashLeaf(want))
+		if err != nil {
+			t.Fatalf("GetLeafByHash(%d): %v", i, err)
+		}
+		if gotIdx != uint64(i) || !bytes.Equal(got, want) {
+			t.Errorf("GetLeafByHash = %d, %q, want %d, %q", gotIdx, got, i, want)
+		}
+	}
+}
+
+func TestVerifyUpdate(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	s, err := note.NewSigner("PRIVATE+KEY+astra+cad5a3d2+ASgwwenlc0uuYcdy7kI44pQvuz1fw8cS5NqS8RkZBXoy")
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	sign := func(cp log.Checkpoint) []byte {
+		t.Helper()
+		r, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, s)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return r
+	}
+	forked := testCheckpoints[5]
+	forked.Hash = h.HashLeaf([]byte("fork"))
+
+	for _, test := range []struct {
+		desc         string
+		oldRaw       []byte
+		newRaw       []byte
+		wantErr      bool
+		wantInconsis bool
+	}{
+		{
+			desc:   "consistent",
+			oldRaw: testRawCheckpoints[2],
+			newRaw: testRawCheckpoints[5],
+		}, {
+			desc:   "same",
+			oldRaw: testRawCheckpoints[5],
+			newRaw: testRawCheckpoints[5],
+		}, {
+			desc:   "from empty",
+			oldRaw: sign(log.Checkpoint{Origin: testOrigin, Hash: h.EmptyRoot()}),
+			newRaw: testRawCheckpoints[5],
+		}, {
+			desc:    "rollback",
+			oldRaw:  testRawCheckpoints[5],
+			newRaw:  testRawCheckpoints[2],
+			wantErr: true,
+		}, {
+			desc:         "fork at same size",
+			oldRaw:       testRawCheckpoints[5],
+			newRaw:       sign(forked),
+			wantErr:      true,
+			wantInconsis: true,
+		}, {
+			desc:         "inconsistent",
+			oldRaw:       sign(forked),
+			newRaw:       testRawCheckpoints[8],
+			wantErr:      true,
+			wantInconsis: true,
+		}, {
+			desc:    "unsigned new checkpoint",
+			oldRaw:  testRawCheckpoints[2],
+			newRaw:  testCheckpoints[5].Marshal(),
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := VerifyUpdate(ctx, testLogFetcher, h, testLogVerifier, testOrigin, test.oldRaw, test.newRaw)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("VerifyUpdate: %v, wantErr %t", err, test.wantErr)
+			}
+			if gotInconsis := errors.As(err, &ErrInconsistency{}); gotInconsis != test.wantInconsis {
+				t.Fatalf("VerifyUpdate: %v, want ErrInconsistency %t", err, test.wantInconsis)
+			}
+			if err != nil {
+				return
+			}
+			want, _, _, err := ParseCheckpoint(test.newRaw, testOrigin, testLogVerifier)
+			if err != nil {
+				t.Fatalf("ParseCheckpoint: %v", err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("VerifyUpdate diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestCheckConsistency(t *testing.T) {
 	ctx := context.Background()
 
@@ -256,6 +1115,32 @@ func TestCheckConsistency(t *testing.T) {
 				testCheckpoints[0],
 				testCheckpoints[3],
 			},
+		}, {
+			desc: "genesis",
+			cp: []log.Checkpoint{
+				testCheckpoints[0],
+				testCheckpoints[8],
+			},
+		}, {
+			desc: "genesis with wrong hash",
+			cp: []log.Checkpoint{
+				{
+					Size: 0,
+					Hash: []byte("This is a banana"),
+				},
+				testCheckpoints[8],
+			},
+			wantErr: true,
+		}, {
+			desc: "genesis to wrong hash",
+			cp: []log.Checkpoint{
+				testCheckpoints[0],
+				{
+					Size: testCheckpoints[8].Size,
+					Hash: []byte("This is a banana"),
+				},
+			},
+			wantErr: true,
 		}, {
 			desc:    "no checkpoints",
 			cp:      []log.Checkpoint{},
@@ -312,6 +1197,32 @@ func TestCheckConsistency(t *testing.T) {
 	}
 }
 
+func TestCheckConsistencyFromGenesisFetchesNoProof(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	cp := testCheckpoints[len(testCheckpoints)-1]
+
+	// recorder returns a fetcher which records the paths it fetches in paths.
+	recorder := func(paths *[]string) Fetcher {
+		return func(ctx context.Context, p string) ([]byte, error) {
+			*paths = append(*paths, p)
+			return testLogFetcher(ctx, p)
+		}
+	}
+	// The only fetches should be those made to verify the larger checkpoint's
+	// root hash.
+	var want, got []string
+	if _, err := NewProofBuilder(ctx, cp, h.HashChildren, recorder(&want)); err != nil {
+		t.Fatalf("NewProofBuilder: %v", err)
+	}
+	if err := CheckConsistency(ctx, h, recorder(&got), []log.Checkpoint{testCheckpoints[0], cp}); err != nil {
+		t.Fatalf("CheckConsistency: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Fetched paths diff (-want +got):\n%s", diff)
+	}
+}
+
 func TestNodeCacheHandlesInvalidRequest(t *testing.T) {
 	ctx := context.Background()
 	wantBytes := []byte("one")
@@ -323,7 +1234,7 @@ func TestNodeCacheHandlesInvalidRequest(t *testing.T) {
 
 	// Large tree, but we're emulating skew since f, above, will return a tile which only knows about 1
 	// leaf.
-	nc := newNodeCache(f, 10)
+	nc := newNodeCache(f, 10, 0)
 
 	if got, err := nc.GetNode(ctx, compact.NewNodeID(0, 0)); err != nil {
 		t.Errorf("got %v, want no error", err)
@@ -336,6 +1247,317 @@ func TestNodeCacheHandlesInvalidRequest(t *testing.T) {
 	}
 }
 
+func TestNodeCacheBounded(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		desc        string
+		maxTiles    int
+		wantFetches int
+	}{
+		{
+			desc:        "unbounded",
+			maxTiles:    0,
+			wantFetches: 2,
+		}, {
+			desc:        "fits",
+			maxTiles:    2,
+			wantFetches: 2,
+		}, {
+			desc:        "evicts",
+			maxTiles:    1,
+			wantFetches: 4,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			fetches := 0
+			f := func(_ context.Context, _, index uint64) (*api.Tile, error) {
+				fetches++
+				return &api.Tile{
+					Nodes: [][]byte{[]byte(fmt.Sprintf("tile %d", index))},
+				}, nil
+			}
+			nc := newNodeCache(f, 512, test.maxTiles)
+			// The first leaves of two different tiles, each requested twice.
+			for _, i := range []uint64{0, 256, 0, 256} {
+				want := fmt.Sprintf("tile %d", i/256)
+				got, err := nc.GetNode(ctx, compact.NewNodeID(0, i))
+				if err != nil {
+					t.Fatalf("GetNode(%d): %v", i, err)
+				}
+				if string(got) != want {
+					t.Errorf("GetNode(%d): got %q, want %q", i, got, want)
+				}
+			}
+			if fetches != test.wantFetches {
+				t.Errorf("got %d tile fetches, want %d", fetches, test.wantFetches)
+			}
+		})
+	}
+}
+
+func TestNodeCacheDeduplicatesFetches(t *testing.T) {
+	ctx := context.Background()
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	f := func(_ context.Context, _, _ uint64) (*api.Tile, error) {
+		fetches.Add(1)
+		<-release
+		return &api.Tile{NumLeaves: 256, Nodes: [][]byte{[]byte("leaf 0"), nil, []byte("leaf 1")}}, nil
+	}
+	nc := newNodeCache(f, 256, 0)
+
+	// Look up nodes from the same tile concurrently, so that most requests
+	// arrive while the tile is being fetched.
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := nc.GetNode(ctx, compact.NewNodeID(0, uint64(i%2))); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("GetNode: %v", err)
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("Got %d tile fetches, want 1", got)
+	}
+}
+
+func TestProofBuilderConcurrentFetches(t *testing.T) {
+	ctx := context.Background()
+	fullTile := &api.Tile{NumLeaves: 256, Nodes: make([][]byte, 511)}
+	for i := range fullTile.Nodes {
+		fullTile.Nodes[i] = make([]byte, 32)
+	}
+	rawTile, err := fullTile.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText: %v", err)
+	}
+
+	const maxConcurrent = 2
+	var mu sync.Mutex
+	fetches := make(map[string]int)
+	inFlight, maxInFlight := 0, 0
+	f := func(_ context.Context, p string) ([]byte, error) {
+		mu.Lock()
+		fetches[p]++
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		// Give other fetches a chance to start.
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return rawTile, nil
+	}
+
+	// Proofs in a tree of this size need nodes from several tiles.
+	const size = 1 << 20
+	nc := newNodeCache(newTileFetcher(f, size, layout.TileWidth), size, 0)
+	nc.maxConcurrentFetches = maxConcurrent
+	pb := &ProofBuilder{
+		cp:        log.Checkpoint{Size: size},
+		nodeCache: nc,
+		h:         rfc6962.DefaultHasher.HashChildren,
+	}
+	for _, i := range []uint64{0, 1, size - 1} {
+		if _, err := pb.InclusionProof(ctx, i); err != nil {
+			t.Fatalf("InclusionProof(%d): %v", i, err)
+		}
+	}
+	if _, err := pb.ConsistencyProof(ctx, 1000, size); err != nil {
+		t.Fatalf("ConsistencyProof: %v", err)
+	}
+
+	if len(fetches) < 2*maxConcurrent {
+		t.Fatalf("Only %d distinct tiles fetched, test needs more", len(fetches))
+	}
+	for p, n := range fetches {
+		if n != 1 {
+			t.Errorf("Tile %q fetched %d times, want 1", p, n)
+		}
+	}
+	if maxInFlight < 2 || maxInFlight > maxConcurrent {
+		t.Errorf("Got up to %d concurrent fetches, want between 2 and %d", maxInFlight, maxConcurrent)
+	}
+}
+
+func TestTileFetcherRejectsShortTile(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		desc      string
+		logSize   uint64
+		numLeaves uint
+		wantErr   bool
+	}{
+		{
+			desc:      "partial tile ok",
+			logSize:   10,
+			numLeaves: 10,
+		}, {
+			desc:      "partial tile short",
+			logSize:   10,
+			numLeaves: 9,
+			wantErr:   true,
+		}, {
+			desc:      "full tile served for partial",
+			logSize:   10,
+			numLeaves: 256,
+		}, {
+			desc:      "full tile ok",
+			logSize:   300,
+			numLeaves: 256,
+		}, {
+			desc:      "full tile short",
+			logSize:   300,
+			numLeaves: 255,
+			wantErr:   true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			tile := api.Tile{NumLeaves: test.numLeaves, Nodes: make([][]byte, 0)}
+			for i := uint(0); i < test.numLeaves; i++ {
+				tile.Nodes = append(tile.Nodes, make([]byte, 32))
+			}
+			raw, err := tile.MarshalText()
+			if err != nil {
+				t.Fatalf("MarshalText: %v", err)
+			}
+			f := func(_ context.Context, _ string) ([]byte, error) {
+				return raw, nil
+			}
+
+			_, err = newTileFetcher(f, test.logSize, layout.TileWidth)(ctx, 0, 0)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("got err %v, want err %t", err, test.wantErr)
+			}
+			if test.wantErr && !errors.As(err, &ErrShortTile{}) {
+				t.Errorf("got error %v, want ErrShortTile", err)
+			}
+		})
+	}
+}
+
+func TestInclusionProofs(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	cp := testCheckpoints[len(testCheckpoints)-1]
+
+	pb, err := NewProofBuilder(ctx, cp, h.HashChildren, testLogFetcher)
+	if err != nil {
+		t.Fatalf("NewProofBuilder: %v", err)
+	}
+	indices := make([]uint64, 0, cp.Size)
+	for i := uint64(0); i < cp.Size; i++ {
+		indices = append(indices, i)
+	}
+	got, err := pb.InclusionProofs(ctx, indices)
+	if err != nil {
+		t.Fatalf("InclusionProofs: %v", err)
+	}
+	if len(got) != len(indices) {
+		t.Fatalf("Got %d proofs, want %d", len(got), len(indices))
+	}
+
+	// Check against individually built proofs from a fresh ProofBuilder.
+	pb2, err := NewProofBuilder(ctx, cp, h.HashChildren, testLogFetcher)
+	if err != nil {
+		t.Fatalf("NewProofBuilder: %v", err)
+	}
+	for _, i := range indices {
+		want, err := pb2.InclusionProof(ctx, i)
+		if err != nil {
+			t.Fatalf("InclusionProof(%d): %v", i, err)
+		}
+		if diff := cmp.Diff(want, got[i]); diff != "" {
+			t.Errorf("Proof for index %d diff (-want +got):\n%s", i, diff)
+		}
+	}
+
+	if _, err := pb.InclusionProofs(ctx, []uint64{cp.Size}); err == nil {
+		t.Error("InclusionProofs for index outside tree succeeded, want error")
+	}
+}
+
+func TestConsistencyProof(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	cp := testCheckpoints[len(testCheckpoints)-1]
+
+	pb, err := NewProofBuilder(ctx, cp, h.HashChildren, testLogFetcher)
+	if err != nil {
+		t.Fatalf("NewProofBuilder: %v", err)
+	}
+	for _, a := range testCheckpoints {
+		for _, b := range testCheckpoints {
+			if a.Size == 0 || a.Size > b.Size {
+				continue
+			}
+			p, err := pb.ConsistencyProof(ctx, a.Size, b.Size)
+			if err != nil {
+				t.Fatalf("ConsistencyProof(%d, %d): %v", a.Size, b.Size, err)
+			}
+			if err := proof.VerifyConsistency(h, a.Size, b.Size, p, a.Hash, b.Hash); err != nil {
+				t.Errorf("VerifyConsistency(%d, %d): %v", a.Size, b.Size, err)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		smaller, larger uint64
+	}{
+		{smaller: 2, larger: 1},
+		{smaller: 1, larger: cp.Size + 1},
+		{smaller: cp.Size + 1, larger: cp.Size + 2},
+	} {
+		if _, err := pb.ConsistencyProof(ctx, test.smaller, test.larger); err == nil {
+			t.Errorf("ConsistencyProof(%d, %d) succeeded, want error", test.smaller, test.larger)
+		}
+	}
+}
+
+func TestProofBuilderStopsFetchingOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// fullTile is a tile with every node populated.
+	fullTile := &api.Tile{NumLeaves: 256, Nodes: make([][]byte, 511)}
+	for i := range fullTile.Nodes {
+		fullTile.Nodes[i] = make([]byte, 32)
+	}
+	// getTile ignores its context, as a badly behaved fetcher might, and
+	// cancels the proof build after the first fetch.
+	fetches := 0
+	getTile := func(_ context.Context, level, index uint64) (*api.Tile, error) {
+		fetches++
+		cancel()
+		return fullTile, nil
+	}
+
+	// A proof in a tree of this size needs nodes from more than one tile.
+	const size = 1 << 16
+	pb := &ProofBuilder{
+		cp:        log.Checkpoint{Size: size},
+		nodeCache: newNodeCache(getTile, size, 0),
+		h:         rfc6962.DefaultHasher.HashChildren,
+	}
+	if _, err := pb.InclusionProof(ctx, 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("InclusionProof: got err %v, want %v", err, context.Canceled)
+	}
+	if fetches != 1 {
+		t.Errorf("Got %d tile fetches, want 1", fetches)
+	}
+}
+
 func TestHandleZeroRoot(t *testing.T) {
 	zeroCP := testCheckpoints[0]
 	if zeroCP.Size != 0 {
@@ -348,3 +1570,193 @@ func TestHandleZeroRoot(t *testing.T) {
 		t.Fatalf("NewProofBuilder: %v", err)
 	}
 }
+
+func TestDiagnoseInclusion(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	cp := testCheckpoints[len(testCheckpoints)-1]
+
+	// Corrupt the node at level 1 index 2 in the bottom-left tile. This node isn't
+	// part of the compact range for the tree, so the ProofBuilder can still be
+	// created.
+	corruptFetcher := func(ctx context.Context, p string) ([]byte, error) {
+		raw, err := testLogFetcher(ctx, p)
+		if err != nil || p != filepath.Join(layout.TilePath("", 0, 0, layout.PartialTileSize(0, 0, cp.Size))) {
+			return raw, err
+		}
+		var tile api.Tile
+		if err := tile.UnmarshalText(raw); err != nil {
+			return nil, err
+		}
+		tile.Nodes[api.TileNodeKey(1, 2)] = make([]byte, 32)
+		return tile.MarshalText()
+	}
+
+	for _, test := range []struct {
+		desc     string
+		f        Fetcher
+		index    uint64
+		leafHash []byte
+		wantErr  *ErrCorruptNode
+	}{
+		{
+			desc:  "valid",
+			f:     testLogFetcher,
+			index: 6,
+		}, {
+			desc:     "wrong leaf",
+			f:        testLogFetcher,
+			index:    6,
+			leafHash: h.HashLeaf([]byte("not the leaf")),
+			wantErr:  &ErrCorruptNode{Level: 0, Index: 6},
+		}, {
+			desc:    "corrupt node on path",
+			f:       corruptFetcher,
+			index:   5,
+			wantErr: &ErrCorruptNode{Level: 1, Index: 2},
+		}, {
+			desc:    "corrupt sibling",
+			f:       corruptFetcher,
+			index:   6,
+			wantErr: &ErrCorruptNode{Level: 1, Index: 2},
+		}, {
+			desc:  "corruption not on path",
+			f:     corruptFetcher,
+			index: 8,
+		}, {
+			desc:  "leaf outside complete subtrees",
+			f:     corruptFetcher,
+			index: cp.Size - 1,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			pb, err := NewProofBuilder(ctx, cp, h.HashChildren, test.f)
+			if err != nil {
+				t.Fatalf("NewProofBuilder: %v", err)
+			}
+			leafHash := test.leafHash
+			if leafHash == nil {
+				lh, err := FetchLeafHashes(ctx, testLogFetcher, test.index, 1, cp.Size)
+				if err != nil {
+					t.Fatalf("FetchLeafHashes: %v", err)
+				}
+				leafHash = lh[0]
+			}
+			err = pb.DiagnoseInclusion(ctx, test.index, leafHash)
+			if test.wantErr == nil {
+				if err != nil {
+					t.Fatalf("DiagnoseInclusion: %v, want no error", err)
+				}
+				return
+			}
+			var cErr ErrCorruptNode
+			if !errors.As(err, &cErr) {
+				t.Fatalf("DiagnoseInclusion: %v, want ErrCorruptNode", err)
+			}
+			if cErr.Level != test.wantErr.Level || cErr.Index != test.wantErr.Index {
+				t.Errorf("Got corrupt node at level %d index %d, want level %d index %d", cErr.Level, cErr.Index, test.wantErr.Level, test.wantErr.Index)
+			}
+			if cErr.TileLevel != 0 || cErr.TileIndex != 0 {
+				t.Errorf("Got corrupt node in tile at level %d index %d, want level 0 index 0", cErr.TileLevel, cErr.TileIndex)
+			}
+		})
+	}
+}
+
+// bundledTestLogFetcher returns a Fetcher which serves the test log with its
+// leaves in bundles of bundleSize, along with the test log's leaves.
+func bundledTestLogFetcher(t *testing.T, bundleSize uint64) (Fetcher, [][]byte) {
+	t.Helper()
+	ctx := context.Background()
+	size := testCheckpoints[len(testCheckpoints)-1].Size
+	var leaves [][]byte
+	bundles := make(map[string][]byte)
+	for i := uint64(0); i < size; i += bundleSize {
+		var bs []string
+		for j := i; j < min(i+bundleSize, size); j++ {
+			l, err := GetLeaf(ctx, testLogFetcher, j)
+			if err != nil {
+				t.Fatalf("GetLeaf(%d): %v", j, err)
+			}
+			leaves = append(leaves, l)
+			bs = append(bs, base64.StdEncoding.EncodeToString(l))
+		}
+		p := filepath.Join(layout.SeqPath("", i/bundleSize))
+		if n := len(bs); uint64(n) < bundleSize {
+			p += fmt.Sprintf(".%d", n)
+		}
+		bundles[p] = []byte(strings.Join(bs, "\n") + "\n")
+	}
+	return func(ctx context.Context, p string) ([]byte, error) {
+		if strings.HasPrefix(p, "seq/") {
+			b, ok := bundles[p]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return b, nil
+		}
+		return testLogFetcher(ctx, p)
+	}, leaves
+}
+
+func TestProofBuilderLeaf(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	cp := testCheckpoints[len(testCheckpoints)-1]
+	const bundleSize = 4
+
+	f, want := bundledTestLogFetcher(t, bundleSize)
+	bundleFetches := 0
+	countingF := func(ctx context.Context, p string) ([]byte, error) {
+		if strings.HasPrefix(p, "seq/") {
+			bundleFetches++
+		}
+		return f(ctx, p)
+	}
+	numBundles := int((cp.Size + bundleSize - 1) / bundleSize)
+
+	for _, test := range []struct {
+		desc        string
+		opts        []ProofBuilderOption
+		f           Fetcher
+		wantFetches int
+	}{
+		{
+			desc: "unbundled",
+			f:    testLogFetcher,
+		}, {
+			desc:        "bundled",
+			opts:        []ProofBuilderOption{WithLeafBundleSize(bundleSize)},
+			f:           countingF,
+			wantFetches: numBundles,
+		}, {
+			desc:        "bundled with bounded cache",
+			opts:        []ProofBuilderOption{WithLeafBundleSize(bundleSize), WithTileCacheSize(1)},
+			f:           countingF,
+			wantFetches: numBundles,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			bundleFetches = 0
+			pb, err := NewProofBuilder(ctx, cp, h.HashChildren, test.f, test.opts...)
+			if err != nil {
+				t.Fatalf("NewProofBuilder: %v", err)
+			}
+			for i := uint64(0); i < cp.Size; i++ {
+				got, err := pb.Leaf(ctx, i)
+				if err != nil {
+					t.Fatalf("Leaf(%d): %v", i, err)
+				}
+				if !bytes.Equal(got, want[i]) {
+					t.Errorf("Leaf(%d) = %q, want %q", i, got, want[i])
+				}
+			}
+			if test.wantFetches > 0 && bundleFetches != test.wantFetches {
+				t.Errorf("Got %d bundle fetches, want %d", bundleFetches, test.wantFetches)
+			}
+			if _, err := pb.Leaf(ctx, cp.Size); err == nil {
+				t.Error("Leaf for index outside tree succeeded, want error")
+			}
+		})
+	}
+}
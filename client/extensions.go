@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// timestampExtensionPrefix prefixes the checkpoint extension line which holds
+// the time at which the checkpoint was published, in seconds since the Unix
+// epoch.
+const timestampExtensionPrefix = "Timestamp: "
+
+// TimestampExtension returns a checkpoint extension line recording t as the
+// checkpoint's publication time.
+func TimestampExtension(t time.Time) string {
+	return fmt.Sprintf("%s%d\n", timestampExtensionPrefix, t.Unix())
+}
+
+// CheckpointTimestamp returns the publication time recorded in the timestamp
+// extension of a checkpoint, where ext holds the checkpoint's extension lines,
+// i.e. the body of the checkpoint following the root hash line.
+//
+// Returns false if ext does not contain a timestamp extension.
+func CheckpointTimestamp(ext []byte) (time.Time, bool, error) {
+	for _, l := range bytes.Split(ext, []byte{'\n'}) {
+		v, ok := bytes.CutPrefix(l, []byte(timestampExtensionPrefix))
+		if !ok {
+			continue
+		}
+		secs, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil || secs < 0 {
+			return time.Time{}, false, fmt.Errorf("invalid timestamp extension %q", l)
+		}
+		return time.Unix(secs, 0), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// frozenExtension is the checkpoint extension line which marks the log as
+// frozen, i.e. the checkpoint is the log's last and the log will not grow.
+const frozenExtension = "Frozen"
+
+// FrozenExtension returns the checkpoint extension line which marks a log as
+// frozen.
+func FrozenExtension() string {
+	return frozenExtension + "\n"
+}
+
+// CheckpointFrozen returns true if the extension lines ext of a checkpoint,
+// i.e. the body of the checkpoint following the root hash line, mark the log
+// as frozen.
+func CheckpointFrozen(ext []byte) bool {
+	for _, l := range bytes.Split(ext, []byte{'\n'}) {
+		if string(l) == frozenExtension {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckpointTimestamp(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	for _, test := range []struct {
+		desc    string
+		ext     string
+		want    time.Time
+		wantOK  bool
+		wantErr bool
+	}{
+		{
+			desc: "no extensions",
+		}, {
+			desc: "other extensions",
+			ext:  "Foo: bar\n",
+		}, {
+			desc:   "timestamp",
+			ext:    TimestampExtension(ts),
+			want:   ts,
+			wantOK: true,
+		}, {
+			desc:   "timestamp after other extension",
+			ext:    "Foo: bar\n" + TimestampExtension(ts),
+			want:   ts,
+			wantOK: true,
+		}, {
+			desc:    "not a number",
+			ext:     "Timestamp: yesterday\n",
+			wantErr: true,
+		}, {
+			desc:    "negative",
+			ext:     "Timestamp: -1\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, ok, err := CheckpointTimestamp([]byte(test.ext))
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("CheckpointTimestamp: %v, wantErr %t", err, test.wantErr)
+			}
+			if ok != test.wantOK || !got.Equal(test.want) {
+				t.Errorf("CheckpointTimestamp = %v, %t, want %v, %t", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestCheckpointFrozen(t *testing.T) {
+	for _, test := range []struct {
+		desc string
+		ext  string
+		want bool
+	}{
+		{
+			desc: "no extensions",
+		}, {
+			desc: "other extensions",
+			ext:  "Foo: bar\n" + TimestampExtension(time.Unix(1700000000, 0)),
+		}, {
+			desc: "frozen",
+			ext:  FrozenExtension(),
+			want: true,
+		}, {
+			desc: "frozen after other extension",
+			ext:  "Foo: bar\n" + FrozenExtension(),
+			want: true,
+		}, {
+			desc: "frozen prefix only",
+			ext:  "Frozen: no\n",
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := CheckpointFrozen([]byte(test.ext)); got != test.want {
+				t.Errorf("CheckpointFrozen = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
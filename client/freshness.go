@@ -0,0 +1,133 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/transparency-dev/formats/log"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// FreshnessState describes a checkpoint seen by a LogStateTracker, for
+// evaluation by a FreshnessPolicy.
+type FreshnessState struct {
+	Checkpoint log.Checkpoint
+	// Timestamp is the publication time recorded in the checkpoint's timestamp
+	// extension, or the zero time if it has none.
+	Timestamp time.Time
+	// Seen is the time at which the tracker first fetched the checkpoint.
+	Seen time.Time
+	// Frozen is true if the checkpoint marks the log as frozen.
+	Frozen bool
+}
+
+// FreshnessPolicy decides whether a log's checkpoints are fresh enough for a
+// client's needs.
+type FreshnessPolicy interface {
+	// CheckFreshness returns an error describing why cur, a newly fetched
+	// checkpoint, violates the policy. prev is the tracker's latest consistent
+	// checkpoint, and is the zero value if the tracker has none.
+	CheckFreshness(prev, cur FreshnessState) error
+}
+
+// FreshnessPolicyFunc is an adapter which allows a function to be used as a
+// FreshnessPolicy.
+type FreshnessPolicyFunc func(prev, cur FreshnessState) error
+
+// CheckFreshness calls f(prev, cur).
+func (f FreshnessPolicyFunc) CheckFreshness(prev, cur FreshnessState) error {
+	return f(prev, cur)
+}
+
+// MaxCheckpointAge returns a FreshnessPolicy which requires checkpoints to
+// have a timestamp extension, and to have been published no more than d
+// before they were fetched. This allows a maximum merge delay to be enforced
+// by clients. The final checkpoint of a frozen log may be of any age.
+func MaxCheckpointAge(d time.Duration) FreshnessPolicy {
+	return FreshnessPolicyFunc(func(_, cur FreshnessState) error {
+		if cur.Frozen {
+			return nil
+		}
+		if cur.Timestamp.IsZero() {
+			return errors.New("checkpoint has no timestamp extension")
+		}
+		if age := cur.Seen.Sub(cur.Timestamp); age > d {
+			return fmt.Errorf("checkpoint at size %d is %v old, exceeding the maximum age of %v", cur.Checkpoint.Size, age.Round(time.Second), d)
+		}
+		return nil
+	})
+}
+
+// MinGrowthRate returns a FreshnessPolicy which requires the log to grow by
+// at least n entries per period, measured from when the tracker's latest
+// consistent checkpoint was first seen. Logs aren't penalised until a full
+// period has elapsed, so that checking frequently doesn't cause violations.
+// Frozen logs aren't expected to grow.
+func MinGrowthRate(n uint64, period time.Duration) FreshnessPolicy {
+	return FreshnessPolicyFunc(func(prev, cur FreshnessState) error {
+		if prev.Seen.IsZero() || prev.Frozen || cur.Frozen {
+			return nil
+		}
+		elapsed := cur.Seen.Sub(prev.Seen)
+		if elapsed < period {
+			return nil
+		}
+		var grown uint64
+		if cur.Checkpoint.Size > prev.Checkpoint.Size {
+			grown = cur.Checkpoint.Size - prev.Checkpoint.Size
+		}
+		if want := uint64(float64(n) * elapsed.Seconds() / period.Seconds()); grown < want {
+			return fmt.Errorf("log grew by %d entries in %v since size %d, want at least %d entries per %v", grown, elapsed.Round(time.Second), prev.Checkpoint.Size, n, period)
+		}
+		return nil
+	})
+}
+
+// ErrStaleCheckpoint is returned by LogStateTracker.Update when it enforces
+// freshness, and a newly fetched checkpoint violates a FreshnessPolicy.
+type ErrStaleCheckpoint struct {
+	// Raw is the raw checkpoint which violated the policy.
+	Raw []byte
+	Err error
+}
+
+func (e ErrStaleCheckpoint) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrStaleCheckpoint) Error() string {
+	return fmt.Sprintf("stale checkpoint: %v", e.Err)
+}
+
+// freshnessState returns the FreshnessState of the checkpoint cp, whose note
+// is n, and which was first seen at the given time.
+func freshnessState(cp log.Checkpoint, n *note.Note, seen time.Time) FreshnessState {
+	s := FreshnessState{Checkpoint: cp, Seen: seen}
+	if n == nil {
+		return s
+	}
+	ext, err := (&log.Checkpoint{}).Unmarshal([]byte(n.Text))
+	if err != nil {
+		return s
+	}
+	if ts, ok, err := CheckpointTimestamp(ext); err == nil && ok {
+		s.Timestamp = ts
+	}
+	s.Frozen = CheckpointFrozen(ext)
+	return s
+}
@@ -0,0 +1,247 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// ParseCheckpointArchiveIndex parses the contents of a checkpoint archive
+// index, and returns the tree sizes it lists.
+func ParseCheckpointArchiveIndex(index []byte) ([]uint64, error) {
+	sizes := make([]uint64, 0)
+	for _, l := range strings.Split(strings.TrimSpace(string(index)), "\n") {
+		if len(l) == 0 {
+			continue
+		}
+		s, err := strconv.ParseUint(l, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checkpoint archive index entry %q: %w", l, err)
+		}
+		sizes = append(sizes, s)
+	}
+	return sizes, nil
+}
+
+// gzipMagic is the header which starts all gzip compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// maybeDecompress returns the gzip decompressed contents of b if it's
+// compressed, and b itself otherwise.
+// Checkpoints and archive indices are text, so can never start with gzipMagic.
+func maybeDecompress(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, gzipMagic) {
+		return b, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// ListCheckpoints returns the tree sizes of the checkpoints held in the log's
+// checkpoint archive, in increasing order.
+// The archive index may be gzip compressed, in which case it's decompressed.
+// An error wrapping os.ErrNotExist is returned if the log has no archive.
+func ListCheckpoints(ctx context.Context, f Fetcher) ([]uint64, error) {
+	index, err := f(ctx, layout.CheckpointArchiveIndexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch checkpoint archive index: %w", err)
+	}
+	if index, err = maybeDecompress(index); err != nil {
+		return nil, fmt.Errorf("failed to decompress checkpoint archive index: %w", err)
+	}
+	sizes, err := ParseCheckpointArchiveIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(sizes); i++ {
+		if sizes[i] <= sizes[i-1] {
+			return nil, fmt.Errorf("checkpoint archive index is not in increasing order at entry %d", i)
+		}
+	}
+	return sizes, nil
+}
+
+// ReadCheckpointAt fetches the raw archived checkpoint for the given tree size.
+// If the archived checkpoint is gzip compressed, it's decompressed.
+func ReadCheckpointAt(ctx context.Context, f Fetcher, size uint64) ([]byte, error) {
+	raw, err := f(ctx, layout.CheckpointArchivePath(size))
+	if err != nil {
+		return nil, err
+	}
+	if raw, err = maybeDecompress(raw); err != nil {
+		return nil, fmt.Errorf("failed to decompress archived checkpoint for size %d: %w", size, err)
+	}
+	return raw, nil
+}
+
+// GetCheckpointAt fetches the archived checkpoint for the given tree size,
+// verifies it with v, and checks that it has the given origin and size.
+// Returns both the parsed structure and the raw serialised checkpoint.
+// An error wrapping os.ErrNotExist is returned if the log has no archived
+// checkpoint for size.
+func GetCheckpointAt(ctx context.Context, f Fetcher, v note.Verifier, origin string, size uint64) (*log.Checkpoint, []byte, error) {
+	raw, err := ReadCheckpointAt(ctx, f, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch archived checkpoint for size %d: %w", size, err)
+	}
+	cp, _, _, err := ParseCheckpoint(raw, origin, v)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse archived checkpoint for size %d: %w", size, err)
+	}
+	if cp.Size != size {
+		return nil, nil, fmt.Errorf("archived checkpoint for size %d has size %d", size, cp.Size)
+	}
+	return cp, raw, nil
+}
+
+// CheckConsistencyRange checks that each of the passed in checkpoints, which
+// must be sorted by increasing size, is consistent with the one which follows
+// it.
+// Every tree is consistent with the empty tree, so no proof is fetched for a
+// checkpoint of size 0, though its root hash must be that of the empty tree.
+// The error returned for the first pair of checkpoints found to be
+// inconsistent is an ErrInconsistency.
+func CheckConsistencyRange(ctx context.Context, h merkle.LogHasher, f Fetcher, cps []log.Checkpoint) error {
+	return checkConsistencyRange(ctx, h, f, cps, nil)
+}
+
+// checkConsistencyRange implements CheckConsistencyRange.
+// If raws is non-nil, it must hold the raw form of each of the checkpoints,
+// and these will be included in any ErrInconsistency returned.
+func checkConsistencyRange(ctx context.Context, h merkle.LogHasher, f Fetcher, cps []log.Checkpoint, raws [][]byte) error {
+	if l := len(cps); l < 2 {
+		return fmt.Errorf("passed %d checkpoints, need at least 2", l)
+	}
+	for i := 1; i < len(cps); i++ {
+		if cps[i].Size < cps[i-1].Size {
+			return fmt.Errorf("checkpoints not sorted by size at index %d (%d < %d)", i, cps[i].Size, cps[i-1].Size)
+		}
+	}
+	pb, err := NewProofBuilder(ctx, cps[len(cps)-1], h.HashChildren, f)
+	if err != nil {
+		return fmt.Errorf("failed to create proofbuilder: %v", err)
+	}
+
+	for i := 0; i < len(cps)-1; i++ {
+		a, b := cps[i], cps[i+1]
+		inconsistent := func(p [][]byte, err error) error {
+			e := ErrInconsistency{Proof: p, Wrapped: err}
+			if raws != nil {
+				e.SmallerRaw, e.LargerRaw = raws[i], raws[i+1]
+			}
+			return e
+		}
+		if a.Size == 0 && !bytes.Equal(a.Hash, h.EmptyRoot()) {
+			return inconsistent(nil, fmt.Errorf("checkpoint of size 0 has hash %x, want the empty root hash %x", a.Hash, h.EmptyRoot()))
+		}
+		if a.Size == b.Size {
+			if bytes.Equal(a.Hash, b.Hash) {
+				continue
+			}
+			return inconsistent(nil, fmt.Errorf("two checkpoints with same size (%d) but different hashes (%x vs %x)", a.Size, a.Hash, b.Hash))
+		}
+		if a.Size == 0 {
+			// Every tree is consistent with the empty tree, so there's no
+			// proof to fetch. The larger checkpoint's root hash is still
+			// verified, either by a later proof or by NewProofBuilder.
+			continue
+		}
+		p, err := pb.ConsistencyProof(ctx, a.Size, b.Size)
+		if err != nil {
+			return fmt.Errorf("failed to fetch consistency between sizes %d, %d: %v", a.Size, b.Size, err)
+		}
+		if err := proof.VerifyConsistency(h, a.Size, b.Size, p, a.Hash, b.Hash); err != nil {
+			return inconsistent(p, fmt.Errorf("invalid consistency proof between sizes %d, %d: %v", a.Size, b.Size, err))
+		}
+	}
+	return nil
+}
+
+// HistoryOption configures optional behaviour of VerifyHistory.
+type HistoryOption func(*historyOpts)
+
+type historyOpts struct {
+	h merkle.LogHasher
+}
+
+// WithHistoryHasher sets the hasher used by the log, which must be passed for
+// logs which don't use RFC6962 hashing, the default.
+func WithHistoryHasher(h merkle.LogHasher) HistoryOption {
+	return func(o *historyOpts) {
+		o.h = h
+	}
+}
+
+// VerifyHistory fetches every checkpoint in the log's checkpoint archive, along
+// with its current checkpoint, and verifies that the log has only ever grown
+// in an append-only fashion, i.e. that each checkpoint is consistent with the
+// next.
+// Checkpoints must verify with v, and have the given origin. The log is
+// assumed to use RFC6962 hashing, unless WithHistoryHasher is passed.
+//
+// The error returned for the first pair of checkpoints found to be
+// inconsistent is an ErrInconsistency. An error wrapping os.ErrNotExist is
+// returned if the log has no checkpoint archive.
+func VerifyHistory(ctx context.Context, f Fetcher, v note.Verifier, origin string, opts ...HistoryOption) error {
+	o := historyOpts{h: rfc6962.DefaultHasher}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	h := o.h
+	latest, latestRaw, _, err := FetchCheckpoint(ctx, f, v, origin)
+	if err != nil {
+		return fmt.Errorf("failed to fetch latest checkpoint: %w", err)
+	}
+	sizes, err := ListCheckpoints(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	cps := make([]log.Checkpoint, 0, len(sizes)+1)
+	raws := make([][]byte, 0, len(sizes)+1)
+	for _, s := range sizes {
+		cp, raw, err := GetCheckpointAt(ctx, f, v, origin, s)
+		if err != nil {
+			return err
+		}
+		cps, raws = append(cps, *cp), append(raws, raw)
+	}
+	if l := len(cps); l > 0 && cps[l-1].Size > latest.Size {
+		return fmt.Errorf("archive contains checkpoint for size %d, larger than the latest checkpoint size %d", cps[l-1].Size, latest.Size)
+	}
+	cps, raws = append(cps, *latest), append(raws, latestRaw)
+	if len(cps) < 2 {
+		// Only the latest checkpoint, so there's nothing to be inconsistent with.
+		return nil
+	}
+	return checkConsistencyRange(ctx, h, f, cps, raws)
+}
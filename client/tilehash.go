@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrTileHashMismatch is returned by a Fetcher created with NewTileHashFetcher
+// when the content of a fetched tile does not match its expected hash.
+type ErrTileHashMismatch struct {
+	// Path is the path of the tile which was fetched.
+	Path string
+	// Want is the expected SHA-256 hash of the tile.
+	Want []byte
+	// Got is the SHA-256 hash of the fetched tile.
+	Got []byte
+}
+
+func (e ErrTileHashMismatch) Error() string {
+	return fmt.Sprintf("tile %q has hash %x, want %x", e.Path, e.Got, e.Want)
+}
+
+// ParseTileHashes parses a list of expected tile hashes, such as might be
+// published by a log operator out of band.
+//
+// Each line of the list holds a hex-encoded SHA-256 hash and the path of the
+// tile relative to the log root, separated by whitespace. This is the format
+// produced by running `sha256sum` over the tile files from the log root.
+// The returned map is keyed by tile path.
+func ParseTileHashes(list []byte) (map[string][]byte, error) {
+	r := make(map[string][]byte)
+	for i, l := range bytes.Split(list, []byte{'\n'}) {
+		if len(bytes.TrimSpace(l)) == 0 {
+			continue
+		}
+		fs := strings.Fields(string(l))
+		if len(fs) != 2 {
+			return nil, fmt.Errorf("line %d: want hash and path, got %q", i+1, l)
+		}
+		h, err := hex.DecodeString(fs[0])
+		if err != nil || len(h) != sha256.Size {
+			return nil, fmt.Errorf("line %d: invalid SHA-256 hash %q", i+1, fs[0])
+		}
+		// sha256sum marks files read in binary mode with a leading '*'.
+		r[path.Clean(strings.TrimPrefix(fs[1], "*"))] = h
+	}
+	return r, nil
+}
+
+// NewTileHashFetcher returns a Fetcher which checks the SHA-256 hashes of
+// tiles fetched via f against those in expected, keyed by tile path, and
+// returns ErrTileHashMismatch if they differ.
+//
+// This allows clients to detect a compromised storage layer or CDN serving
+// modified tiles independently of verifying Merkle proofs.
+// Objects which are not present in expected are returned unchecked.
+func NewTileHashFetcher(f Fetcher, expected map[string][]byte) Fetcher {
+	return func(ctx context.Context, p string) ([]byte, error) {
+		b, err := f(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		want, ok := expected[path.Clean(p)]
+		if !ok {
+			return b, nil
+		}
+		if got := sha256.Sum256(b); !bytes.Equal(got[:], want) {
+			return nil, ErrTileHashMismatch{Path: p, Want: want, Got: got[:]}
+		}
+		return b, nil
+	}
+}
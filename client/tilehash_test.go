@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestTileHashFetcher(t *testing.T) {
+	ctx := context.Background()
+	objects := map[string][]byte{
+		"tile/00/000":     []byte("good tile"),
+		"tile/00/001":     []byte("tampered tile"),
+		"tile/00/002.01":  []byte("unlisted tile"),
+		"leaves/00/00/00": []byte("leaf"),
+	}
+	f := func(_ context.Context, p string) ([]byte, error) {
+		b, ok := objects[p]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return b, nil
+	}
+	good := sha256.Sum256([]byte("good tile"))
+	orig := sha256.Sum256([]byte("original tile"))
+	list := fmt.Sprintf("%x  tile/00/000\n%x *tile/00/001\n", good, orig)
+	expected, err := ParseTileHashes([]byte(list))
+	if err != nil {
+		t.Fatalf("ParseTileHashes: %v", err)
+	}
+	hf := NewTileHashFetcher(f, expected)
+
+	for _, p := range []string{"tile/00/000", "tile/00/002.01", "leaves/00/00/00"} {
+		if got, err := hf(ctx, p); err != nil {
+			t.Errorf("Fetch(%q): %v", p, err)
+		} else if string(got) != string(objects[p]) {
+			t.Errorf("Fetch(%q) = %q, want %q", p, got, objects[p])
+		}
+	}
+	if _, err := hf(ctx, "tile/00/001"); !errors.As(err, &ErrTileHashMismatch{}) {
+		t.Errorf("Fetch(tampered) = %v, want ErrTileHashMismatch", err)
+	}
+	if _, err := hf(ctx, "tile/00/003"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Fetch(missing) = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestParseTileHashesErrors(t *testing.T) {
+	for _, list := range []string{
+		"deadbeef tile/00/000\n",
+		fmt.Sprintf("%x\n", sha256.Sum256(nil)),
+		fmt.Sprintf("%x tile/00/000 extra\n", sha256.Sum256(nil)),
+	} {
+		if _, err := ParseTileHashes([]byte(list)); err == nil {
+			t.Errorf("ParseTileHashes(%q): want error", list)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+// CheckpointUpdate is a newly published checkpoint, delivered by
+// SubscribeCheckpoints.
+type CheckpointUpdate struct {
+	Checkpoint *log.Checkpoint
+	Raw        []byte
+	Note       *note.Note
+}
+
+// SubscribeOption configures optional behaviour of SubscribeCheckpoints.
+type SubscribeOption func(*subscribeOpts)
+
+type subscribeOpts struct {
+	interval time.Duration
+	hc       *http.Client
+	u        *url.URL
+	wait     time.Duration
+}
+
+// WithSubscribeInterval sets how long SubscribeCheckpoints waits between
+// fetches of the checkpoint when it isn't long-polling. The default is 10
+// seconds.
+func WithSubscribeInterval(d time.Duration) SubscribeOption {
+	return func(o *subscribeOpts) {
+		o.interval = d
+	}
+}
+
+// WithLongPoll causes SubscribeCheckpoints to fetch the checkpoint at u with
+// hc, rather than with its Fetcher, using conditional GET requests which ask
+// the server to wait up to wait for the checkpoint to change before
+// responding.
+//
+// Requests carry an If-None-Match header with the ETag of the last checkpoint
+// seen, and a "Prefer: wait=<seconds>" header (RFC 7240). Servers which
+// support long-polling indicate so with a Preference-Applied header; for
+// those which don't, conditional requests are still made, but only once per
+// subscribe interval once the checkpoint is unchanged. hc's timeout, if any,
+// should exceed wait.
+func WithLongPoll(hc *http.Client, u *url.URL, wait time.Duration) SubscribeOption {
+	return func(o *subscribeOpts) {
+		o.hc = hc
+		o.u = u
+		o.wait = wait
+	}
+}
+
+// SubscribeCheckpoints watches the log's checkpoint, and sends each newly
+// published checkpoint which verifies with v and has the given origin to the
+// returned channel. The log's current checkpoint is sent first. The channel is
+// closed once ctx is done.
+//
+// Checkpoints are only compared with the last one sent, so the caller is
+// responsible for checking consistency between them, e.g. with a
+// LogStateTracker. Failures to fetch or verify a checkpoint are logged, and
+// retried after the subscribe interval.
+func SubscribeCheckpoints(ctx context.Context, f Fetcher, v note.Verifier, origin string, opts ...SubscribeOption) <-chan CheckpointUpdate {
+	o := &subscribeOpts{interval: 10 * time.Second}
+	for _, opt := range opts {
+		opt(o)
+	}
+	next := func(ctx context.Context) ([]byte, bool, error) {
+		raw, err := f(ctx, layout.CheckpointPath)
+		return raw, false, err
+	}
+	if o.hc != nil {
+		next = (&longPoller{hc: o.hc, u: o.u, wait: o.wait}).poll
+	}
+
+	ch := make(chan CheckpointUpdate)
+	go func() {
+		defer close(ch)
+		var last []byte
+		for {
+			raw, again, err := next(ctx)
+			switch {
+			case err != nil:
+				if ctx.Err() != nil {
+					return
+				}
+				klog.Warningf("Failed to fetch checkpoint: %v", err)
+			case raw != nil && !bytes.Equal(raw, last):
+				cp, _, n, err := ParseCheckpoint(raw, origin, v)
+				if err != nil {
+					klog.Warningf("Ignoring checkpoint: %v", err)
+					break
+				}
+				last = raw
+				select {
+				case ch <- CheckpointUpdate{Checkpoint: cp, Raw: raw, Note: n}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if again && err == nil {
+				// Either the server has already waited for the checkpoint to
+				// change, or it has just changed.
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(o.interval):
+			}
+		}
+	}()
+	return ch
+}
+
+// longPoller fetches a checkpoint over HTTP using conditional long-poll
+// requests.
+type longPoller struct {
+	hc   *http.Client
+	u    *url.URL
+	wait time.Duration
+	// etag and last are the ETag and contents of the last checkpoint fetched.
+	etag string
+	last []byte
+}
+
+// poll fetches the checkpoint, returning nil if it hasn't changed since the
+// last call. The returned bool is true if poll can be called again right away,
+// either because the server applied the requested wait before responding, or
+// because the checkpoint changed, in which case the next request will find out
+// whether the server supports long-polling. Servers which ignore
+// If-None-Match, or send no ETag, respond with the unchanged checkpoint, which
+// must not cause an immediate retry.
+func (l *longPoller) poll(ctx context.Context) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.u.String(), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if l.etag != "" {
+		req.Header.Set("If-None-Match", l.etag)
+	}
+	req.Header.Set("Prefer", fmt.Sprintf("wait=%d", int(l.wait.Seconds())))
+	resp, err := l.hc.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	waited := strings.HasPrefix(resp.Header.Get("Preference-Applied"), "wait")
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, waited, nil
+	case http.StatusOK:
+		raw, err := ReadAllLimited(resp.Body, DefaultMaxResponseSize)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read checkpoint from %q: %w", l.u, err)
+		}
+		l.etag = resp.Header.Get("ETag")
+		if bytes.Equal(raw, l.last) {
+			return nil, waited, nil
+		}
+		l.last = raw
+		return raw, true, nil
+	case http.StatusNotFound:
+		return nil, false, fmt.Errorf("get(%q): %w", l.u, os.ErrNotExist)
+	default:
+		return nil, false, fmt.Errorf("get(%q): %v", l.u, resp.Status)
+	}
+}
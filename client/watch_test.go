@@ -0,0 +1,199 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscribeCheckpointsPolling(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Each fetch returns the next of the test checkpoints, repeating each one
+	// a few times so that unchanged checkpoints are seen.
+	var calls atomic.Int64
+	f := func(ctx context.Context, p string) ([]byte, error) {
+		i := min(int(calls.Add(1)-1)/3, len(testRawCheckpoints)-1)
+		return testRawCheckpoints[i], nil
+	}
+	ch := SubscribeCheckpoints(ctx, f, testLogVerifier, testOrigin, WithSubscribeInterval(time.Millisecond))
+	for i, want := range testCheckpoints {
+		u := <-ch
+		if u.Checkpoint.Size != want.Size || !bytes.Equal(u.Raw, testRawCheckpoints[i]) {
+			t.Fatalf("Update %d: got checkpoint at size %d, want size %d", i, u.Checkpoint.Size, want.Size)
+		}
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+// longPollServer serves a checkpoint which can be replaced by tests, and
+// supports conditional long-poll requests if longPoll is set. If static is
+// set, it behaves like plain static hosting: it sends no ETag, and ignores
+// both If-None-Match and the Prefer header.
+type longPollServer struct {
+	longPoll bool
+	static   bool
+	requests atomic.Int64
+
+	mu      sync.Mutex
+	cp      []byte
+	gen     int
+	changed chan struct{}
+}
+
+func newLongPollServer(longPoll bool, cp []byte) *longPollServer {
+	return &longPollServer{longPoll: longPoll, cp: cp, changed: make(chan struct{})}
+}
+
+func (s *longPollServer) set(cp []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cp = cp
+	s.gen++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *longPollServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	s.mu.Lock()
+	cp, etag, changed := s.cp, fmt.Sprintf("%q", fmt.Sprint(s.gen)), s.changed
+	s.mu.Unlock()
+	if s.static {
+		_, _ = w.Write(cp)
+		return
+	}
+	if s.longPoll && r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("Preference-Applied", r.Header.Get("Prefer"))
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	s.mu.Lock()
+	cp, etag = s.cp, fmt.Sprintf("%q", fmt.Sprint(s.gen))
+	s.mu.Unlock()
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	_, _ = w.Write(cp)
+}
+
+func TestSubscribeCheckpointsLongPoll(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		longPoll bool
+	}{
+		{desc: "long-poll", longPoll: true},
+		{desc: "fallback", longPoll: false},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s := newLongPollServer(test.longPoll, testRawCheckpoints[0])
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+			u, err := url.Parse(srv.URL + "/checkpoint")
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			interval := 20 * time.Millisecond
+			if test.longPoll {
+				// Updates should arrive without waiting for the interval.
+				interval = time.Hour
+			}
+			ch := SubscribeCheckpoints(ctx, nil, testLogVerifier, testOrigin, WithSubscribeInterval(interval), WithLongPoll(srv.Client(), u, 5*time.Second))
+			for i := range testRawCheckpoints {
+				if i > 0 {
+					s.set(testRawCheckpoints[i])
+				}
+				select {
+				case u := <-ch:
+					if !bytes.Equal(u.Raw, testRawCheckpoints[i]) {
+						t.Fatalf("Update %d: got checkpoint at size %d, want size %d", i, u.Checkpoint.Size, testCheckpoints[i].Size)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Timed out waiting for update %d", i)
+				}
+			}
+			if test.longPoll {
+				// One request per update, plus the outstanding one.
+				if got, max := s.requests.Load(), int64(len(testRawCheckpoints)+1); got > max {
+					t.Errorf("Made %d requests, want at most %d", got, max)
+				}
+			}
+		})
+	}
+}
+
+func TestSubscribeCheckpointsStaticServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newLongPollServer(false, testRawCheckpoints[0])
+	s.static = true
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/checkpoint")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	const interval = 50 * time.Millisecond
+	ch := SubscribeCheckpoints(ctx, nil, testLogVerifier, testOrigin, WithSubscribeInterval(interval), WithLongPoll(srv.Client(), u, 5*time.Second))
+	select {
+	case u := <-ch:
+		if !bytes.Equal(u.Raw, testRawCheckpoints[0]) {
+			t.Fatalf("Got checkpoint at size %d, want size %d", u.Checkpoint.Size, testCheckpoints[0].Size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for first update")
+	}
+
+	// With the checkpoint unchanged, requests should be made once per
+	// interval, rather than in a tight loop.
+	const elapsed = 10 * interval
+	time.Sleep(elapsed)
+	if got, max := s.requests.Load(), int64(elapsed/interval)+3; got > max {
+		t.Errorf("Made %d requests in %v, want at most %d", got, elapsed, max)
+	}
+
+	// Changes must still be picked up.
+	s.set(testRawCheckpoints[1])
+	select {
+	case u := <-ch:
+		if !bytes.Equal(u.Raw, testRawCheckpoints[1]) {
+			t.Fatalf("Got checkpoint at size %d, want size %d", u.Checkpoint.Size, testCheckpoints[1].Size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for second update")
+	}
+}
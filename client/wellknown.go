@@ -0,0 +1,170 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/transparency-dev/merkle"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"golang.org/x/mod/sumdb/note"
+)
+
+const (
+	// WellKnownManifestPath is the path, relative to the root of a domain, of
+	// the manifest describing the log served by that domain.
+	WellKnownManifestPath = ".well-known/transparency/log.json"
+	// WellKnownCheckpointPath is the path, relative to the root of a domain, at
+	// which the latest checkpoint of the log described by the manifest may be
+	// published.
+	WellKnownCheckpointPath = ".well-known/transparency/checkpoint"
+)
+
+// ErrNoWellKnownLog is returned when a domain doesn't publish a log manifest at
+// WellKnownManifestPath. It wraps os.ErrNotExist.
+var ErrNoWellKnownLog = fmt.Errorf("no well-known log manifest: %w", os.ErrNotExist)
+
+// LogManifest describes a log, and is published by a domain at
+// WellKnownManifestPath so that tools can find and verify the log given
+// only the domain name.
+type LogManifest struct {
+	// Origin is the expected first line of the log's checkpoints.
+	Origin string `json:"origin"`
+	// PublicKey is the log's note verifier key.
+	PublicKey string `json:"publicKey"`
+	// LogURL is the root URL of the log's storage. It may be relative to the
+	// root of the domain publishing the manifest.
+	LogURL string `json:"logUrl"`
+	// LeafBundleSize is the number of leaves in each of the log's leaf
+	// bundles, or 0 if the log doesn't bundle leaves.
+	LeafBundleSize uint64 `json:"leafBundleSize,omitempty"`
+}
+
+// FetchLogManifest fetches and parses the log manifest of a domain.
+// f must fetch resources relative to the root of the domain, e.g. https://example.com/.
+// Returns ErrNoWellKnownLog if the domain doesn't publish a manifest, so that
+// callers can fall back to configuring the log explicitly.
+func FetchLogManifest(ctx context.Context, f Fetcher) (*LogManifest, error) {
+	raw, err := f(ctx, WellKnownManifestPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNoWellKnownLog
+		}
+		return nil, fmt.Errorf("failed to fetch log manifest: %w", err)
+	}
+	var m LogManifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse log manifest: %w", err)
+	}
+	if m.Origin == "" {
+		return nil, errors.New("log manifest has no origin")
+	}
+	if m.LeafBundleSize > 0 {
+		if err := layout.ValidateLeafBundleSize(m.LeafBundleSize); err != nil {
+			return nil, fmt.Errorf("invalid log manifest: %w", err)
+		}
+	}
+	return &m, nil
+}
+
+// Verifier returns a note verifier for the public key in the manifest.
+//
+// Note that the key is only as trustworthy as the domain which served the
+// manifest; callers which already know the log's key should use it instead.
+func (m LogManifest) Verifier() (note.Verifier, error) {
+	if m.PublicKey == "" {
+		return nil, errors.New("log manifest has no public key")
+	}
+	v, err := note.NewVerifier(m.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key in log manifest: %w", err)
+	}
+	return v, nil
+}
+
+// ResolveLogURL returns the root URL of the log's storage, resolving a
+// relative LogURL against domainRoot, the URL of the domain which served the
+// manifest. The returned URL always ends with a "/".
+// If the manifest has no LogURL, the log is assumed to be served from the
+// domain root.
+func (m LogManifest) ResolveLogURL(domainRoot *url.URL) (*url.URL, error) {
+	u := m.LogURL
+	if u == "" {
+		u = "/"
+	}
+	// The URL must reference a directory, by definition.
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	r, err := domainRoot.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log URL %q in log manifest: %w", m.LogURL, err)
+	}
+	return r, nil
+}
+
+// WellKnownCheckpointFetcher returns a Fetcher which reads the log's checkpoint
+// from the domain's WellKnownCheckpointPath via domainF, and all other
+// resources from the log's storage via logF.
+// If the domain doesn't publish a well-known checkpoint, the checkpoint is
+// read from the log's storage instead.
+func WellKnownCheckpointFetcher(domainF, logF Fetcher) Fetcher {
+	return func(ctx context.Context, p string) ([]byte, error) {
+		if p != layout.CheckpointPath {
+			return logF(ctx, p)
+		}
+		cp, err := domainF(ctx, WellKnownCheckpointPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return logF(ctx, p)
+		}
+		return cp, err
+	}
+}
+
+// NewWellKnownTracker returns a LogStateTracker for the log described by the
+// manifest published by the domain rooted at domainRoot, along with the
+// manifest itself.
+//
+// newFetcher must return a Fetcher for resources relative to the given URL.
+// If v is nil, the public key in the manifest is used to verify checkpoints.
+// Returns ErrNoWellKnownLog if the domain doesn't publish a manifest.
+func NewWellKnownTracker(ctx context.Context, domainRoot *url.URL, newFetcher func(root *url.URL) Fetcher, h merkle.LogHasher, v note.Verifier) (LogStateTracker, *LogManifest, error) {
+	domainF := newFetcher(domainRoot)
+	m, err := FetchLogManifest(ctx, domainF)
+	if err != nil {
+		return LogStateTracker{}, nil, err
+	}
+	if v == nil {
+		if v, err = m.Verifier(); err != nil {
+			return LogStateTracker{}, nil, err
+		}
+	}
+	logURL, err := m.ResolveLogURL(domainRoot)
+	if err != nil {
+		return LogStateTracker{}, nil, err
+	}
+	f := WellKnownCheckpointFetcher(domainF, newFetcher(logURL))
+	lst, err := NewLogStateTracker(ctx, f, h, nil, v, m.Origin, UnilateralConsensus(f))
+	if err != nil {
+		return LogStateTracker{}, nil, err
+	}
+	return lst, m, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/transparency-dev/merkle/rfc6962"
+)
+
+func TestNewWellKnownTracker(t *testing.T) {
+	ctx := context.Background()
+	const testPubKey = "astra+cad5a3d2+AZJqeuyE/GnknsCNh1eCtDtwdAwKBddOlS8M2eI1Jt4b"
+	domain, err := url.Parse("https://example.com/")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	logSize := testCheckpoints[len(testCheckpoints)-1].Size
+
+	for _, test := range []struct {
+		desc string
+		// objects are served relative to the domain root, in addition to the
+		// test log which is served under /log/.
+		objects      map[string]string
+		wantErr      bool
+		wantNotExist bool
+		wantSize     uint64
+	}{
+		{
+			desc: "manifest and checkpoint",
+			objects: map[string]string{
+				WellKnownManifestPath:   `{"origin": "` + testOrigin + `", "publicKey": "` + testPubKey + `", "logUrl": "/log"}`,
+				WellKnownCheckpointPath: string(testRawCheckpoints[3]),
+			},
+			wantSize: testCheckpoints[3].Size,
+		}, {
+			desc: "no well-known checkpoint",
+			objects: map[string]string{
+				WellKnownManifestPath: `{"origin": "` + testOrigin + `", "publicKey": "` + testPubKey + `", "logUrl": "https://example.com/log/"}`,
+			},
+			wantSize: logSize,
+		}, {
+			desc:         "no manifest",
+			wantErr:      true,
+			wantNotExist: true,
+		}, {
+			desc: "wrong origin",
+			objects: map[string]string{
+				WellKnownManifestPath: `{"origin": "example.com/other", "publicKey": "` + testPubKey + `", "logUrl": "/log/"}`,
+			},
+			wantErr: true,
+		}, {
+			desc: "misaligned leaf bundles",
+			objects: map[string]string{
+				WellKnownManifestPath: `{"origin": "` + testOrigin + `", "publicKey": "` + testPubKey + `", "logUrl": "/log/", "leafBundleSize": 100}`,
+			},
+			wantErr: true,
+		}, {
+			desc: "aligned leaf bundles",
+			objects: map[string]string{
+				WellKnownManifestPath: `{"origin": "` + testOrigin + `", "publicKey": "` + testPubKey + `", "logUrl": "/log/", "leafBundleSize": 256}`,
+			},
+			wantSize: logSize,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			newFetcher := func(root *url.URL) Fetcher {
+				return func(ctx context.Context, p string) ([]byte, error) {
+					u, err := root.Parse(p)
+					if err != nil {
+						return nil, err
+					}
+					if lp, ok := strings.CutPrefix(u.Path, "/log/"); ok {
+						return testLogFetcher(ctx, lp)
+					}
+					if o, ok := test.objects[strings.TrimPrefix(u.Path, "/")]; ok {
+						return []byte(o), nil
+					}
+					return nil, os.ErrNotExist
+				}
+			}
+			lst, m, err := NewWellKnownTracker(ctx, domain, newFetcher, rfc6962.DefaultHasher, nil)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("NewWellKnownTracker succeeded, want error")
+				}
+				if test.wantNotExist && !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("NewWellKnownTracker = %v, want error wrapping os.ErrNotExist", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewWellKnownTracker: %v", err)
+			}
+			if m.Origin != testOrigin {
+				t.Errorf("Got manifest origin %q, want %q", m.Origin, testOrigin)
+			}
+			if got := lst.LatestConsistent.Size; got != test.wantSize {
+				t.Errorf("Got tracker size %d, want %d", got, test.wantSize)
+			}
+			// Proofs must be built from the log's storage.
+			if _, err := lst.ProofBuilder.InclusionProof(ctx, 0); err != nil {
+				t.Errorf("InclusionProof: %v", err)
+			}
+		})
+	}
+}
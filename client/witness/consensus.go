@@ -96,9 +96,9 @@ func getCheckpointN(ctx context.Context, f client.Fetcher, logID string, N int,
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to fetch from distributor: %v", err)
 	}
-	cp, _, n, err := fmt_log.ParseCheckpoint(cpRaw, origin, logSigV, witSigVs...)
+	cp, _, n, err := client.ParseCheckpoint(cpRaw, origin, logSigV, witSigVs...)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("error parsing Checkpoint from %q: %v", p, err)
+		return nil, nil, nil, fmt.Errorf("error parsing Checkpoint from %q: %w", p, err)
 	}
 	return cp, n, cpRaw, nil
 }
@@ -22,6 +22,7 @@ import (
 
 	"github.com/transparency-dev/formats/log"
 	"github.com/transparency-dev/serverless-log/client"
+	"github.com/transparency-dev/serverless-log/testonly"
 	"golang.org/x/mod/sumdb/note"
 	"k8s.io/klog/v2"
 )
@@ -172,16 +173,7 @@ func (f mapFetcher) Fetch(_ context.Context, p string) ([]byte, error) {
 
 func newCP(t *testing.T, size int, sigs ...note.Signer) []byte {
 	t.Helper()
-	cp := log.Checkpoint{
-		Origin: testOrigin,
-		Size:   uint64(size),
-		Hash:   []byte("banana"),
-	}
-	ret, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, sigs...)
-	if err != nil {
-		t.Fatalf("Failed to sign note: %v", err)
-	}
-	return ret
+	return testonly.SignCheckpoint(log.Checkpoint{Size: uint64(size), Hash: []byte("banana")}, testOrigin, sigs...)
 }
 
 func genKeyPair(t *testing.T, name string) (note.Signer, note.Verifier) {
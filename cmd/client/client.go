@@ -21,7 +21,6 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -68,8 +67,13 @@ func flagStringList(name, usage string) *aString {
 
 var (
 	cacheDir            = flag.String("cache_dir", defaultCacheLocation(), "Where to cache client state for logs, if empty don't store anything locally")
+	cacheObjects        = flag.Bool("cache_objects", false, "If set, objects fetched from the log (e.g. tiles) will also be cached under --cache_dir to avoid refetching them on subsequent runs")
+	tileHashes          = flag.String("tile_hashes", "", "If set, the path of a file listing expected SHA-256 hashes of the log's tiles, in sha256sum format, which fetched tiles will be checked against")
+	tileCacheSize       = flag.Int("tile_cache_size", 0, "If > 0, the maximum number of tiles held in memory while building proofs, otherwise all fetched tiles are held")
+	checkpointCacheTTL  = flag.Duration("checkpoint_cache_ttl", 0, "When --cache_objects is set, how long a fetched checkpoint, or checkpoint archive index, may be served from the cache")
 	distributorURLs     = flagStringList("distributor_url", "URL identifying the root of a distributor (can specify this flag repeatedly)")
 	logURL              = flag.String("log_url", "", "Log storage root URL, e.g. file:///path/to/log or https://log.server/and/path")
+	domain              = flag.String("domain", "", "If set, the log's URL, origin, and public key are discovered from the manifest at https://<domain>/"+client.WellKnownManifestPath+", unless set explicitly by the corresponding flags. Falls back to the flags if the domain doesn't publish a manifest")
 	logPubKeyFile       = flag.String("log_public_key", "", "Location of log public key file. If unset, uses the contents of the SERVERLESS_LOG_PUBLIC_KEY environment variable")
 	logID               = flag.String("log_id", "", "LogID used by distributors. Will be derived from log public key if unset")
 	origin              = flag.String("origin", "", "Expected first line of checkpoints from log")
@@ -79,6 +83,8 @@ var (
 	outputConsistency   = flag.String("output_consistency_proof", "", "If set, the update and consistency commands will write the verified consistency proof used to update the checkpoint to this file")
 	outputInclusion     = flag.String("output_inclusion_proof", "", "If set, the inclusion command will write the verified inclusion proof to this file")
 	inclusionHash       = flag.Bool("inclusion_hash", false, "If set to true, the inclusion command will take a base64 encoded leaf hash instead of a file name")
+	tilesOnly           = flag.Bool("tiles_only", false, "If set, the inclusion command finds the index of a leaf by searching the log's tiles, rather than via its leafhash objects, for logs which only publish tiles")
+	maxResponseSize     = flag.Int64("max_response_size", client.DefaultMaxResponseSize, "The maximum size in bytes of any object read from the log over HTTP. Larger responses are rejected, rather than being read into memory")
 )
 
 func usage() {
@@ -86,6 +92,7 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "  consistency <from-size> <to-size>\n - build consistency proof between two log sizes\n")
 	fmt.Fprintf(os.Stderr, "  inclusion <file or leaf hash> [index-in-log]\n - verify inclusion of a file in the log\n")
 	fmt.Fprintf(os.Stderr, "  update - force the client to update its latest checkpoint\n")
+	fmt.Fprintf(os.Stderr, "  inspect <checkpoint file>\n - show the origin of a checkpoint and the keys it is signed by, without verifying it\n")
 	os.Exit(-1)
 }
 
@@ -94,7 +101,30 @@ func main() {
 	flag.Parse()
 	ctx := context.Background()
 
+	// inspect is intended to help configure the client, so must work without
+	// any other flags being set.
+	if args := flag.Args(); len(args) > 0 && args[0] == "inspect" {
+		if err := inspectCheckpoint(args[1:]); err != nil {
+			klog.Exitf("Command %q failed: %q", args[0], err)
+		}
+		return
+	}
+
+	var domainRoot *url.URL
+	var manifest *client.LogManifest
+	if *domain != "" {
+		var err error
+		domainRoot, manifest, err = discoverLog(ctx, *domain)
+		if err != nil {
+			klog.Exitf("Failed to discover log: %v", err)
+		}
+	}
+
 	logSigV, _, err := logSigVerifier(*logPubKeyFile)
+	if err != nil && manifest != nil {
+		klog.Warningf("No log public key configured, trusting the key published by %s", *domain)
+		logSigV, err = manifest.Verifier()
+	}
 	if err != nil {
 		klog.Exitf("failed to read log public key: %v", err)
 	}
@@ -132,6 +162,23 @@ func main() {
 	}
 
 	f := newFetcher(rootURL)
+	if manifest != nil {
+		f = client.WellKnownCheckpointFetcher(newFetcher(domainRoot), f)
+	}
+	if *tileHashes != "" {
+		list, err := os.ReadFile(*tileHashes)
+		if err != nil {
+			klog.Exitf("Failed to read tile hashes: %v", err)
+		}
+		expected, err := client.ParseTileHashes(list)
+		if err != nil {
+			klog.Exitf("Failed to parse tile hashes: %v", err)
+		}
+		f = client.NewTileHashFetcher(f, expected)
+	}
+	if *cacheObjects && len(*cacheDir) > 0 {
+		f = client.NewCachingFetcher(f, filepath.Join(*cacheDir, logID, "objects"), *checkpointCacheTTL)
+	}
 	lc, err := newLogClientTool(ctx, logID, f, logSigV, witnesses, distribs)
 	if err != nil {
 		klog.Exitf("Failed to create new client: %v", err)
@@ -163,6 +210,35 @@ func main() {
 	}
 }
 
+// discoverLog fetches the well-known log manifest published by domain, and uses
+// it to set any of the --log_url and --origin flags which weren't set explicitly.
+// Returns a nil manifest if the domain doesn't publish one.
+func discoverLog(ctx context.Context, domain string) (*url.URL, *client.LogManifest, error) {
+	domainRoot, err := url.Parse("https://" + domain + "/")
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid domain %q: %v", domain, err)
+	}
+	m, err := client.FetchLogManifest(ctx, newFetcher(domainRoot))
+	if errors.Is(err, os.ErrNotExist) {
+		klog.Warningf("%s doesn't publish a log manifest, falling back to flags", domain)
+		return domainRoot, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if *logURL == "" {
+		u, err := m.ResolveLogURL(domainRoot)
+		if err != nil {
+			return nil, nil, err
+		}
+		*logURL = u.String()
+	}
+	if *origin == "" {
+		*origin = m.Origin
+	}
+	klog.V(1).Infof("Discovered log %q at %s", *origin, *logURL)
+	return domainRoot, m, nil
+}
+
 // logClientTool encapsulates the "application level" interaction with the log.
 // It relies heavily on the components provided by the `internal/client` package
 // to accomplish this.
@@ -227,7 +303,7 @@ func (l *logClientTool) consistencyProof(ctx context.Context, args []string) err
 		return errors.New("from-size must be less than to-size")
 	}
 
-	builder, err := client.NewProofBuilder(ctx, l.Tracker.LatestConsistent, l.Hasher.HashChildren, l.Fetcher)
+	builder, err := client.NewProofBuilder(ctx, l.Tracker.LatestConsistent, l.Hasher.HashChildren, l.Fetcher, client.WithTileCacheSize(*tileCacheSize))
 	if err != nil {
 		return fmt.Errorf("failed to create proof builder: %w", err)
 	}
@@ -290,6 +366,12 @@ func (l *logClientTool) inclusionProofArgs(ctx context.Context, args []string) (
 		if err != nil {
 			return nil, 0, fmt.Errorf("invalid index-in-log %q: %w", args[1], err)
 		}
+	} else if *tilesOnly {
+		idx, err = client.FindLeafIndex(ctx, l.Fetcher, l.Tracker.LatestConsistent.Size, lh)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to find leaf in tiles: %w", err)
+		}
+		klog.Infof("Leaf %q found at index %d", args[0], idx)
 	} else {
 		idx, err = client.LookupIndex(ctx, l.Fetcher, lh)
 		if err != nil {
@@ -310,7 +392,7 @@ func (l *logClientTool) inclusionProof(ctx context.Context, args []string) error
 	// TODO(al): wait for growth if necessary
 
 	cp := l.Tracker.LatestConsistent
-	builder, err := client.NewProofBuilder(ctx, cp, l.Hasher.HashChildren, l.Fetcher)
+	builder, err := client.NewProofBuilder(ctx, cp, l.Hasher.HashChildren, l.Fetcher, client.WithTileCacheSize(*tileCacheSize))
 	if err != nil {
 		return fmt.Errorf("failed to create proof builder: %w", err)
 	}
@@ -323,6 +405,9 @@ func (l *logClientTool) inclusionProof(ctx context.Context, args []string) error
 	klog.V(1).Infof("Built inclusion proof: %#x", p)
 
 	if err := proof.VerifyInclusion(l.Hasher, idx, cp.Size, lh, p, cp.Hash); err != nil {
+		if dErr := builder.DiagnoseInclusion(ctx, idx, lh); dErr != nil {
+			return fmt.Errorf("failed to verify inclusion proof: %q: %w", err, dErr)
+		}
 		return fmt.Errorf("failed to verify inclusion proof: %q", err)
 	}
 
@@ -372,6 +457,29 @@ func (l *logClientTool) updateCheckpoint(ctx context.Context, args []string) err
 	return nil
 }
 
+// inspectCheckpoint prints the origin of the checkpoint in the given file, and
+// the names and hashes of the keys it carries signatures from.
+func inspectCheckpoint(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: inspect <checkpoint file>")
+	}
+	raw, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	origin, signers, err := client.InspectCheckpoint(raw)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Origin: %s\n", origin)
+	fmt.Println("Signed by (unverified):")
+	for _, s := range signers {
+		fmt.Printf("  %s\n", s)
+	}
+	fmt.Println("Configure --origin and --log_public_key to match these to verify this checkpoint.")
+	return nil
+}
+
 // newFetcher creates a Fetcher for the log at the given root location.
 func newFetcher(root *url.URL) client.Fetcher {
 	get := getByScheme[root.Scheme]
@@ -419,7 +527,11 @@ func readHTTP(ctx context.Context, u *url.URL) ([]byte, error) {
 			klog.Errorf("resp.Body.Close(): %v", err)
 		}
 	}()
-	return io.ReadAll(resp.Body)
+	body, err := client.ReadAllLimited(resp.Body, *maxResponseSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", u.String(), err)
+	}
+	return body, nil
 }
 
 // loadLocalCheckpoint reads the serialised checkpoint for the given logID from the
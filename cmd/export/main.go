@@ -0,0 +1,167 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// export is a tool which downloads all of the entries in a serverless log,
+// verifies their inclusion under the log's current checkpoint, and writes
+// them out as a stream of JSON lines.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/client"
+	"github.com/transparency-dev/serverless-log/internal/cmdutil"
+	"k8s.io/klog/v2"
+)
+
+var (
+	logURL           = flag.String("log_url", "", "Log storage root URL, e.g. file:///path/to/log or https://log.server/and/path")
+	logPubKeyFile    = flag.String("log_public_key", "", "Location of log public key file. If unset, uses the contents of the SERVERLESS_LOG_PUBLIC_KEY environment variable")
+	origin           = flag.String("origin", "", "Expected first line of checkpoints from log")
+	output           = flag.String("output", "", "File to write exported entries to, if unset entries are written to stdout")
+	outputCheckpoint = flag.String("output_checkpoint", "", "If set, the checkpoint which the exported entries were verified against will be written to this file")
+	batchSize        = flag.Uint64("batch_size", 256, "Number of entries to verify inclusion for at a time")
+	parallelism      = flag.Int("fetch_parallelism", 1, "Maximum number of leaves, or leaf bundles, to fetch concurrently. Entries are still exported in order")
+	leafBundleSize   = flag.Uint64("leaf_bundle_size", 1, "The log-configured number of leaves in each leaf bundle")
+	strict           = flag.Bool("strict", false, "If set, the checkpoint's root hash is also recomputed from the log's tiles before exporting, and from all of the exported entries afterwards, and the export fails if either doesn't match")
+	maxResponseSize  = flag.Int64("max_response_size", client.DefaultMaxResponseSize, "The maximum size in bytes of any object read from the log over HTTP. Larger responses are rejected, rather than being read into memory")
+)
+
+// entry is the JSON form of an exported log entry.
+type entry struct {
+	Index uint64 `json:"index"`
+	Leaf  []byte `json:"leaf"`
+}
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	ctx := context.Background()
+
+	if *batchSize == 0 {
+		klog.Exit("--batch_size must be > 0")
+	}
+	if err := layout.ValidateLeafBundleSize(*leafBundleSize); err != nil {
+		klog.Exitf("Invalid --leaf_bundle_size: %v", err)
+	}
+	v, err := cmdutil.LogSigVerifier(*logPubKeyFile)
+	if err != nil {
+		klog.Exitf("Failed to read log public key: %v", err)
+	}
+	u := *logURL
+	if len(u) == 0 {
+		klog.Exit("--log_url must be provided")
+	}
+	// url must reference a directory, by definition
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	rootURL, err := url.Parse(u)
+	if err != nil {
+		klog.Exitf("Invalid log URL: %v", err)
+	}
+	f, err := cmdutil.NewFetcher(rootURL, *maxResponseSize)
+	if err != nil {
+		klog.Exitf("Failed to create fetcher: %v", err)
+	}
+
+	cp, cpRaw, _, err := client.FetchCheckpoint(ctx, f, v, *origin)
+	if err != nil {
+		klog.Exitf("Failed to fetch checkpoint: %v", err)
+	}
+	klog.Infof("Exporting %d entries verified against checkpoint with root hash %x", cp.Size, cp.Hash)
+
+	var w io.Writer = os.Stdout
+	if len(*output) > 0 {
+		o, err := os.Create(*output)
+		if err != nil {
+			klog.Exitf("Failed to create output file: %v", err)
+		}
+		defer o.Close()
+		w = o
+	}
+	bw := bufio.NewWriter(w)
+
+	h := rfc6962.DefaultHasher
+	pb, err := client.NewProofBuilder(ctx, *cp, h.HashChildren, f)
+	if err != nil {
+		klog.Exitf("Failed to create proof builder: %v", err)
+	}
+
+	// verify checks the inclusion of a batch of leaves, and writes them out.
+	var batch []entry
+	verify := func() error {
+		indices := make([]uint64, 0, len(batch))
+		for _, e := range batch {
+			indices = append(indices, e.Index)
+		}
+		proofs, err := pb.InclusionProofs(ctx, indices)
+		if err != nil {
+			return fmt.Errorf("failed to build inclusion proofs: %v", err)
+		}
+		enc := json.NewEncoder(bw)
+		for _, e := range batch {
+			if err := proof.VerifyInclusion(h, e.Index, cp.Size, h.HashLeaf(e.Leaf), proofs[e.Index], cp.Hash); err != nil {
+				if dErr := pb.DiagnoseInclusion(ctx, e.Index, h.HashLeaf(e.Leaf)); dErr != nil {
+					return fmt.Errorf("failed to verify inclusion of entry %d: %v: %v", e.Index, err, dErr)
+				}
+				return fmt.Errorf("failed to verify inclusion of entry %d: %v", e.Index, err)
+			}
+			if err := enc.Encode(e); err != nil {
+				return fmt.Errorf("failed to write entry %d: %v", e.Index, err)
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	opts := []client.DownloadOption{client.WithDownloadParallelism(*parallelism), client.WithDownloadBundleSize(*leafBundleSize)}
+	if *strict {
+		opts = append(opts, client.WithDownloadVerification(h, cp.Hash))
+	}
+	err = client.DownloadAllLeaves(ctx, f, cp.Size, func(i uint64, leaf []byte) error {
+		batch = append(batch, entry{Index: i, Leaf: leaf})
+		if uint64(len(batch)) < *batchSize {
+			return nil
+		}
+		return verify()
+	}, opts...)
+	if err == nil && len(batch) > 0 {
+		err = verify()
+	}
+	if err != nil {
+		klog.Exitf("Export failed: %v", err)
+	}
+	if err := bw.Flush(); err != nil {
+		klog.Exitf("Failed to flush output: %v", err)
+	}
+
+	if len(*outputCheckpoint) > 0 {
+		if err := os.WriteFile(*outputCheckpoint, cpRaw, 0644); err != nil {
+			klog.Exitf("Failed to write checkpoint: %v", err)
+		}
+	}
+	klog.Infof("Exported %d entries", cp.Size)
+}
@@ -21,8 +21,11 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 
 	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/client"
 	"github.com/transparency-dev/serverless-log/internal/storage/fs"
 	"github.com/transparency-dev/serverless-log/pkg/log"
 	"golang.org/x/mod/sumdb/note"
@@ -37,6 +40,15 @@ var (
 	pubKeyFile  = flag.String("public_key", "", "Location of public key file. If unset, uses the contents of the SERVERLESS_LOG_PUBLIC_KEY environment variable.")
 	privKeyFile = flag.String("private_key", "", "Location of private key file. If unset, uses the contents of the SERVERLESS_LOG_PRIVATE_KEY environment variable.")
 	origin      = flag.String("origin", "", "Log origin string to use in produced checkpoint.")
+	archiveCPs  = flag.Bool("archive_checkpoints", false, "If set, every checkpoint written will also be stored in the log's checkpoint archive. Once enabled, archiving remains enabled for the log.")
+	compressCPs = flag.Bool("compress_archive", false, "If set, checkpoints stored in the log's checkpoint archive, and the archive index, are gzip compressed.")
+	validate    = flag.Bool("validate_frontier", false, "If set, check that the tiles for the existing tree are consistent with the current checkpoint before integrating new entries.")
+	detectGaps  = flag.Bool("detect_gaps", false, "If set, refuse to integrate anything if there's a gap in the sequenced entries, rather than integrating only those before the gap.")
+	maxPending  = flag.Uint64("max_pending", 0, "If set, refuse to integrate anything if more than this many sequenced entries are pending integration.")
+	cpInterval  = flag.Uint64("checkpoint_interval", 0, "If set, publish an intermediate checkpoint after integrating each batch of this many entries.")
+	freeze      = flag.Bool("freeze", false, "If set, retire the log by re-signing its current checkpoint with the frozen extension line, after which no further entries will be integrated.")
+	watch       = flag.Duration("watch_interval", 0, "If set, keep running until interrupted, integrating and publishing newly sequenced entries whenever they're found, and looking for them at this interval.")
+	tileWidth   = flag.Uint64("tile_width", layout.TileWidth, "The width of the log's tiles, which must be a power of two. This must be the same every time the log is integrated, and clients must be configured with it.")
 )
 
 func main() {
@@ -47,6 +59,9 @@ func main() {
 	if len(*origin) == 0 {
 		klog.Exitf("Please set --origin flag to log identifier.")
 	}
+	if err := layout.ValidateTileWidth(*tileWidth); err != nil {
+		klog.Exitf("Invalid --tile_width: %v", err)
+	}
 
 	h := rfc6962.DefaultHasher
 	// Read log public key from file or environment variable
@@ -88,6 +103,12 @@ func main() {
 		if err != nil {
 			klog.Exitf("Failed to create log: %q", err)
 		}
+		if *archiveCPs {
+			if err := st.EnableCheckpointArchive(); err != nil {
+				klog.Exitf("Failed to enable checkpoint archive: %q", err)
+			}
+		}
+		st.SetCheckpointArchiveCompression(*compressCPs)
 		cp := fmtlog.Checkpoint{
 			Hash: h.EmptyRoot(),
 		}
@@ -108,7 +129,7 @@ func main() {
 	if err != nil {
 		klog.Exitf("Failed to instantiate Verifier: %q", err)
 	}
-	cp, _, _, err := fmtlog.ParseCheckpoint(cpRaw, *origin, v)
+	cp, _, _, err := client.ParseCheckpoint(cpRaw, *origin, v)
 	if err != nil {
 		klog.Exitf("Failed to open Checkpoint: %q", err)
 	}
@@ -116,9 +137,49 @@ func main() {
 	if err != nil {
 		klog.Exitf("Failed to load storage: %q", err)
 	}
+	if err := st.SetTileWidth(*tileWidth); err != nil {
+		klog.Exitf("Failed to set tile width: %q", err)
+	}
+	if *archiveCPs {
+		if err := st.EnableCheckpointArchive(); err != nil {
+			klog.Exitf("Failed to enable checkpoint archive: %q", err)
+		}
+	}
+	st.SetCheckpointArchiveCompression(*compressCPs)
+
+	if *freeze {
+		if err := log.Freeze(ctx, st, s, *origin); err != nil {
+			klog.Exitf("Failed to freeze log: %q", err)
+		}
+		klog.Infof("Froze log at size %d", cp.Size)
+		return
+	}
+
+	if *watch > 0 {
+		runDriver(ctx, cp, s, st)
+		return
+	}
 
 	// Integrate new entries
-	newCp, err := log.Integrate(ctx, cp.Size, st, h)
+	opts := []log.IntegrateOption{log.WithTileWidth(*tileWidth)}
+	if *cpInterval > 0 {
+		opts = append(opts, log.WithCheckpointInterval(*cpInterval, func(ctx context.Context, cp *fmtlog.Checkpoint) error {
+			return signAndWrite(ctx, cp, cpNote, s, st)
+		}))
+	}
+	if *maxPending > 0 {
+		opts = append(opts, log.WithMaxPending(*maxPending))
+	}
+	if *detectGaps {
+		opts = append(opts, log.WithGapDetection())
+	}
+	if *validate {
+		opts = append(opts, log.WithFrontierValidation(cp.Hash))
+	}
+	opts = append(opts, log.WithObserver(log.IntegrateObserverFunc(func(_ context.Context, s log.IntegrateStats) {
+		klog.V(1).Infof("Integrated %d entries from size %d to %d, writing %d tiles, in %v", s.EntriesAdded, s.FromSize, s.ToSize, s.TilesWritten, s.Duration)
+	})))
+	newCp, err := log.Integrate(ctx, cp.Size, st, h, opts...)
 	if err != nil {
 		klog.Exitf("Failed to integrate: %q", err)
 	}
@@ -132,6 +193,37 @@ func main() {
 	}
 }
 
+// runDriver integrates and publishes newly sequenced entries until interrupted.
+func runDriver(ctx context.Context, cp *fmtlog.Checkpoint, s note.Signer, st *fs.Storage) {
+	if *validate {
+		klog.Exit("--validate_frontier is not supported with --watch_interval")
+	}
+	iOpts := []log.IntegrateOption{log.WithTileWidth(*tileWidth)}
+	if *maxPending > 0 {
+		iOpts = append(iOpts, log.WithMaxPending(*maxPending))
+	}
+	if *detectGaps {
+		iOpts = append(iOpts, log.WithGapDetection())
+	}
+	iOpts = append(iOpts, log.WithObserver(log.IntegrateObserverFunc(func(_ context.Context, s log.IntegrateStats) {
+		klog.V(1).Infof("Integrated %d entries from size %d to %d, writing %d tiles, in %v", s.EntriesAdded, s.FromSize, s.ToSize, s.TilesWritten, s.Duration)
+	})))
+	d, err := log.NewDriver(st, rfc6962.DefaultHasher, *cp, *origin, []note.Signer{s},
+		log.WithPollInterval(*watch),
+		log.WithBatchSize(*cpInterval),
+		log.WithIntegrateOptions(iOpts...))
+	if err != nil {
+		klog.Exitf("Failed to create driver: %q", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+	klog.Infof("Watching for new entries every %v from size %d", *watch, cp.Size)
+	if err := d.Run(ctx); err != nil {
+		klog.Exitf("Driver failed: %q", err)
+	}
+}
+
 func getKeyFile(path string) (string, error) {
 	k, err := os.ReadFile(path)
 	if err != nil {
@@ -0,0 +1,262 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// proxy is an HTTP server which sits in front of a serverless log and serves
+// its checkpoint and leaves to clients which are unable to verify them
+// themselves.
+//
+// The proxy only serves checkpoints whose signature it has verified, and
+// which it has proven to be consistent with every checkpoint it has served
+// before. Leaf bundles are only served once the inclusion of each leaf they
+// contain has been verified under the latest such checkpoint. Clients of the
+// proxy therefore need to trust the proxy, but not the log's storage.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/client"
+	"github.com/transparency-dev/serverless-log/internal/cmdutil"
+	"k8s.io/klog/v2"
+)
+
+var (
+	listen         = flag.String("listen", ":8080", "Address to serve HTTP requests on")
+	logURL         = flag.String("log_url", "", "Upstream log storage root URL, e.g. file:///path/to/log or https://log.server/and/path")
+	logPubKeyFile  = flag.String("log_public_key", "", "Location of log public key file. If unset, uses the contents of the SERVERLESS_LOG_PUBLIC_KEY environment variable")
+	origin         = flag.String("origin", "", "Expected first line of checkpoints from log")
+	leafBundleSize = flag.Uint64("leaf_bundle_size", 1, "The log-configured number of leaves in each leaf bundle")
+	updateInterval = flag.Duration("update_interval", 10*time.Second, "How often to check the upstream log for a new checkpoint")
+	maxCPAge       = flag.Duration("max_checkpoint_age", 0, "If set, upstream checkpoints must carry a timestamp extension no older than this when fetched, e.g. to enforce the log's maximum merge delay")
+	enforceFresh   = flag.Bool("enforce_freshness", false, "If set, checkpoints older than --max_checkpoint_age are not served, otherwise they're served with a warning")
+	maxRespSize    = flag.Int64("max_response_size", client.DefaultMaxResponseSize, "The maximum size in bytes of any object read from the log over HTTP. Larger responses are rejected, rather than being read into memory")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	ctx := context.Background()
+
+	if err := layout.ValidateLeafBundleSize(*leafBundleSize); err != nil {
+		klog.Exitf("Invalid --leaf_bundle_size: %v", err)
+	}
+	v, err := cmdutil.LogSigVerifier(*logPubKeyFile)
+	if err != nil {
+		klog.Exitf("Failed to read log public key: %v", err)
+	}
+	u := *logURL
+	if len(u) == 0 {
+		klog.Exit("--log_url must be provided")
+	}
+	// url must reference a directory, by definition
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	rootURL, err := url.Parse(u)
+	if err != nil {
+		klog.Exitf("Invalid log URL: %v", err)
+	}
+	f, err := cmdutil.NewFetcher(rootURL, *maxRespSize)
+	if err != nil {
+		klog.Exitf("Failed to create fetcher: %v", err)
+	}
+
+	tracker, err := client.NewLogStateTracker(ctx, f, rfc6962.DefaultHasher, nil, v, *origin, client.UnilateralConsensus(f))
+	if err != nil {
+		klog.Exitf("Failed to create LogStateTracker: %v", err)
+	}
+	if *maxCPAge > 0 {
+		tracker.FreshnessPolicies = []client.FreshnessPolicy{client.MaxCheckpointAge(*maxCPAge)}
+		tracker.EnforceFreshness = *enforceFresh
+	}
+	p := &proxy{
+		f:          f,
+		bundleSize: *leafBundleSize,
+		tracker:    tracker,
+	}
+	if err := p.update(ctx); err != nil {
+		klog.Exitf("Failed to get initial state of the log: %v", err)
+	}
+	go func() {
+		t := time.NewTicker(*updateInterval)
+		defer t.Stop()
+		for range t.C {
+			if err := p.update(ctx); err != nil {
+				klog.Errorf("Failed to update checkpoint: %v", err)
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+layout.CheckpointPath, p.serveCheckpoint)
+	mux.HandleFunc("/seq/", p.serveLeafBundle)
+	klog.Infof("Serving verified contents of %s on %s", rootURL, *listen)
+	if err := http.ListenAndServe(*listen, mux); err != nil {
+		klog.Exitf("ListenAndServe: %v", err)
+	}
+}
+
+// proxy serves the verified contents of an upstream log.
+type proxy struct {
+	f          client.Fetcher
+	bundleSize uint64
+
+	// mu guards tracker, including its ProofBuilder which is not thread-safe.
+	mu      sync.Mutex
+	tracker client.LogStateTracker
+}
+
+// update fetches the latest checkpoint from the upstream log, and if it's
+// consistent with the one currently being served, starts serving it instead.
+func (p *proxy) update(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	oldSize := p.tracker.LatestConsistent.Size
+	if _, _, _, err := p.tracker.Update(ctx); err != nil {
+		if e := (client.ErrInconsistency{}); errors.As(err, &e) {
+			klog.Errorf("Upstream log presented an inconsistent checkpoint, continuing to serve the last good one:\n%s\n%v", e.LargerRaw, e)
+		}
+		return err
+	}
+	if newSize := p.tracker.LatestConsistent.Size; newSize != oldSize {
+		klog.V(1).Infof("Updated checkpoint from %d to %d", oldSize, newSize)
+	}
+	return nil
+}
+
+// serveCheckpoint serves the latest checkpoint which has been verified, and
+// proven consistent with those served before it.
+func (p *proxy) serveCheckpoint(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	cpRaw := p.tracker.LatestConsistentRaw
+	p.mu.Unlock()
+	if _, err := w.Write(cpRaw); err != nil {
+		klog.Errorf("Failed to write checkpoint response: %v", err)
+	}
+}
+
+// serveLeafBundle serves a leaf bundle from the upstream log once each of the
+// leaves in it has been proven to be included under the latest checkpoint.
+func (p *proxy) serveLeafBundle(w http.ResponseWriter, r *http.Request) {
+	first, n, err := p.parseBundlePath(strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	raw, err := p.f(r.Context(), strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, fmt.Sprintf("failed to fetch leaves from upstream log: %v", err), http.StatusBadGateway)
+		return
+	}
+	leaves, err := p.splitBundle(raw, n)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid leaf bundle from upstream log: %v", err), http.StatusBadGateway)
+		return
+	}
+	if err := p.verifyInclusion(r.Context(), first, leaves); err != nil {
+		klog.Warningf("Rejecting leaves from %d: %v", first, err)
+		http.Error(w, fmt.Sprintf("failed to verify leaves from upstream log: %v", err), http.StatusBadGateway)
+		return
+	}
+	if _, err := w.Write(raw); err != nil {
+		klog.Errorf("Failed to write leaf bundle response: %v", err)
+	}
+}
+
+// parseBundlePath returns the index of the first leaf in the leaf bundle at
+// the given path, and the number of leaves it should contain.
+func (p *proxy) parseBundlePath(path string) (uint64, uint64, error) {
+	seqPath, suffix, partial := strings.Cut(path, ".")
+	bi, err := layout.SeqFromPath("", filepath.FromSlash(seqPath))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid leaf bundle path %q: %v", path, err)
+	}
+	n := p.bundleSize
+	if partial {
+		if n, err = strconv.ParseUint(suffix, 10, 64); err != nil || n == 0 || n >= p.bundleSize {
+			return 0, 0, fmt.Errorf("invalid partial leaf bundle size in %q", path)
+		}
+	}
+	return bi * p.bundleSize, n, nil
+}
+
+// splitBundle returns the n leaves contained in the raw leaf bundle.
+func (p *proxy) splitBundle(raw []byte, n uint64) ([][]byte, error) {
+	if p.bundleSize == 1 {
+		return [][]byte{raw}, nil
+	}
+	lines := bytes.Split(bytes.TrimSuffix(raw, []byte("\n")), []byte("\n"))
+	if uint64(len(lines)) != n {
+		return nil, fmt.Errorf("got %d leaves, want %d", len(lines), n)
+	}
+	leaves := make([][]byte, 0, n)
+	for i, l := range lines {
+		leaf, err := base64.StdEncoding.DecodeString(string(l))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode leaf %d: %v", i, err)
+		}
+		leaves = append(leaves, leaf)
+	}
+	return leaves, nil
+}
+
+// verifyInclusion checks that leaves, the first of which is at index first,
+// are included in the log under the latest checkpoint.
+func (p *proxy) verifyInclusion(ctx context.Context, first uint64, leaves [][]byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cp := p.tracker.LatestConsistent
+	if end := first + uint64(len(leaves)); end > cp.Size {
+		return fmt.Errorf("leaves up to index %d are not yet committed to by the latest checkpoint of size %d", end-1, cp.Size)
+	}
+	indices := make([]uint64, 0, len(leaves))
+	for i := range leaves {
+		indices = append(indices, first+uint64(i))
+	}
+	proofs, err := p.tracker.ProofBuilder.InclusionProofs(ctx, indices)
+	if err != nil {
+		return fmt.Errorf("failed to build inclusion proofs: %v", err)
+	}
+	h := p.tracker.Hasher
+	for i, l := range leaves {
+		idx := first + uint64(i)
+		if err := proof.VerifyInclusion(h, idx, cp.Size, h.HashLeaf(l), proofs[idx], cp.Hash); err != nil {
+			if dErr := p.tracker.ProofBuilder.DiagnoseInclusion(ctx, idx, h.HashLeaf(l)); dErr != nil {
+				return fmt.Errorf("failed to verify inclusion of leaf %d: %v: %v", idx, err, dErr)
+			}
+			return fmt.Errorf("failed to verify inclusion of leaf %d: %v", idx, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package main provides a command line tool which independently validates a
+// serverless log's checkpoint by replaying all of its sequenced entries into
+// a fresh tree, and comparing the result with the signed checkpoint.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/client"
+	"github.com/transparency-dev/serverless-log/internal/storage/fs"
+	"github.com/transparency-dev/serverless-log/pkg/log"
+	"github.com/transparency-dev/serverless-log/testonly"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+var (
+	storageDir = flag.String("storage_dir", "", "Root directory of the log to validate. The log is only read from.")
+	pubKeyFile = flag.String("public_key", "", "Location of public key file. If unset, uses the contents of the SERVERLESS_LOG_PUBLIC_KEY environment variable.")
+	origin     = flag.String("origin", "", "Log origin string expected in the log's checkpoint.")
+)
+
+// errEnoughEntries is used to stop scanning once all of the entries committed
+// to by the checkpoint have been seen.
+var errEnoughEntries = errors.New("enough entries")
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	ctx := context.Background()
+
+	if len(*origin) == 0 {
+		klog.Exitf("Please set --origin flag to log identifier.")
+	}
+	var pubKey string
+	if len(*pubKeyFile) > 0 {
+		k, err := os.ReadFile(*pubKeyFile)
+		if err != nil {
+			klog.Exitf("Unable to get public key: %q", err)
+		}
+		pubKey = string(k)
+	} else {
+		pubKey = os.Getenv("SERVERLESS_LOG_PUBLIC_KEY")
+		if len(pubKey) == 0 {
+			klog.Exit("Supply public key file path using --public_key or set SERVERLESS_LOG_PUBLIC_KEY environment variable")
+		}
+	}
+	v, err := note.NewVerifier(pubKey)
+	if err != nil {
+		klog.Exitf("Failed to instantiate Verifier: %q", err)
+	}
+
+	st, err := fs.OpenReadOnly(*storageDir)
+	if err != nil {
+		klog.Exitf("Failed to open log: %q", err)
+	}
+	cpRaw, err := st.ReadCheckpoint(ctx)
+	if err != nil {
+		klog.Exitf("Failed to read log checkpoint: %q", err)
+	}
+	cp, _, _, err := client.ParseCheckpoint(cpRaw, *origin, v)
+	if err != nil {
+		klog.Exitf("Failed to open Checkpoint: %q", err)
+	}
+
+	if err := replay(ctx, st, cp.Size, cp.Hash); err != nil {
+		klog.Exitf("Checkpoint is NOT valid: %v", err)
+	}
+	klog.Infof("Checkpoint is valid: replaying %d stored entries reproduced root hash %x", cp.Size, cp.Hash)
+}
+
+// replay sequences the first size entries stored in st into a fresh in-memory
+// tree, and checks that its root hash matches root.
+// If it doesn't, the leaf hashes of the fresh tree are compared with those
+// stored in st's tiles to find the first differing entry.
+func replay(ctx context.Context, st *fs.Storage, size uint64, root []byte) error {
+	h := rfc6962.DefaultHasher
+	mem := testonly.NewMemStorage()
+	n, err := st.ScanSequenced(ctx, 0, func(seq uint64, entry []byte) error {
+		if seq >= size {
+			return errEnoughEntries
+		}
+		if _, err := mem.Sequence(ctx, h.HashLeaf(entry), entry); err != nil {
+			return fmt.Errorf("failed to replay entry %d: %v", seq, err)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errEnoughEntries) {
+		return fmt.Errorf("failed to scan sequenced entries: %v", err)
+	}
+	if n < size {
+		return fmt.Errorf("checkpoint commits to %d entries, but only %d contiguous entries are stored; entry %d is missing", size, n, n)
+	}
+
+	newCP, err := log.Integrate(ctx, 0, mem, h)
+	if err != nil {
+		return fmt.Errorf("failed to integrate replayed entries: %v", err)
+	}
+	if newCP == nil {
+		// Nothing was integrated, which is only correct for an empty log.
+		if size != 0 || !bytes.Equal(root, h.EmptyRoot()) {
+			return fmt.Errorf("replayed tree is empty, but checkpoint has size %d and root hash %x", size, root)
+		}
+		return nil
+	}
+	if newCP.Size != size {
+		return fmt.Errorf("replayed tree has size %d, want %d", newCP.Size, size)
+	}
+	if bytes.Equal(newCP.Hash, root) {
+		return nil
+	}
+
+	// Find where the replayed tree and the stored tree diverge.
+	want, err := client.FetchLeafHashes(ctx, mem.Fetcher(), 0, size, size)
+	if err != nil {
+		return fmt.Errorf("failed to fetch replayed leaf hashes: %v", err)
+	}
+	got, err := client.FetchLeafHashes(ctx, st.Fetcher(), 0, size, size)
+	if err != nil {
+		return fmt.Errorf("replayed root hash %x differs from checkpoint root hash %x, and failed to read stored leaf hashes: %v", newCP.Hash, root, err)
+	}
+	for i := range want {
+		if !bytes.Equal(want[i], got[i]) {
+			return fmt.Errorf("replayed root hash %x differs from checkpoint root hash %x: first differing entry is %d, whose stored leaf hash is %x but whose contents hash to %x", newCP.Hash, root, i, got[i], want[i])
+		}
+	}
+	return fmt.Errorf("replayed root hash %x differs from checkpoint root hash %x, but all stored leaf hashes match the stored entries; the log's higher tiles or its checkpoint are corrupt", newCP.Hash, root)
+}
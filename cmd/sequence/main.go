@@ -24,14 +24,14 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/client"
 	"github.com/transparency-dev/serverless-log/internal/storage/fs"
 	"golang.org/x/mod/sumdb/note"
 
 	"github.com/transparency-dev/merkle/rfc6962"
 	"github.com/transparency-dev/serverless-log/pkg/log"
 	"k8s.io/klog/v2"
-
-	fmtlog "github.com/transparency-dev/formats/log"
 )
 
 var (
@@ -39,6 +39,9 @@ var (
 	entries    = flag.String("entries", "", "File path glob of entries to add to the log.")
 	pubKeyFile = flag.String("public_key", "", "Location of public key file. If unset, uses the contents of the SERVERLESS_LOG_PUBLIC_KEY environment variable.")
 	origin     = flag.String("origin", "", "Log origin string to check for in checkpoint.")
+	leafFormat = flag.String("leaf_format", "", "If set, entries must be in this format to be sequenced, others are rejected. Supported formats: json")
+	rebuildIdx = flag.Bool("rebuild_dedupe_index", false, "If set, restore any missing dedupe index entries for sequenced entries before sequencing, e.g. after a crash.")
+	tileWidth  = flag.Uint64("tile_width", layout.TileWidth, "The width of the log's tiles, which must be a power of two and match the width the log is integrated with.")
 )
 
 func main() {
@@ -60,6 +63,14 @@ func main() {
 		}
 	}
 
+	var validator log.LeafValidator
+	if len(*leafFormat) > 0 {
+		var ok bool
+		if validator, ok = log.LeafValidators[*leafFormat]; !ok {
+			klog.Exitf("Unsupported --leaf_format %q", *leafFormat)
+		}
+	}
+
 	toAdd, err := filepath.Glob(*entries)
 	if err != nil {
 		klog.Exitf("Failed to glob entries %q: %q", *entries, err)
@@ -81,7 +92,7 @@ func main() {
 	if err != nil {
 		klog.Exitf("Failed to instantiate Verifier: %q", err)
 	}
-	cp, _, _, err := fmtlog.ParseCheckpoint(cpRaw, *origin, v)
+	cp, _, _, err := client.ParseCheckpoint(cpRaw, *origin, v)
 	if err != nil {
 		klog.Exitf("Failed to parse Checkpoint: %q", err)
 	}
@@ -90,6 +101,18 @@ func main() {
 	if err != nil {
 		klog.Exitf("Failed to load storage: %q", err)
 	}
+	if err := st.SetTileWidth(*tileWidth); err != nil {
+		klog.Exitf("Invalid --tile_width: %v", err)
+	}
+	st.SetLeafValidator(validator)
+
+	if *rebuildIdx {
+		restored, err := st.RebuildDedupeIndex(context.Background(), 0, h.HashLeaf)
+		if err != nil {
+			klog.Exitf("Failed to rebuild dedupe index: %q", err)
+		}
+		klog.Infof("Restored %d dedupe index entries", restored)
+	}
 
 	// sequence entries
 
@@ -113,6 +136,7 @@ func main() {
 		close(entries)
 	}()
 
+	rejected := 0
 	for entry := range entries {
 		// ask storage to sequence
 		lh := h.HashLeaf(entry.b)
@@ -121,6 +145,10 @@ func main() {
 		if err != nil {
 			if errors.Is(err, log.ErrDupeLeaf) {
 				dupe = true
+			} else if errors.As(err, &log.ErrInvalidLeaf{}) {
+				klog.Warningf("Rejected %q: %v", entry.name, err)
+				rejected++
+				continue
 			} else {
 				klog.Exitf("failed to sequence %q: %q", entry.name, err)
 			}
@@ -131,4 +159,7 @@ func main() {
 		}
 		klog.Info(l)
 	}
+	if rejected > 0 {
+		klog.Exitf("Rejected %d invalid entries", rejected)
+	}
 }
@@ -0,0 +1,363 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage provides a log storage implementation on Amazon S3.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/transparency-dev/serverless-log/api"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/client"
+	"github.com/transparency-dev/serverless-log/pkg/log"
+	"k8s.io/klog/v2"
+)
+
+// Client is a serverless storage implementation which uses an S3 bucket to
+// store tree state. The naming of the objects in the bucket is:
+//
+//	leaves/aa/bb/cc/ddeeff...
+//	seq/aa/bb/cc/ddeeff...
+//	tile/<level>/aa/bb/ccddee...
+//	checkpoint
+//
+// The functions on this struct are not thread-safe.
+type Client struct {
+	s3Client *s3.Client
+	// bucket is the name of the bucket where tree data will be stored.
+	bucket string
+	// nextSeq is a hint to the Sequence func as to what the next available
+	// sequence number is to help performance.
+	// Note that nextSeq may be <= than the actual next available number, but
+	// never greater.
+	nextSeq uint64
+	// checkpointETag is the ETag of the checkpoint object that this client
+	// last read. This is used for read-modify-write of the checkpoint, in the
+	// same way as GCS generation numbers are by the GCS implementation.
+	checkpointETag string
+
+	checkpointCacheControl string
+	otherCacheControl      string
+}
+
+// ErrCheckpointConflict is returned by WriteCheckpoint if the checkpoint has
+// been written by someone else since it was last read by this client.
+var ErrCheckpointConflict = errors.New("checkpoint has changed since it was read")
+
+// ClientOpts holds configuration options for the storage client.
+type ClientOpts struct {
+	// Bucket is the name of the bucket to use for storing log state.
+	Bucket string
+	// Region is the AWS region which hosts the bucket. If unset, the region
+	// is taken from the environment or shared config, as usual for the AWS SDK.
+	Region string
+	// CheckpointCacheControl, if set, will cause the Cache-Control header associated with the
+	// checkpoint object to be set to this value.
+	CheckpointCacheControl string
+	// OtherCacheControl, if set, will cause the Cache-Control header associated with the
+	// all non-checkpoint objects to be set to this value.
+	OtherCacheControl string
+	// Endpoint, if set, overrides the S3 endpoint used by the client, e.g. to
+	// target MinIO or another S3 compatible service. Path-style addressing is
+	// used with custom endpoints.
+	Endpoint string
+}
+
+// NewClient returns a Client which allows interaction with the log stored in
+// the specified bucket on S3. Credentials are loaded from the environment or
+// shared config, as usual for the AWS SDK.
+func NewClient(ctx context.Context, opts ClientOpts) (*Client, error) {
+	var lopts []func(*config.LoadOptions) error
+	if opts.Region != "" {
+		lopts = append(lopts, config.WithRegion(opts.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, lopts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	c := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &Client{
+		s3Client:               c,
+		bucket:                 opts.Bucket,
+		checkpointCacheControl: opts.CheckpointCacheControl,
+		otherCacheControl:      opts.OtherCacheControl,
+	}, nil
+}
+
+// SetNextSeq sets the input as the nextSeq of the client.
+func (c *Client) SetNextSeq(num uint64) {
+	c.nextSeq = num
+}
+
+// httpStatus returns the HTTP status code of the S3 response which caused
+// err, or 0 if err wasn't caused by an S3 response.
+func httpStatus(err error) int {
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		return re.HTTPStatusCode()
+	}
+	return 0
+}
+
+// isPreconditionFailure returns true if err was caused by a conditional
+// write's precondition not being met. S3 reports a conditional write which
+// races with another write to the same object as a conflict.
+func isPreconditionFailure(err error) bool {
+	s := httpStatus(err)
+	return s == http.StatusPreconditionFailed || s == http.StatusConflict
+}
+
+// getObject returns the content of the object at key, along with its ETag.
+// If the object does not exist, the returned error wraps os.ErrNotExist.
+func (c *Client) getObject(ctx context.Context, key string) ([]byte, string, error) {
+	out, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if httpStatus(err) == http.StatusNotFound {
+			return nil, "", fmt.Errorf("object %q in bucket %q: %w", key, c.bucket, os.ErrNotExist)
+		}
+		return nil, "", fmt.Errorf("failed to get object %q in bucket %q: %w", key, c.bucket, err)
+	}
+	defer out.Body.Close()
+	d, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read object %q in bucket %q: %w", key, c.bucket, err)
+	}
+	return d, aws.ToString(out.ETag), nil
+}
+
+// putObject writes data to the object at key. If ifNoneMatch is set, the
+// write only succeeds if no object exists at key, and if ifMatch is set, it
+// only succeeds if the existing object has that ETag.
+func (c *Client) putObject(ctx context.Context, key string, data []byte, cacheControl string, ifNoneMatch bool, ifMatch string) error {
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	}
+	if cacheControl != "" {
+		in.CacheControl = aws.String(cacheControl)
+	}
+	if ifNoneMatch {
+		in.IfNoneMatch = aws.String("*")
+	}
+	if ifMatch != "" {
+		in.IfMatch = aws.String(ifMatch)
+	}
+	_, err := c.s3Client.PutObject(ctx, in)
+	return err
+}
+
+// WriteCheckpoint stores a raw log checkpoint on S3 if it matches the ETag
+// that the client thinks the checkpoint has. The client updates the ETag of
+// the checkpoint whenever ReadCheckpoint is called.
+//
+// This method will fail to write if 1) the checkpoint exists and the client
+// has never read it, or 2) the checkpoint has been updated since the client
+// called ReadCheckpoint. In both cases, the returned error wraps
+// ErrCheckpointConflict.
+func (c *Client) WriteCheckpoint(ctx context.Context, newCPRaw []byte) error {
+	err := c.putObject(ctx, layout.CheckpointPath, newCPRaw, c.checkpointCacheControl, c.checkpointETag == "", c.checkpointETag)
+	if err != nil {
+		if isPreconditionFailure(err) {
+			return fmt.Errorf("%w: %v", ErrCheckpointConflict, err)
+		}
+		return fmt.Errorf("failed to write checkpoint to bucket %q: %w", c.bucket, err)
+	}
+	return nil
+}
+
+// ReadCheckpoint reads from S3 and returns the contents of the log checkpoint.
+func (c *Client) ReadCheckpoint(ctx context.Context) ([]byte, error) {
+	d, etag, err := c.getObject(ctx, layout.CheckpointPath)
+	if err != nil {
+		return nil, err
+	}
+	c.checkpointETag = etag
+	return d, nil
+}
+
+// Fetcher returns a client.Fetcher which reads objects from the log's bucket.
+// Unlike ReadCheckpoint, fetching the checkpoint doesn't change the ETag which
+// WriteCheckpoint expects it to have.
+func (c *Client) Fetcher() client.Fetcher {
+	return func(ctx context.Context, p string) ([]byte, error) {
+		d, _, err := c.getObject(ctx, p)
+		return d, err
+	}
+}
+
+// GetTile returns the tile at the given tile-level and tile-index.
+// If no complete tile exists at that location, it will attempt to find a
+// partial tile for the given tree size at that location.
+func (c *Client) GetTile(ctx context.Context, level, index, logSize uint64) (*api.Tile, error) {
+	tileSize := layout.PartialTileSize(level, index, logSize)
+	// Pass an empty rootDir since we don't need this concept in S3.
+	objName := filepath.Join(layout.TilePath("", level, index, tileSize))
+	t, _, err := c.getObject(ctx, objName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Return the generic NotExist error so that tileCache.Visit can differentiate
+			// between this and other errors.
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+
+	var tile api.Tile
+	if err := tile.UnmarshalText(t); err != nil {
+		return nil, fmt.Errorf("failed to parse tile: %w", err)
+	}
+	return &tile, nil
+}
+
+// ScanSequenced calls the provided function once for each contiguous entry
+// in storage starting at begin.
+// The scan will abort if the function returns an error, otherwise it will
+// return the number of sequenced entries scanned.
+func (c *Client) ScanSequenced(ctx context.Context, begin uint64, f func(seq uint64, entry []byte) error) (uint64, error) {
+	end := begin
+	for {
+		// Pass an empty rootDir since we don't need this concept in S3.
+		sp := filepath.Join(layout.SeqPath("", end))
+		entry, _, err := c.getObject(ctx, sp)
+		if errors.Is(err, os.ErrNotExist) {
+			// we're done.
+			return end - begin, nil
+		} else if err != nil {
+			return end - begin, fmt.Errorf("failed to read leafdata at index %d: %w", end, err)
+		}
+		if err := f(end, entry); err != nil {
+			return end - begin, err
+		}
+		end++
+	}
+}
+
+// Sequence assigns the given leaf entry to the next available sequence number.
+// This method will attempt to silently squash duplicate leaves, but it cannot
+// be guaranteed that no duplicate entries will exist.
+// Returns the sequence number assigned to this leaf (if the leaf has already
+// been sequenced it will return the original sequence number and ErrDupeLeaf).
+func (c *Client) Sequence(ctx context.Context, leafhash []byte, leaf []byte) (uint64, error) {
+	// 1. Check for dupe leafhash
+	// 2. Create seq file
+	// 3. Create leafhash file containing assigned sequence number
+
+	// Check for dupe leaf already present.
+	leafPath := filepath.Join(layout.LeafPath("", leafhash))
+	seqString, _, err := c.getObject(ctx, leafPath)
+	if err == nil {
+		// If there is one, it should contain the existing leaf's sequence number,
+		// so return that.
+		origSeq, err := strconv.ParseUint(string(seqString), 16, 64)
+		if err != nil {
+			return 0, err
+		}
+		return origSeq, log.ErrDupeLeaf
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	// Now try to sequence it, we may have to skip over some newly sequenced
+	// entries if Sequence has been called since the last time an
+	// Integrate/WriteCheckpoint was called.
+	for {
+		seq := c.nextSeq
+		seqPath := filepath.Join(layout.SeqPath("", seq))
+
+		// Conditionally write only if the object does not exist yet. This may
+		// exist if there is more than one instance of the sequencer writing to
+		// the same log.
+		if err := c.putObject(ctx, seqPath, leaf, c.otherCacheControl, true, ""); err != nil {
+			if isPreconditionFailure(err) {
+				// That sequence number is in use, try the next one.
+				klog.V(2).Infof("Sequence number %d in use, trying %d", seq, seq+1)
+				c.nextSeq++
+				continue
+			}
+			return 0, fmt.Errorf("failed to write seq object %q: %w", seqPath, err)
+		}
+		c.nextSeq = seq + 1
+
+		// Create a leafhash file containing the assigned sequence number.
+		// This isn't infallible though, if we crash after writing the sequence
+		// file above but before doing this, a resubmission of the same leafhash
+		// would be permitted.
+		if err := c.putObject(ctx, leafPath, []byte(strconv.FormatUint(seq, 16)), c.otherCacheControl, false, ""); err != nil {
+			return 0, fmt.Errorf("couldn't create leafhash object %q: %w", leafPath, err)
+		}
+
+		// All done!
+		return seq, nil
+	}
+}
+
+// StoreTile writes a tile out to S3.
+// Fully populated tiles are stored at the path corresponding to the level &
+// index parameters, partially populated (i.e. right-hand edge) tiles are
+// stored with a .xx suffix where xx is the number of "tile leaves" in hex.
+func (c *Client) StoreTile(ctx context.Context, level, index uint64, tile *api.Tile) error {
+	tileSize := uint64(tile.NumLeaves)
+	klog.V(2).Infof("StoreTile: level %d index %x ts: %x", level, index, tileSize)
+	if tileSize == 0 || tileSize > layout.TileWidth {
+		return fmt.Errorf("tileSize %d must be > 0 and <= %d", tileSize, layout.TileWidth)
+	}
+	t, err := tile.MarshalText()
+	if err != nil {
+		return fmt.Errorf("failed to marshal tile: %w", err)
+	}
+
+	// Pass an empty rootDir since we don't need this concept in S3.
+	tPath := filepath.Join(layout.TilePath("", level, index, tileSize%layout.TileWidth))
+
+	// Tiles, partial or full, should only be written once.
+	if err := c.putObject(ctx, tPath, t, c.otherCacheControl, true, ""); err != nil {
+		if !isPreconditionFailure(err) {
+			return fmt.Errorf("failed to write tile object %q to bucket %q: %w", tPath, c.bucket, err)
+		}
+		// If we run into a precondition failure, check that the object which
+		// exists contains the same content that we want to write.
+		existing, _, err := c.getObject(ctx, tPath)
+		if err != nil {
+			return fmt.Errorf("failed to read content of %q: %w", tPath, err)
+		}
+		if !bytes.Equal(existing, t) {
+			return fmt.Errorf("assertion that tile content for %q has not changed failed", tPath)
+		}
+		klog.V(2).Infof("StoreTile: identical tile already exists for level %d index %x ts: %x", level, index, tileSize)
+	}
+	return nil
+}
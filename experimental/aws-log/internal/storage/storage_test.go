@@ -0,0 +1,255 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api"
+	"github.com/transparency-dev/serverless-log/pkg/log"
+)
+
+const testBucket = "test-bucket"
+
+// fakeObject is an object held by fakeS3.
+type fakeObject struct {
+	data         []byte
+	etag         string
+	cacheControl string
+}
+
+// fakeS3 is a minimal in-memory S3 server which supports path-style GET and
+// PUT of objects, including the conditional puts relied upon by Client.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+// get returns the object stored at key.
+func (f *fakeS3) get(key string) fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[key]
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testBucket+"/")
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, exists := f.objects[key]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", o.etag)
+		_, _ = w.Write(o.data)
+	case http.MethodPut:
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && (!exists || m != o.etag) {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		d, err := io.ReadAll(r.Body)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		o = fakeObject{data: d, etag: fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(d))), cacheControl: r.Header.Get("Cache-Control")}
+		f.objects[key] = o
+		w.Header().Set("ETag", o.etag)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// newTestClient returns a Client for testBucket on the fake S3 server.
+func newTestClient(ctx context.Context, t *testing.T, f *fakeS3, opts ClientOpts) *Client {
+	t.Helper()
+	s := httptest.NewServer(f)
+	t.Cleanup(s.Close)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	opts.Bucket, opts.Region, opts.Endpoint = testBucket, "us-east-1", s.URL
+	c, err := NewClient(ctx, opts)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+func TestWriteCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3{objects: make(map[string]fakeObject)}
+	c1 := newTestClient(ctx, t, f, ClientOpts{CheckpointCacheControl: "no-cache"})
+	c2 := newTestClient(ctx, t, f, ClientOpts{})
+
+	// With no checkpoint, the first write succeeds.
+	if err := c1.WriteCheckpoint(ctx, []byte("one")); err != nil {
+		t.Fatalf("WriteCheckpoint(one): %v", err)
+	}
+	if got, want := f.get("checkpoint").cacheControl, "no-cache"; got != want {
+		t.Errorf("Got checkpoint Cache-Control %q, want %q", got, want)
+	}
+	// A client which has never read the checkpoint can't overwrite it.
+	if err := c2.WriteCheckpoint(ctx, []byte("two")); !errors.Is(err, ErrCheckpointConflict) {
+		t.Fatalf("WriteCheckpoint(two) without read: got err %v, want ErrCheckpointConflict", err)
+	}
+
+	// Fetching the checkpoint doesn't allow it to be overwritten.
+	if got, err := c2.Fetcher()(ctx, "checkpoint"); err != nil || !bytes.Equal(got, []byte("one")) {
+		t.Fatalf("Fetch checkpoint = %q, %v, want %q", got, err, "one")
+	}
+	if err := c2.WriteCheckpoint(ctx, []byte("two")); !errors.Is(err, ErrCheckpointConflict) {
+		t.Fatalf("WriteCheckpoint(two) after fetch: got err %v, want ErrCheckpointConflict", err)
+	}
+
+	// Both clients read the checkpoint, but only the first write succeeds.
+	for _, c := range []*Client{c1, c2} {
+		got, err := c.ReadCheckpoint(ctx)
+		if err != nil {
+			t.Fatalf("ReadCheckpoint: %v", err)
+		}
+		if want := []byte("one"); !bytes.Equal(got, want) {
+			t.Fatalf("ReadCheckpoint = %q, want %q", got, want)
+		}
+	}
+	if err := c2.WriteCheckpoint(ctx, []byte("two")); err != nil {
+		t.Fatalf("WriteCheckpoint(two) after read: %v", err)
+	}
+	if err := c1.WriteCheckpoint(ctx, []byte("three")); !errors.Is(err, ErrCheckpointConflict) {
+		t.Fatalf("WriteCheckpoint(three) after concurrent write: got err %v, want ErrCheckpointConflict", err)
+	}
+	if got, want := f.get("checkpoint").data, []byte("two"); !bytes.Equal(got, want) {
+		t.Errorf("Got stored checkpoint %q, want %q", got, want)
+	}
+}
+
+func TestStoreTile(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3{objects: make(map[string]fakeObject)}
+	c := newTestClient(ctx, t, f, ClientOpts{OtherCacheControl: "max-age=3600"})
+
+	tile := &api.Tile{NumLeaves: 2, Nodes: [][]byte{bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32), bytes.Repeat([]byte{3}, 32)}}
+	if err := c.StoreTile(ctx, 0, 0, tile); err != nil {
+		t.Fatalf("StoreTile: %v", err)
+	}
+	if got, want := f.get("tile/00/0000/00/00/00.02").cacheControl, "max-age=3600"; got != want {
+		t.Errorf("Got tile Cache-Control %q, want %q", got, want)
+	}
+	got, err := c.GetTile(ctx, 0, 0, 2)
+	if err != nil {
+		t.Fatalf("GetTile: %v", err)
+	}
+	if got.NumLeaves != tile.NumLeaves || len(got.Nodes) != len(tile.Nodes) {
+		t.Errorf("GetTile = %+v, want %+v", got, tile)
+	}
+
+	// Storing an identical tile again is fine, but different content is not.
+	if err := c.StoreTile(ctx, 0, 0, tile); err != nil {
+		t.Errorf("StoreTile(identical): %v", err)
+	}
+	tile.Nodes[0] = bytes.Repeat([]byte{4}, 32)
+	if err := c.StoreTile(ctx, 0, 0, tile); err == nil {
+		t.Error("StoreTile(different): got nil err, want error")
+	}
+	if _, err := c.GetTile(ctx, 0, 1, 300); err == nil {
+		t.Error("GetTile(missing): got nil err, want error")
+	}
+}
+
+func TestSequenceAndIntegrate(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	f := &fakeS3{objects: make(map[string]fakeObject)}
+	c1 := newTestClient(ctx, t, f, ClientOpts{})
+	c2 := newTestClient(ctx, t, f, ClientOpts{})
+
+	leaves := [][]byte{[]byte("zero"), []byte("one"), []byte("two"), []byte("three")}
+	for i, l := range leaves[:3] {
+		seq, err := c1.Sequence(ctx, h.HashLeaf(l), l)
+		if err != nil {
+			t.Fatalf("Sequence(%q): %v", l, err)
+		}
+		if seq != uint64(i) {
+			t.Errorf("Sequence(%q) = %d, want %d", l, seq, i)
+		}
+	}
+	// Duplicates return the original sequence number.
+	if seq, err := c2.Sequence(ctx, h.HashLeaf(leaves[1]), leaves[1]); !errors.Is(err, log.ErrDupeLeaf) || seq != 1 {
+		t.Errorf("Sequence(dupe) = %d, %v, want 1, ErrDupeLeaf", seq, err)
+	}
+	// A client which doesn't know about the sequenced entries skips over them.
+	if seq, err := c2.Sequence(ctx, h.HashLeaf(leaves[3]), leaves[3]); err != nil || seq != 3 {
+		t.Errorf("Sequence(%q) = %d, %v, want 3, nil", leaves[3], seq, err)
+	}
+
+	var scanned [][]byte
+	n, err := c1.ScanSequenced(ctx, 0, func(_ uint64, entry []byte) error {
+		scanned = append(scanned, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanSequenced: %v", err)
+	}
+	if n != uint64(len(leaves)) {
+		t.Errorf("ScanSequenced = %d, want %d", n, len(leaves))
+	}
+	for i := range leaves {
+		if !bytes.Equal(scanned[i], leaves[i]) {
+			t.Errorf("Scanned entry %d = %q, want %q", i, scanned[i], leaves[i])
+		}
+	}
+
+	cp, err := log.Integrate(ctx, 0, c1, h)
+	if err != nil {
+		t.Fatalf("Integrate: %v", err)
+	}
+	hashes := make([][]byte, 0, len(leaves))
+	for _, l := range leaves {
+		hashes = append(hashes, h.HashLeaf(l))
+	}
+	want := h.HashChildren(h.HashChildren(hashes[0], hashes[1]), h.HashChildren(hashes[2], hashes[3]))
+	if cp.Size != uint64(len(leaves)) || !bytes.Equal(cp.Hash, want) {
+		t.Errorf("Integrate = size %d root %x, want size %d root %x", cp.Size, cp.Hash, len(leaves), want)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gcp_serverless_module/internal/storage"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/transparency-dev/serverless-log/pkg/log"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// breakerThresholdEnv is the name of the env var which configures the
+	// number of consecutive storage failures which will trip the breaker.
+	breakerThresholdEnv = "BREAKER_FAILURE_THRESHOLD"
+	// breakerCoolDownEnv is the name of the env var which configures how long
+	// the breaker stays open, as a Go duration string, e.g. "30s".
+	breakerCoolDownEnv = "BREAKER_COOL_DOWN"
+
+	defaultBreakerThreshold = 5
+	defaultBreakerCoolDown  = 30 * time.Second
+)
+
+// errCircuitOpen is returned when a storage operation is refused because
+// the circuit breaker is open.
+var errCircuitOpen = errors.New("circuit breaker open: storage is unavailable")
+
+// breaker guards storage operations made by all invocations of the functions
+// handled by this instance.
+var breaker = newCircuitBreakerFromEnv()
+
+// circuitBreaker is a simple circuit breaker which opens after a configured
+// number of consecutive failures, refusing all operations for a cool-down
+// period. After the cool-down, the breaker half-opens, admitting a single
+// probe operation while continuing to refuse all others. If the probe
+// succeeds the breaker closes, otherwise it reopens for another cool-down.
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	threshold int
+	coolDown  time.Duration
+
+	failures int
+	// openUntil is the end of the current cool-down, or zero if the breaker
+	// is closed. It stays set while the breaker is half-open.
+	openUntil time.Time
+	// probing is true while the probe admitted by a half-open breaker is
+	// in flight.
+	probing bool
+}
+
+// newCircuitBreakerFromEnv returns a circuitBreaker configured via env vars,
+// falling back to defaults for any which are unset or invalid.
+func newCircuitBreakerFromEnv() *circuitBreaker {
+	threshold := defaultBreakerThreshold
+	if v := os.Getenv(breakerThresholdEnv); v != "" {
+		if t, err := strconv.Atoi(v); err == nil && t > 0 {
+			threshold = t
+		} else {
+			fmt.Printf("Ignoring invalid %s value %q\n", breakerThresholdEnv, v)
+		}
+	}
+	coolDown := defaultBreakerCoolDown
+	if v := os.Getenv(breakerCoolDownEnv); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			coolDown = d
+		} else {
+			fmt.Printf("Ignoring invalid %s value %q\n", breakerCoolDownEnv, v)
+		}
+	}
+	return &circuitBreaker{threshold: threshold, coolDown: coolDown}
+}
+
+// allow returns errCircuitOpen if operations should not currently be attempted,
+// i.e. if the breaker is open, or is half-open with its probe in flight.
+// It doesn't admit the caller as the probe, so can be used to reject requests
+// early without holding the breaker half-open.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return errCircuitOpen
+	}
+	return nil
+}
+
+// acquire returns errCircuitOpen if an operation should not be attempted now.
+// Otherwise, probe is true if the operation is the probe admitted by a
+// half-open breaker, and the outcome of the operation must be passed to
+// record.
+func (b *circuitBreaker) acquire() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return false, nil
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false, errCircuitOpen
+	}
+	// Cool-down has expired, let this operation through to see if things
+	// have recovered.
+	b.probing = true
+	return true, nil
+}
+
+// record updates the state of the breaker with the outcome of an operation,
+// which is the half-open breaker's probe if probe is true.
+func (b *circuitBreaker) record(err error, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	if !isStorageFailure(err) {
+		b.failures = 0
+		if probe {
+			b.openUntil = time.Time{}
+		}
+		return
+	}
+	b.failures++
+	if probe || b.failures >= b.threshold {
+		fmt.Printf("Circuit breaker opening for %v after %d consecutive failures: %v\n", b.coolDown, b.failures, err)
+		b.openUntil = time.Now().Add(b.coolDown)
+		b.failures = 0
+	}
+}
+
+// call runs f if the breaker allows it, and records its outcome.
+func (b *circuitBreaker) call(f func() error) error {
+	probe, err := b.acquire()
+	if err != nil {
+		return err
+	}
+	err = f()
+	b.record(err, probe)
+	return err
+}
+
+// isStorageFailure returns true if err indicates that storage is unhealthy,
+// rather than being an expected outcome of a storage operation.
+func isStorageFailure(err error) bool {
+	if err == nil ||
+		errors.Is(err, errCircuitOpen) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, log.ErrDupeLeaf) ||
+		errors.Is(err, storage.ErrLeaseHeld) ||
+		errors.Is(err, storage.ErrMissingLogSignature) ||
+		errors.As(err, &storage.ErrInvalidLeaf{}) ||
+		errors.Is(err, storage.ErrCheckpointConflict) ||
+		errors.As(err, &storage.ErrCheckpointRollback{}) ||
+		errors.Is(err, gcs.ErrObjectNotExist) ||
+		errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	var e *googleapi.Error
+	if errors.As(err, &e) && e.Code == http.StatusPreconditionFailed {
+		return false
+	}
+	return true
+}
+
+// statusFor returns the HTTP status code to use when reporting err.
+func statusFor(err error) int {
+	if errors.Is(err, errCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, storage.ErrLeaseHeld) || errors.Is(err, storage.ErrLeaseLost) || errors.Is(err, storage.ErrCheckpointConflict) || errors.As(err, &storage.ErrCheckpointRollback{}) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, errUnsupportedKMSKey) || errors.As(err, &storage.ErrInvalidLeaf{}) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
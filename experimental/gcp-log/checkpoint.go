@@ -0,0 +1,58 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p
+
+import (
+	"errors"
+	"fmt"
+
+	fmtlog "github.com/transparency-dev/formats/log"
+	"golang.org/x/mod/sumdb/note"
+)
+
+var (
+	// errMalformedCheckpoint indicates that the checkpoint object could not
+	// be parsed, e.g. because it is empty or corrupt.
+	errMalformedCheckpoint = errors.New("malformed checkpoint")
+	// errUnverifiedCheckpoint indicates that the checkpoint is well-formed,
+	// but isn't validly signed by the log's key, e.g. because the wrong KMS
+	// key is configured.
+	errUnverifiedCheckpoint = errors.New("checkpoint signature verification failed")
+	// errOriginMismatch indicates that the checkpoint is for a different log.
+	errOriginMismatch = errors.New("checkpoint origin mismatch")
+)
+
+// parseCheckpoint verifies and parses a raw checkpoint, returning an error
+// wrapping one of errMalformedCheckpoint, errUnverifiedCheckpoint, or
+// errOriginMismatch on failure so that the cause can be told apart.
+func parseCheckpoint(raw []byte, origin string, v note.Verifier) (*fmtlog.Checkpoint, error) {
+	n, err := note.Open(raw, note.VerifierList(v))
+	if err != nil {
+		var unverified *note.UnverifiedNoteError
+		var invalid *note.InvalidSignatureError
+		if errors.As(err, &unverified) || errors.As(err, &invalid) {
+			return nil, fmt.Errorf("%w: %v", errUnverifiedCheckpoint, err)
+		}
+		return nil, fmt.Errorf("%w: %v", errMalformedCheckpoint, err)
+	}
+	cp := &fmtlog.Checkpoint{}
+	if _, err := cp.Unmarshal([]byte(n.Text)); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformedCheckpoint, err)
+	}
+	if cp.Origin != origin {
+		return nil, fmt.Errorf("%w: got %q, want %q", errOriginMismatch, cp.Origin, origin)
+	}
+	return cp, nil
+}
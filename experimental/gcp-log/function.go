@@ -17,17 +17,27 @@
 package p
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/gcp_serverless_module/internal/storage"
 
 	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	gcs "cloud.google.com/go/storage"
 	"github.com/transparency-dev/armored-witness/pkg/kmssigner"
 	fmtlog "github.com/transparency-dev/formats/log"
 	"github.com/transparency-dev/merkle/rfc6962"
@@ -45,6 +55,41 @@ type requestData struct {
 	KMSKeyName     string `json:"kmsKeyName"`
 	KMSKeyLocation string `json:"kmsKeyLocation"`
 	KMSKeyVersion  uint   `json:"kmsKeyVersion"`
+	// Optional algorithm of the KMS signing keys, which must be one of the
+	// keys of noteKeyAlgorithms. Defaults to ed25519.
+	KMSKeyAlgorithm string `json:"kmsKeyAlgorithm"`
+
+	// Optional witness key used to co-sign checkpoints written by Integrate.
+	// The key must live in the same KMS key ring and location as the log key.
+	WitnessKMSKeyName    string `json:"witnessKmsKeyName"`
+	WitnessKMSKeyVersion uint   `json:"witnessKmsKeyVersion"`
+	WitnessNoteKeyName   string `json:"witnessNoteKeyName"`
+
+	// Optional mirror bucket to which tree data (tiles and checkpoints) will
+	// also be written by Integrate.
+	MirrorBucket string `json:"mirrorBucket"`
+	// If set, failures to write to the mirror bucket will be logged but
+	// otherwise ignored.
+	MirrorBestEffort bool `json:"mirrorBestEffort"`
+
+	// If > 0, limits the rate of tile, seq, and leafhash object writes.
+	MaxWriteOpsPerSecond int `json:"maxWriteOpsPerSecond"`
+	// If set, checkpoint and tile writes will be read back and verified.
+	VerifyWrites bool `json:"verifyWrites"`
+
+	// If > 0, GCS reads and writes which fail with a transient error are
+	// retried up to this many times, with exponential backoff starting at
+	// StorageInitialBackoffMs milliseconds.
+	StorageMaxRetries       int  `json:"storageMaxRetries"`
+	StorageInitialBackoffMs uint `json:"storageInitialBackoffMs"`
+
+	// Optional GCS API endpoint, e.g. of an emulator, to use instead of the
+	// default. If storageWithoutAuth is set, requests are unauthenticated.
+	StorageEndpoint    string `json:"storageEndpoint"`
+	StorageWithoutAuth bool   `json:"storageWithoutAuth"`
+
+	// If set, buckets created by Integrate are not made readable by all users.
+	DisablePublicRead bool `json:"disablePublicRead"`
 
 	// Cache-Control header for checkpoint objects
 	CheckpointCacheControl string `json:"checkpointCacheControl"`
@@ -53,56 +98,154 @@ type requestData struct {
 
 	// For Sequence requests.
 	EntriesDir string `json:"entriesDir"`
+	// For Sequence requests. If set, leaves must be in this format, which
+	// must be one of the keys of leafValidators, or they are rejected.
+	LeafFormat string `json:"leafFormat"`
+	// If > 1, sequenced entries are stored in leaf bundles of this many
+	// entries. This must be the same for every request to a log.
+	LeafBundleSize uint64 `json:"leafBundleSize"`
+	// If non-zero, the width of the log's tiles, rather than the default of
+	// 256. This must be the same for every request to a log, and leaf bundles
+	// must align with the tiles. Only the default width can currently be
+	// integrated, see checkIntegrateArgs.
+	TileWidth uint64 `json:"tileWidth"`
+	// If > 0, the sequencer will hold a lease of this many seconds while
+	// assigning sequence numbers, and fail fast if another sequencer holds it.
+	SequencerLeaseSeconds uint `json:"sequencerLeaseSeconds"`
+	// Optional identity of this sequencer when holding the lease.
+	SequencerID string `json:"sequencerId"`
 
 	// For Integrate requests.
 	Initialise bool `json:"initialise"`
 
 	// For Integrate requests.
 	CreateBucket bool `json:"createBucket"`
+	// For Integrate requests. If the checkpoint is updated by a concurrent
+	// integration before it can be written, re-read it and integrate again
+	// up to this many times.
+	IntegrateRetries uint `json:"integrateRetries"`
+	// For Integrate requests. If > 0, only entries with sequence numbers
+	// below this are integrated, so that the new checkpoint has at most
+	// this size. Later entries are left for a subsequent integration.
+	TargetSize uint64 `json:"targetSize"`
 }
 
 func validateCommonArgs(w http.ResponseWriter, d requestData) (ok bool) {
-	if len(d.Origin) == 0 {
-		http.Error(w, "Please set `origin` in HTTP body to log identifier.", http.StatusBadRequest)
+	if err := checkCommonArgs(d); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return false
 	}
+	return true
+}
+
+// checkCommonArgs returns an error describing the first missing or invalid
+// argument common to all requests, if any.
+func checkCommonArgs(d requestData) error {
+	if len(d.Origin) == 0 {
+		return errors.New("Please set `origin` in request to log identifier.")
+	}
 	if len(d.KMSKeyRing) == 0 {
-		http.Error(w, "Please set `kmsKeyRing` in HTTP body to the signing key's key ring.",
-			http.StatusBadRequest)
-		return false
+		return errors.New("Please set `kmsKeyRing` in request to the signing key's key ring.")
 	}
 	if len(d.KMSKeyName) == 0 {
-		http.Error(w, "Please set `kmsKeyName` in HTTP body to the signing key's name.",
-			http.StatusBadRequest)
-		return false
+		return errors.New("Please set `kmsKeyName` in request to the signing key's name.")
 	}
 	if len(d.KMSKeyLocation) == 0 {
-		http.Error(w, "Please set `kmsKeyLocation` in HTTP body to the signing key's location.",
-			http.StatusBadRequest)
-		return false
+		return errors.New("Please set `kmsKeyLocation` in request to the signing key's location.")
 	}
 	if d.KMSKeyVersion == 0 {
-		http.Error(w, "Please set `kmsKeyVersion` in HTTP body to the signing key's version as an integer.",
-			http.StatusBadRequest)
-		return false
+		return errors.New("Please set `kmsKeyVersion` in request to the signing key's version as an integer.")
 	}
 	if len(d.NoteKeyName) == 0 {
-		http.Error(w, "Please set `noteKeyName` in HTTP body to the key name for the note.",
-			http.StatusBadRequest)
-		return false
+		return errors.New("Please set `noteKeyName` in request to the key name for the note.")
+	}
+	if _, ok := noteKeyAlgorithms[kmsKeyAlgorithm(d)]; !ok {
+		return fmt.Errorf("Unsupported `kmsKeyAlgorithm` %q in request, supported algorithms are: %s.", d.KMSKeyAlgorithm, supportedKeyAlgorithms())
+	}
+	if _, ok := leafValidators[d.LeafFormat]; len(d.LeafFormat) > 0 && !ok {
+		return fmt.Errorf("Unsupported `leafFormat` %q in request, supported formats are: %s.", d.LeafFormat, supportedLeafFormats())
+	}
+	if len(d.WitnessKMSKeyName) > 0 {
+		if d.WitnessKMSKeyVersion == 0 {
+			return errors.New("Please set `witnessKmsKeyVersion` in request to the witness signing key's version as an integer.")
+		}
+		if len(d.WitnessNoteKeyName) == 0 {
+			return errors.New("Please set `witnessNoteKeyName` in request to the witness key name for the note.")
+		}
 	}
 
-	return true
+	return nil
+}
+
+// checkIntegrateArgs returns an error describing the first argument which
+// prevents the log from being integrated, if any.
+func checkIntegrateArgs(d requestData) error {
+	// log.Integrate, from the version of serverless-log which the functions
+	// are built with, only builds tiles of the default width.
+	if d.TileWidth != 0 && d.TileWidth != storage.DefaultTileWidth {
+		return fmt.Errorf("Integrating logs with a `tileWidth` of %d is not supported, only the default of %d.", d.TileWidth, storage.DefaultTileWidth)
+	}
+	return nil
 }
 
 // newClient returns a storage Client built for the request args.
 func newClient(ctx context.Context, d requestData) (*storage.Client, error) {
-	return storage.NewClient(ctx, storage.ClientOpts{
+	return newClientForBucket(ctx, d, d.Bucket)
+}
+
+// newClientForBucket returns a storage Client for the given bucket, configured
+// with the other request args.
+func newClientForBucket(ctx context.Context, d requestData, bucket string) (*storage.Client, error) {
+	c, err := storage.NewClient(ctx, storage.ClientOpts{
 		ProjectID:              os.Getenv("GCP_PROJECT"),
-		Bucket:                 d.Bucket,
+		Bucket:                 bucket,
 		CheckpointCacheControl: d.CheckpointCacheControl,
 		OtherCacheControl:      d.OtherCacheControl,
+		MaxWriteOpsPerSecond:   d.MaxWriteOpsPerSecond,
+		SequencerLease:         time.Duration(d.SequencerLeaseSeconds) * time.Second,
+		SequencerID:            d.SequencerID,
+		VerifyWrites:           d.VerifyWrites,
+		Endpoint:               d.StorageEndpoint,
+		WithoutAuthentication:  d.StorageWithoutAuth,
+		MaxRetries:             d.StorageMaxRetries,
+		InitialBackoff:         time.Duration(d.StorageInitialBackoffMs) * time.Millisecond,
+		DisablePublicRead:      d.DisablePublicRead,
+		LeafBundleSize:         d.LeafBundleSize,
+		TileWidth:              d.TileWidth,
 	})
+	if err != nil {
+		return nil, err
+	}
+	c.SetLeafValidator(leafValidators[d.LeafFormat])
+	return c, nil
+}
+
+// leafValidators maps the names of the formats which leaves can be required
+// to be in to a function which returns an error if a leaf isn't in that format.
+var leafValidators = map[string]func([]byte) error{
+	"json": func(leaf []byte) error {
+		if !json.Valid(leaf) {
+			return errors.New("leaf is not valid JSON")
+		}
+		return nil
+	},
+}
+
+// logOpCounts logs the number of GCS operations made by c on bucket while
+// handling an invocation of fn, so that operators can estimate GCS costs.
+func logOpCounts(fn, bucket string, c *storage.Client) {
+	fmt.Printf("%s: made %v on bucket %q\n", fn, c.OpCounts(), bucket)
+}
+
+// supportedLeafFormats returns a human readable list of the supported leaf
+// formats.
+func supportedLeafFormats() string {
+	formats := make([]string, 0, len(leafValidators))
+	for f := range leafValidators {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	return strings.Join(formats, ", ")
 }
 
 // Sequence is the entrypoint of the `sequence` GCF function.
@@ -121,6 +264,10 @@ func Sequence(w http.ResponseWriter, r *http.Request) {
 	if ok := validateCommonArgs(w, d); !ok {
 		return
 	}
+	if err := breaker.allow(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if len(d.EntriesDir) == 0 {
 		http.Error(w, fmt.Sprintf("Please set `entriesDir` in HTTP body to the "+
 			"prefix name of the GCS objects in the %q bucket to sequence.", d.Bucket),
@@ -136,47 +283,81 @@ func Sequence(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to create GCS client: %q", err), http.StatusInternalServerError)
 		return
 	}
+	defer logOpCounts("Sequence", d.Bucket, client)
+	defer func() {
+		if err := client.ReleaseLease(ctx); err != nil {
+			fmt.Printf("Failed to release sequencer lease: %v\n", err)
+		}
+	}()
 
 	// Read the current log checkpoint to retrieve next sequence number.
 
-	cpBytes, err := client.ReadCheckpoint(ctx)
+	size, err := checkpointSize(ctx, client, d)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to read log checkpoint: %q", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusFor(err))
 		return
 	}
+	client.SetNextSeq(size)
 
-	// Setup KMS note signer and verifier.
-
-	kmClient, _, noteVerifier, err := setupKMS(ctx, w, os.Getenv("GCP_PROJECT"),
-		d.KMSKeyLocation, d.KMSKeyRing, d.KMSKeyName, d.KMSKeyVersion, d.NoteKeyName)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer kmClient.Close()
-
-	// Check signatures
+	summary := &sequenceSummary{}
+	defer summary.write(w)
+	sequenceObjects(ctx, client, d, summary)
+}
 
-	cp, _, _, err := fmtlog.ParseCheckpoint(cpBytes, d.Origin, noteVerifier)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse Checkpoint: %q", err), http.StatusInternalServerError)
-		return
+// sequenceObjects sequences the objects under d.EntriesDir in batches,
+// recording the outcome for each in summary. It carries on past objects which
+// fail so that one bad object doesn't prevent the rest of the batch from being
+// sequenced, unless the failure aborts the batch.
+func sequenceObjects(ctx context.Context, client *storage.Client, d requestData, summary *sequenceSummary) {
+	validate := leafValidators[d.LeafFormat]
+	var pending []pendingObject
+	// flush sequences the pending objects, and returns false if the batch has
+	// been aborted.
+	flush := func() bool {
+		defer func() { pending = pending[:0] }()
+		if len(pending) == 0 {
+			return true
+		}
+		leaves := make([][]byte, len(pending))
+		for i, o := range pending {
+			leaves[i] = o.data
+		}
+		seqs, dupes, err := sequenceBatch(ctx, client, leaves)
+		if err != nil {
+			for _, o := range pending {
+				if !summary.fail(o.name, fmt.Errorf("failed to sequence: %w", err)) {
+					return false
+				}
+			}
+			return true
+		}
+		for i, o := range pending {
+			summary.sequenced(dupes[i])
+			l := fmt.Sprintf("Sequence num %d assigned to %s", seqs[i], o.name)
+			if dupes[i] {
+				l += " (dupe)"
+			}
+			fmt.Println(l)
+		}
+		return true
 	}
-	client.SetNextSeq(cp.Size)
-
-	// sequence entries
-
-	h := rfc6962.DefaultHasher
 	it := client.GetObjects(ctx, d.EntriesDir)
 	for {
-		attrs, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
+		var attrs *gcs.ObjectAttrs
+		err := breaker.call(func() error {
+			var err error
+			attrs, err = it.Next()
+			if err == iterator.Done {
+				return nil
+			}
+			return err
+		})
 		if err != nil {
-			http.Error(w,
-				fmt.Sprintf("Bucket(%q).Objects: %v", d.Bucket, err),
-				http.StatusInternalServerError)
+			summary.abort(fmt.Errorf("Bucket(%q).Objects: %w", d.Bucket, err))
+			return
+		}
+		if attrs == nil {
+			flush()
 			return
 		}
 		// Skip this directory - only add files under it.
@@ -184,83 +365,357 @@ func Sequence(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		bytes, err := client.GetObjectData(ctx, attrs.Name)
+		var bytes []byte
+		err = breaker.call(func() error {
+			bytes, err = client.GetObjectData(ctx, attrs.Name)
+			return err
+		})
 		fmt.Printf("Sequencing object %q with content %q\n", attrs.Name, string(bytes))
 		if err != nil {
-			http.Error(w,
-				fmt.Sprintf("Failed to get data of object %q: %q", attrs.Name, err),
-				http.StatusInternalServerError)
-			return
+			if !summary.fail(attrs.Name, fmt.Errorf("failed to get data of object: %w", err)) {
+				return
+			}
+			continue
 		}
 
-		// ask storage to sequence
-		lh := h.HashLeaf(bytes)
-		dupe := false
-		seq, err := client.Sequence(ctx, lh, bytes)
-		if err != nil {
-			if errors.Is(err, log.ErrDupeLeaf) {
-				dupe = true
-			} else {
-				http.Error(w,
-					fmt.Sprintf("Failed to sequence %q: %q", attrs.Name, err),
-					http.StatusInternalServerError)
-				return
+		if validate != nil {
+			if err := validate(bytes); err != nil {
+				if !summary.fail(attrs.Name, fmt.Errorf("failed to sequence: %w", storage.ErrInvalidLeaf{Err: err})) {
+					return
+				}
+				continue
 			}
 		}
 
-		l := fmt.Sprintf("Sequence num %d assigned to %s", seq, attrs.Name)
-		if dupe {
-			l += " (dupe)"
+		pending = append(pending, pendingObject{name: attrs.Name, data: bytes})
+		if len(pending) == sequenceBatchSize && !flush() {
+			return
 		}
-		fmt.Println(l)
 	}
 }
 
+// sequenceBatchSize is the maximum number of objects which the Sequence
+// function asks storage to sequence at once.
+const sequenceBatchSize = 100
+
+// pendingObject is an object which the Sequence function has read, and is
+// waiting to sequence.
+type pendingObject struct {
+	name string
+	data []byte
+}
+
+// sequenceSummary is the JSON response of the Sequence function, describing
+// the outcome for each of the objects it was asked to sequence.
+type sequenceSummary struct {
+	// Sequenced is the number of objects newly assigned a sequence number.
+	Sequenced int `json:"sequenced"`
+	// Dupes is the number of objects which had already been sequenced.
+	Dupes int `json:"dupes"`
+	// Failed is the number of objects which could not be sequenced.
+	Failed   int               `json:"failed"`
+	Failures []sequenceFailure `json:"failures,omitempty"`
+	// Aborted, if set, is the reason that the remaining objects in the batch
+	// were not processed.
+	Aborted string `json:"aborted,omitempty"`
+
+	abortErr error
+	// failStatus is the most severe HTTP status of the failures.
+	failStatus int
+}
+
+// sequenceFailure describes an object which could not be sequenced.
+type sequenceFailure struct {
+	Object string `json:"object"`
+	Error  string `json:"error"`
+}
+
+// sequenced records that an object was successfully sequenced.
+func (s *sequenceSummary) sequenced(dupe bool) {
+	if dupe {
+		s.Dupes++
+		return
+	}
+	s.Sequenced++
+}
+
+// fail records that object could not be sequenced because of err.
+// Returns false if err also prevents the rest of the batch from being
+// sequenced, e.g. because the circuit breaker is open, in which case the
+// batch is aborted.
+func (s *sequenceSummary) fail(object string, err error) bool {
+	fmt.Printf("Failed to sequence object %q: %v\n", object, err)
+	s.Failed++
+	s.Failures = append(s.Failures, sequenceFailure{Object: object, Error: err.Error()})
+	s.failStatus = max(s.failStatus, statusFor(err))
+	if errors.Is(err, errCircuitOpen) ||
+		errors.Is(err, storage.ErrLeaseHeld) ||
+		errors.Is(err, storage.ErrLeaseLost) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		s.abort(err)
+		return false
+	}
+	return true
+}
+
+// abort records that the remaining objects in the batch will not be processed
+// because of err.
+func (s *sequenceSummary) abort(err error) {
+	fmt.Printf("Aborting sequencing: %v\n", err)
+	s.Aborted = err.Error()
+	s.abortErr = err
+}
+
+// status returns the HTTP status code for the response: OK only if every
+// object was sequenced, otherwise the status for the error which aborted the
+// batch, or the most severe status of the objects which failed. So, if the
+// only failures were invalid leaves, the status is Bad Request.
+func (s *sequenceSummary) status() int {
+	switch {
+	case s.abortErr != nil:
+		return statusFor(s.abortErr)
+	case s.Failed > 0:
+		return s.failStatus
+	}
+	return http.StatusOK
+}
+
+// write writes the summary to w as the JSON response.
+func (s *sequenceSummary) write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(s.status())
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		fmt.Printf("Failed to write response: %v\n", err)
+	}
+}
+
+// checkpointSize reads and verifies the log's current checkpoint, and returns
+// its size.
+func checkpointSize(ctx context.Context, client *storage.Client, d requestData) (uint64, error) {
+	kmClient, _, noteVerifier, err := setupKMS(ctx, os.Getenv("GCP_PROJECT"),
+		d.KMSKeyLocation, d.KMSKeyRing, d.KMSKeyName, d.KMSKeyVersion, kmsKeyAlgorithm(d), d.NoteKeyName)
+	if err != nil {
+		return 0, err
+	}
+	defer kmClient.Close()
+	return verifiedCheckpointSize(ctx, client, d.Origin, noteVerifier)
+}
+
+// verifiedCheckpointSize reads the log's current checkpoint, verifies it with
+// v, and returns its size.
+func verifiedCheckpointSize(ctx context.Context, client *storage.Client, origin string, v note.Verifier) (uint64, error) {
+	var cpBytes []byte
+	err := breaker.call(func() error {
+		var err error
+		cpBytes, err = client.ReadCheckpoint(ctx)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read log checkpoint: %w", err)
+	}
+
+	cp, err := parseCheckpoint(cpBytes, origin, v)
+	if err != nil {
+		return 0, err
+	}
+	return cp.Size, nil
+}
+
+// sequencer is the subset of the storage client used to sequence leaves.
+type sequencer interface {
+	Sequence(ctx context.Context, leafhash []byte, leaf []byte) (uint64, error)
+}
+
+// sequenceLeaf asks storage to assign a sequence number to leaf.
+// If the leaf has already been sequenced, its existing sequence number is
+// returned along with dupe set to true.
+func sequenceLeaf(ctx context.Context, s sequencer, leaf []byte) (seq uint64, dupe bool, err error) {
+	lh := rfc6962.DefaultHasher.HashLeaf(leaf)
+	err = breaker.call(func() error {
+		seq, err = s.Sequence(ctx, lh, leaf)
+		return err
+	})
+	if errors.Is(err, log.ErrDupeLeaf) {
+		return seq, true, nil
+	}
+	return seq, false, err
+}
+
+// batchSequencer is the subset of the storage client used to sequence batches
+// of leaves.
+type batchSequencer interface {
+	BatchSequence(ctx context.Context, leaves []struct{ Hash, Data []byte }) ([]uint64, []bool, error)
+}
+
+// sequenceBatch asks storage to assign sequence numbers to leaves.
+// Returns the sequence number of each leaf, and whether it had already been
+// sequenced, in which case its existing sequence number is returned.
+func sequenceBatch(ctx context.Context, s batchSequencer, leaves [][]byte) (seqs []uint64, dupes []bool, err error) {
+	batch := make([]struct{ Hash, Data []byte }, len(leaves))
+	for i, leaf := range leaves {
+		batch[i].Hash, batch[i].Data = rfc6962.DefaultHasher.HashLeaf(leaf), leaf
+	}
+	err = breaker.call(func() error {
+		seqs, dupes, err = s.BatchSequence(ctx, batch)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(seqs) != len(leaves) || len(dupes) != len(leaves) {
+		return nil, nil, fmt.Errorf("got %d sequence numbers and %d dupe indicators for %d leaves", len(seqs), len(dupes), len(leaves))
+	}
+	return seqs, dupes, nil
+}
+
+// noteKeyAlgorithms maps the names of the KMS key algorithms which can be used
+// to sign notes to a function which checks that a public key uses that
+// algorithm.
+var noteKeyAlgorithms = map[string]func(crypto.PublicKey) bool{
+	"ed25519": func(k crypto.PublicKey) bool {
+		_, ok := k.(ed25519.PublicKey)
+		return ok
+	},
+}
+
+// errUnsupportedKMSKey is returned when a KMS key can't be used to sign notes
+// with the requested algorithm.
+var errUnsupportedKMSKey = errors.New("unsupported KMS key")
+
+// kmsKeyAlgorithm returns the KMS key algorithm requested by d.
+func kmsKeyAlgorithm(d requestData) string {
+	if d.KMSKeyAlgorithm == "" {
+		return "ed25519"
+	}
+	return d.KMSKeyAlgorithm
+}
+
+// supportedKeyAlgorithms returns a human readable list of the supported KMS
+// key algorithms.
+func supportedKeyAlgorithms() string {
+	algs := make([]string, 0, len(noteKeyAlgorithms))
+	for a := range noteKeyAlgorithms {
+		algs = append(algs, a)
+	}
+	sort.Strings(algs)
+	return strings.Join(algs, ", ")
+}
+
+// checkKMSPublicKey returns an error wrapping errUnsupportedKMSKey if the
+// PEM-encoded public key doesn't use the given note-compatible algorithm.
+func checkKMSPublicKey(pemKey []byte, alg string) error {
+	isAlg, ok := noteKeyAlgorithms[alg]
+	if !ok {
+		return fmt.Errorf("%w: algorithm %q is not supported, supported algorithms are: %s", errUnsupportedKMSKey, alg, supportedKeyAlgorithms())
+	}
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return fmt.Errorf("%w: failed to decode public key PEM", errUnsupportedKMSKey)
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%w: failed to parse public key: %v", errUnsupportedKMSKey, err)
+	}
+	if !isAlg(k) {
+		return fmt.Errorf("%w: public key is of type %T, which is not an %s key", errUnsupportedKMSKey, k, alg)
+	}
+	return nil
+}
+
+// checkKMSKey fetches the public key of the KMS key version kmsKeyName, and
+// checks that it uses the given algorithm. This catches keys which can't be used
+// to sign notes before any signing is attempted.
+func checkKMSKey(ctx context.Context, kmClient *kms.KeyManagementClient, kmsKeyName, alg string) error {
+	resp, err := kmClient.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: kmsKeyName})
+	if err != nil {
+		return fmt.Errorf("Failed to get public key for %q: %q", kmsKeyName, err)
+	}
+	if err := checkKMSPublicKey([]byte(resp.Pem), alg); err != nil {
+		return fmt.Errorf("KMS key %q can't be used: %w", kmsKeyName, err)
+	}
+	return nil
+}
+
 // setupKMS returns a KeyManagementClient, note signer, note verifier, and
 // error. If this function does not return an error, the caller is responsible
 // for calling Close() on the KeyManagementClient.
-func setupKMS(ctx context.Context, w http.ResponseWriter, gcpProject, keyLocation, keyRing,
-	keyName string, keyVersion uint, noteKeyName string) (*kms.KeyManagementClient, note.Signer, note.Verifier, error) {
+// If the KMS key doesn't use keyAlgorithm, the returned error wraps
+// errUnsupportedKMSKey.
+func setupKMS(ctx context.Context, gcpProject, keyLocation, keyRing,
+	keyName string, keyVersion uint, keyAlgorithm, noteKeyName string) (*kms.KeyManagementClient, note.Signer, note.Verifier, error) {
 	kmsKeyName := fmt.Sprintf(kmssigner.KeyVersionNameFormat, gcpProject,
 		keyLocation, keyRing, keyName, keyVersion)
 
 	kmClient, err := kms.NewKeyManagementClient(ctx)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return nil, nil, nil, fmt.Errorf("Failed to create KeyManagementClient: %q", err)
 	}
 
+	if err := checkKMSKey(ctx, kmClient, kmsKeyName, keyAlgorithm); err != nil {
+		defer kmClient.Close()
+		return nil, nil, nil, err
+	}
+
 	noteSigner, err := kmssigner.New(ctx, kmClient, kmsKeyName, noteKeyName)
 	if err != nil {
 		defer kmClient.Close()
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return nil, nil, nil, fmt.Errorf("Failed to instantiate signer: %q", err)
 	}
 
 	vkey, err := kmssigner.VerifierKeyString(ctx, kmClient, kmsKeyName, noteSigner.Name())
 	if err != nil {
 		defer kmClient.Close()
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return nil, nil, nil, fmt.Errorf("Failed to create verifier key string: %q", err)
 	}
 
 	noteVerifier, err := note.NewVerifier(vkey)
 	if err != nil {
 		defer kmClient.Close()
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return nil, nil, nil, fmt.Errorf("Failed to instantiate verifier: %q", err)
 	}
 
 	return kmClient, noteSigner, noteVerifier, nil
 }
 
+// kmsError reports an error setting up KMS signing to the client. Keys which
+// are unsuitable for signing notes are reported in detail, since this is a
+// configuration error which the caller can fix.
+func kmsError(w http.ResponseWriter, err error) {
+	fmt.Println(err)
+	status := statusFor(err)
+	if status == http.StatusBadRequest {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// setupWitnessSigner returns a note signer for the optional witness key
+// configured in the request, or nil if no witness key was requested.
+func setupWitnessSigner(ctx context.Context, kmClient *kms.KeyManagementClient, gcpProject string, d requestData) (note.Signer, error) {
+	if len(d.WitnessKMSKeyName) == 0 {
+		return nil, nil
+	}
+	kmsKeyName := fmt.Sprintf(kmssigner.KeyVersionNameFormat, gcpProject,
+		d.KMSKeyLocation, d.KMSKeyRing, d.WitnessKMSKeyName, d.WitnessKMSKeyVersion)
+	if err := checkKMSKey(ctx, kmClient, kmsKeyName, kmsKeyAlgorithm(d)); err != nil {
+		return nil, err
+	}
+	witnessSigner, err := kmssigner.New(ctx, kmClient, kmsKeyName, d.WitnessNoteKeyName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to instantiate witness signer: %q", err)
+	}
+	return witnessSigner, nil
+}
+
 // Integrate is the entrypoint of the `integrate` GCF function.
 func Integrate(w http.ResponseWriter, r *http.Request) {
 	// process request args
 
 	d := requestData{}
 	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
-		fmt.Sprintf("json.NewDecoder: %v\n", err)
+		fmt.Printf("json.NewDecoder: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to decode JSON: %q", err), http.StatusBadRequest)
 		return
 	}
@@ -268,96 +723,457 @@ func Integrate(w http.ResponseWriter, r *http.Request) {
 	if ok := validateCommonArgs(w, d); !ok {
 		return
 	}
+	if err := checkIntegrateArgs(d); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := breaker.allow(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 
 	// Setup KMS note signer and verifier.
 	ctx := r.Context()
-	kmClient, noteSigner, noteVerifier, err := setupKMS(ctx, w, os.Getenv("GCP_PROJECT"),
-		d.KMSKeyLocation, d.KMSKeyRing, d.KMSKeyName, d.KMSKeyVersion, d.NoteKeyName)
+	kmClient, noteSigner, noteVerifier, err := setupKMS(ctx, os.Getenv("GCP_PROJECT"),
+		d.KMSKeyLocation, d.KMSKeyRing, d.KMSKeyName, d.KMSKeyVersion, kmsKeyAlgorithm(d), d.NoteKeyName)
 	if err != nil {
-		fmt.Println(err)
+		kmsError(w, err)
 		return
 	}
 	defer kmClient.Close()
 
+	signers := []note.Signer{noteSigner}
+	witnessSigner, err := setupWitnessSigner(ctx, kmClient, os.Getenv("GCP_PROJECT"), d)
+	if err != nil {
+		kmsError(w, err)
+		return
+	}
+	if witnessSigner != nil {
+		signers = append(signers, witnessSigner)
+	}
+
 	client, err := newClient(ctx, d)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create GCS client: %v", err), http.StatusBadRequest)
 		return
 	}
+	defer logOpCounts("Integrate", d.Bucket, client)
 
-	var cpNote note.Note
-	h := rfc6962.DefaultHasher
-	if d.Initialise {
-		if d.CreateBucket {
-			if err := client.Create(ctx, d.Bucket); err != nil {
-				http.Error(w, fmt.Sprintf("Failed to create bucket for log: %v", err), http.StatusBadRequest)
+	// Refuse to publish any checkpoint which isn't signed by the log.
+	client.SetCheckpointVerifier(noteVerifier)
+
+	// st is the storage which tree data will be written to.
+	st, mirror, err := integrationStorage(ctx, d, client, noteVerifier)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create mirror GCS client: %v", err), http.StatusBadRequest)
+		return
+	}
+	if mirror != nil {
+		defer logOpCounts("Integrate", d.MirrorBucket, mirror)
+	}
+
+	if d.Initialise && d.CreateBucket {
+		if err := client.Create(ctx, d.Bucket); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create bucket for log: %v", err), http.StatusBadRequest)
+			return
+		}
+		if mirror != nil {
+			if err := mirror.Create(ctx, d.MirrorBucket); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to create mirror bucket for log: %v", err), http.StatusBadRequest)
 				return
 			}
 		}
+	}
 
-		cp := fmtlog.Checkpoint{
-			Hash: h.EmptyRoot(),
-		}
-		if err := signAndWrite(ctx, &cp, cpNote, noteSigner, client, d.Origin); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to sign: %q", err), http.StatusInternalServerError)
-		}
-		fmt.Fprintf(w, fmt.Sprintf("Initialised log at %s.", d.Bucket))
+	integrate(ctx, w, d, st, client.ReadCheckpoint, noteVerifier, signers...)
+}
+
+// integrationStorage returns the storage which integrating into client's log
+// writes tree data to: client itself, or, if the request configures a mirror
+// bucket, client mirrored to a client for that bucket, which is also returned.
+// Checkpoints written to either bucket must verify with v.
+func integrationStorage(ctx context.Context, d requestData, client *storage.Client, v note.Verifier) (log.Storage, *storage.Client, error) {
+	if len(d.MirrorBucket) == 0 {
+		return client, nil, nil
+	}
+	mirror, err := newClientForBucket(ctx, d, d.MirrorBucket)
+	if err != nil {
+		return nil, nil, err
+	}
+	mirror.SetCheckpointVerifier(v)
+	return storage.NewMirroredClient(client, mirror, d.MirrorBestEffort), mirror, nil
+}
+
+// SequenceAndIntegrate is the entrypoint of the `sequence-and-integrate` GCF
+// function. It sequences the objects under `entriesDir`, as Sequence does, and
+// then integrates them into the log and publishes a new checkpoint, as
+// Integrate does, sharing the storage client and KMS signer between the two.
+// The log must already have been initialised.
+func SequenceAndIntegrate(w http.ResponseWriter, r *http.Request) {
+	d := requestData{}
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode JSON: %q", err), http.StatusBadRequest)
 		return
 	}
 
+	if ok := validateCommonArgs(w, d); !ok {
+		return
+	}
+	if err := checkIntegrateArgs(d); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := breaker.allow(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if len(d.EntriesDir) == 0 {
+		http.Error(w, fmt.Sprintf("Please set `entriesDir` in HTTP body to the "+
+			"prefix name of the GCS objects in the %q bucket to sequence.", d.Bucket),
+			http.StatusBadRequest)
+		return
+	}
+	if d.Initialise {
+		http.Error(w, "Please use the integrate function to initialise the log.", http.StatusBadRequest)
+		return
+	}
+
+	// Setup KMS note signer and verifier.
+	ctx := r.Context()
+	kmClient, noteSigner, noteVerifier, err := setupKMS(ctx, os.Getenv("GCP_PROJECT"),
+		d.KMSKeyLocation, d.KMSKeyRing, d.KMSKeyName, d.KMSKeyVersion, kmsKeyAlgorithm(d), d.NoteKeyName)
+	if err != nil {
+		kmsError(w, err)
+		return
+	}
+	defer kmClient.Close()
+
+	signers := []note.Signer{noteSigner}
+	witnessSigner, err := setupWitnessSigner(ctx, kmClient, os.Getenv("GCP_PROJECT"), d)
+	if err != nil {
+		kmsError(w, err)
+		return
+	}
+	if witnessSigner != nil {
+		signers = append(signers, witnessSigner)
+	}
+
 	// init storage
-	cpRaw, err := client.ReadCheckpoint(ctx)
+
+	client, err := newClient(ctx, d)
 	if err != nil {
-		http.Error(w,
-			fmt.Sprintf("Failed to read log checkpoint: %q", err),
-			http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to create GCS client: %q", err), http.StatusInternalServerError)
 		return
 	}
+	defer logOpCounts("SequenceAndIntegrate", d.Bucket, client)
+	defer func() {
+		if err := client.ReleaseLease(ctx); err != nil {
+			fmt.Printf("Failed to release sequencer lease: %v\n", err)
+		}
+	}()
+	client.SetCheckpointVerifier(noteVerifier)
 
-	// Check signatures
-	cp, _, _, err := fmtlog.ParseCheckpoint(cpRaw, d.Origin, noteVerifier)
+	st, mirror, err := integrationStorage(ctx, d, client, noteVerifier)
 	if err != nil {
-		http.Error(w,
-			fmt.Sprintf("Failed to open Checkpoint: %q", err),
-			http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to create mirror GCS client: %v", err), http.StatusBadRequest)
 		return
 	}
+	if mirror != nil {
+		defer logOpCounts("SequenceAndIntegrate", d.MirrorBucket, mirror)
+	}
 
-	// Integrate new entries
-	newCp, err := log.Integrate(ctx, cp.Size, client, h)
+	size, err := verifiedCheckpointSize(ctx, client, d.Origin, noteVerifier)
 	if err != nil {
-		http.Error(w,
-			fmt.Sprintf("Failed to integrate: %q", err),
-			http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusFor(err))
 		return
 	}
-	if newCp == nil {
-		http.Error(w, "Nothing to integrate", http.StatusBadRequest)
+	client.SetNextSeq(size)
+
+	summary := &sequenceAndIntegrateSummary{sequenceSummary: &sequenceSummary{}, TreeSize: size}
+	defer summary.write(w)
+	sequenceObjects(ctx, client, d, summary.sequenceSummary)
+	if summary.abortErr != nil {
 		return
 	}
 
-	err = signAndWrite(ctx, newCp, cpNote, noteSigner, client, d.Origin)
+	// Integrate whatever was sequenced, including any entries sequenced by
+	// earlier calls but not yet integrated.
+	newSize, _, err := integrateEntries(ctx, d, st, client.ReadCheckpoint, noteVerifier, signers...)
+	switch {
+	case errors.Is(err, errNothingToIntegrate):
+	case err != nil:
+		fmt.Printf("Failed to integrate: %v\n", err)
+		summary.integrateErr = err
+		summary.IntegrateError = err.Error()
+	default:
+		summary.TreeSize = newSize
+	}
+}
+
+// sequenceAndIntegrateSummary is the JSON response of the SequenceAndIntegrate
+// function. It extends the sequenceSummary with the outcome of integration.
+type sequenceAndIntegrateSummary struct {
+	*sequenceSummary
+	// TreeSize is the size of the log's checkpoint once the sequenced objects
+	// have been integrated.
+	TreeSize uint64 `json:"treeSize"`
+	// IntegrateError, if set, is the reason that the sequenced objects could
+	// not be integrated, in which case TreeSize is the size of the checkpoint
+	// before sequencing.
+	IntegrateError string `json:"integrateError,omitempty"`
+
+	integrateErr error
+}
+
+// status returns the HTTP status code for the response: that of the
+// integration error if there is one, otherwise that of sequencing.
+func (s *sequenceAndIntegrateSummary) status() int {
+	var he httpError
+	switch {
+	case s.integrateErr == nil:
+		return s.sequenceSummary.status()
+	case errors.As(s.integrateErr, &he):
+		return he.status
+	}
+	return http.StatusInternalServerError
+}
+
+// write writes the summary to w as the JSON response.
+func (s *sequenceAndIntegrateSummary) write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(s.status())
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		fmt.Printf("Failed to write response: %v\n", err)
+	}
+}
+
+// integrate initialises the log or integrates newly sequenced entries into
+// it, depending on the request, and writes the outcome to w.
+func integrate(ctx context.Context, w http.ResponseWriter, d requestData, st log.Storage,
+	readCheckpoint func(context.Context) ([]byte, error), v note.Verifier, signers ...note.Signer) {
+	_, msg, err := integrateEntries(ctx, d, st, readCheckpoint, v, signers...)
 	if err != nil {
-		http.Error(w,
-			fmt.Sprintf("Failed to sign: %q", err),
-			http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		var he httpError
+		if errors.As(err, &he) {
+			status = he.status
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
+	fmt.Fprint(w, msg)
+}
+
+// httpError is an error which should be reported to the caller with the given
+// HTTP status.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e httpError) Unwrap() error {
+	return e.err
+}
+
+// errNothingToIntegrate is returned by integrateEntries if there are no newly
+// sequenced entries to integrate.
+var errNothingToIntegrate = errors.New("Nothing to integrate")
+
+// integrateEntries initialises the log or integrates newly sequenced entries
+// into it, depending on the request. It returns the size of the log's
+// checkpoint, and a message describing the outcome if it's noteworthy. Errors
+// are returned as an httpError.
+//
+// The initialise path writes a checkpoint for the empty tree without
+// reading any existing checkpoint, so readCheckpoint is only called when
+// integrating entries into an already initialised log.
+func integrateEntries(ctx context.Context, d requestData, st log.Storage,
+	readCheckpoint func(context.Context) ([]byte, error), v note.Verifier, signers ...note.Signer) (uint64, string, error) {
+	var cpNote note.Note
+	h := rfc6962.DefaultHasher
+	if d.Initialise {
+		cp := fmtlog.Checkpoint{
+			Hash: h.EmptyRoot(),
+		}
+		if err := signAndWrite(ctx, &cp, cpNote, st, d.Origin, signers...); err != nil {
+			return 0, "", httpError{statusFor(err), fmt.Errorf("Failed to sign: %q", err)}
+		}
+		return 0, fmt.Sprintf("Initialised log at %s.", d.Bucket), nil
+	}
+
+	// prev and attempted are the checkpoint read, and the checkpoint which
+	// failed to be written, by the previous attempt, if any.
+	var prev, attempted *fmtlog.Checkpoint
+	for attempt := uint(0); ; attempt++ {
+		// init storage
+		var cpRaw []byte
+		err := breaker.call(func() error {
+			var err error
+			cpRaw, err = readCheckpoint(ctx)
+			return err
+		})
+		if err != nil {
+			return 0, "", httpError{statusFor(err), fmt.Errorf("Failed to read log checkpoint: %q", err)}
+		}
+
+		// Check signatures
+		cp, err := parseCheckpoint(cpRaw, d.Origin, v)
+		if err != nil {
+			return 0, "", httpError{http.StatusInternalServerError, fmt.Errorf("Failed to open Checkpoint: %q", err)}
+		}
+		if attempted != nil {
+			if err := checkConcurrentCheckpoint(prev, attempted, cp); err != nil {
+				return 0, "", httpError{http.StatusInternalServerError, fmt.Errorf("Failed to retry integration: %q", err)}
+			}
+		}
+		if d.TargetSize > 0 && d.TargetSize < cp.Size {
+			if attempted != nil {
+				return cp.Size, fmt.Sprintf("Log was integrated to size %d by a concurrent integration.", cp.Size), nil
+			}
+			return 0, "", httpError{http.StatusBadRequest, fmt.Errorf("Target size %d is below the current checkpoint size %d", d.TargetSize, cp.Size)}
+		}
+
+		// Integrate new entries
+		var newCp *fmtlog.Checkpoint
+		err = breaker.call(func() error {
+			var err error
+			if d.TargetSize > 0 {
+				newCp, err = log.Integrate(ctx, cp.Size, boundedStorage{Storage: st, size: d.TargetSize}, h)
+			} else {
+				newCp, err = log.Integrate(ctx, cp.Size, st, h)
+			}
+			return err
+		})
+		if err != nil {
+			return 0, "", httpError{statusFor(err), fmt.Errorf("Failed to integrate: %q", err)}
+		}
+		if newCp == nil {
+			if attempted != nil {
+				return cp.Size, fmt.Sprintf("Log was integrated to size %d by a concurrent integration.", cp.Size), nil
+			}
+			return 0, "", httpError{http.StatusBadRequest, errNothingToIntegrate}
+		}
+
+		err = signAndWrite(ctx, newCp, cpNote, st, d.Origin, signers...)
+		if err == nil {
+			return newCp.Size, "", nil
+		}
+		if !errors.Is(err, storage.ErrCheckpointConflict) || attempt >= d.IntegrateRetries {
+			return 0, "", httpError{statusFor(err), fmt.Errorf("Failed to sign: %q", err)}
+		}
+		fmt.Printf("Checkpoint write conflicted with a concurrent integration, retrying (attempt %d of %d): %v\n", attempt+1, d.IntegrateRetries, err)
+		select {
+		case <-ctx.Done():
+			return 0, "", httpError{http.StatusServiceUnavailable, fmt.Errorf("Failed to retry integration: %q", ctx.Err())}
+		case <-time.After(integrateRetryBackoff << attempt):
+		}
+		prev, attempted = cp, newCp
+	}
+}
+
+// errTargetReached is used to stop scanning sequenced entries once the
+// target size of an integration has been reached.
+var errTargetReached = errors.New("target size reached")
 
-	return
+// boundedStorage is a log.Storage which only exposes sequenced entries below
+// size to ScanSequenced, so that integration stops at that tree size.
+type boundedStorage struct {
+	log.Storage
+	size uint64
 }
 
-// signAndWrite signs a checkpoint and writes the new checkpoint to GCS.
+func (b boundedStorage) ScanSequenced(ctx context.Context, begin uint64, f func(seq uint64, entry []byte) error) (uint64, error) {
+	if begin >= b.size {
+		return 0, nil
+	}
+	n, err := b.Storage.ScanSequenced(ctx, begin, func(seq uint64, entry []byte) error {
+		if seq >= b.size {
+			return errTargetReached
+		}
+		return f(seq, entry)
+	})
+	if errors.Is(err, errTargetReached) {
+		return n, nil
+	}
+	return n, err
+}
+
+// integrateRetryBackoff is how long integrate waits before its first retry
+// after losing a race to write the checkpoint. The wait doubles with each
+// further retry.
+var integrateRetryBackoff = 100 * time.Millisecond
+
+// checkConcurrentCheckpoint checks that cur, a checkpoint read after failing
+// to write attempted because the checkpoint was updated concurrently, is
+// consistent with the concurrent writer having been another integrator
+// racing to extend the same tree from prev.
+//
+// It returns an error if cur indicates that the log has genuinely diverged,
+// i.e. the tree has shrunk, or has a different root hash at the same size as
+// either prev or attempted. In that case integration must not be retried.
+func checkConcurrentCheckpoint(prev, attempted, cur *fmtlog.Checkpoint) error {
+	if cur.Size < prev.Size {
+		return fmt.Errorf("checkpoint went backwards from size %d to %d", prev.Size, cur.Size)
+	}
+	for _, cp := range []*fmtlog.Checkpoint{prev, attempted} {
+		if cur.Size == cp.Size && !bytes.Equal(cur.Hash, cp.Hash) {
+			return fmt.Errorf("concurrently written checkpoint has root hash %x at size %d, want %x", cur.Hash, cur.Size, cp.Hash)
+		}
+	}
+	return nil
+}
+
+// signAndWrite signs a checkpoint and writes the new checkpoint to storage.
+// The first signer must be the log's signer, any further signers (e.g. a
+// witness) will add their co-signatures to the same note.
 func signAndWrite(ctx context.Context, cp *fmtlog.Checkpoint, cpNote note.Note,
-	s note.Signer, client *storage.Client, origin string) error {
+	st log.Storage, origin string, s ...note.Signer) error {
 	cp.Origin = origin
 	cpNote.Text = string(cp.Marshal())
-	cpNoteSigned, err := note.Sign(&cpNote, s)
+	cpNoteSigned, err := note.Sign(&cpNote, s...)
 	if err != nil {
 		return fmt.Errorf("failed to sign Checkpoint: %w", err)
 	}
-	if err := client.WriteCheckpoint(ctx, cpNoteSigned); err != nil {
+	if err := breaker.call(func() error { return st.WriteCheckpoint(ctx, cpNoteSigned) }); err != nil {
 		return fmt.Errorf("failed to store new log checkpoint: %w", err)
 	}
 	return nil
 }
+
+// Healthz is the entrypoint of the `healthz` GCF function.
+// It checks that the log's bucket, and its mirror bucket if configured, can be
+// used to store the log, and responds with the reason if not. Only the
+// `bucket` and `mirrorBucket` request args are used.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	d := requestData{}
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode JSON: %q", err), http.StatusBadRequest)
+		return
+	}
+	if len(d.Bucket) == 0 {
+		http.Error(w, "Please set `bucket` in request to the log's bucket.", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	for _, bucket := range []string{d.Bucket, d.MirrorBucket} {
+		if len(bucket) == 0 {
+			continue
+		}
+		client, err := newClientForBucket(ctx, d, bucket)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create GCS client: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := client.Preflight(ctx); err != nil {
+			fmt.Println(err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,793 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gcp_serverless_module/internal/storage"
+	"github.com/google/go-cmp/cmp"
+	fmtlog "github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/compact"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/pkg/log"
+	"github.com/transparency-dev/serverless-log/testonly"
+	"golang.org/x/mod/sumdb/note"
+)
+
+const testOrigin = "example.com/log/testdata"
+
+func TestIntegrateEmptyToFirstEntries(t *testing.T) {
+	ctx := context.Background()
+	skey, vkey, err := note.GenerateKey(rand.Reader, testOrigin)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	s, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	v, err := note.NewVerifier(vkey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	st := testonly.NewMemStorage()
+	f := st.Fetcher()
+	readCheckpoint := func(ctx context.Context) ([]byte, error) {
+		return f(ctx, layout.CheckpointPath)
+	}
+	d := requestData{Origin: testOrigin, Bucket: "test-log"}
+	h := rfc6962.DefaultHasher
+
+	// checkpoint returns the current checkpoint in the store.
+	checkpoint := func() *fmtlog.Checkpoint {
+		t.Helper()
+		cpRaw, err := readCheckpoint(ctx)
+		if err != nil {
+			t.Fatalf("Failed to read checkpoint: %v", err)
+		}
+		cp, _, _, err := fmtlog.ParseCheckpoint(cpRaw, testOrigin, v)
+		if err != nil {
+			t.Fatalf("Failed to parse checkpoint: %v", err)
+		}
+		return cp
+	}
+
+	// Initialising must not require, or read, an existing checkpoint.
+	init := d
+	init.Initialise = true
+	w := httptest.NewRecorder()
+	integrate(ctx, w, init, st, func(context.Context) ([]byte, error) {
+		return nil, errors.New("checkpoint read while initialising")
+	}, v, s)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Initialise: got status %d (%s), want %d", w.Code, w.Body, http.StatusOK)
+	}
+	if cp := checkpoint(); cp.Size != 0 || !bytes.Equal(cp.Hash, h.EmptyRoot()) {
+		t.Fatalf("Initialised checkpoint: got size %d hash %x, want empty tree", cp.Size, cp.Hash)
+	}
+
+	// Integrating an empty log should leave the checkpoint unchanged.
+	w = httptest.NewRecorder()
+	integrate(ctx, w, d, st, readCheckpoint, v, s)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Integrate empty log: got status %d (%s), want %d", w.Code, w.Body, http.StatusBadRequest)
+	}
+	if cp := checkpoint(); cp.Size != 0 {
+		t.Fatalf("Checkpoint after empty integration: got size %d, want 0", cp.Size)
+	}
+
+	// Sequence and integrate the first entries.
+	const numLeaves = 10
+	r := (&compact.RangeFactory{Hash: h.HashChildren}).NewEmptyRange(0)
+	for i := 0; i < numLeaves; i++ {
+		leaf := []byte(fmt.Sprintf("leaf %d", i))
+		lh := h.HashLeaf(leaf)
+		if _, err := st.Sequence(ctx, lh, leaf); err != nil {
+			t.Fatalf("Sequence(%d): %v", i, err)
+		}
+		if err := r.Append(lh, nil); err != nil {
+			t.Fatalf("Append(%d): %v", i, err)
+		}
+	}
+	wantRoot, err := r.GetRootHash(nil)
+	if err != nil {
+		t.Fatalf("GetRootHash: %v", err)
+	}
+	w = httptest.NewRecorder()
+	integrate(ctx, w, d, st, readCheckpoint, v, s)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Integrate: got status %d (%s), want %d", w.Code, w.Body, http.StatusOK)
+	}
+	if cp := checkpoint(); cp.Size != numLeaves || !bytes.Equal(cp.Hash, wantRoot) {
+		t.Fatalf("Integrated checkpoint: got size %d hash %x, want size %d hash %x", cp.Size, cp.Hash, numLeaves, wantRoot)
+	}
+}
+
+// conflictingStorage is a log.Storage whose WriteCheckpoint reports a conflict
+// the first conflicts times it's called, having first called concurrent, if
+// set, to simulate a concurrent writer.
+type conflictingStorage struct {
+	*testonly.MemStorage
+	conflicts  int
+	concurrent func()
+}
+
+func (c *conflictingStorage) WriteCheckpoint(ctx context.Context, newCPRaw []byte) error {
+	if c.conflicts > 0 {
+		c.conflicts--
+		if c.concurrent != nil {
+			c.concurrent()
+		}
+		return fmt.Errorf("%w: test conflict", storage.ErrCheckpointConflict)
+	}
+	return c.MemStorage.WriteCheckpoint(ctx, newCPRaw)
+}
+
+func TestIntegrateRetriesOnConflict(t *testing.T) {
+	defer func(b time.Duration) { integrateRetryBackoff = b }(integrateRetryBackoff)
+	integrateRetryBackoff = time.Millisecond
+
+	ctx := context.Background()
+	skey, vkey, err := note.GenerateKey(rand.Reader, testOrigin)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	s, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	v, err := note.NewVerifier(vkey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	h := rfc6962.DefaultHasher
+	const numLeaves = 10
+	r := (&compact.RangeFactory{Hash: h.HashChildren}).NewEmptyRange(0)
+	for i := 0; i < numLeaves; i++ {
+		if err := r.Append(h.HashLeaf([]byte(fmt.Sprintf("leaf %d", i))), nil); err != nil {
+			t.Fatalf("Append(%d): %v", i, err)
+		}
+	}
+	wantRoot, err := r.GetRootHash(nil)
+	if err != nil {
+		t.Fatalf("GetRootHash: %v", err)
+	}
+
+	// writeCheckpoint returns a function which directly writes a checkpoint
+	// for a tree of the given size and root to st.
+	writeCheckpoint := func(st *testonly.MemStorage, size uint64, root []byte) func() {
+		return func() {
+			cp := fmtlog.Checkpoint{Origin: testOrigin, Size: size, Hash: root}
+			cpRaw, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, s)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			if err := st.WriteCheckpoint(ctx, cpRaw); err != nil {
+				t.Fatalf("WriteCheckpoint: %v", err)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		desc       string
+		conflicts  int
+		retries    uint
+		concurrent func(st *testonly.MemStorage) func()
+		wantCode   int
+		wantSize   uint64
+	}{
+		{
+			desc:      "no retries",
+			conflicts: 1,
+			wantCode:  http.StatusConflict,
+		}, {
+			desc:      "retried",
+			conflicts: 1,
+			retries:   2,
+			wantCode:  http.StatusOK,
+			wantSize:  numLeaves,
+		}, {
+			desc:      "too many conflicts",
+			conflicts: 3,
+			retries:   2,
+			wantCode:  http.StatusConflict,
+		}, {
+			desc:      "concurrent integration won",
+			conflicts: 1,
+			retries:   1,
+			concurrent: func(st *testonly.MemStorage) func() {
+				return writeCheckpoint(st, numLeaves, wantRoot)
+			},
+			wantCode: http.StatusOK,
+			wantSize: numLeaves,
+		}, {
+			desc:      "diverged",
+			conflicts: 1,
+			retries:   1,
+			concurrent: func(st *testonly.MemStorage) func() {
+				return writeCheckpoint(st, numLeaves, h.HashLeaf([]byte("not the root")))
+			},
+			wantCode: http.StatusInternalServerError,
+			wantSize: numLeaves,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			mem := testonly.NewMemStorage()
+			writeCheckpoint(mem, 0, h.EmptyRoot())()
+			for i := 0; i < numLeaves; i++ {
+				leaf := []byte(fmt.Sprintf("leaf %d", i))
+				if _, err := mem.Sequence(ctx, h.HashLeaf(leaf), leaf); err != nil {
+					t.Fatalf("Sequence(%d): %v", i, err)
+				}
+			}
+			st := &conflictingStorage{MemStorage: mem, conflicts: test.conflicts}
+			if test.concurrent != nil {
+				st.concurrent = test.concurrent(mem)
+			}
+			f := mem.Fetcher()
+			readCheckpoint := func(ctx context.Context) ([]byte, error) {
+				return f(ctx, layout.CheckpointPath)
+			}
+
+			w := httptest.NewRecorder()
+			d := requestData{Origin: testOrigin, Bucket: "test-log", IntegrateRetries: test.retries}
+			integrate(ctx, w, d, st, readCheckpoint, v, s)
+			if w.Code != test.wantCode {
+				t.Fatalf("Integrate: got status %d (%s), want %d", w.Code, w.Body, test.wantCode)
+			}
+			cpRaw, err := readCheckpoint(ctx)
+			if err != nil {
+				t.Fatalf("Failed to read checkpoint: %v", err)
+			}
+			cp, _, _, err := fmtlog.ParseCheckpoint(cpRaw, testOrigin, v)
+			if err != nil {
+				t.Fatalf("Failed to parse checkpoint: %v", err)
+			}
+			if cp.Size != test.wantSize {
+				t.Errorf("Got checkpoint size %d, want %d", cp.Size, test.wantSize)
+			}
+		})
+	}
+}
+
+func TestIntegrateTargetSize(t *testing.T) {
+	ctx := context.Background()
+	skey, vkey, err := note.GenerateKey(rand.Reader, testOrigin)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	s, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	v, err := note.NewVerifier(vkey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	h := rfc6962.DefaultHasher
+	st := testonly.NewMemStorage()
+	f := st.Fetcher()
+	readCheckpoint := func(ctx context.Context) ([]byte, error) {
+		return f(ctx, layout.CheckpointPath)
+	}
+	d := requestData{Origin: testOrigin, Bucket: "test-log"}
+
+	init := d
+	init.Initialise = true
+	w := httptest.NewRecorder()
+	integrate(ctx, w, init, st, readCheckpoint, v, s)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Initialise: got status %d (%s), want %d", w.Code, w.Body, http.StatusOK)
+	}
+	const numLeaves = 10
+	roots := make(map[uint64][]byte)
+	r := (&compact.RangeFactory{Hash: h.HashChildren}).NewEmptyRange(0)
+	for i := 0; i < numLeaves; i++ {
+		leaf := []byte(fmt.Sprintf("leaf %d", i))
+		lh := h.HashLeaf(leaf)
+		if _, err := st.Sequence(ctx, lh, leaf); err != nil {
+			t.Fatalf("Sequence(%d): %v", i, err)
+		}
+		if err := r.Append(lh, nil); err != nil {
+			t.Fatalf("Append(%d): %v", i, err)
+		}
+		if roots[r.End()], err = r.GetRootHash(nil); err != nil {
+			t.Fatalf("GetRootHash: %v", err)
+		}
+	}
+
+	for _, test := range []struct {
+		desc       string
+		targetSize uint64
+		wantCode   int
+		wantSize   uint64
+	}{
+		{desc: "bounded", targetSize: 4, wantCode: http.StatusOK, wantSize: 4},
+		{desc: "below checkpoint", targetSize: 2, wantCode: http.StatusBadRequest, wantSize: 4},
+		{desc: "at checkpoint", targetSize: 4, wantCode: http.StatusBadRequest, wantSize: 4},
+		{desc: "beyond pending", targetSize: 20, wantCode: http.StatusOK, wantSize: numLeaves},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			req := d
+			req.TargetSize = test.targetSize
+			w := httptest.NewRecorder()
+			integrate(ctx, w, req, st, readCheckpoint, v, s)
+			if w.Code != test.wantCode {
+				t.Fatalf("Integrate: got status %d (%s), want %d", w.Code, w.Body, test.wantCode)
+			}
+			cpRaw, err := readCheckpoint(ctx)
+			if err != nil {
+				t.Fatalf("Failed to read checkpoint: %v", err)
+			}
+			cp, _, _, err := fmtlog.ParseCheckpoint(cpRaw, testOrigin, v)
+			if err != nil {
+				t.Fatalf("Failed to parse checkpoint: %v", err)
+			}
+			if cp.Size != test.wantSize || !bytes.Equal(cp.Hash, roots[test.wantSize]) {
+				t.Errorf("Got checkpoint size %d hash %x, want size %d hash %x", cp.Size, cp.Hash, test.wantSize, roots[test.wantSize])
+			}
+		})
+	}
+}
+
+// dupeSequencer is a sequencer which reports every leaf as a duplicate of the
+// leaf at index seq.
+type dupeSequencer struct {
+	seq uint64
+}
+
+func (d dupeSequencer) Sequence(_ context.Context, _, _ []byte) (uint64, error) {
+	return d.seq, log.ErrDupeLeaf
+}
+
+func TestSequenceLeaf(t *testing.T) {
+	ctx := context.Background()
+	st := testonly.NewMemStorage()
+	for i, leaf := range [][]byte{[]byte("one"), []byte("two")} {
+		seq, dupe, err := sequenceLeaf(ctx, st, leaf)
+		if err != nil {
+			t.Fatalf("sequenceLeaf(%q): %v", leaf, err)
+		}
+		if seq != uint64(i) || dupe {
+			t.Errorf("sequenceLeaf(%q) = %d, %t, want %d, false", leaf, seq, dupe, i)
+		}
+	}
+
+	// Duplicate leaves should return their original index, and not be an error
+	// so that the message carrying them is acked.
+	seq, dupe, err := sequenceLeaf(ctx, dupeSequencer{seq: 42}, []byte("one"))
+	if err != nil {
+		t.Fatalf("sequenceLeaf(dupe): %v", err)
+	}
+	if seq != 42 || !dupe {
+		t.Errorf("sequenceLeaf(dupe) = %d, %t, want 42, true", seq, dupe)
+	}
+}
+
+// fakeBatchSequencer assigns each leaf its position in the batch, plus offset,
+// and reports leaves equal to dupe as duplicates.
+type fakeBatchSequencer struct {
+	offset uint64
+	dupe   string
+	// short causes one fewer result than leaves to be returned.
+	short bool
+}
+
+func (f fakeBatchSequencer) BatchSequence(_ context.Context, leaves []struct{ Hash, Data []byte }) ([]uint64, []bool, error) {
+	var seqs []uint64
+	var dupes []bool
+	for i, l := range leaves {
+		if !bytes.Equal(l.Hash, rfc6962.DefaultHasher.HashLeaf(l.Data)) {
+			return nil, nil, fmt.Errorf("leaf %d has wrong hash", i)
+		}
+		seqs = append(seqs, f.offset+uint64(i))
+		dupes = append(dupes, string(l.Data) == f.dupe)
+	}
+	if f.short {
+		seqs, dupes = seqs[1:], dupes[1:]
+	}
+	return seqs, dupes, nil
+}
+
+func TestSequenceBatch(t *testing.T) {
+	ctx := context.Background()
+	leaves := [][]byte{[]byte("one"), []byte("two"), []byte("three")}
+	seqs, dupes, err := sequenceBatch(ctx, fakeBatchSequencer{offset: 10, dupe: "two"}, leaves)
+	if err != nil {
+		t.Fatalf("sequenceBatch: %v", err)
+	}
+	if diff := cmp.Diff([]uint64{10, 11, 12}, seqs); diff != "" {
+		t.Errorf("Sequence numbers diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]bool{false, true, false}, dupes); diff != "" {
+		t.Errorf("Dupes diff (-want +got):\n%s", diff)
+	}
+
+	if _, _, err := sequenceBatch(ctx, fakeBatchSequencer{short: true}, leaves); err == nil {
+		t.Error("sequenceBatch with missing results succeeded, want error")
+	}
+}
+
+func TestSequenceSummary(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		record     func(s *sequenceSummary)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "all sequenced",
+			record: func(s *sequenceSummary) {
+				s.sequenced(false)
+				s.sequenced(true)
+				s.sequenced(false)
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   `{"sequenced":2,"dupes":1,"failed":0}`,
+		},
+		{
+			name: "partial failure",
+			record: func(s *sequenceSummary) {
+				s.sequenced(false)
+				if !s.fail("entries/bad", errors.New("boom")) {
+					t.Error("fail() aborted the batch for a per-object error")
+				}
+				s.sequenced(false)
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   `{"sequenced":2,"dupes":0,"failed":1,"failures":[{"object":"entries/bad","error":"boom"}]}`,
+		},
+		{
+			name: "invalid leaves",
+			record: func(s *sequenceSummary) {
+				s.sequenced(false)
+				s.fail("entries/a", fmt.Errorf("failed to sequence: %w", storage.ErrInvalidLeaf{Err: errors.New("not JSON")}))
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"sequenced":1,"dupes":0,"failed":1,"failures":[{"object":"entries/a","error":"failed to sequence: invalid leaf: not JSON"}]}`,
+		},
+		{
+			name: "aborted",
+			record: func(s *sequenceSummary) {
+				s.sequenced(false)
+				if s.fail("entries/b", fmt.Errorf("failed to sequence: %w", storage.ErrLeaseHeld)) {
+					t.Error("fail() didn't abort the batch when the lease is held")
+				}
+			},
+			wantStatus: http.StatusConflict,
+			wantBody:   `{"sequenced":1,"dupes":0,"failed":1,"failures":[{"object":"entries/b","error":"failed to sequence: sequencer lease is held by another sequencer"}],"aborted":"failed to sequence: sequencer lease is held by another sequencer"}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := &sequenceSummary{}
+			test.record(s)
+			w := httptest.NewRecorder()
+			s.write(w)
+			if got := w.Code; got != test.wantStatus {
+				t.Errorf("status = %d, want %d", got, test.wantStatus)
+			}
+			if got := string(bytes.TrimSpace(w.Body.Bytes())); got != test.wantBody {
+				t.Errorf("body = %s, want %s", got, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestSequenceAndIntegrateSummary(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		integrateErr error
+		wantStatus   int
+		wantBody     string
+	}{
+		{
+			name:       "integrated",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"sequenced":2,"dupes":0,"failed":0,"treeSize":12}`,
+		},
+		{
+			name:         "integration failed",
+			integrateErr: httpError{http.StatusServiceUnavailable, errors.New("Failed to integrate: boom")},
+			wantStatus:   http.StatusServiceUnavailable,
+			wantBody:     `{"sequenced":2,"dupes":0,"failed":0,"treeSize":12,"integrateError":"Failed to integrate: boom"}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := &sequenceAndIntegrateSummary{sequenceSummary: &sequenceSummary{}, TreeSize: 12}
+			s.sequenced(false)
+			s.sequenced(false)
+			if test.integrateErr != nil {
+				s.integrateErr = test.integrateErr
+				s.IntegrateError = test.integrateErr.Error()
+			}
+			w := httptest.NewRecorder()
+			s.write(w)
+			if got := w.Code; got != test.wantStatus {
+				t.Errorf("status = %d, want %d", got, test.wantStatus)
+			}
+			if got := string(bytes.TrimSpace(w.Body.Bytes())); got != test.wantBody {
+				t.Errorf("body = %s, want %s", got, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestCheckKMSPublicKey(t *testing.T) {
+	pemKey := func(t *testing.T, k any) []byte {
+		t.Helper()
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			t.Fatalf("MarshalPKIXPublicKey: %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	for _, test := range []struct {
+		desc    string
+		pem     []byte
+		alg     string
+		wantErr bool
+	}{
+		{
+			desc: "ed25519",
+			pem:  pemKey(t, edKey),
+			alg:  "ed25519",
+		}, {
+			desc:    "wrong key type",
+			pem:     pemKey(t, &ecKey.PublicKey),
+			alg:     "ed25519",
+			wantErr: true,
+		}, {
+			desc:    "unsupported algorithm",
+			pem:     pemKey(t, &ecKey.PublicKey),
+			alg:     "ecdsa-p256",
+			wantErr: true,
+		}, {
+			desc:    "invalid PEM",
+			pem:     []byte("not a key"),
+			alg:     "ed25519",
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			err := checkKMSPublicKey(test.pem, test.alg)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("checkKMSPublicKey = %v, want err %t", err, test.wantErr)
+			}
+			if test.wantErr && !errors.Is(err, errUnsupportedKMSKey) {
+				t.Errorf("checkKMSPublicKey = %v, want errUnsupportedKMSKey", err)
+			}
+			if test.wantErr && statusFor(err) != http.StatusBadRequest {
+				t.Errorf("statusFor(%v) = %d, want %d", err, statusFor(err), http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCheckCommonArgsKeyAlgorithm(t *testing.T) {
+	d := requestData{
+		Origin:         testOrigin,
+		KMSKeyRing:     "ring",
+		KMSKeyName:     "key",
+		KMSKeyLocation: "global",
+		KMSKeyVersion:  1,
+		NoteKeyName:    "note",
+	}
+	for _, test := range []struct {
+		alg     string
+		wantErr bool
+	}{
+		{alg: ""},
+		{alg: "ed25519"},
+		{alg: "rsa", wantErr: true},
+	} {
+		d.KMSKeyAlgorithm = test.alg
+		if err := checkCommonArgs(d); (err != nil) != test.wantErr {
+			t.Errorf("checkCommonArgs with kmsKeyAlgorithm %q = %v, want err %t", test.alg, err, test.wantErr)
+		}
+	}
+}
+
+func TestCheckIntegrateArgs(t *testing.T) {
+	for _, test := range []struct {
+		tileWidth uint64
+		wantErr   bool
+	}{
+		{tileWidth: 0},
+		{tileWidth: 256},
+		{tileWidth: 512, wantErr: true},
+		{tileWidth: 16, wantErr: true},
+	} {
+		if err := checkIntegrateArgs(requestData{TileWidth: test.tileWidth}); (err != nil) != test.wantErr {
+			t.Errorf("checkIntegrateArgs with tileWidth %d = %v, want err %t", test.tileWidth, err, test.wantErr)
+		}
+	}
+}
+
+func TestLeafFormat(t *testing.T) {
+	d := requestData{
+		Origin:         testOrigin,
+		KMSKeyRing:     "ring",
+		KMSKeyName:     "key",
+		KMSKeyLocation: "global",
+		KMSKeyVersion:  1,
+		NoteKeyName:    "note",
+	}
+	for _, test := range []struct {
+		format  string
+		wantErr bool
+	}{
+		{format: ""},
+		{format: "json"},
+		{format: "xml", wantErr: true},
+	} {
+		d.LeafFormat = test.format
+		if err := checkCommonArgs(d); (err != nil) != test.wantErr {
+			t.Errorf("checkCommonArgs with leafFormat %q = %v, want err %t", test.format, err, test.wantErr)
+		}
+	}
+
+	validate := leafValidators["json"]
+	for leaf, wantErr := range map[string]bool{
+		`{"a": 1}`: false,
+		`[1, 2]`:   false,
+		`{"a": 1`:  true,
+		"":         true,
+	} {
+		if err := validate([]byte(leaf)); (err != nil) != wantErr {
+			t.Errorf("json validator(%q) = %v, want err %t", leaf, err, wantErr)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	errStorage := errors.New("storage failure")
+	failing := func() error { return errStorage }
+	ok := func() error { return nil }
+	newBreaker := func(t *testing.T) *circuitBreaker {
+		t.Helper()
+		b := &circuitBreaker{threshold: 3, coolDown: time.Hour}
+		for i := 0; i < b.threshold; i++ {
+			if err := b.call(failing); !errors.Is(err, errStorage) {
+				t.Fatalf("call %d: got %v, want storage failure", i, err)
+			}
+		}
+		return b
+	}
+	// coolDown makes the breaker's cool-down elapse.
+	coolDown := func(b *circuitBreaker) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.openUntil = time.Now()
+	}
+
+	t.Run("threshold", func(t *testing.T) {
+		b := &circuitBreaker{threshold: 3, coolDown: time.Hour}
+		for i := 0; i < b.threshold-1; i++ {
+			_ = b.call(failing)
+		}
+		// Non-storage failures reset the count.
+		_ = b.call(func() error { return log.ErrDupeLeaf })
+		for i := 0; i < b.threshold-1; i++ {
+			_ = b.call(failing)
+		}
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow after %d consecutive failures: %v", b.threshold-1, err)
+		}
+		_ = b.call(failing)
+		if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+			t.Errorf("allow after %d consecutive failures: got %v, want errCircuitOpen", b.threshold, err)
+		}
+	})
+
+	t.Run("open", func(t *testing.T) {
+		b := newBreaker(t)
+		called := false
+		if err := b.call(func() error { called = true; return nil }); !errors.Is(err, errCircuitOpen) {
+			t.Errorf("call while open: got %v, want errCircuitOpen", err)
+		}
+		if called {
+			t.Error("Operation was run while the breaker was open")
+		}
+		if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+			t.Errorf("allow while open: got %v, want errCircuitOpen", err)
+		}
+	})
+
+	t.Run("single probe", func(t *testing.T) {
+		b := newBreaker(t)
+		coolDown(b)
+		// Checking that operations are allowed doesn't take the probe.
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow after cool-down: %v", err)
+		}
+		probeRunning, probeDone := make(chan struct{}), make(chan struct{})
+		probeErr := make(chan error)
+		go func() {
+			probeErr <- b.call(func() error {
+				close(probeRunning)
+				<-probeDone
+				return nil
+			})
+		}()
+		<-probeRunning
+		if err := b.call(ok); !errors.Is(err, errCircuitOpen) {
+			t.Errorf("call while probe in flight: got %v, want errCircuitOpen", err)
+		}
+		if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+			t.Errorf("allow while probe in flight: got %v, want errCircuitOpen", err)
+		}
+		close(probeDone)
+		if err := <-probeErr; err != nil {
+			t.Fatalf("probe: %v", err)
+		}
+		if err := b.call(ok); err != nil {
+			t.Errorf("call after successful probe: %v", err)
+		}
+	})
+
+	t.Run("probe fails", func(t *testing.T) {
+		b := newBreaker(t)
+		coolDown(b)
+		if err := b.call(failing); !errors.Is(err, errStorage) {
+			t.Fatalf("probe: got %v, want storage failure", err)
+		}
+		if err := b.call(ok); !errors.Is(err, errCircuitOpen) {
+			t.Errorf("call after failed probe: got %v, want errCircuitOpen", err)
+		}
+	})
+
+	t.Run("late result doesn't resolve probe", func(t *testing.T) {
+		b := newBreaker(t)
+		coolDown(b)
+		probe, err := b.acquire()
+		if err != nil || !probe {
+			t.Fatalf("acquire after cool-down: got (%t, %v), want (true, nil)", probe, err)
+		}
+		// A call admitted before the breaker opened finishes successfully.
+		b.record(nil, false)
+		if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+			t.Errorf("allow while probe in flight: got %v, want errCircuitOpen", err)
+		}
+		b.record(nil, true)
+		if err := b.allow(); err != nil {
+			t.Errorf("allow after successful probe: %v", err)
+		}
+	})
+}
@@ -5,11 +5,13 @@ go 1.22.7
 require (
 	cloud.google.com/go/kms v1.15.5
 	cloud.google.com/go/storage v1.33.0
+	github.com/google/go-cmp v0.6.0
 	github.com/transparency-dev/armored-witness v0.0.0-20231106114509-3d1fed57e76e
 	github.com/transparency-dev/formats v0.0.0-20230928092353-f8ed364213f7
 	github.com/transparency-dev/merkle v0.0.2
 	github.com/transparency-dev/serverless-log v0.0.0-20231001212932-d1a42e72eef9
 	golang.org/x/mod v0.17.0
+	golang.org/x/sync v0.10.0
 	google.golang.org/api v0.149.0
 	k8s.io/klog/v2 v2.110.1
 )
@@ -30,7 +32,6 @@ require (
 	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/oauth2 v0.13.0 // indirect
-	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2 // indirect
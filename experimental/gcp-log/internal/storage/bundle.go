@@ -0,0 +1,218 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"google.golang.org/api/iterator"
+	"k8s.io/klog/v2"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// Sequenced entries are stored in leaf bundles, each of which holds up to
+// leafBundleSize entries. With a bundle size of 1, each entry is stored as is
+// in its own object at layout.SeqPath. Otherwise, a bundle holds one base64
+// encoded entry per line, and is stored at the layout.SeqPath of its bundle
+// index once full.
+//
+// Sequencing an entry into a bundle writes a new partial bundle, holding that
+// entry and all those before it in the bundle, at the bundle's path suffixed
+// with the number of entries it holds, e.g. seq/00/00/00/00/02.5. This means
+// that a bundle exists for every tree size, at the path readers such as the
+// hammer expect, and that sequence numbers can be claimed by conditionally
+// creating the object, as when each entry has its own object.
+//
+// The cost of this is that filling a bundle of n entries writes n objects
+// holding O(n²) entries between them, and leaves n-1 partial bundles behind.
+// Only the latest, unfilled, bundle is ever looked for by listing its partial
+// versions, since full bundles are read first. Superseded partial bundles can
+// be deleted with GCPartialBundles, and the bundle size should be kept small,
+// e.g. no larger than the tile width, to bound the amount written.
+
+// validateLeafBundleSize returns an error if leaf bundles of the given size
+// would not align with the boundaries of tiles of the given width, i.e. unless
+// it divides, or is a multiple of, the tile width.
+//
+// This is a copy of layout.ValidateLeafBundleSizeForWidth, which the version
+// of serverless-log this module is pinned to doesn't have. It should be
+// removed once the pin is moved past it.
+func validateLeafBundleSize(n, width uint64) error {
+	if n == 0 || (width%n != 0 && n%width != 0) {
+		return fmt.Errorf("leaf bundle size %d must divide, or be a multiple of, the tile width of %d", n, width)
+	}
+	return nil
+}
+
+// seqObject returns the path of the object which is created when the entry
+// with the given sequence number is sequenced.
+func (c *Client) seqObject(seq uint64) string {
+	// Pass an empty rootDir since we don't need this concept in GCS.
+	p := filepath.Join(layout.SeqPath("", seq/c.leafBundleSize))
+	if n := seq%c.leafBundleSize + 1; n < c.leafBundleSize {
+		p += fmt.Sprintf(".%d", n)
+	}
+	return p
+}
+
+// appendBundleEntry returns the content of the bundle b with entry added.
+func (c *Client) appendBundleEntry(b, entry []byte) []byte {
+	if c.leafBundleSize == 1 {
+		return entry
+	}
+	b = append(bytes.Clone(b), base64.StdEncoding.EncodeToString(entry)...)
+	return append(b, '\n')
+}
+
+// sequencedBundle returns the entries which have been sequenced into the leaf
+// bundle holding seq so far, starting with the first entry in the bundle.
+// If seq hasn't been sequenced, it returns fewer entries than are needed to
+// reach it.
+func (c *Client) sequencedBundle(ctx context.Context, seq uint64) ([][]byte, error) {
+	bkt := c.gcsClient.Bucket(c.bucket)
+	bs := c.leafBundleSize
+	start := seq - seq%bs
+	// All but the latest bundle will be full, so try that first.
+	p := c.seqObject(start + bs - 1)
+	raw, err := c.readObject(ctx, bkt.Object(p))
+	if errors.Is(err, gcs.ErrObjectNotExist) && bs > 1 {
+		var n uint64
+		if n, err = c.partialBundleSize(ctx, start/bs); err != nil {
+			return nil, err
+		}
+		if n <= seq-start {
+			return nil, nil
+		}
+		p = c.seqObject(start + n - 1)
+		raw, err = c.readObject(ctx, bkt.Object(p))
+	}
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read object %q in bucket %q: %w", p, c.bucket, err)
+	}
+	if bs == 1 {
+		return [][]byte{raw}, nil
+	}
+	lines := bytes.Split(bytes.TrimSuffix(raw, []byte("\n")), []byte("\n"))
+	entries := make([][]byte, 0, len(lines))
+	for i, l := range lines {
+		e, err := base64.StdEncoding.DecodeString(string(l))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode entry %d of object %q in bucket %q: %v", i, p, c.bucket, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// partialBundleSize returns the number of entries held by the largest partial
+// version of leaf bundle bi, or 0 if there are none.
+func (c *Client) partialBundleSize(ctx context.Context, bi uint64) (uint64, error) {
+	prefix := filepath.Join(layout.SeqPath("", bi)) + "."
+	c.ops.lists.Add(1)
+	it := c.gcsClient.Bucket(c.bucket).Objects(ctx, &gcs.Query{Prefix: prefix})
+	var max uint64
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return max, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to list objects under %q in bucket %q: %w", prefix, c.bucket, err)
+		}
+		n, err := strconv.ParseUint(strings.TrimPrefix(attrs.Name, prefix), 10, 64)
+		if err != nil || n >= c.leafBundleSize {
+			continue
+		}
+		if n > max {
+			max = n
+		}
+	}
+}
+
+// GCPartialBundles deletes partial leaf bundles which have been superseded,
+// i.e. ones which aren't the latest bundle of a tree of any of the sizes in
+// keepReferencedBy, and which hold fewer entries than have been sequenced into
+// the bundle by the largest of those trees.
+//
+// As with GCPartialTiles, keepReferencedBy should contain the size of the
+// current checkpoint, along with the sizes of any older checkpoints which
+// clients may still be using. Partial bundles beyond the largest of these
+// sizes, including the one which the next entry sequenced will be appended to,
+// are never deleted.
+func (c *Client) GCPartialBundles(ctx context.Context, keepReferencedBy []uint64) error {
+	if len(keepReferencedBy) == 0 {
+		return errors.New("no tree sizes to keep partial bundles for")
+	}
+	if c.leafBundleSize == 1 {
+		return nil
+	}
+	var maxSize uint64
+	for _, s := range keepReferencedBy {
+		maxSize = max(maxSize, s)
+	}
+
+	const prefix = "seq/"
+	c.ops.lists.Add(1)
+	it := c.gcsClient.Bucket(c.bucket).Objects(ctx, &gcs.Query{Prefix: prefix})
+	deleted := 0
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list objects under %q in bucket %q: %w", prefix, c.bucket, err)
+		}
+		name, suffix, ok := strings.Cut(attrs.Name, ".")
+		if !ok {
+			continue
+		}
+		bi, err := layout.SeqFromPath("", name)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.ParseUint(suffix, 10, 64)
+		if err != nil || n == 0 || n >= c.leafBundleSize {
+			continue
+		}
+		size := bi*c.leafBundleSize + n
+		if size >= maxSize || slices.Contains(keepReferencedBy, size) {
+			continue
+		}
+		if err := c.writeThrottle.wait(ctx); err != nil {
+			return err
+		}
+		c.ops.deletes.Add(1)
+		if err := c.gcsClient.Bucket(c.bucket).Object(attrs.Name).Delete(ctx); err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+			return fmt.Errorf("failed to delete partial leaf bundle %q in bucket %q: %w", attrs.Name, c.bucket, err)
+		}
+		klog.V(2).Infof("GCPartialBundles: deleted %q", attrs.Name)
+		deleted++
+	}
+	klog.V(1).Infof("GCPartialBundles: deleted %d superseded partial leaf bundles", deleted)
+	return nil
+}
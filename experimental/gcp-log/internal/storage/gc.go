@@ -0,0 +1,129 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/iterator"
+	"k8s.io/klog/v2"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// GCPartialTiles deletes partial tiles which have been superseded, i.e. ones
+// which aren't needed by a tree of any of the sizes in keepReferencedBy, and
+// which cover fewer leaves than the tile at the same position in the largest
+// of those trees.
+//
+// keepReferencedBy should contain the size of the current checkpoint, along
+// with the sizes of any older checkpoints which clients may still be using.
+// Partial tiles beyond the largest of these sizes, e.g. ones written by an
+// integration whose checkpoint hasn't yet been published, are never deleted.
+func (c *Client) GCPartialTiles(ctx context.Context, keepReferencedBy []uint64) error {
+	if len(keepReferencedBy) == 0 {
+		return errors.New("no tree sizes to keep partial tiles for")
+	}
+	var maxSize uint64
+	for _, s := range keepReferencedBy {
+		maxSize = max(maxSize, s)
+	}
+
+	c.ops.lists.Add(1)
+	it := c.gcsClient.Bucket(c.bucket).Objects(ctx, &gcs.Query{Prefix: "tile/"})
+	deleted := 0
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list tiles in bucket %q: %w", c.bucket, err)
+		}
+		level, index, partial, ok := parseTilePath(attrs.Name, c.tileWidth)
+		if !ok || partial == 0 {
+			continue
+		}
+		if partial >= tileCoverage(level, index, maxSize, c.tileWidth) || referencedBy(level, index, partial, keepReferencedBy, c.tileWidth) {
+			continue
+		}
+		if err := c.writeThrottle.wait(ctx); err != nil {
+			return err
+		}
+		c.ops.deletes.Add(1)
+		if err := c.gcsClient.Bucket(c.bucket).Object(attrs.Name).Delete(ctx); err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+			return fmt.Errorf("failed to delete partial tile %q in bucket %q: %w", attrs.Name, c.bucket, err)
+		}
+		klog.V(2).Infof("GCPartialTiles: deleted %q", attrs.Name)
+		deleted++
+	}
+	klog.V(1).Infof("GCPartialTiles: deleted %d superseded partial tiles", deleted)
+	return nil
+}
+
+// tileCoverage returns the number of tile leaves populated in the tile of the
+// given width at the given level and index in a tree of the given size, with
+// width meaning that the tile is full.
+func tileCoverage(level, index, treeSize, width uint64) uint64 {
+	sizeAtLevel := treeSize >> (level * tileHeight(width))
+	start := index * width
+	if sizeAtLevel <= start {
+		return 0
+	}
+	return min(width, sizeAtLevel-start)
+}
+
+// referencedBy returns true if the partial tile of the given width, with the
+// given level, index, and size, is needed by a tree of any of the given sizes.
+func referencedBy(level, index, partial uint64, sizes []uint64, width uint64) bool {
+	for _, s := range sizes {
+		if tileCoverage(level, index, s, width) == partial {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTilePath parses an object name of the form produced by layout.TilePath,
+// i.e. tile/<level>/<index path>[.<partial size>], returning false if name is
+// not a valid path for a tile of the given width.
+func parseTilePath(name string, width uint64) (level, index, partial uint64, ok bool) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 6 || parts[0] != "tile" {
+		return 0, 0, 0, false
+	}
+	level, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	last, suffix, hasSuffix := strings.Cut(parts[5], ".")
+	if hasSuffix {
+		if partial, err = strconv.ParseUint(suffix, 16, 64); err != nil || partial == 0 || partial >= width {
+			return 0, 0, 0, false
+		}
+	}
+	for _, p := range []string{parts[2], parts[3], parts[4], last} {
+		v, err := strconv.ParseUint(p, 16, 64)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		index = index<<8 | v
+	}
+	return level, index, partial, true
+}
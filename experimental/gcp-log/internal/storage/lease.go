@@ -0,0 +1,186 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// sequencerLeasePath is the location of the object used to hold the sequencer lease.
+const sequencerLeasePath = "sequencer.lease"
+
+// ErrLeaseHeld is returned by Sequence when the sequencer lease is enabled,
+// but is currently held by another sequencer.
+var ErrLeaseHeld = errors.New("sequencer lease is held by another sequencer")
+
+// ErrLeaseLost is returned by Sequence and BatchSequence when this client held
+// the sequencer lease, but may no longer do so, e.g. because it couldn't be
+// refreshed before it expired, or another sequencer has taken it over. The
+// caller must stop sequencing, as another sequencer may be assigning the same
+// sequence numbers.
+var ErrLeaseLost = errors.New("sequencer lease was lost")
+
+// leaseRecord is the content of the sequencer lease object.
+type leaseRecord struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// sequencerLease tracks this client's view of the sequencer lease.
+//
+// The lease relies on the clocks of competing sequencers being roughly in
+// sync, the lease duration should be chosen to be much larger than any
+// expected skew.
+type sequencerLease struct {
+	duration time.Duration
+	holder   string
+	// gen is the generation of the lease object last written by this client,
+	// or zero if this client does not hold the lease.
+	gen int64
+	// expires is the time at which this client's hold on the lease expires.
+	expires time.Time
+	// synced is true once nextSeq is known to be the next available sequence
+	// number while holding the lease.
+	synced bool
+}
+
+func newSequencerLease(d time.Duration, holder string) *sequencerLease {
+	if d <= 0 {
+		return nil
+	}
+	if holder == "" {
+		host, _ := os.Hostname()
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			panic(fmt.Errorf("failed to generate sequencer ID: %v", err))
+		}
+		holder = fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+	}
+	return &sequencerLease{duration: d, holder: holder}
+}
+
+// acquireLease acquires, or refreshes, the sequencer lease.
+// The lease is only refreshed once more than half of its duration has elapsed.
+// Returns ErrLeaseHeld if the lease is held by another sequencer, or
+// ErrLeaseLost if this client held the lease but failed to refresh it.
+func (c *Client) acquireLease(ctx context.Context) error {
+	l := c.lease
+	if l.gen != 0 && time.Until(l.expires) > l.duration/2 {
+		return nil
+	}
+	held := l.gen != 0
+	err := c.writeLease(ctx)
+	if err != nil && held && (errors.Is(err, ErrLeaseHeld) || !time.Now().Before(l.expires)) {
+		return c.loseLease(err)
+	}
+	return err
+}
+
+// loseLease records that this client no longer holds the sequencer lease
+// because of err, and returns an error wrapping both ErrLeaseLost and err.
+func (c *Client) loseLease(err error) error {
+	c.lease.gen, c.lease.synced = 0, false
+	return fmt.Errorf("%w: %w", ErrLeaseLost, err)
+}
+
+// writeLease reads the sequencer lease, and if it's free or held by this
+// client, conditionally writes it with a new expiry time.
+func (c *Client) writeLease(ctx context.Context) error {
+	l := c.lease
+	obj := c.gcsClient.Bucket(c.bucket).Object(sequencerLeasePath)
+	cond := gcs.Conditions{DoesNotExist: true}
+	c.ops.reads.Add(1)
+	r, err := obj.NewReader(ctx)
+	switch {
+	case errors.Is(err, gcs.ErrObjectNotExist):
+		l.synced = false
+	case err != nil:
+		return fmt.Errorf("failed to read sequencer lease: %w", err)
+	default:
+		raw, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read sequencer lease: %w", err)
+		}
+		var rec leaseRecord
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return fmt.Errorf("failed to parse sequencer lease: %w", err)
+		}
+		if rec.Holder != l.holder && time.Now().Before(rec.Expires) {
+			return fmt.Errorf("%w (holder %q until %v)", ErrLeaseHeld, rec.Holder, rec.Expires)
+		}
+		if r.Attrs.Generation != l.gen {
+			// Someone else may have held the lease since we last did.
+			l.synced = false
+		}
+		cond = gcs.Conditions{GenerationMatch: r.Attrs.Generation}
+	}
+
+	rec := leaseRecord{Holder: l.holder, Expires: time.Now().Add(l.duration)}
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sequencer lease: %w", err)
+	}
+	c.ops.writes.Add(1)
+	w := obj.If(cond).NewWriter(ctx)
+	w.ObjectAttrs.CacheControl = "no-store"
+	if _, err := w.Write(raw); err != nil {
+		return fmt.Errorf("failed to write sequencer lease: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		l.gen = 0
+		var e *googleapi.Error
+		if errors.As(err, &e) && e.Code == http.StatusPreconditionFailed {
+			// Another sequencer took the lease first.
+			return ErrLeaseHeld
+		}
+		return fmt.Errorf("failed to write sequencer lease: %w", err)
+	}
+	l.gen, l.expires = w.Attrs().Generation, rec.Expires
+	return nil
+}
+
+// ReleaseLease releases the sequencer lease if it is held by this client, so
+// that other sequencers may acquire it without waiting for it to expire.
+// It is a no-op if the lease is not enabled or not held.
+func (c *Client) ReleaseLease(ctx context.Context) error {
+	l := c.lease
+	if l == nil || l.gen == 0 {
+		return nil
+	}
+	gen := l.gen
+	l.gen, l.synced = 0, false
+	c.ops.deletes.Add(1)
+	err := c.gcsClient.Bucket(c.bucket).Object(sequencerLeasePath).If(gcs.Conditions{GenerationMatch: gen}).Delete(ctx)
+	var e *googleapi.Error
+	if errors.Is(err, gcs.ErrObjectNotExist) || (errors.As(err, &e) && e.Code == http.StatusPreconditionFailed) {
+		// The lease has already been taken over or removed.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/transparency-dev/serverless-log/api"
+	"k8s.io/klog/v2"
+)
+
+// MirroredClient is a log storage implementation which writes tree data (i.e.
+// tiles and checkpoints) to both a primary and a mirror Client.
+//
+// All reads, and all sequencing operations, are performed against the primary
+// only; the mirror is intended to be a read replica of the log's tree data for
+// disaster recovery purposes.
+//
+// The functions on this struct are not thread-safe.
+type MirroredClient struct {
+	*Client
+	mirror *Client
+	// bestEffort controls whether failures to write to the mirror are
+	// returned as errors, or merely logged.
+	bestEffort bool
+}
+
+// NewMirroredClient returns a MirroredClient which uses primary as the source
+// of truth, and copies tree data to mirror.
+//
+// If bestEffort is true, failures to write to the mirror will be logged but
+// will not cause the operation to fail, otherwise write operations will only
+// succeed if they succeed on both primary and mirror.
+func NewMirroredClient(primary, mirror *Client, bestEffort bool) *MirroredClient {
+	return &MirroredClient{
+		Client:     primary,
+		mirror:     mirror,
+		bestEffort: bestEffort,
+	}
+}
+
+// mirrorErr handles an error encountered while writing to the mirror.
+func (m *MirroredClient) mirrorErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("failed to write to mirror bucket %q: %w", m.mirror.bucket, err)
+	if m.bestEffort {
+		klog.Warningf("Ignoring mirror failure: %v", err)
+		return nil
+	}
+	return err
+}
+
+// StoreTile writes a tile to the primary, and then the mirror.
+func (m *MirroredClient) StoreTile(ctx context.Context, level, index uint64, tile *api.Tile) error {
+	if err := m.Client.StoreTile(ctx, level, index, tile); err != nil {
+		return err
+	}
+	return m.mirrorErr(m.mirror.StoreTile(ctx, level, index, tile))
+}
+
+// WriteCheckpoint writes the checkpoint to the primary, and then the mirror.
+//
+// The checkpoint is only written to the mirror if the primary accepts it, so
+// a checkpoint rejected for missing the log's signature is written to neither.
+//
+// The write to the primary is subject to the same generation precondition
+// as Client.WriteCheckpoint. The mirror's checkpoint is replaced with the
+// new checkpoint regardless of its generation, though if a checkpoint verifier
+// is set a larger checkpoint on the mirror is still not rolled back.
+func (m *MirroredClient) WriteCheckpoint(ctx context.Context, newCPRaw []byte) error {
+	if err := m.Client.WriteCheckpoint(ctx, newCPRaw); err != nil {
+		return err
+	}
+	if err := m.mirror.refreshCheckpointGen(ctx); err != nil {
+		return m.mirrorErr(err)
+	}
+	return m.mirrorErr(m.mirror.WriteCheckpoint(ctx, newCPRaw))
+}
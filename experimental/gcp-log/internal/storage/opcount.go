@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// OpCounts holds the number of GCS operations made by a Client, for use in
+// estimating the cost of a workload.
+type OpCounts struct {
+	// Reads is the number of object reads and metadata requests, which are
+	// billed as Class B operations.
+	Reads uint64
+	// Writes is the number of object writes, which are billed as Class A
+	// operations. Bucket creation and ACL updates are also counted as writes.
+	Writes uint64
+	// Lists is the number of object and bucket listings, which are billed as
+	// Class A operations. Each listing is counted once, though a listing which
+	// spans several pages of results is billed once per page.
+	Lists uint64
+	// Deletes is the number of object deletions, which are free.
+	Deletes uint64
+}
+
+// ClassA returns the number of operations billed as Class A operations.
+func (o OpCounts) ClassA() uint64 {
+	return o.Writes + o.Lists
+}
+
+// ClassB returns the number of operations billed as Class B operations.
+func (o OpCounts) ClassB() uint64 {
+	return o.Reads
+}
+
+func (o OpCounts) String() string {
+	return fmt.Sprintf("%d reads, %d writes, %d lists, %d deletes (%d class A, %d class B)", o.Reads, o.Writes, o.Lists, o.Deletes, o.ClassA(), o.ClassB())
+}
+
+// opCounters counts the GCS operations made by a Client. It's safe for
+// concurrent use, since some Client methods make operations concurrently.
+type opCounters struct {
+	reads, writes, lists, deletes atomic.Uint64
+}
+
+// OpCounts returns the number of GCS operations made by the client so far.
+func (c *Client) OpCounts() OpCounts {
+	return OpCounts{
+		Reads:   c.ops.reads.Load(),
+		Writes:  c.ops.writes.Load(),
+		Lists:   c.ops.lists.Load(),
+		Deletes: c.ops.deletes.Load(),
+	}
+}
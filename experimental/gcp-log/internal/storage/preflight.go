@@ -0,0 +1,158 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"k8s.io/klog/v2"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// preflightPrefix is the prefix of the probe objects written by Preflight.
+// These objects are deleted once the checks are complete.
+const preflightPrefix = ".preflight/"
+
+// ErrPreflight is returned by Preflight when the bucket is not suitable for
+// storing a log.
+type ErrPreflight struct {
+	Bucket string
+	// Check is a short description of the check which failed.
+	Check string
+	// Err describes why the check failed, and how it might be fixed.
+	Err error
+}
+
+func (e ErrPreflight) Error() string {
+	return fmt.Sprintf("bucket %q failed preflight check %q: %v", e.Bucket, e.Check, e.Err)
+}
+
+func (e ErrPreflight) Unwrap() error {
+	return e.Err
+}
+
+// Preflight checks that the client's bucket is configured such that the log
+// can be stored in it. It checks that:
+//   - the bucket exists, and its metadata can be read
+//   - the bucket has no retention policy, which would prevent the checkpoint
+//     from being updated
+//   - a probe object can be written, read back, and deleted
+//   - writes conditional on the object not existing fail if it does, since
+//     the checkpoint and tiles rely on these preconditions
+//
+// The returned error is an ErrPreflight which describes the first check to
+// fail. Preflight is intended to be called at startup, or by health checks,
+// so that misconfiguration is reported clearly rather than as a failure
+// partway through sequencing or integration.
+func (c *Client) Preflight(ctx context.Context) error {
+	bkt := c.gcsClient.Bucket(c.bucket)
+	fail := func(check string, err error) error {
+		return ErrPreflight{Bucket: c.bucket, Check: check, Err: err}
+	}
+
+	c.ops.reads.Add(1)
+	attrs, err := bkt.Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, gcs.ErrBucketNotExist) {
+			return fail("bucket exists", fmt.Errorf("bucket not found, check the bucket name and project: %w", err))
+		}
+		return fail("bucket exists", permissionHint(err, "storage.buckets.get"))
+	}
+	klog.V(1).Infof("Preflight: bucket %q is in location %q with storage class %q", c.bucket, attrs.Location, attrs.StorageClass)
+	if rp := attrs.RetentionPolicy; rp != nil && rp.RetentionPeriod > 0 {
+		return fail("no retention policy", fmt.Errorf("bucket has a retention policy of %v, which prevents the checkpoint from being updated; remove the retention policy", rp.RetentionPeriod))
+	}
+	if attrs.VersioningEnabled {
+		klog.Warningf("Preflight: bucket %q has object versioning enabled, so every checkpoint update will leave behind a noncurrent version", c.bucket)
+	}
+
+	probePath := fmt.Sprintf("%s%d", preflightPrefix, time.Now().UnixNano())
+	probe := []byte("serverless-log preflight probe\n")
+	obj := bkt.Object(probePath)
+
+	c.ops.writes.Add(1)
+	w := obj.If(gcs.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	if _, err := w.Write(probe); err != nil {
+		return fail("write object", permissionHint(err, "storage.objects.create"))
+	}
+	if err := w.Close(); err != nil {
+		return fail("write object", permissionHint(err, "storage.objects.create"))
+	}
+	gen := w.Attrs().Generation
+	deleted := false
+	defer func() {
+		if deleted {
+			return
+		}
+		// Best effort clean up of the probe after an earlier check failed.
+		c.ops.deletes.Add(1)
+		if err := obj.If(gcs.Conditions{GenerationMatch: gen}).Delete(ctx); err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+			klog.Warningf("Preflight: failed to delete probe object %q in bucket %q: %v", probePath, c.bucket, err)
+		}
+	}()
+
+	c.ops.reads.Add(1)
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return fail("read object", permissionHint(err, "storage.objects.get"))
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return fail("read object", permissionHint(err, "storage.objects.get"))
+	}
+	if !bytes.Equal(got, probe) {
+		return fail("read object", fmt.Errorf("probe object %q read back as %q, want %q", probePath, got, probe))
+	}
+
+	c.ops.writes.Add(1)
+	w = obj.If(gcs.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	if _, err := w.Write(probe); err == nil {
+		err = w.Close()
+		var e *googleapi.Error
+		switch {
+		case err == nil:
+			gen = w.Attrs().Generation
+			return fail("preconditions", fmt.Errorf("overwriting probe object %q succeeded despite a DoesNotExist precondition, so concurrent writers can't be detected", probePath))
+		case !errors.As(err, &e) || e.Code != http.StatusPreconditionFailed:
+			return fail("preconditions", fmt.Errorf("overwriting probe object %q with a DoesNotExist precondition failed with %v, want HTTP status %d", probePath, err, http.StatusPreconditionFailed))
+		}
+	}
+
+	c.ops.deletes.Add(1)
+	if err := obj.If(gcs.Conditions{GenerationMatch: gen}).Delete(ctx); err != nil {
+		return fail("delete object", permissionHint(err, "storage.objects.delete"))
+	}
+	deleted = true
+	return nil
+}
+
+// permissionHint annotates err with the IAM permission which is needed if err
+// is due to the caller not having permission to access the bucket.
+func permissionHint(err error, permission string) error {
+	var e *googleapi.Error
+	if errors.As(err, &e) && (e.Code == http.StatusForbidden || e.Code == http.StatusUnauthorized) {
+		return fmt.Errorf("permission denied, check that the function's service account has the %s permission on the bucket: %w", permission, err)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultInitialBackoff is used as the initial backoff if retries are
+	// enabled without specifying one.
+	defaultInitialBackoff = 100 * time.Millisecond
+	// maxBackoff caps the exponential backoff between retries.
+	maxBackoff = 30 * time.Second
+)
+
+// retryPolicy retries GCS operations which fail with a transient error.
+// The zero value makes no retries.
+type retryPolicy struct {
+	maxRetries     int
+	initialBackoff time.Duration
+}
+
+// newRetryPolicy returns a policy which retries up to maxRetries times, waiting
+// initialBackoff before the first retry and doubling the wait each time.
+func newRetryPolicy(maxRetries int, initialBackoff time.Duration) retryPolicy {
+	if maxRetries <= 0 {
+		return retryPolicy{}
+	}
+	if initialBackoff <= 0 {
+		initialBackoff = defaultInitialBackoff
+	}
+	return retryPolicy{maxRetries: maxRetries, initialBackoff: initialBackoff}
+}
+
+// isRetryable returns true if err is a GCS error whose status code indicates
+// that the operation may succeed if retried.
+// Precondition failures are never retried, as the conditional writes which
+// cause them are how concurrent writers are detected.
+func isRetryable(err error) bool {
+	var e *googleapi.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch e.Code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do calls f until it succeeds, fails with an error which isn't retryable, or
+// the retries are exhausted, and returns the last error from f.
+// No retry is made if ctx would expire before the backoff has elapsed.
+func (p retryPolicy) do(ctx context.Context, f func() error) error {
+	backoff := p.initialBackoff
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.maxRetries || !isRetryable(err) {
+			return err
+		}
+		if d, ok := ctx.Deadline(); ok && time.Until(d) < backoff {
+			return err
+		}
+		klog.V(1).Infof("Retrying GCS operation in %v after attempt %d failed: %v", backoff, attempt+1, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
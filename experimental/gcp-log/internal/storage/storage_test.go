@@ -0,0 +1,1711 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	fmtlog "github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"golang.org/x/mod/sumdb/note"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// flakyTransport responds to the first len(failures) requests with the given
+// status codes, and to all later requests with ok. A status code of 0 lets the
+// corresponding request through to ok.
+type flakyTransport struct {
+	mu       sync.Mutex
+	failures []int
+	ok       func(*http.Request) *http.Response
+	requests int
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	n := t.requests
+	t.requests++
+	t.mu.Unlock()
+	if n < len(t.failures) && t.failures[n] != 0 {
+		if req.Body != nil {
+			io.Copy(io.Discard, req.Body)
+			req.Body.Close()
+		}
+		return response(req, t.failures[n], fmt.Sprintf(`{"error":{"code":%d,"message":"injected failure"}}`, t.failures[n])), nil
+	}
+	return t.ok(req), nil
+}
+
+func (t *flakyTransport) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.requests
+}
+
+func response(req *http.Request, code int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Status:     http.StatusText(code),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+func newFlakyClient(t *testing.T, tr *flakyTransport, maxRetries int, initialBackoff time.Duration) *Client {
+	t.Helper()
+	c, err := NewClient(context.Background(), ClientOpts{
+		Bucket:         "bucket",
+		HTTPClient:     &http.Client{Transport: tr},
+		MaxRetries:     maxRetries,
+		InitialBackoff: initialBackoff,
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+func TestRetryRead(t *testing.T) {
+	okRead := func(req *http.Request) *http.Response {
+		return response(req, http.StatusOK, "data")
+	}
+	for _, test := range []struct {
+		desc         string
+		failures     []int
+		maxRetries   int
+		wantErr      bool
+		wantRequests int
+	}{
+		{
+			desc:         "no failures",
+			maxRetries:   3,
+			wantRequests: 1,
+		}, {
+			desc:         "transient failures",
+			failures:     []int{429, 500, 502, 503, 504},
+			maxRetries:   5,
+			wantRequests: 6,
+		}, {
+			desc:         "retries exhausted",
+			failures:     []int{503, 503, 503},
+			maxRetries:   2,
+			wantErr:      true,
+			wantRequests: 3,
+		}, {
+			desc:         "not retryable",
+			failures:     []int{403},
+			maxRetries:   3,
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			tr := &flakyTransport{failures: test.failures, ok: okRead}
+			c := newFlakyClient(t, tr, test.maxRetries, time.Millisecond)
+			got, err := c.GetObjectData(context.Background(), "object")
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("GetObjectData: got err %v, want err %t", err, test.wantErr)
+			}
+			if err == nil && string(got) != "data" {
+				t.Errorf("GetObjectData: got %q, want %q", got, "data")
+			}
+			if got := tr.count(); got != test.wantRequests {
+				t.Errorf("Got %d requests, want %d", got, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryWrite(t *testing.T) {
+	okWrite := func(req *http.Request) *http.Response {
+		return response(req, http.StatusOK, `{"bucket":"bucket","name":"object","generation":"1"}`)
+	}
+	tile := &api.Tile{NumLeaves: 1, Nodes: [][]byte{{0x01}}}
+
+	tr := &flakyTransport{failures: []int{503, 429}, ok: okWrite}
+	c := newFlakyClient(t, tr, 3, time.Millisecond)
+	if err := c.StoreTile(context.Background(), 0, 0, tile); err != nil {
+		t.Fatalf("StoreTile: %v", err)
+	}
+	if got, want := tr.count(), 3; got != want {
+		t.Errorf("StoreTile made %d requests, want %d", got, want)
+	}
+
+	// Precondition failures must not be retried.
+	tr = &flakyTransport{failures: []int{http.StatusPreconditionFailed}, ok: okWrite}
+	c = newFlakyClient(t, tr, 3, time.Millisecond)
+	if err := c.WriteCheckpoint(context.Background(), []byte("checkpoint")); !errors.Is(err, ErrCheckpointConflict) {
+		t.Fatalf("WriteCheckpoint: got %v, want ErrCheckpointConflict", err)
+	}
+	if got, want := tr.count(), 1; got != want {
+		t.Errorf("WriteCheckpoint made %d requests, want %d", got, want)
+	}
+}
+
+func TestRetryRespectsDeadline(t *testing.T) {
+	tr := &flakyTransport{failures: []int{503, 503}, ok: func(req *http.Request) *http.Response {
+		return response(req, http.StatusOK, "data")
+	}}
+	c := newFlakyClient(t, tr, 3, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := c.GetObjectData(ctx, "object"); err == nil {
+		t.Fatal("GetObjectData succeeded, want error as backoff exceeds deadline")
+	}
+	if got, want := tr.count(), 1; got != want {
+		t.Errorf("Got %d requests, want %d", got, want)
+	}
+}
+
+// fakeGCS is a transport which serves a single bucket of objects from memory.
+// It supports reading, listing, deleting, and multipart uploads of objects,
+// the latter two with optional generation preconditions, as well as reading
+// the bucket's metadata, listing and creating buckets, and setting and listing
+// their ACLs.
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// gens holds the generations of objects which have been written or
+	// deleted through the fake. Objects which were added directly to objects
+	// are at generation 1.
+	gens map[string]int64
+	// meta holds the metadata of objects which have been uploaded through the
+	// fake.
+	meta map[string]uploadMeta
+	// retention, if > 0, is the retention period of the bucket.
+	retention time.Duration
+	// ignorePreconditions, if set, causes uploads to ignore their generation
+	// preconditions, as some emulators do.
+	ignorePreconditions bool
+	// acls maps the names of the buckets which exist to their ACLs, which map
+	// entities to roles.
+	acls map[string]map[string]string
+	// uniformAccess, if set, causes ACL changes to be rejected, as for buckets
+	// with uniform bucket-level access enforced.
+	uniformAccess bool
+}
+
+func (f *fakeGCS) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const jsonPrefix, xmlPrefix = "/storage/v1/b/bucket/o/", "/bucket/"
+	const bucketsPath = "/storage/v1/b"
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == bucketsPath:
+		var items []string
+		for b := range f.acls {
+			items = append(items, fmt.Sprintf(`{"name":%q}`, b))
+		}
+		return response(req, http.StatusOK, fmt.Sprintf(`{"items":[%s]}`, strings.Join(items, ","))), nil
+	case req.Method == http.MethodPost && req.URL.Path == bucketsPath:
+		var attrs struct{ Name string }
+		if err := json.NewDecoder(req.Body).Decode(&attrs); err != nil {
+			return nil, err
+		}
+		if f.acls == nil {
+			f.acls = make(map[string]map[string]string)
+		}
+		f.acls[attrs.Name] = make(map[string]string)
+		return response(req, http.StatusOK, fmt.Sprintf(`{"name":%q}`, attrs.Name)), nil
+	case req.Method == http.MethodGet && req.URL.Path == bucketsPath+"/bucket":
+		var rp string
+		if f.retention > 0 {
+			rp = fmt.Sprintf(`,"retentionPolicy":{"retentionPeriod":"%d","effectiveTime":"2024-01-01T00:00:00Z"}`, int64(f.retention.Seconds()))
+		}
+		return response(req, http.StatusOK, fmt.Sprintf(`{"name":"bucket"%s}`, rp)), nil
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, bucketsPath+"/") && !strings.Contains(strings.TrimPrefix(req.URL.Path, bucketsPath+"/"), "/"):
+		return response(req, http.StatusNotFound, `{"error":{"code":404,"message":"bucket not found"}}`), nil
+	case strings.HasPrefix(req.URL.Path, bucketsPath+"/") && strings.Contains(req.URL.Path, "/acl"):
+		bucket, entity, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, bucketsPath+"/"), "/acl")
+		acl, ok := f.acls[bucket]
+		if !ok {
+			return response(req, http.StatusNotFound, `{"error":{"code":404,"message":"not found"}}`), nil
+		}
+		switch req.Method {
+		case http.MethodPut:
+			if f.uniformAccess {
+				return response(req, http.StatusBadRequest, `{"error":{"code":400,"message":"uniform bucket-level access is enabled"}}`), nil
+			}
+			var rule struct{ Entity, Role string }
+			if err := json.NewDecoder(req.Body).Decode(&rule); err != nil {
+				return nil, err
+			}
+			acl[strings.TrimPrefix(entity, "/")] = rule.Role
+			return response(req, http.StatusOK, fmt.Sprintf(`{"entity":%q,"role":%q}`, rule.Entity, rule.Role)), nil
+		case http.MethodGet:
+			var items []string
+			for e, r := range acl {
+				items = append(items, fmt.Sprintf(`{"entity":%q,"role":%q}`, e, r))
+			}
+			return response(req, http.StatusOK, fmt.Sprintf(`{"items":[%s]}`, strings.Join(items, ","))), nil
+		}
+	case req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/upload/"):
+		meta, data, err := readUpload(req)
+		if err != nil {
+			return nil, err
+		}
+		name := meta.Name
+		if !f.ignorePreconditions && !f.generationMatches(req, name) {
+			return response(req, http.StatusPreconditionFailed, `{"error":{"code":412,"message":"precondition failed"}}`), nil
+		}
+		f.nextGeneration(name)
+		f.objects[name] = data
+		if f.meta == nil {
+			f.meta = make(map[string]uploadMeta)
+		}
+		meta.updated = time.Now()
+		f.meta[name] = meta
+		return response(req, http.StatusOK, f.attrs(name)), nil
+	case req.Method == http.MethodGet && req.URL.Path == strings.TrimSuffix(jsonPrefix, "/"):
+		q := req.URL.Query()
+		var names []string
+		for name := range f.objects {
+			if strings.HasPrefix(name, q.Get("prefix")) && name >= q.Get("startOffset") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		var items []string
+		for _, name := range names {
+			items = append(items, fmt.Sprintf(`{"bucket":"bucket","name":%q}`, name))
+		}
+		return response(req, http.StatusOK, fmt.Sprintf(`{"items":[%s]}`, strings.Join(items, ","))), nil
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, jsonPrefix):
+		name := strings.TrimPrefix(req.URL.Path, jsonPrefix)
+		data, ok := f.objects[name]
+		if !ok {
+			return response(req, http.StatusNotFound, `{"error":{"code":404,"message":"not found"}}`), nil
+		}
+		if req.URL.Query().Get("alt") == "media" {
+			return f.media(req, name, data), nil
+		}
+		return response(req, http.StatusOK, f.attrs(name)), nil
+	case req.Method == http.MethodDelete && strings.HasPrefix(req.URL.Path, jsonPrefix):
+		name := strings.TrimPrefix(req.URL.Path, jsonPrefix)
+		if _, ok := f.objects[name]; !ok {
+			return response(req, http.StatusNotFound, `{"error":{"code":404,"message":"not found"}}`), nil
+		}
+		if !f.generationMatches(req, name) {
+			return response(req, http.StatusPreconditionFailed, `{"error":{"code":412,"message":"precondition failed"}}`), nil
+		}
+		f.nextGeneration(name)
+		delete(f.objects, name)
+		delete(f.meta, name)
+		return response(req, http.StatusNoContent, ""), nil
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, xmlPrefix):
+		name := strings.TrimPrefix(req.URL.Path, xmlPrefix)
+		data, ok := f.objects[name]
+		if !ok {
+			return response(req, http.StatusNotFound, ""), nil
+		}
+		return f.media(req, name, data), nil
+	}
+	return nil, fmt.Errorf("unexpected request %s %s", req.Method, req.URL)
+}
+
+// attrs returns the JSON metadata of the named object.
+func (f *fakeGCS) attrs(name string) string {
+	m := f.meta[name]
+	var updated string
+	if !m.updated.IsZero() {
+		updated = fmt.Sprintf(`,"updated":%q`, m.updated.Format(time.RFC3339Nano))
+	}
+	return fmt.Sprintf(`{"bucket":"bucket","name":%q,"generation":"%d","size":"%d","contentType":%q,"cacheControl":%q%s}`,
+		name, f.generation(name), len(f.objects[name]), m.ContentType, m.CacheControl, updated)
+}
+
+// generation returns the current generation of the named object, or 0 if it
+// doesn't exist.
+func (f *fakeGCS) generation(name string) int64 {
+	if _, ok := f.objects[name]; !ok {
+		return 0
+	}
+	if g, ok := f.gens[name]; ok {
+		return g
+	}
+	return 1
+}
+
+// nextGeneration records that the named object has been written or deleted,
+// and returns its new generation. Generations are never reused.
+func (f *fakeGCS) nextGeneration(name string) int64 {
+	if f.gens == nil {
+		f.gens = make(map[string]int64)
+	}
+	f.gens[name] = max(f.gens[name], 1) + 1
+	return f.gens[name]
+}
+
+// generationMatches returns false if req has an ifGenerationMatch precondition
+// which the named object doesn't meet.
+func (f *fakeGCS) generationMatches(req *http.Request, name string) bool {
+	want := req.URL.Query().Get("ifGenerationMatch")
+	return want == "" || want == strconv.FormatInt(f.generation(name), 10)
+}
+
+// media returns a response containing the content of the named object, or a
+// 404 if req is for a generation other than the current one.
+func (f *fakeGCS) media(req *http.Request, name string, data []byte) *http.Response {
+	gen := f.generation(name)
+	if want := req.URL.Query().Get("generation"); want != "" && want != strconv.FormatInt(gen, 10) {
+		return response(req, http.StatusNotFound, `{"error":{"code":404,"message":"not found"}}`)
+	}
+	resp := response(req, http.StatusOK, string(data))
+	resp.Header.Set("X-Goog-Generation", strconv.FormatInt(gen, 10))
+	return resp
+}
+
+// uploadMeta is the metadata of an object uploaded to fakeGCS.
+type uploadMeta struct {
+	Name, ContentType, CacheControl string
+	updated                         time.Time
+}
+
+// readUpload returns the metadata and content of the object in a multipart
+// upload.
+func readUpload(req *http.Request) (uploadMeta, []byte, error) {
+	var attrs uploadMeta
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return attrs, nil, err
+	}
+	r := multipart.NewReader(req.Body, params["boundary"])
+	meta, err := r.NextPart()
+	if err != nil {
+		return attrs, nil, err
+	}
+	if err := json.NewDecoder(meta).Decode(&attrs); err != nil {
+		return attrs, nil, err
+	}
+	media, err := r.NextPart()
+	if err != nil {
+		return attrs, nil, err
+	}
+	data, err := io.ReadAll(media)
+	return attrs, data, err
+}
+
+func TestBatchSequence(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	gcs := &fakeGCS{objects: make(map[string][]byte)}
+	c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: gcs}})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	batch := func(leaves ...string) []struct{ Hash, Data []byte } {
+		var b []struct{ Hash, Data []byte }
+		for _, l := range leaves {
+			b = append(b, struct{ Hash, Data []byte }{Hash: h.HashLeaf([]byte(l)), Data: []byte(l)})
+		}
+		return b
+	}
+	seqObject := func(seq uint64) string {
+		return filepath.Join(layout.SeqPath("", seq))
+	}
+
+	seqs, dupes, err := c.BatchSequence(ctx, batch("a", "b", "a", "c"))
+	if err != nil {
+		t.Fatalf("BatchSequence: %v", err)
+	}
+	if diff := cmp.Diff([]uint64{0, 1, 0, 2}, seqs); diff != "" {
+		t.Errorf("Sequence numbers diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]bool{false, false, true, false}, dupes); diff != "" {
+		t.Errorf("Dupes diff (-want +got):\n%s", diff)
+	}
+
+	// Simulate another sequencer taking sequence number 4 after this client
+	// has found that 3 is the next available number. The leaf which loses out
+	// is sequenced after the rest of the batch.
+	gcs.objects[seqObject(4)] = []byte("other")
+	c.SetNextSeq(3)
+	seqs, dupes, err = c.BatchSequence(ctx, batch("d", "b", "e", "f"))
+	if err != nil {
+		t.Fatalf("BatchSequence: %v", err)
+	}
+	if diff := cmp.Diff([]uint64{3, 1, 6, 5}, seqs); diff != "" {
+		t.Errorf("Sequence numbers diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]bool{false, true, false, false}, dupes); diff != "" {
+		t.Errorf("Dupes diff (-want +got):\n%s", diff)
+	}
+	for seq, want := range []string{"a", "b", "c", "d", "other", "f", "e"} {
+		if got := string(gcs.objects[seqObject(uint64(seq))]); got != want {
+			t.Errorf("Entry %d is %q, want %q", seq, got, want)
+		}
+	}
+}
+
+func TestBatchSequenceBundles(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	gcs := &fakeGCS{objects: make(map[string][]byte)}
+	c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: gcs}, LeafBundleSize: 4})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	batch := func(leaves ...string) []struct{ Hash, Data []byte } {
+		var b []struct{ Hash, Data []byte }
+		for _, l := range leaves {
+			b = append(b, struct{ Hash, Data []byte }{Hash: h.HashLeaf([]byte(l)), Data: []byte(l)})
+		}
+		return b
+	}
+	bundle := func(entries ...string) string {
+		var b strings.Builder
+		for _, e := range entries {
+			b.WriteString(base64.StdEncoding.EncodeToString([]byte(e)) + "\n")
+		}
+		return b.String()
+	}
+
+	if _, err := c.Sequence(ctx, h.HashLeaf([]byte("a")), []byte("a")); err != nil {
+		t.Fatalf("Sequence: %v", err)
+	}
+	before := c.OpCounts()
+	seqs, _, err := c.BatchSequence(ctx, batch("b", "c", "d", "e", "f"))
+	if err != nil {
+		t.Fatalf("BatchSequence: %v", err)
+	}
+	if diff := cmp.Diff([]uint64{1, 2, 3, 4, 5}, seqs); diff != "" {
+		t.Errorf("Sequence numbers diff (-want +got):\n%s", diff)
+	}
+	// One read of each leafhash object, one lookup of the next sequence
+	// number, and one read of the partial bundle it's added to.
+	if got, want := c.OpCounts().Reads-before.Reads, uint64(7); got != want {
+		t.Errorf("BatchSequence made %d reads, want %d", got, want)
+	}
+	for p, want := range map[string]string{
+		"seq/00/00/00/00/00.2": bundle("a", "b"),
+		"seq/00/00/00/00/00.3": bundle("a", "b", "c"),
+		"seq/00/00/00/00/00":   bundle("a", "b", "c", "d"),
+		"seq/00/00/00/00/01.1": bundle("e"),
+		"seq/00/00/00/00/01.2": bundle("e", "f"),
+	} {
+		if got := string(gcs.objects[p]); got != want {
+			t.Errorf("Object %q is %q, want %q", p, got, want)
+		}
+	}
+
+	// Simulate another sequencer filling bundle 1 after this client has found
+	// that 6 is the next available number. The rest of the batch is then
+	// sequenced on top of the other sequencer's entries.
+	gcs.objects["seq/00/00/00/00/01"] = []byte(bundle("e", "f", "x", "y"))
+	seqs, _, err = c.BatchSequence(ctx, batch("g", "h", "i"))
+	if err != nil {
+		t.Fatalf("BatchSequence: %v", err)
+	}
+	if diff := cmp.Diff([]uint64{6, 8, 9}, seqs); diff != "" {
+		t.Errorf("Sequence numbers diff (-want +got):\n%s", diff)
+	}
+	for p, want := range map[string]string{
+		"seq/00/00/00/00/01.3": bundle("e", "f", "g"),
+		"seq/00/00/00/00/01":   bundle("e", "f", "x", "y"),
+		"seq/00/00/00/00/02.1": bundle("h"),
+		"seq/00/00/00/00/02.2": bundle("h", "i"),
+	} {
+		if got := string(gcs.objects[p]); got != want {
+			t.Errorf("Object %q is %q, want %q", p, got, want)
+		}
+	}
+}
+
+func TestBatchSequenceWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	gcs := &fakeGCS{objects: make(map[string][]byte)}
+	// The batch makes a leafhash read for each of its 4 leaves, then looks up
+	// the next sequence number, before writing the seq objects in order. The
+	// write of the second leaf fails, as does its one retry.
+	tr := &flakyTransport{
+		failures: []int{0, 0, 0, 0, 0, 0, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		ok: func(req *http.Request) *http.Response {
+			resp, err := gcs.RoundTrip(req)
+			if err != nil {
+				return response(req, http.StatusInternalServerError, err.Error())
+			}
+			return resp
+		},
+	}
+	c := newFlakyClient(t, tr, 1, time.Millisecond)
+	batch := func(leaves ...string) []struct{ Hash, Data []byte } {
+		var b []struct{ Hash, Data []byte }
+		for _, l := range leaves {
+			b = append(b, struct{ Hash, Data []byte }{Hash: h.HashLeaf([]byte(l)), Data: []byte(l)})
+		}
+		return b
+	}
+
+	if _, _, err := c.BatchSequence(ctx, batch("a", "b", "c", "d")); err == nil {
+		t.Fatal("BatchSequence: got no error for a failed write")
+	}
+	for seq := uint64(1); seq < 4; seq++ {
+		if data, ok := gcs.objects[filepath.Join(layout.SeqPath("", seq))]; ok {
+			t.Errorf("Entry %d is %q after the write of entry 1 failed, want no entry", seq, data)
+		}
+	}
+
+	// Resubmitting the batch must carry on from the failed write, finding that
+	// the leaf written before it was sequenced.
+	seqs, dupes, err := c.BatchSequence(ctx, batch("a", "b", "c", "d"))
+	if err != nil {
+		t.Fatalf("BatchSequence: %v", err)
+	}
+	if diff := cmp.Diff([]uint64{0, 1, 2, 3}, seqs); diff != "" {
+		t.Errorf("Sequence numbers diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]bool{true, false, false, false}, dupes); diff != "" {
+		t.Errorf("Dupes diff (-want +got):\n%s", diff)
+	}
+	for seq, want := range []string{"a", "b", "c", "d"} {
+		if got := string(gcs.objects[filepath.Join(layout.SeqPath("", uint64(seq)))]); got != want {
+			t.Errorf("Entry %d is %q, want %q", seq, got, want)
+		}
+	}
+}
+
+// lostResponseTransport passes requests through to next, but replaces the
+// response to the first upload with a 503, as if the response to a write which
+// GCS committed had been lost.
+type lostResponseTransport struct {
+	mu   sync.Mutex
+	next http.RoundTripper
+	lost bool
+}
+
+func (t *lostResponseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost || !strings.HasPrefix(req.URL.Path, "/upload/") {
+		return resp, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lost {
+		return resp, nil
+	}
+	t.lost = true
+	return response(req, http.StatusServiceUnavailable, `{"error":{"code":503,"message":"injected failure"}}`), nil
+}
+
+func TestSequenceRetryAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	for _, test := range []struct {
+		desc     string
+		sequence func(c *Client, leaf []byte) (uint64, error)
+	}{
+		{
+			desc: "Sequence",
+			sequence: func(c *Client, leaf []byte) (uint64, error) {
+				return c.Sequence(ctx, h.HashLeaf(leaf), leaf)
+			},
+		}, {
+			desc: "BatchSequence",
+			sequence: func(c *Client, leaf []byte) (uint64, error) {
+				seqs, _, err := c.BatchSequence(ctx, []struct{ Hash, Data []byte }{{Hash: h.HashLeaf(leaf), Data: leaf}})
+				if err != nil {
+					return 0, err
+				}
+				return seqs[0], nil
+			},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			gcs := &fakeGCS{objects: make(map[string][]byte)}
+			c, err := NewClient(ctx, ClientOpts{
+				Bucket:         "bucket",
+				HTTPClient:     &http.Client{Transport: &lostResponseTransport{next: gcs}},
+				MaxRetries:     1,
+				InitialBackoff: time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+
+			// The write of the seq object is committed, but its response is
+			// lost, so the retry fails its DoesNotExist precondition.
+			seq, err := test.sequence(c, []byte("a"))
+			if err != nil {
+				t.Fatalf("sequence: %v", err)
+			}
+			if seq != 0 {
+				t.Errorf("Got sequence number %d, want 0", seq)
+			}
+			if data, ok := gcs.objects[filepath.Join(layout.SeqPath("", 1))]; ok {
+				t.Errorf("Leaf was sequenced again as entry 1 (%q)", data)
+			}
+			seq, err = test.sequence(c, []byte("b"))
+			if err != nil {
+				t.Fatalf("sequence: %v", err)
+			}
+			if seq != 1 {
+				t.Errorf("Got sequence number %d for the next leaf, want 1", seq)
+			}
+		})
+	}
+}
+
+// signedCheckpoint returns a checkpoint for a tree of the given size, signed
+// by s.
+func signedCheckpoint(t *testing.T, s note.Signer, size uint64) []byte {
+	t.Helper()
+	cp := fmtlog.Checkpoint{Origin: "test", Size: size, Hash: make([]byte, 32)}
+	raw, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, s)
+	if err != nil {
+		t.Fatalf("note.Sign: %v", err)
+	}
+	return raw
+}
+
+func newTestSigner(t *testing.T, name string) (note.Signer, note.Verifier) {
+	t.Helper()
+	skey, vkey, err := note.GenerateKey(nil, name)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	s, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	v, err := note.NewVerifier(vkey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	return s, v
+}
+
+func TestWriteCheckpointRollback(t *testing.T) {
+	ctx := context.Background()
+	s, v := newTestSigner(t, "log")
+	const storedSize = 10
+	for _, test := range []struct {
+		desc    string
+		size    uint64
+		wantErr bool
+	}{
+		{desc: "smaller", size: storedSize - 1, wantErr: true},
+		{desc: "equal", size: storedSize},
+		{desc: "larger", size: storedSize + 1},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			stored := signedCheckpoint(t, s, storedSize)
+			gcs := &fakeGCS{objects: map[string][]byte{layout.CheckpointPath: stored}}
+			c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: gcs}})
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			c.SetCheckpointVerifier(v)
+			if _, err := c.ReadCheckpoint(ctx); err != nil {
+				t.Fatalf("ReadCheckpoint: %v", err)
+			}
+
+			cp := signedCheckpoint(t, s, test.size)
+			err = c.WriteCheckpoint(ctx, cp)
+			if test.wantErr {
+				var e ErrCheckpointRollback
+				if !errors.As(err, &e) {
+					t.Fatalf("WriteCheckpoint: got error %v, want ErrCheckpointRollback", err)
+				}
+				if e.Stored != storedSize || e.New != test.size {
+					t.Errorf("WriteCheckpoint: got %+v, want stored size %d and new size %d", e, storedSize, test.size)
+				}
+				if got := gcs.objects[layout.CheckpointPath]; !bytes.Equal(got, stored) {
+					t.Errorf("Stored checkpoint was replaced by %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WriteCheckpoint: %v", err)
+			}
+			if got := gcs.objects[layout.CheckpointPath]; !bytes.Equal(got, cp) {
+				t.Errorf("Stored checkpoint is %q, want %q", got, cp)
+			}
+		})
+	}
+}
+
+func TestWriteCheckpointSignature(t *testing.T) {
+	ctx := context.Background()
+	s, v := newTestSigner(t, "log")
+	other, _ := newTestSigner(t, "log")
+	for _, test := range []struct {
+		desc    string
+		cp      func() []byte
+		wantErr bool
+	}{
+		{
+			desc: "unsigned",
+			cp: func() []byte {
+				cp := fmtlog.Checkpoint{Origin: "test", Size: 1, Hash: make([]byte, 32)}
+				return cp.Marshal()
+			},
+			wantErr: true,
+		}, {
+			desc:    "wrong key",
+			cp:      func() []byte { return signedCheckpoint(t, other, 1) },
+			wantErr: true,
+		}, {
+			desc: "signed by log",
+			cp:   func() []byte { return signedCheckpoint(t, s, 1) },
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			gcs := &fakeGCS{objects: make(map[string][]byte)}
+			c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: gcs}})
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			c.SetCheckpointVerifier(v)
+
+			cp := test.cp()
+			err = c.WriteCheckpoint(ctx, cp)
+			if gotErr := errors.Is(err, ErrMissingLogSignature); gotErr != test.wantErr {
+				t.Fatalf("WriteCheckpoint: got error %v, want ErrMissingLogSignature: %t", err, test.wantErr)
+			}
+			got, stored := gcs.objects[layout.CheckpointPath]
+			if test.wantErr {
+				if stored {
+					t.Errorf("Checkpoint %q was stored despite not being signed by the log", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WriteCheckpoint: %v", err)
+			}
+			if !bytes.Equal(got, cp) {
+				t.Errorf("Stored checkpoint is %q, want %q", got, cp)
+			}
+		})
+	}
+}
+
+func TestSequencerLease(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	gcs := &fakeGCS{objects: make(map[string][]byte)}
+	newClient := func(id string) *Client {
+		t.Helper()
+		c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: gcs}, SequencerLease: time.Minute, SequencerID: id})
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		return c
+	}
+	sequence := func(c *Client, leaf string) (uint64, error) {
+		return c.Sequence(ctx, h.HashLeaf([]byte(leaf)), []byte(leaf))
+	}
+	lease := func() leaseRecord {
+		t.Helper()
+		var rec leaseRecord
+		if err := json.Unmarshal(gcs.objects[sequencerLeasePath], &rec); err != nil {
+			t.Fatalf("Failed to parse lease: %v", err)
+		}
+		return rec
+	}
+	// expire makes the stored lease, and a's view of it, expire as though a
+	// had stalled for longer than the lease duration.
+	expire := func(a *Client) {
+		t.Helper()
+		rec := lease()
+		rec.Expires = time.Now().Add(-time.Second)
+		raw, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatalf("Failed to marshal lease: %v", err)
+		}
+		gcs.objects[sequencerLeasePath] = raw
+		a.lease.expires = rec.Expires
+	}
+	a, b := newClient("a"), newClient("b")
+
+	if seq, err := sequence(a, "0"); err != nil || seq != 0 {
+		t.Fatalf("Sequence by a: got (%d, %v), want (0, nil)", seq, err)
+	}
+	if got := lease().Holder; got != "a" {
+		t.Errorf("Lease holder is %q, want a", got)
+	}
+	if _, err := sequence(b, "1"); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("Sequence by b while a holds the lease: got %v, want ErrLeaseHeld", err)
+	}
+	if data, ok := gcs.objects[filepath.Join(layout.SeqPath("", 1))]; ok {
+		t.Errorf("Entry 1 (%q) written without the lease", data)
+	}
+
+	// Once a's lease expires, b may take it over, but must first probe for
+	// the sequence numbers which a used.
+	expire(a)
+	if seq, err := sequence(b, "1"); err != nil || seq != 1 {
+		t.Fatalf("Sequence by b after a's lease expired: got (%d, %v), want (1, nil)", seq, err)
+	}
+	if got := lease().Holder; got != "b" {
+		t.Errorf("Lease holder is %q, want b", got)
+	}
+	// a has lost the lease, so must stop sequencing.
+	if _, err := sequence(a, "2"); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Sequence by a after losing the lease: got %v, want ErrLeaseLost", err)
+	}
+	if data, ok := gcs.objects[filepath.Join(layout.SeqPath("", 2))]; ok {
+		t.Errorf("Entry 2 (%q) written after the lease was lost", data)
+	}
+
+	// Finding that a sequence number has been used by someone else while
+	// holding the lease means that the lease can't be trusted.
+	gcs.objects[filepath.Join(layout.SeqPath("", 2))] = []byte("other")
+	if _, err := sequence(b, "3"); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Sequence by b after another sequencer used its next number: got %v, want ErrLeaseLost", err)
+	}
+	if seq, err := sequence(b, "3"); err != nil || seq != 3 {
+		t.Errorf("Sequence by b after reacquiring the lease: got (%d, %v), want (3, nil)", seq, err)
+	}
+
+	if err := b.ReleaseLease(ctx); err != nil {
+		t.Fatalf("ReleaseLease: %v", err)
+	}
+	if _, ok := gcs.objects[sequencerLeasePath]; ok {
+		t.Error("Lease still exists after being released")
+	}
+	if seq, err := sequence(a, "4"); err != nil || seq != 4 {
+		t.Errorf("Sequence by a after b released the lease: got (%d, %v), want (4, nil)", seq, err)
+	}
+}
+
+func TestReleaseLeaseAfterTakeover(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	gcs := &fakeGCS{objects: make(map[string][]byte)}
+	clients := make(map[string]*Client)
+	for _, id := range []string{"a", "b"} {
+		c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: gcs}, SequencerLease: time.Minute, SequencerID: id})
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		clients[id] = c
+	}
+	a, b := clients["a"], clients["b"]
+
+	if _, err := a.Sequence(ctx, h.HashLeaf([]byte("0")), []byte("0")); err != nil {
+		t.Fatalf("Sequence by a: %v", err)
+	}
+	// b takes over the lease while a still thinks it holds it.
+	gcs.objects[sequencerLeasePath] = []byte(`{"holder":"a","expires":"2000-01-01T00:00:00Z"}`)
+	if _, err := b.Sequence(ctx, h.HashLeaf([]byte("1")), []byte("1")); err != nil {
+		t.Fatalf("Sequence by b: %v", err)
+	}
+	want := gcs.objects[sequencerLeasePath]
+
+	// a's release is conditioned on the generation it wrote, so mustn't
+	// remove b's lease.
+	if err := a.ReleaseLease(ctx); err != nil {
+		t.Fatalf("ReleaseLease by a: %v", err)
+	}
+	if got := gcs.objects[sequencerLeasePath]; !bytes.Equal(got, want) {
+		t.Errorf("Lease is %q after a released it, want b's lease %q", got, want)
+	}
+	if err := b.ReleaseLease(ctx); err != nil {
+		t.Fatalf("ReleaseLease by b: %v", err)
+	}
+	if got, ok := gcs.objects[sequencerLeasePath]; ok {
+		t.Errorf("Lease is %q after b released it, want no lease", got)
+	}
+}
+
+func TestCreatePublicRead(t *testing.T) {
+	for _, test := range []struct {
+		desc              string
+		disablePublicRead bool
+		uniformAccess     bool
+		wantPublic        bool
+	}{
+		{desc: "default", wantPublic: true},
+		{desc: "disabled", disablePublicRead: true, wantPublic: false},
+		// Failing to set the ACL must not prevent the log from being created.
+		{desc: "uniform access", uniformAccess: true, wantPublic: false},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ctx := context.Background()
+			gcs := &fakeGCS{objects: make(map[string][]byte), uniformAccess: test.uniformAccess}
+			c, err := NewClient(ctx, ClientOpts{
+				ProjectID:         "project",
+				HTTPClient:        &http.Client{Transport: gcs},
+				DisablePublicRead: test.disablePublicRead,
+			})
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			if err := c.Create(ctx, "log"); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			public, err := c.PublicRead(ctx)
+			if err != nil {
+				t.Fatalf("PublicRead: %v", err)
+			}
+			if public != test.wantPublic {
+				t.Errorf("PublicRead = %v, want %v", public, test.wantPublic)
+			}
+			if err := c.Create(ctx, "log"); err == nil {
+				t.Error("Create of existing bucket succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestTileWidth(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewClient(ctx, ClientOpts{Bucket: "bucket", TileWidth: 300}); err == nil {
+		t.Error("NewClient with tile width 300 succeeded, want error")
+	}
+	// Bundles of 768 entries align with the default tiles, but not 512 wide ones.
+	if _, err := NewClient(ctx, ClientOpts{Bucket: "bucket", TileWidth: 512, LeafBundleSize: 768}); err == nil {
+		t.Error("NewClient with tile width 512 and leaf bundle size 768 succeeded, want error")
+	}
+
+	const width = 512
+	gcs := &fakeGCS{objects: make(map[string][]byte)}
+	c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: gcs}, TileWidth: width})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	tile := func(n int) *api.Tile {
+		tile := &api.Tile{NumLeaves: uint(n)}
+		for i := 0; i < 2*n-1; i++ {
+			tile.Nodes = append(tile.Nodes, []byte{byte(i)})
+		}
+		return tile
+	}
+
+	for _, test := range []struct {
+		desc      string
+		index     uint64
+		numLeaves int
+		logSize   uint64
+		wantPath  string
+	}{
+		{desc: "partial wider than 256", index: 0, numLeaves: 300, logSize: 300, wantPath: "tile/00/0000/00/00/00.12c"},
+		{desc: "full", index: 1, numLeaves: width, logSize: 3 * width, wantPath: "tile/00/0000/00/00/01"},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			want := tile(test.numLeaves)
+			if err := c.StoreTile(ctx, 0, test.index, want); err != nil {
+				t.Fatalf("StoreTile: %v", err)
+			}
+			if _, ok := gcs.objects[test.wantPath]; !ok {
+				t.Errorf("No tile stored at %q", test.wantPath)
+			}
+			got, err := c.GetTile(ctx, 0, test.index, test.logSize)
+			if err != nil {
+				t.Fatalf("GetTile: %v", err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("GetTile diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+	if err := c.StoreTile(ctx, 0, 2, tile(width+1)); err == nil {
+		t.Error("StoreTile with a tile wider than the log's tiles succeeded, want error")
+	}
+}
+
+func TestLeafBundles(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	const numLeaves = 10
+	var leaves [][]byte
+	for i := 0; i < numLeaves; i++ {
+		leaves = append(leaves, []byte(fmt.Sprintf("leaf %d", i)))
+	}
+	for _, test := range []struct {
+		bundleSize uint64
+		wantPaths  []string
+	}{
+		{
+			// Legacy layout, with each entry stored in its own object.
+			bundleSize: 1,
+			wantPaths:  []string{"seq/00/00/00/00/00", "seq/00/00/00/00/09"},
+		}, {
+			bundleSize: 4,
+			wantPaths: []string{
+				"seq/00/00/00/00/00.1", "seq/00/00/00/00/00.3", "seq/00/00/00/00/00",
+				"seq/00/00/00/00/01", "seq/00/00/00/00/02.1", "seq/00/00/00/00/02.2",
+			},
+		}, {
+			bundleSize: 256,
+			wantPaths:  []string{"seq/00/00/00/00/00.1", "seq/00/00/00/00/00.10"},
+		},
+	} {
+		t.Run(fmt.Sprintf("%d", test.bundleSize), func(t *testing.T) {
+			gcs := &fakeGCS{objects: make(map[string][]byte)}
+			c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: gcs}, LeafBundleSize: test.bundleSize})
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			// Sequence some of the leaves individually, and the rest in a batch.
+			for _, l := range leaves[:3] {
+				if _, err := c.Sequence(ctx, h.HashLeaf(l), l); err != nil {
+					t.Fatalf("Sequence: %v", err)
+				}
+			}
+			var batch []struct{ Hash, Data []byte }
+			for _, l := range leaves[3:] {
+				batch = append(batch, struct{ Hash, Data []byte }{Hash: h.HashLeaf(l), Data: l})
+			}
+			seqs, _, err := c.BatchSequence(ctx, batch)
+			if err != nil {
+				t.Fatalf("BatchSequence: %v", err)
+			}
+			if diff := cmp.Diff([]uint64{3, 4, 5, 6, 7, 8, 9}, seqs); diff != "" {
+				t.Errorf("BatchSequence numbers diff (-want +got):\n%s", diff)
+			}
+			for _, p := range test.wantPaths {
+				if _, ok := gcs.objects[p]; !ok {
+					t.Errorf("Missing object %q", p)
+				}
+			}
+
+			for _, begin := range []uint64{0, 3, 5, numLeaves} {
+				var scanned, listed [][]byte
+				n, err := c.ScanSequenced(ctx, begin, func(seq uint64, entry []byte) error {
+					if want := begin + uint64(len(scanned)); seq != want {
+						return fmt.Errorf("got seq %d, want %d", seq, want)
+					}
+					scanned = append(scanned, entry)
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("ScanSequenced(%d): %v", begin, err)
+				}
+				if n != numLeaves-begin {
+					t.Errorf("ScanSequenced(%d) = %d, want %d", begin, n, numLeaves-begin)
+				}
+				if diff := cmp.Diff(leaves[begin:], scanned, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("ScanSequenced(%d) entries diff (-want +got):\n%s", begin, diff)
+				}
+				if err := c.ListSequenced(ctx, begin, func(seq uint64, entry []byte) error {
+					if want := begin + uint64(len(listed)); seq != want {
+						return fmt.Errorf("got seq %d, want %d", seq, want)
+					}
+					listed = append(listed, entry)
+					return nil
+				}); err != nil {
+					t.Fatalf("ListSequenced(%d): %v", begin, err)
+				}
+				if diff := cmp.Diff(leaves[begin:], listed, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("ListSequenced(%d) entries diff (-want +got):\n%s", begin, diff)
+				}
+			}
+
+			got, err := c.ReadSequencedRange(ctx, 2, 9)
+			if err != nil {
+				t.Fatalf("ReadSequencedRange: %v", err)
+			}
+			if diff := cmp.Diff(leaves[2:9], got); diff != "" {
+				t.Errorf("ReadSequencedRange entries diff (-want +got):\n%s", diff)
+			}
+			if _, err := c.ReadSequencedRange(ctx, 8, numLeaves+1); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("ReadSequencedRange beyond the sequenced entries: got %v, want os.ErrNotExist", err)
+			}
+		})
+	}
+
+	if _, err := NewClient(ctx, ClientOpts{Bucket: "bucket", LeafBundleSize: 3}); err == nil {
+		t.Error("NewClient with a leaf bundle size which doesn't align with tiles succeeded")
+	}
+}
+
+func TestParseTilePath(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		width       uint64
+		wantLevel   uint64
+		wantIndex   uint64
+		wantPartial uint64
+		wantOK      bool
+	}{
+		{name: "tile/00/0000/00/00/00", width: 256, wantOK: true},
+		{name: "tile/00/0000/00/00/05.2a", width: 256, wantIndex: 5, wantPartial: 42, wantOK: true},
+		{name: "tile/01/0000/00/01/02.ff", width: 256, wantLevel: 1, wantIndex: 0x102, wantPartial: 255, wantOK: true},
+		{name: "tile/00/0001/00/00/00", width: 256, wantIndex: 1 << 24, wantOK: true},
+		{name: "tile/02/0123/45/67/89.01", width: 256, wantLevel: 2, wantIndex: 0x123456789, wantPartial: 1, wantOK: true},
+		{name: "tile/00/0000/00/00/00.100", width: 512, wantPartial: 256, wantOK: true},
+		{name: "tile/00/0000/00/00/00.100", width: 256},
+		{name: "tile/00/0000/00/00/00.00", width: 256},
+		{name: "tile/00/0000/00/00/00.zz", width: 256},
+		{name: "tile/00/0000/00/zz/00", width: 256},
+		{name: "tile/zz/0000/00/00/00", width: 256},
+		{name: "tile/00/0000/00/00", width: 256},
+		{name: "seq/00/00/00/00/00", width: 256},
+	} {
+		t.Run(fmt.Sprintf("%s width %d", test.name, test.width), func(t *testing.T) {
+			level, index, partial, ok := parseTilePath(test.name, test.width)
+			if ok != test.wantOK {
+				t.Fatalf("parseTilePath(%q, %d) ok = %t, want %t", test.name, test.width, ok, test.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if level != test.wantLevel || index != test.wantIndex || partial != test.wantPartial {
+				t.Errorf("parseTilePath(%q, %d) = (%d, %d, %d), want (%d, %d, %d)", test.name, test.width, level, index, partial, test.wantLevel, test.wantIndex, test.wantPartial)
+			}
+			dir, file := layout.TilePath("", level, index, partial)
+			if got := filepath.Join(dir, file); got != test.name {
+				t.Errorf("layout.TilePath(%d, %d, %d) = %q, want %q", level, index, partial, got, test.name)
+			}
+		})
+	}
+}
+
+func TestTileCoverage(t *testing.T) {
+	for _, test := range []struct {
+		desc                          string
+		level, index, treeSize, width uint64
+		want                          uint64
+	}{
+		{desc: "empty tree", level: 0, index: 0, treeSize: 0, width: 256, want: 0},
+		{desc: "partial", level: 0, index: 0, treeSize: 10, width: 256, want: 10},
+		{desc: "full", level: 0, index: 0, treeSize: 300, width: 256, want: 256},
+		{desc: "partial second tile", level: 0, index: 1, treeSize: 300, width: 256, want: 44},
+		{desc: "beyond tree", level: 0, index: 2, treeSize: 300, width: 256, want: 0},
+		{desc: "level 1 partial", level: 1, index: 0, treeSize: 10*256 + 5, width: 256, want: 10},
+		{desc: "level 1 full", level: 1, index: 0, treeSize: 256*256 + 5, width: 256, want: 256},
+		{desc: "level 1 empty", level: 1, index: 0, treeSize: 255, width: 256, want: 0},
+		{desc: "top index segment", level: 0, index: 1 << 24, treeSize: 1<<32 + 5, width: 256, want: 5},
+		{desc: "wide partial", level: 0, index: 0, treeSize: 300, width: 512, want: 300},
+		{desc: "wide level 1", level: 1, index: 0, treeSize: 3*512 + 1, width: 512, want: 3},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := tileCoverage(test.level, test.index, test.treeSize, test.width); got != test.want {
+				t.Errorf("tileCoverage(%d, %d, %d, %d) = %d, want %d", test.level, test.index, test.treeSize, test.width, got, test.want)
+			}
+		})
+	}
+}
+
+func TestReferencedBy(t *testing.T) {
+	for _, test := range []struct {
+		desc                  string
+		level, index, partial uint64
+		sizes                 []uint64
+		want                  bool
+	}{
+		{desc: "current size", level: 0, index: 0, partial: 20, sizes: []uint64{20}, want: true},
+		{desc: "exactly referenced by older size", level: 0, index: 0, partial: 10, sizes: []uint64{20, 10}, want: true},
+		{desc: "superseded", level: 0, index: 0, partial: 10, sizes: []uint64{20, 15}, want: false},
+		{desc: "later tile", level: 0, index: 1, partial: 10, sizes: []uint64{300, 266}, want: true},
+		{desc: "level 1", level: 1, index: 0, partial: 2, sizes: []uint64{3 * 256, 2*256 + 7}, want: true},
+		{desc: "level 1 superseded", level: 1, index: 0, partial: 1, sizes: []uint64{3 * 256, 2*256 + 7}, want: false},
+		{desc: "no sizes", level: 0, index: 0, partial: 10, want: false},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := referencedBy(test.level, test.index, test.partial, test.sizes, 256); got != test.want {
+				t.Errorf("referencedBy(%d, %d, %d, %v) = %t, want %t", test.level, test.index, test.partial, test.sizes, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGCPartialTiles(t *testing.T) {
+	ctx := context.Background()
+	gcs := &fakeGCS{objects: map[string][]byte{
+		"tile/00/0000/00/00/00.05": nil,
+		"tile/00/0000/00/00/00.0a": nil,
+		"tile/00/0000/00/00/00.14": nil,
+		"tile/00/0000/00/00/00.1e": nil,
+		"tile/01/0000/00/00/00.01": nil,
+		"tile/00/0001/00/00/00.05": nil,
+		"tile/00/0000/00/00/01":    nil,
+		"tile/not-a-tile":          nil,
+		"seq/00/00/00/00/05":       nil,
+	}}
+	c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: gcs}})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := c.GCPartialTiles(ctx, nil); err == nil {
+		t.Error("GCPartialTiles with no sizes succeeded, want error")
+	}
+
+	// Keep tiles for the current tree of size 20 and an older one of size 10.
+	if err := c.GCPartialTiles(ctx, []uint64{20, 10}); err != nil {
+		t.Fatalf("GCPartialTiles: %v", err)
+	}
+	var got []string
+	for name := range gcs.objects {
+		got = append(got, name)
+	}
+	want := []string{
+		// Referenced by the older tree.
+		"tile/00/0000/00/00/00.0a",
+		// Referenced by the current tree.
+		"tile/00/0000/00/00/00.14",
+		// Beyond the current tree, e.g. written by an unpublished integration.
+		"tile/00/0000/00/00/00.1e",
+		"tile/01/0000/00/00/00.01",
+		"tile/00/0001/00/00/00.05",
+		// Not partial tiles.
+		"tile/00/0000/00/00/01",
+		"tile/not-a-tile",
+		"seq/00/00/00/00/05",
+	}
+	if diff := cmp.Diff(want, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("Objects after GCPartialTiles diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestGCPartialBundles(t *testing.T) {
+	ctx := context.Background()
+	gcs := &fakeGCS{objects: map[string][]byte{
+		"seq/00/00/00/00/00.1":     nil,
+		"seq/00/00/00/00/00.2":     nil,
+		"seq/00/00/00/00/00.3":     nil,
+		"seq/00/00/00/00/00":       nil,
+		"seq/00/00/00/00/01.1":     nil,
+		"seq/00/00/00/00/01.2":     nil,
+		"seq/00/00/00/00/01.3":     nil,
+		"seq/00/00/00/00/02.x":     nil,
+		"tile/00/0000/00/00/00.05": nil,
+	}}
+	c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: gcs}, LeafBundleSize: 4})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := c.GCPartialBundles(ctx, nil); err == nil {
+		t.Error("GCPartialBundles with no sizes succeeded, want error")
+	}
+
+	// Keep bundles for the current tree of size 6 and an older one of size 2.
+	if err := c.GCPartialBundles(ctx, []uint64{6, 2}); err != nil {
+		t.Fatalf("GCPartialBundles: %v", err)
+	}
+	var got []string
+	for name := range gcs.objects {
+		got = append(got, name)
+	}
+	want := []string{
+		// Referenced by the older tree.
+		"seq/00/00/00/00/00.2",
+		// Referenced by the current tree.
+		"seq/00/00/00/00/01.2",
+		// Beyond the current tree, and needed to sequence the next entry.
+		"seq/00/00/00/00/01.3",
+		// Not partial bundles.
+		"seq/00/00/00/00/00",
+		"seq/00/00/00/00/02.x",
+		"tile/00/0000/00/00/00.05",
+	}
+	if diff := cmp.Diff(want, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("Objects after GCPartialBundles diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestObjectInfo(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeGCS{objects: make(map[string][]byte)}
+	c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: fake}, OtherCacheControl: "max-age=3600"})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := c.ObjectInfo(ctx, "tile/00/0000/00/00/00"); !errors.Is(err, gcs.ErrObjectNotExist) {
+		t.Fatalf("ObjectInfo of missing object: got %v, want ErrObjectNotExist", err)
+	}
+
+	tile := &api.Tile{NumLeaves: 256, Nodes: make([][]byte, 511)}
+	for i := range tile.Nodes {
+		tile.Nodes[i] = make([]byte, 32)
+	}
+	if err := c.StoreTile(ctx, 0, 0, tile); err != nil {
+		t.Fatalf("StoreTile: %v", err)
+	}
+	raw, err := tile.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText: %v", err)
+	}
+	info, err := c.ObjectInfo(ctx, "tile/00/0000/00/00/00")
+	if err != nil {
+		t.Fatalf("ObjectInfo: %v", err)
+	}
+	if info.Generation == 0 || info.Updated.IsZero() {
+		t.Errorf("ObjectInfo = %+v, want a generation and update time", info)
+	}
+	if got, want := info.Size, int64(len(raw)); got != want {
+		t.Errorf("ObjectInfo size = %d, want %d", got, want)
+	}
+	if got, want := info.CacheControl, "max-age=3600"; got != want {
+		t.Errorf("ObjectInfo cache control = %q, want %q", got, want)
+	}
+}
+
+func TestMirroredClient(t *testing.T) {
+	ctx := context.Background()
+	tile := &api.Tile{NumLeaves: 1, Nodes: [][]byte{make([]byte, 32)}}
+	const tilePath = "tile/00/0000/00/00/00.01"
+	newClient := func(tr http.RoundTripper) *Client {
+		t.Helper()
+		c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: tr}})
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		return c
+	}
+	// broken is a mirror which rejects every request.
+	broken := &flakyTransport{ok: func(req *http.Request) *http.Response {
+		return response(req, http.StatusForbidden, `{"error":{"code":403,"message":"forbidden"}}`)
+	}}
+
+	t.Run("mirrored", func(t *testing.T) {
+		primary, mirror := &fakeGCS{objects: make(map[string][]byte)}, &fakeGCS{objects: make(map[string][]byte)}
+		m := NewMirroredClient(newClient(primary), newClient(mirror), false)
+		if err := m.StoreTile(ctx, 0, 0, tile); err != nil {
+			t.Fatalf("StoreTile: %v", err)
+		}
+		if err := m.WriteCheckpoint(ctx, []byte("checkpoint")); err != nil {
+			t.Fatalf("WriteCheckpoint: %v", err)
+		}
+		for _, f := range []*fakeGCS{primary, mirror} {
+			for _, p := range []string{tilePath, layout.CheckpointPath} {
+				if _, ok := f.objects[p]; !ok {
+					t.Errorf("Missing object %q", p)
+				}
+			}
+		}
+		// Sequencing only touches the primary.
+		if _, err := m.Sequence(ctx, []byte("hash"), []byte("leaf")); err != nil {
+			t.Fatalf("Sequence: %v", err)
+		}
+		if _, ok := mirror.objects["seq/00/00/00/00/00"]; ok {
+			t.Error("Sequenced entry was written to the mirror")
+		}
+	})
+
+	for _, test := range []struct {
+		desc       string
+		bestEffort bool
+		wantErr    bool
+	}{
+		{desc: "mirror failure", wantErr: true},
+		{desc: "best effort mirror failure", bestEffort: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			primary := &fakeGCS{objects: make(map[string][]byte)}
+			m := NewMirroredClient(newClient(primary), newClient(broken), test.bestEffort)
+			if err := m.StoreTile(ctx, 0, 0, tile); (err != nil) != test.wantErr {
+				t.Errorf("StoreTile: got %v, want err %t", err, test.wantErr)
+			}
+			if err := m.WriteCheckpoint(ctx, []byte("checkpoint")); (err != nil) != test.wantErr {
+				t.Errorf("WriteCheckpoint: got %v, want err %t", err, test.wantErr)
+			}
+			// The primary is written to regardless.
+			for _, p := range []string{tilePath, layout.CheckpointPath} {
+				if _, ok := primary.objects[p]; !ok {
+					t.Errorf("Missing object %q on primary", p)
+				}
+			}
+		})
+	}
+}
+
+func TestListSequencedGaps(t *testing.T) {
+	ctx := context.Background()
+	seqObject := func(seq uint64) string {
+		return filepath.Join(layout.SeqPath("", seq))
+	}
+	fake := &fakeGCS{objects: map[string][]byte{
+		seqObject(0): []byte("zero"),
+		seqObject(1): []byte("one"),
+		seqObject(3): []byte("three"),
+		seqObject(5): []byte("five"),
+	}}
+	c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: fake}})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	for _, test := range []struct {
+		begin uint64
+		want  []string
+	}{
+		{begin: 0, want: []string{"zero", "one", "three", "five"}},
+		{begin: 2, want: []string{"three", "five"}},
+		{begin: 6},
+	} {
+		var got []string
+		if err := c.ListSequenced(ctx, test.begin, func(seq uint64, entry []byte) error {
+			if want := seqObject(seq); fake.objects[want] == nil {
+				return fmt.Errorf("visited seq %d, which has no entry", seq)
+			}
+			got = append(got, string(entry))
+			return nil
+		}); err != nil {
+			t.Fatalf("ListSequenced(%d): %v", test.begin, err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("ListSequenced(%d) diff (-want +got):\n%s", test.begin, diff)
+		}
+	}
+
+	// Listing stops at the first error returned by f.
+	wantErr := errors.New("stop")
+	calls := 0
+	if err := c.ListSequenced(ctx, 0, func(uint64, []byte) error {
+		calls++
+		return wantErr
+	}); !errors.Is(err, wantErr) || calls != 1 {
+		t.Errorf("ListSequenced with failing f: got (%v, %d calls), want (%v, 1 call)", err, calls, wantErr)
+	}
+
+	// Unlike ListSequenced, ReadSequencedRange requires a contiguous range.
+	got, err := c.ReadSequencedRange(ctx, 0, 2)
+	if err != nil {
+		t.Fatalf("ReadSequencedRange: %v", err)
+	}
+	if diff := cmp.Diff([][]byte{[]byte("zero"), []byte("one")}, got); diff != "" {
+		t.Errorf("ReadSequencedRange diff (-want +got):\n%s", diff)
+	}
+	if _, err := c.ReadSequencedRange(ctx, 0, 4); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadSequencedRange over a gap: got %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestWriteThrottle(t *testing.T) {
+	ctx := context.Background()
+	if th := newWriteThrottle(0); th != nil {
+		t.Errorf("newWriteThrottle(0) = %v, want nil", th)
+	}
+	var unlimited *writeThrottle
+	if err := unlimited.wait(ctx); err != nil {
+		t.Errorf("wait on nil throttle: %v", err)
+	}
+
+	const n, rate = 5, 100
+	th := newWriteThrottle(rate)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := th.wait(ctx); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	// The first operation proceeds immediately, and each later one waits for
+	// its interval.
+	if got, want := time.Since(start), (n-1)*time.Second/rate; got < want {
+		t.Errorf("%d operations took %v, want at least %v", n, got, want)
+	}
+
+	// Writes through the client are throttled, except for checkpoints.
+	fake := &fakeGCS{objects: make(map[string][]byte)}
+	c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: fake}, MaxWriteOpsPerSecond: 1})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	tile := &api.Tile{NumLeaves: 1, Nodes: [][]byte{make([]byte, 32)}}
+	if err := c.StoreTile(ctx, 0, 0, tile); err != nil {
+		t.Fatalf("StoreTile: %v", err)
+	}
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := c.StoreTile(shortCtx, 0, 1, tile); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("StoreTile beyond the write rate: got %v, want context.DeadlineExceeded", err)
+	}
+	if err := c.WriteCheckpoint(ctx, []byte("checkpoint")); err != nil {
+		t.Errorf("WriteCheckpoint beyond the write rate: %v", err)
+	}
+}
+
+// corruptingTransport passes requests through to fakeGCS, but replaces the
+// content of each object uploaded with corrupt, as if storage were faulty.
+type corruptingTransport struct {
+	*fakeGCS
+	corrupt []byte
+}
+
+func (t corruptingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.fakeGCS.RoundTrip(req)
+	if err == nil && t.corrupt != nil && req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/upload/") {
+		t.mu.Lock()
+		for name := range t.meta {
+			t.objects[name] = t.corrupt
+		}
+		t.mu.Unlock()
+	}
+	return resp, err
+}
+
+func TestVerifyWrites(t *testing.T) {
+	ctx := context.Background()
+	tile := &api.Tile{NumLeaves: 1, Nodes: [][]byte{make([]byte, 32)}}
+	for _, test := range []struct {
+		desc         string
+		verifyWrites bool
+		corrupt      []byte
+		wantErr      bool
+	}{
+		{desc: "verified", verifyWrites: true},
+		{desc: "corrupt", verifyWrites: true, corrupt: []byte("corrupt"), wantErr: true},
+		{desc: "corrupt without verification", corrupt: []byte("corrupt")},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			tr := corruptingTransport{fakeGCS: &fakeGCS{objects: make(map[string][]byte)}, corrupt: test.corrupt}
+			c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: tr}, VerifyWrites: test.verifyWrites})
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			var e ErrWriteVerification
+			if err := c.StoreTile(ctx, 0, 0, tile); test.wantErr != errors.As(err, &e) || (!test.wantErr && err != nil) {
+				t.Errorf("StoreTile: got %v, want ErrWriteVerification %t", err, test.wantErr)
+			}
+			if err := c.WriteCheckpoint(ctx, []byte("checkpoint")); test.wantErr != errors.As(err, &e) || (!test.wantErr && err != nil) {
+				t.Errorf("WriteCheckpoint: got %v, want ErrWriteVerification %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		desc      string
+		bucket    string
+		fake      *fakeGCS
+		wantCheck string
+	}{
+		{
+			desc: "ok",
+			fake: &fakeGCS{objects: make(map[string][]byte)},
+		}, {
+			desc:      "missing bucket",
+			bucket:    "missing",
+			fake:      &fakeGCS{objects: make(map[string][]byte)},
+			wantCheck: "bucket exists",
+		}, {
+			desc:      "retention policy",
+			fake:      &fakeGCS{objects: make(map[string][]byte), retention: time.Hour},
+			wantCheck: "no retention policy",
+		}, {
+			desc:      "preconditions ignored",
+			fake:      &fakeGCS{objects: make(map[string][]byte), ignorePreconditions: true},
+			wantCheck: "preconditions",
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			bucket := test.bucket
+			if bucket == "" {
+				bucket = "bucket"
+			}
+			c, err := NewClient(ctx, ClientOpts{Bucket: bucket, HTTPClient: &http.Client{Transport: test.fake}})
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			err = c.Preflight(ctx)
+			var e ErrPreflight
+			if test.wantCheck == "" && err != nil {
+				t.Fatalf("Preflight: %v", err)
+			} else if test.wantCheck != "" && (!errors.As(err, &e) || e.Check != test.wantCheck) {
+				t.Fatalf("Preflight: got %v, want ErrPreflight for check %q", err, test.wantCheck)
+			}
+			// The probe object is always cleaned up.
+			for name := range test.fake.objects {
+				if strings.HasPrefix(name, preflightPrefix) {
+					t.Errorf("Probe object %q left behind", name)
+				}
+			}
+		})
+	}
+}
+
+func TestOpCounts(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeGCS{objects: map[string][]byte{
+		"tile/00/0000/00/00/00.01": nil,
+		"object":                   []byte("data"),
+	}}
+	c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", HTTPClient: &http.Client{Transport: fake}})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := c.StoreTile(ctx, 0, 0, &api.Tile{NumLeaves: 2, Nodes: [][]byte{make([]byte, 32), make([]byte, 32), make([]byte, 32)}}); err != nil {
+		t.Fatalf("StoreTile: %v", err)
+	}
+	if _, err := c.GetObjectData(ctx, "object"); err != nil {
+		t.Fatalf("GetObjectData: %v", err)
+	}
+	// Deletes the partial tile superseded by the one stored above.
+	if err := c.GCPartialTiles(ctx, []uint64{2}); err != nil {
+		t.Fatalf("GCPartialTiles: %v", err)
+	}
+	got := c.OpCounts()
+	if diff := cmp.Diff(OpCounts{Reads: 1, Writes: 1, Lists: 1, Deletes: 1}, got); diff != "" {
+		t.Errorf("OpCounts diff (-want +got):\n%s", diff)
+	}
+	if got.ClassA() != 2 || got.ClassB() != 1 {
+		t.Errorf("Got %d class A and %d class B operations, want 2 and 1", got.ClassA(), got.ClassB())
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeGCS{objects: map[string][]byte{"object": []byte("data")}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		resp, err := fake.RoundTrip(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(ctx, ClientOpts{Bucket: "bucket", Endpoint: srv.URL + "/storage/v1/", WithoutAuthentication: true})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	got, err := c.GetObjectData(ctx, "object")
+	if err != nil {
+		t.Fatalf("GetObjectData: %v", err)
+	}
+	if string(got) != "data" {
+		t.Errorf("GetObjectData = %q, want %q", got, "data")
+	}
+	if err := c.StoreTile(ctx, 0, 0, &api.Tile{NumLeaves: 1, Nodes: [][]byte{make([]byte, 32)}}); err != nil {
+		t.Fatalf("StoreTile: %v", err)
+	}
+	if _, ok := fake.objects["tile/00/0000/00/00/00.01"]; !ok {
+		t.Error("StoreTile didn't write to the endpoint")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// writeThrottle limits the rate at which write operations are made.
+// A nil *writeThrottle imposes no limit.
+type writeThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// next is the earliest time at which the next operation may proceed.
+	next time.Time
+}
+
+// newWriteThrottle returns a throttle which allows opsPerSecond operations per
+// second, or nil if opsPerSecond is not positive.
+func newWriteThrottle(opsPerSecond int) *writeThrottle {
+	if opsPerSecond <= 0 {
+		return nil
+	}
+	return &writeThrottle{interval: time.Second / time.Duration(opsPerSecond)}
+}
+
+// wait blocks until the caller may make a write operation, or ctx is done.
+func (t *writeThrottle) wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	d := t.next.Sub(now)
+	t.next = t.next.Add(t.interval)
+	t.mu.Unlock()
+
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
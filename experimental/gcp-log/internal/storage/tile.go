@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// DefaultTileWidth is the width of a log's tiles unless ClientOpts.TileWidth
+// says otherwise.
+const DefaultTileWidth = 256
+
+// validateTileWidth returns an error unless width is a valid tile width, i.e.
+// a power of two which is at least 2.
+// Like validateLeafBundleSize, it copies layout.ValidateTileWidth, and should
+// be removed once the serverless-log pin is moved past it.
+func validateTileWidth(width uint64) error {
+	if width < 2 || width&(width-1) != 0 {
+		return fmt.Errorf("tile width %d must be a power of two, and at least 2", width)
+	}
+	return nil
+}
+
+// tileHeight returns the number of tree levels covered by tiles of the given
+// width, which must be valid.
+func tileHeight(width uint64) uint64 {
+	return uint64(bits.TrailingZeros64(width))
+}
+
+// partialTileSize returns the expected number of leaves in a tile of the given
+// width at the given location within a tree of the specified logSize, or 0 if
+// the tile is expected to be fully populated.
+func partialTileSize(level, index, logSize, width uint64) uint64 {
+	sizeAtLevel := logSize >> (level * tileHeight(width))
+	if index < sizeAtLevel/width {
+		return 0
+	}
+	return sizeAtLevel % width
+}
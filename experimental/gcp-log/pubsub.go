@@ -0,0 +1,112 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/gcp_serverless_module/internal/storage"
+)
+
+// pubSubConfigEnv is the name of the environment variable holding the JSON
+// encoded request args used by SequencePubSub, since Pub/Sub triggered
+// functions have no request body to carry them.
+const pubSubConfigEnv = "SEQUENCE_PUBSUB_CONFIG"
+
+// PubSubMessage is the payload of a Pub/Sub event.
+type PubSubMessage struct {
+	// Data is the leaf to be sequenced. Pub/Sub delivers it base64 encoded,
+	// which encoding/json decodes for us.
+	Data []byte `json:"data"`
+}
+
+// SequencePubSub is the entrypoint of the `sequence-pubsub` GCF function,
+// which sequences a single leaf delivered in a Pub/Sub message.
+//
+// Returning nil acks the message, whereas returning an error causes Pub/Sub
+// to redeliver it if the function was deployed with retries enabled.
+// Messages which can never be sequenced (i.e. those with no data, or which
+// fail leaf validation) are logged and acked, as are duplicates of leaves which have already been sequenced.
+func SequencePubSub(ctx context.Context, m PubSubMessage) error {
+	if len(m.Data) == 0 {
+		fmt.Println("Ignoring Pub/Sub message with no data")
+		return nil
+	}
+
+	d, err := pubSubRequestData()
+	if err != nil {
+		return err
+	}
+	if err := breaker.allow(); err != nil {
+		return err
+	}
+
+	client, err := newClient(ctx, d)
+	if err != nil {
+		return fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	defer logOpCounts("SequencePubSub", d.Bucket, client)
+	defer func() {
+		if err := client.ReleaseLease(ctx); err != nil {
+			fmt.Printf("Failed to release sequencer lease: %v\n", err)
+		}
+	}()
+
+	size, err := checkpointSize(ctx, client, d)
+	if err != nil {
+		return err
+	}
+	client.SetNextSeq(size)
+
+	seq, dupe, err := sequenceLeaf(ctx, client, m.Data)
+	if errors.As(err, &storage.ErrInvalidLeaf{}) {
+		// Redelivering the message won't make the leaf valid.
+		fmt.Printf("Rejecting Pub/Sub message: %v\n", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sequence leaf: %w", err)
+	}
+	l := fmt.Sprintf("Sequence num %d assigned to Pub/Sub message", seq)
+	if dupe {
+		l += " (dupe)"
+	}
+	fmt.Println(l)
+	return nil
+}
+
+// pubSubRequestData returns the request args for SequencePubSub from the
+// environment.
+func pubSubRequestData() (requestData, error) {
+	d := requestData{}
+	c := os.Getenv(pubSubConfigEnv)
+	if c == "" {
+		return d, fmt.Errorf("please set %s to the JSON encoded sequence request args", pubSubConfigEnv)
+	}
+	if err := json.Unmarshal([]byte(c), &d); err != nil {
+		return d, fmt.Errorf("failed to decode %s: %w", pubSubConfigEnv, err)
+	}
+	if err := checkCommonArgs(d); err != nil {
+		return d, err
+	}
+	if len(d.Bucket) == 0 {
+		return d, errors.New("please set `bucket` to the name of the log's GCS bucket")
+	}
+	return d, nil
+}
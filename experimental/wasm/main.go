@@ -219,7 +219,7 @@ func queueLeaf() js.Func {
 
 func tileFetcher(treeSize uint64, f client.Fetcher) client.GetTileFunc {
 	return func(ctx context.Context, l, i uint64) (*api.Tile, error) {
-		dir, p := layout.TilePath("", l, i, treeSize)
+		dir, p := layout.TilePath("", l, i, layout.PartialTileSize(l, i, treeSize))
 		tRaw, err := f(ctx, path.Join(dir, p))
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch tile at level: %d, index: %d: %v", l, i, err)
@@ -17,17 +17,24 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"io"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/serverless-log/api"
 	"github.com/transparency-dev/serverless-log/api/layout"
 	"github.com/transparency-dev/serverless-log/client"
 	"k8s.io/klog/v2"
@@ -36,32 +43,61 @@ import (
 // NewLeafReader creates a LeafReader.
 // The next function provides a strategy for which leaves will be read.
 // Custom implementations can be passed, or use RandomNextLeaf or MonotonicallyIncreasingNextLeaf.
-func NewLeafReader(tracker *client.LogStateTracker, f client.Fetcher, next func(uint64) uint64, bundleSize int, throttle <-chan bool, errchan chan<- error, leafchan chan<- Leaf) *LeafReader {
+// shared, if non-nil, is a bundle cache shared with other readers, otherwise the
+// reader caches only the last bundle it fetched.
+// latency, if non-nil, records how long each leaf bundle fetch takes.
+// If parallelism is > 1, the reader fetches up to that many consecutive leaf
+// bundles concurrently, while still reading leaves in the order returned by
+// next. This is intended for readers which read contiguous ranges of leaves.
+// If verifyInclusion is true, the reader also checks that each leaf it reads is
+// committed to by the checkpoint it was read against, using an inclusion proof.
+// tileWidth is the width of the log's tiles, which are used for verification.
+func NewLeafReader(tracker *client.LogStateTracker, f client.Fetcher, next func(uint64) uint64, bundleSize, tileWidth, parallelism int, shared *SharedBundleCache, verifyInclusion bool, throttle <-chan bool, latency *LatencyTracker, errchan chan<- error, leafchan chan<- Leaf) *LeafReader {
 	if bundleSize <= 0 {
 		panic("bundleSize must be > 0")
 	}
+	if err := layout.ValidateTileWidth(uint64(tileWidth)); err != nil {
+		panic(err)
+	}
+	if err := layout.ValidateLeafBundleSizeForWidth(uint64(bundleSize), uint64(tileWidth)); err != nil {
+		panic(err)
+	}
 	return &LeafReader{
-		tracker:    tracker,
-		f:          f,
-		next:       next,
-		bundleSize: bundleSize,
-		throttle:   throttle,
-		errchan:    errchan,
-		leafchan:   leafchan,
+		tracker:     tracker,
+		f:           f,
+		next:        next,
+		bundleSize:  bundleSize,
+		tileWidth:   uint64(tileWidth),
+		parallelism: parallelism,
+		shared:      shared,
+		verify:      verifyInclusion,
+		throttle:    throttle,
+		latency:     latency,
+		errchan:     errchan,
+		leafchan:    leafchan,
 	}
 }
 
 // LeafReader reads leaves from the tree.
 type LeafReader struct {
-	tracker    *client.LogStateTracker
-	f          client.Fetcher
-	next       func(uint64) uint64
-	bundleSize int
-	throttle   <-chan bool
-	errchan    chan<- error
-	leafchan   chan<- Leaf
-	cancel     func()
-	c          leafBundleCache
+	tracker     *client.LogStateTracker
+	f           client.Fetcher
+	next        func(uint64) uint64
+	bundleSize  int
+	tileWidth   uint64
+	parallelism int
+	throttle    <-chan bool
+	latency     *LatencyTracker
+	errchan     chan<- error
+	leafchan    chan<- Leaf
+	cancel      func()
+	c           leafBundleCache
+	shared      *SharedBundleCache
+	verify      bool
+	// pb builds inclusion proofs against pbCP, and is rebuilt when the tracked
+	// checkpoint changes.
+	pb   *client.ProofBuilder
+	pbCP log.Checkpoint
 }
 
 // Run runs the log reader. This should be called in a goroutine.
@@ -70,13 +106,18 @@ func (r *LeafReader) Run(ctx context.Context) {
 		panic("LeafReader was ran multiple times")
 	}
 	ctx, r.cancel = context.WithCancel(ctx)
+	if r.parallelism > 1 {
+		r.runParallel(ctx)
+		return
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-r.throttle:
 		}
-		size := r.tracker.LatestConsistent.Size
+		cp := r.tracker.LatestConsistent
+		size := cp.Size
 		if size == 0 {
 			continue
 		}
@@ -86,8 +127,13 @@ func (r *LeafReader) Run(ctx context.Context) {
 		}
 		klog.V(2).Infof("LeafReader getting %d", i)
 		data, err := r.getLeaf(ctx, i, size)
+		readOpsCounter.Inc()
 		if err != nil {
 			r.errchan <- fmt.Errorf("failed to get leaf %d: %v", i, err)
+		} else if r.verify {
+			if err := r.verifyInclusion(ctx, cp, i, data); err != nil {
+				r.errchan <- fmt.Errorf("leaf %d failed inclusion verification: %v", i, err)
+			}
 		}
 		r.leafchan <- Leaf{
 			Index: uint64(i),
@@ -96,42 +142,197 @@ func (r *LeafReader) Run(ctx context.Context) {
 	}
 }
 
+// runParallel reads leaves in batches, fetching the leaf bundles needed by each
+// batch concurrently, and passing the leaves to the consumer in the order in
+// which they were read. Each batch covers up to r.parallelism bundles.
+func (r *LeafReader) runParallel(ctx context.Context) {
+	bundleSize := uint64(r.bundleSize)
+	for {
+		cp := r.tracker.LatestConsistent
+		size := cp.Size
+		// Read leaf indices, one per throttle token, until the batch ends on
+		// a bundle boundary having reached the parallelism limit.
+		var indices, bundles []uint64
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.throttle:
+			}
+			i := r.next(size)
+			if i >= size {
+				break
+			}
+			indices = append(indices, i)
+			if bi := i / bundleSize; len(bundles) == 0 || bundles[len(bundles)-1] != bi {
+				bundles = append(bundles, bi)
+			}
+			if len(bundles) >= r.parallelism && ((i+1)%bundleSize == 0 || i+1 == size) {
+				break
+			}
+		}
+		if len(indices) == 0 {
+			continue
+		}
+
+		// The results act as a reorder buffer, so leaves are passed on in order
+		// however the concurrent fetches complete.
+		type result struct {
+			leaves [][]byte
+			err    error
+		}
+		results := make(map[uint64]result, len(bundles))
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, bi := range bundles {
+			wg.Add(1)
+			go func(bi uint64) {
+				defer wg.Done()
+				leaves, err := r.fetchBundle(ctx, bi, size)
+				mu.Lock()
+				defer mu.Unlock()
+				results[bi] = result{leaves: leaves, err: err}
+			}(bi)
+		}
+		wg.Wait()
+
+		for _, i := range indices {
+			bi := i / bundleSize
+			res := results[bi]
+			var data []byte
+			err := res.err
+			if err == nil {
+				data, err = leafBundleCache{start: bi * bundleSize, leaves: res.leaves}.get(i)
+			}
+			readOpsCounter.Inc()
+			if err != nil {
+				r.errchan <- fmt.Errorf("failed to get leaf %d: %v", i, err)
+			} else if r.verify {
+				if err := r.verifyInclusion(ctx, cp, i, data); err != nil {
+					r.errchan <- fmt.Errorf("leaf %d failed inclusion verification: %v", i, err)
+				}
+			}
+			r.leafchan <- Leaf{
+				Index: i,
+				Data:  data,
+			}
+		}
+	}
+}
+
 // getLeaf fetches the raw contents committed to at a given leaf index.
 func (r *LeafReader) getLeaf(ctx context.Context, i uint64, logSize uint64) ([]byte, error) {
 	if i >= logSize {
 		return nil, fmt.Errorf("requested leaf %d >= log size %d", i, logSize)
 	}
-	if cached, _ := r.c.get(i); cached != nil {
-		klog.V(2).Infof("Using cached result for index %d", i)
-		return cached, nil
+	if r.shared == nil {
+		if cached, _ := r.c.get(i); cached != nil {
+			klog.V(2).Infof("Using cached result for index %d", i)
+			return cached, nil
+		}
 	}
 	bi := i / uint64(r.bundleSize)
+	bs, err := r.fetchBundle(ctx, bi, logSize)
+	if err != nil {
+		return nil, fmt.Errorf("leaf index %d: %w", i, err)
+	}
+	c := leafBundleCache{
+		start:  bi * uint64(r.bundleSize),
+		leaves: bs,
+	}
+	if r.shared == nil {
+		r.c = c
+	}
+	return c.get(i)
+}
+
+// fetchBundle returns the raw, base64 encoded, entries of leaf bundle bi in a
+// tree of size logSize, using the shared bundle cache if there is one.
+// It is safe to call concurrently.
+func (r *LeafReader) fetchBundle(ctx context.Context, bi, logSize uint64) ([][]byte, error) {
+	bundleSize := uint64(r.bundleSize)
 	br := uint64(0)
 	// Check for partial leaf bundle
-	if bi == logSize/uint64(r.bundleSize) {
-		br = logSize % uint64(r.bundleSize)
+	if bi == logSize/bundleSize {
+		br = logSize % bundleSize
 	}
-	p := filepath.Join(layout.SeqPath("", bi))
-	if br > 0 {
-		p += fmt.Sprintf(".%d", br)
+	p := layout.BundlePath(bi*bundleSize, bundleSize, logSize)
+	if r.shared != nil {
+		if bs, ok := r.shared.get(p); ok {
+			klog.V(2).Infof("Using shared cached result for bundle %d", bi)
+			return bs, nil
+		}
 	}
+	start := time.Now()
 	bRaw, err := r.f(ctx, p)
+	d := time.Since(start)
+	r.latency.Observe(d)
+	readLatencyHistogram.Observe(d.Seconds())
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("leaf index %d not found: %w", i, err)
+			return nil, fmt.Errorf("leaf bundle %d not found: %w", bi, err)
 		}
-		return nil, fmt.Errorf("failed to fetch leaf index %d: %w", i, err)
+		return nil, fmt.Errorf("failed to fetch leaf bundle %d: %w", bi, err)
 	}
 	bs := bytes.Split(bRaw, []byte("\n"))
 	if l := len(bs); uint64(l) <= br {
 		return nil, fmt.Errorf("huh, short leaf bundle with %d entries, want %d", l, br)
 	}
-	r.c = leafBundleCache{
-		start:  bi * uint64(r.bundleSize),
-		leaves: bs,
+	if uint64(r.bundleSize) == r.tileWidth {
+		if err := r.verifyBundle(ctx, bi, br, bs, logSize); err != nil {
+			return nil, fmt.Errorf("leaf bundle %d failed verification: %w", bi, err)
+		}
+	}
+	if r.shared != nil {
+		r.shared.add(p, bs)
 	}
+	return bs, nil
+}
 
-	return r.c.get(i)
+// verifyBundle checks the leaves in bundle bi against the level-0 tile which
+// covers them. This is only possible when leaf bundles are the same width as tiles.
+func (r *LeafReader) verifyBundle(ctx context.Context, bi, br uint64, bs [][]byte, logSize uint64) error {
+	n := br
+	if n == 0 {
+		n = uint64(r.bundleSize)
+	}
+	if uint64(len(bs)) < n {
+		return fmt.Errorf("short leaf bundle with %d entries, want %d", len(bs), n)
+	}
+	leaves := make([][]byte, 0, n)
+	for i, l := range bs[:n] {
+		leaf, err := base64.StdEncoding.DecodeString(string(l))
+		if err != nil {
+			return fmt.Errorf("failed to decode entry %d: %v", i, err)
+		}
+		leaves = append(leaves, leaf)
+	}
+	tRaw, err := r.f(ctx, filepath.Join(layout.TilePath("", 0, bi, layout.PartialTileSizeForWidth(0, bi, logSize, r.tileWidth))))
+	if err != nil {
+		return fmt.Errorf("failed to fetch tile: %w", err)
+	}
+	var tile api.Tile
+	if err := tile.UnmarshalText(tRaw); err != nil {
+		return fmt.Errorf("failed to parse tile: %w", err)
+	}
+	return client.VerifyBundle(leaves, &tile, r.tracker.Hasher)
+}
+
+// verifyInclusion checks that data is the leaf at index i in the tree committed
+// to by cp, using an inclusion proof built from the log's tiles.
+func (r *LeafReader) verifyInclusion(ctx context.Context, cp log.Checkpoint, i uint64, data []byte) error {
+	if r.pb == nil || r.pbCP.Size != cp.Size || !bytes.Equal(r.pbCP.Hash, cp.Hash) {
+		pb, err := client.NewProofBuilder(ctx, cp, r.tracker.Hasher.HashChildren, r.f, client.WithTileWidth(r.tileWidth))
+		if err != nil {
+			return fmt.Errorf("failed to create proof builder for size %d: %v", cp.Size, err)
+		}
+		r.pb, r.pbCP = pb, cp
+	}
+	p, err := r.pb.InclusionProof(ctx, i)
+	if err != nil {
+		return fmt.Errorf("failed to build inclusion proof in tree of size %d: %v", cp.Size, err)
+	}
+	return proof.VerifyInclusion(r.tracker.Hasher, i, cp.Size, r.tracker.Hasher.HashLeaf(data), p, cp.Hash)
 }
 
 // Kills this leaf reader at the next opportune moment.
@@ -142,9 +343,9 @@ func (r *LeafReader) Kill() {
 	}
 }
 
-// leafBundleCache stores the results of the last fetched tile. This allows
-// readers that read contiguous blocks of leaves to act more like real
-// clients and fetch a tile of 256 leaves once, instead of 256 times.
+// leafBundleCache stores the results of the last fetched leaf bundle. This
+// allows readers that read contiguous blocks of leaves to act more like real
+// clients and fetch a bundle of leaves once, instead of once per leaf.
 type leafBundleCache struct {
 	start  uint64
 	leaves [][]byte
@@ -159,6 +360,54 @@ func (tc leafBundleCache) get(i uint64) ([]byte, error) {
 	return nil, errors.New("not found")
 }
 
+// NewSharedBundleCache creates a SharedBundleCache which holds up to size leaf bundles.
+func NewSharedBundleCache(size int) *SharedBundleCache {
+	bundles, err := lru.New[string, [][]byte](size)
+	if err != nil {
+		panic(err)
+	}
+	return &SharedBundleCache{bundles: bundles}
+}
+
+// SharedBundleCache is a concurrency-safe cache of leaf bundles which can be
+// shared between LeafReaders. This models a single client process with one
+// cache, rather than many independent clients.
+type SharedBundleCache struct {
+	bundles *lru.Cache[string, [][]byte]
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+}
+
+// get returns the cached bundle stored at path p, if present.
+func (c *SharedBundleCache) get(p string) ([][]byte, bool) {
+	bs, ok := c.bundles.Get(p)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return bs, ok
+}
+
+// add stores the bundle fetched from path p.
+func (c *SharedBundleCache) add(p string, bs [][]byte) {
+	c.bundles.Add(p, bs)
+}
+
+// Hits returns the number of lookups which were served from the cache.
+func (c *SharedBundleCache) Hits() uint64 {
+	return c.hits.Load()
+}
+
+// Misses returns the number of lookups which required the bundle to be fetched.
+func (c *SharedBundleCache) Misses() uint64 {
+	return c.misses.Load()
+}
+
+func (c *SharedBundleCache) String() string {
+	return fmt.Sprintf("Shared cache hits: %d, misses: %d", c.Hits(), c.Misses())
+}
+
 // RandomNextLeaf returns a function that fetches a random leaf available in the tree.
 func RandomNextLeaf() func(uint64) uint64 {
 	return func(size uint64) uint64 {
@@ -169,40 +418,99 @@ func RandomNextLeaf() func(uint64) uint64 {
 // MonotonicallyIncreasingNextLeaf returns a function that always wants the next available
 // leaf after the one it previously fetched. It starts at leaf 0.
 func MonotonicallyIncreasingNextLeaf() func(uint64) uint64 {
-	var i uint64
+	return MonotonicallyIncreasingNextLeafFrom(0, nil)
+}
+
+// MonotonicallyIncreasingNextLeafFrom is like MonotonicallyIncreasingNextLeaf, but starts
+// at leaf start. If progress is non-nil, it is raised to the index of each leaf wanted,
+// so that it records the furthest progress of all readers sharing it.
+func MonotonicallyIncreasingNextLeafFrom(start uint64, progress *atomic.Uint64) func(uint64) uint64 {
+	i := start
 	return func(size uint64) uint64 {
 		if i < size {
 			r := i
 			i++
+			if progress != nil {
+				for p := progress.Load(); r > p && !progress.CompareAndSwap(p, r); p = progress.Load() {
+				}
+			}
 			return r
 		}
 		return size
 	}
 }
 
+// NewLeafDedupe creates a LeafDedupe which remembers up to size recently
+// submitted leaves.
+func NewLeafDedupe(size int) *LeafDedupe {
+	seen, err := lru.New[[32]byte, bool](size)
+	if err != nil {
+		panic(err)
+	}
+	return &LeafDedupe{seen: seen}
+}
+
+// LeafDedupe is a client-side cache of the hashes of recently submitted leaves.
+// It can be shared between LogWriters to avoid resubmitting leaves which have
+// already been written, so that the true rate of new writes can be measured.
+type LeafDedupe struct {
+	seen    *lru.Cache[[32]byte, bool]
+	skipped atomic.Uint64
+}
+
+// Seen records the leaf as having been submitted, and returns true if it had
+// already been recorded.
+func (d *LeafDedupe) Seen(leaf []byte) bool {
+	found, _ := d.seen.ContainsOrAdd(sha256.Sum256(leaf), true)
+	if found {
+		d.skipped.Add(1)
+	}
+	return found
+}
+
+// Skipped returns the number of submissions which have been skipped because
+// the leaf had already been submitted.
+func (d *LeafDedupe) Skipped() uint64 {
+	return d.skipped.Load()
+}
+
+func (d *LeafDedupe) String() string {
+	return fmt.Sprintf("Dupe submissions skipped: %d", d.Skipped())
+}
+
 // NewLogWriter creates a LogWriter.
 // u is the URL of the write endpoint for the log.
+// batchSize is the number of leaves submitted in each request. If it's > 1, u must
+// be an endpoint which accepts batches of leaves, see writeBatch for the format.
 // gen is a function that generates new leaves to add.
-func NewLogWriter(hc *http.Client, u *url.URL, gen func() []byte, throttle <-chan bool, errchan chan<- error, leafchan chan<- Leaf) *LogWriter {
+// dedupe, if non-nil, is used to skip submitting leaves which have recently been submitted.
+// latency, if non-nil, records how long each write request takes.
+func NewLogWriter(hc *http.Client, u *url.URL, batchSize int, gen func() []byte, dedupe *LeafDedupe, throttle <-chan bool, latency *LatencyTracker, errchan chan<- error, leafchan chan<- Leaf) *LogWriter {
 	return &LogWriter{
-		hc:       hc,
-		u:        u,
-		gen:      gen,
-		throttle: throttle,
-		errchan:  errchan,
-		leafchan: leafchan,
+		hc:        hc,
+		u:         u,
+		batchSize: batchSize,
+		gen:       gen,
+		dedupe:    dedupe,
+		throttle:  throttle,
+		latency:   latency,
+		errchan:   errchan,
+		leafchan:  leafchan,
 	}
 }
 
 // LogWriter writes new leaves to the log that are generated by `gen`.
 type LogWriter struct {
-	hc       *http.Client
-	u        *url.URL
-	gen      func() []byte
-	throttle <-chan bool
-	errchan  chan<- error
-	leafchan chan<- Leaf
-	cancel   func()
+	hc        *http.Client
+	u         *url.URL
+	batchSize int
+	gen       func() []byte
+	dedupe    *LeafDedupe
+	throttle  <-chan bool
+	latency   *LatencyTracker
+	errchan   chan<- error
+	leafchan  chan<- Leaf
+	cancel    func()
 }
 
 // Run runs the log writer. This should be called in a goroutine.
@@ -211,6 +519,7 @@ func (w *LogWriter) Run(ctx context.Context) {
 		panic("LogWriter was ran multiple times")
 	}
 	ctx, w.cancel = context.WithCancel(ctx)
+	var batch [][]byte
 	for {
 		select {
 		case <-ctx.Done():
@@ -218,47 +527,114 @@ func (w *LogWriter) Run(ctx context.Context) {
 		case <-w.throttle:
 		}
 		newLeaf := w.gen()
-
-		req, err := http.NewRequest(http.MethodPost, w.u.String(), bytes.NewReader(newLeaf))
-		if err != nil {
-			w.errchan <- fmt.Errorf("failed to create request: %v", err)
+		if w.dedupe != nil && w.dedupe.Seen(newLeaf) {
+			klog.V(2).Infof("Skipping submission of recently submitted leaf %q", newLeaf)
 			continue
 		}
-		if len(*bearerToken) > 0 {
-			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", *bearerToken))
-		}
-		resp, err := hc.Do(req.WithContext(ctx))
-		if err != nil {
-			w.errchan <- fmt.Errorf("failed to write leaf: %v", err)
+		if w.batchSize <= 1 {
+			w.writeLeaf(ctx, newLeaf)
 			continue
 		}
-		body, err := io.ReadAll(resp.Body)
-		_ = resp.Body.Close()
-		if err != nil {
-			w.errchan <- fmt.Errorf("failed to read body: %v", err)
+		batch = append(batch, newLeaf)
+		if len(batch) < w.batchSize {
 			continue
 		}
-		if resp.StatusCode != http.StatusOK {
-			w.errchan <- fmt.Errorf("write leaf was not OK. Status code: %d. Body: %q", resp.StatusCode, body)
-			continue
-		}
-		if resp.Request.Method != http.MethodPost {
-			w.errchan <- fmt.Errorf("write leaf was redirected to %s", resp.Request.URL)
-			continue
-		}
-		parts := bytes.Split(body, []byte("\n"))
-		index, err := strconv.Atoi(string(parts[0]))
+		w.writeBatch(ctx, batch)
+		batch = nil
+	}
+}
+
+// writeLeaf submits a single leaf to the log.
+func (w *LogWriter) writeLeaf(ctx context.Context, leaf []byte) {
+	body, err := w.post(ctx, leaf)
+	if err != nil {
+		w.errchan <- fmt.Errorf("failed to write leaf: %v", err)
+		return
+	}
+	parts := bytes.Split(body, []byte("\n"))
+	index, err := strconv.Atoi(string(parts[0]))
+	if err != nil {
+		w.errchan <- fmt.Errorf("write leaf failed to parse response: %v", body)
+		return
+	}
+
+	w.leafchan <- Leaf{
+		Index: uint64(index),
+		Data:  leaf,
+	}
+	klog.V(2).Infof("Wrote leaf at index %d", index)
+}
+
+// writeBatch submits a batch of leaves to the log in a single request.
+//
+// The request body contains one base64-encoded leaf per line, the same framing
+// as is used for leaf bundles, and the response body is expected to contain the
+// decimal index assigned to each leaf, one per line, in the same order.
+func (w *LogWriter) writeBatch(ctx context.Context, leaves [][]byte) {
+	var req bytes.Buffer
+	for _, l := range leaves {
+		req.WriteString(base64.StdEncoding.EncodeToString(l))
+		req.WriteByte('\n')
+	}
+	body, err := w.post(ctx, req.Bytes())
+	if err != nil {
+		w.errchan <- fmt.Errorf("failed to write batch of %d leaves: %v", len(leaves), err)
+		return
+	}
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	if len(lines) != len(leaves) {
+		w.errchan <- fmt.Errorf("write batch of %d leaves got %d indices in response: %q", len(leaves), len(lines), body)
+		return
+	}
+	for i, l := range lines {
+		index, err := strconv.ParseUint(string(bytes.TrimSpace(l)), 10, 64)
 		if err != nil {
-			w.errchan <- fmt.Errorf("write leaf failed to parse response: %v", body)
-			continue
+			w.errchan <- fmt.Errorf("write batch failed to parse index %d of response: %q", i, l)
+			return
 		}
-
 		w.leafchan <- Leaf{
-			Index: uint64(index),
-			Data:  newLeaf,
+			Index: index,
+			Data:  leaves[i],
 		}
-		klog.V(2).Infof("Wrote leaf at index %d", index)
 	}
+	klog.V(2).Infof("Wrote batch of %d leaves", len(leaves))
+}
+
+// post sends data to the write endpoint, and returns the body of the response.
+func (w *LogWriter) post(ctx context.Context, data []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, w.u.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if len(*bearerToken) > 0 {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", *bearerToken))
+	}
+	writeOpsCounter.Inc()
+	start := time.Now()
+	resp, err := w.hc.Do(req.WithContext(ctx))
+	if err != nil {
+		w.observeLatency(time.Since(start))
+		return nil, err
+	}
+	body, err := client.ReadAllLimited(resp.Body, *maxResponseSize)
+	_ = resp.Body.Close()
+	w.observeLatency(time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("write was not OK. Status code: %d. Body: %q", resp.StatusCode, body)
+	}
+	if resp.Request.Method != http.MethodPost {
+		return nil, fmt.Errorf("write was redirected to %s", resp.Request.URL)
+	}
+	return body, nil
+}
+
+// observeLatency records the latency d of a write request.
+func (w *LogWriter) observeLatency(d time.Duration) {
+	w.latency.Observe(d)
+	writeLatencyHistogram.Observe(d.Seconds())
 }
 
 // Kills this writer at the next opportune moment.
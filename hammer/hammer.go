@@ -21,55 +21,102 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/rivo/tview"
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle"
 	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api/layout"
 	"github.com/transparency-dev/serverless-log/client"
 	"golang.org/x/mod/sumdb/note"
 	"k8s.io/klog/v2"
 )
 
 func init() {
-	flag.Var(&logURL, "log_url", "Log storage root URL (can be specified multiple times), e.g. https://log.server/and/path/")
+	flag.Var(&logURL, "log_url", "Log storage root URL which reads are made from (can be specified multiple times), e.g. https://log.server/and/path/")
+	flag.Var(&logURL, "read_log_url", "Alias for --log_url")
 }
 
 var (
 	logURL multiStringFlag
 
+	writeURL         = flag.String("write_url", "", "Root URL of the log's origin, which /add requests are sent to. If unset, the last --log_url is used. This allows reads to go via a CDN while writes go directly to the origin")
+	checkpointSource = flag.String("checkpoint_source", "read", "Where the log state tracker fetches checkpoints from: read (via --log_url) or write (via --write_url)")
+
 	bearerToken   = flag.String("bearer_token", "", "The bearer token for auth. For GCP this is the result of `gcloud auth print-identity-token`")
 	logPubKeyFile = flag.String("log_public_key", "", "Location of log public key file. If unset, uses the contents of the SERVERLESS_LOG_PUBLIC_KEY environment variable")
 	origin        = flag.String("origin", "", "Expected first line of checkpoints from log")
-
-	maxReadOpsPerSecond = flag.Int("max_read_ops", 20, "The maximum number of read operations per second")
-	numReadersRandom    = flag.Int("num_readers_random", 4, "The number of readers looking for random leaves")
-	numReadersFull      = flag.Int("num_readers_full", 4, "The number of readers downloading the whole log")
-
+	cacheDir      = flag.String("cache_dir", "", "If set, objects fetched from the log will be cached in this directory, and reused across runs")
+	hasherName    = flag.String("hasher", "rfc6962", "The name of the Merkle tree hasher used by the log, this must match the log's configuration")
+	minTreeSize   = flag.Uint64("min_tree_size", 0, "If set, the hammer will exit at startup if the log is smaller than this size")
+	maxCPAge      = flag.Duration("max_checkpoint_age", 0, "If set, and the log publishes checkpoint timestamps, warn when the latest checkpoint is older than this")
+
+	maxReadOpsPerSecond  = flag.Int("max_read_ops", 20, "The maximum number of read operations per second")
+	numReadersRandom     = flag.Int("num_readers_random", 4, "The number of readers looking for random leaves")
+	numReadersFull       = flag.Int("num_readers_full", 4, "The number of readers downloading the whole log")
+	fullReaderParallel   = flag.Int("full_reader_parallelism", 1, "The number of leaf bundles each full reader fetches concurrently. Leaves are still checked in order")
+	verifyInclusion      = flag.Bool("verify_inclusion", false, "If set, readers verify an inclusion proof for each leaf they read against the latest consistent checkpoint, reporting failures as errors")
+	readLatencySLO       = flag.Duration("read_latency_slo", 0, "If set, the read throttle adapts to find the highest rate at which the p95 latency of leaf bundle fetches stays under this duration, starting from --max_read_ops")
 	maxWriteOpsPerSecond = flag.Int("max_write_ops", 0, "The maximum number of write operations per second")
+	writeLatencySLO      = flag.Duration("write_latency_slo", 0, "If set, the write throttle adapts to find the highest rate at which the p95 latency of writes stays under this duration, starting from --max_write_ops")
 	numWriters           = flag.Int("num_writers", 0, "The number of independent write tasks to run")
+	writeBatchSize       = flag.Int("write_batch_size", 1, "If > 1, each writer accumulates this many leaves and submits them in a single request to the add-batch endpoint rather than to add")
+
+	leafBundleSize  = flag.Int("leaf_bundle_size", 1, "The log-configured number of leaves in each leaf bundle")
+	tileWidth       = flag.Int("tile_width", layout.TileWidth, "The log-configured width of its tiles, i.e. the number of leaves covered by each level-0 tile")
+	sharedCacheSize = flag.Int("shared_reader_cache_size", 0, "If > 0, all readers share a single cache holding this many leaf bundles, otherwise each reader caches only its last fetched bundle")
+	leafMinSize     = flag.Int("leaf_min_size", 0, "Minimum size in bytes of individual leaves")
+	dupChance       = flag.Float64("dup_chance", 0.1, "The probability that a generated leaf will be a duplicate of a previously generated leaf")
+	dupMaxAge       = flag.Int("dup_max_age", 1, "Duplicate leaves are chosen from among this many most recently generated leaves. The default of 1 only duplicates the previous leaf; larger values test longer-term deduplication in the log")
+	dupDist         = flag.String("dup_distribution", "uniform", "How duplicate leaves are chosen when --dup_max_age > 1: uniform, or recent to favour more recently generated leaves")
+	dedupeSize      = flag.Int("writer_dedupe_size", 0, "If > 0, writers will skip submitting any leaf which is among this many recently submitted leaves")
 
-	leafBundleSize = flag.Int("leaf_bundle_size", 1, "The log-configured number of leaves in each leaf bundle")
-	leafMinSize    = flag.Int("leaf_min_size", 0, "Minimum size in bytes of individual leaves")
+	maxErrorRate = flag.Float64("max_error_rate", 0, "If > 0, the hammer stops and exits with a non-zero status once more than this many errors per second, averaged over --error_window, have been reported. This allows the hammer to be used as a pass/fail load test")
+	errorWindow  = flag.Duration("error_window", time.Minute, "The window over which the error rate is measured for --max_error_rate")
+
+	maxResponseSize = flag.Int64("max_response_size", client.DefaultMaxResponseSize, "The maximum size in bytes of any object read from the log over HTTP. Larger responses are rejected, rather than being read into memory")
+
+	forceHTTP1 = flag.Bool("force_http1", false, "If set, only HTTP/1.1 is used, otherwise HTTP/2 is negotiated with servers which support it. This allows comparing how a log or CDN performs with each protocol")
 
 	showUI = flag.Bool("show_ui", true, "Set to false to disable the text-based UI")
 
+	statusFormat  = flag.String("status_format", "", "If set to json, periodically emit a status line in this format to stdout. This is independent of --show_ui, and is intended for use with --show_ui=false")
+	stateFile     = flag.String("state_file", "", "If set, aggregate counters and worker progress are periodically saved to this JSON file, and restored from it at startup, so that restarted runs carry on from where they left off")
+	stateInterval = flag.Duration("state_interval", time.Minute, "Interval at which to save state when --state_file is set")
+
+	statusInterval = flag.Duration("status_interval", time.Second, "Interval at which to emit status lines when --status_format is set")
+
+	timeseriesFile     = flag.String("timeseries_file", "", "If set, a row recording the reads, writes, errors and bytes transferred in each --timeseries_interval, along with the tree size and checkpoint age, is appended to this file. This is independent of --show_ui, and is intended for post-run analysis")
+	timeseriesFormat   = flag.String("timeseries_format", "csv", "Format of rows appended to --timeseries_file: csv or json")
+	timeseriesInterval = flag.Duration("timeseries_interval", time.Second, "Interval covered by each row appended to --timeseries_file")
+
+	metricsAddr = flag.String("metrics_addr", "", "If set, Prometheus metrics are served at /metrics on this address, e.g. :8080")
+
+	// hashers maps the supported values of --hasher to their implementations.
+	hashers = map[string]merkle.LogHasher{
+		"rfc6962": rfc6962.DefaultHasher,
+	}
+
+	// hc is the client used for all HTTP requests. Its transport is set up in
+	// main, once flags have been parsed.
 	hc = &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:        256,
-			MaxIdleConnsPerHost: 256,
-			DisableKeepAlives:   false,
-		},
 		Timeout: 5 * time.Second,
 	}
+	// protocols counts the connections made by hc, by protocol.
+	protocols *ProtocolCounter
+	// traffic counts the requests made by hc, and the bytes they transfer.
+	traffic *TrafficCounter
 )
 
 type roundRobinFetcher struct {
@@ -97,7 +144,8 @@ func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
 
 	logSigV, _, err := logSigVerifier(*logPubKeyFile)
 	if err != nil {
@@ -107,6 +155,51 @@ func main() {
 	if len(logURL) == 0 {
 		klog.Exitf("--log_url must be provided")
 	}
+	hasher, ok := hashers[*hasherName]
+	if !ok {
+		klog.Exitf("Unsupported --hasher %q", *hasherName)
+	}
+	switch *statusFormat {
+	case "", "json":
+	default:
+		klog.Exitf("Unsupported --status_format %q", *statusFormat)
+	}
+	switch *timeseriesFormat {
+	case "csv", "json":
+	default:
+		klog.Exitf("Unsupported --timeseries_format %q", *timeseriesFormat)
+	}
+	switch *checkpointSource {
+	case "read", "write":
+	default:
+		klog.Exitf("Unsupported --checkpoint_source %q", *checkpointSource)
+	}
+	if *maxErrorRate > 0 && *errorWindow <= 0 {
+		klog.Exitf("--error_window must be positive when --max_error_rate is set")
+	}
+	if *fullReaderParallel <= 0 {
+		klog.Exitf("--full_reader_parallelism must be > 0")
+	}
+	if *leafBundleSize <= 0 {
+		klog.Exitf("--leaf_bundle_size must be > 0")
+	}
+	if *tileWidth <= 0 {
+		klog.Exitf("--tile_width must be > 0")
+	}
+	if err := layout.ValidateTileWidth(uint64(*tileWidth)); err != nil {
+		klog.Exitf("Invalid --tile_width: %v", err)
+	}
+	if err := layout.ValidateLeafBundleSizeForWidth(uint64(*leafBundleSize), uint64(*tileWidth)); err != nil {
+		klog.Exitf("Invalid --leaf_bundle_size: %v", err)
+	}
+	protocols = NewProtocolCounter(newTransport(*forceHTTP1))
+	traffic = NewTrafficCounter(protocols)
+	hc.Transport = traffic
+	switch *dupDist {
+	case "uniform", "recent":
+	default:
+		klog.Exitf("Unsupported --dup_distribution %q", *dupDist)
+	}
 
 	var rootURL *url.URL
 	fetchers := []client.Fetcher{}
@@ -120,15 +213,45 @@ func main() {
 		if err != nil {
 			klog.Exitf("Invalid log URL: %v", err)
 		}
-		fetchers = append(fetchers, newFetcher(rootURL))
+		fetcher := newFetcher(rootURL)
+		if len(*cacheDir) > 0 {
+			// Each log URL gets its own cache, since their content may (briefly) differ.
+			fetcher = client.NewCachingFetcher(fetcher, filepath.Join(*cacheDir, url.PathEscape(rootURL.String())), 0)
+		}
+		fetchers = append(fetchers, fetcher)
 
 	}
 	f := roundRobinFetcher{f: fetchers}
 
+	if len(*writeURL) > 0 {
+		s := *writeURL
+		// url must reference a directory, by definition
+		if !strings.HasSuffix(s, "/") {
+			s += "/"
+		}
+		rootURL, err = url.Parse(s)
+		if err != nil {
+			klog.Exitf("Invalid write URL: %v", err)
+		}
+	}
+	cpFetcher := f.Fetch
+	if *checkpointSource == "write" {
+		cpFetcher = newFetcher(rootURL)
+	}
+
+	if *minTreeSize > 0 {
+		size, err := client.Size(ctx, cpFetcher, logSigV, *origin)
+		if err != nil {
+			klog.Exitf("Failed to get size of the log: %v", err)
+		}
+		if size < *minTreeSize {
+			klog.Exitf("Log size %d is smaller than --min_tree_size %d", size, *minTreeSize)
+		}
+	}
+
 	var cpRaw []byte
-	cons := client.UnilateralConsensus(f.Fetch)
-	hasher := rfc6962.DefaultHasher
-	tracker, err := client.NewLogStateTracker(ctx, f.Fetch, hasher, cpRaw, logSigV, *origin, cons)
+	cons := client.UnilateralConsensus(cpFetcher)
+	tracker, err := client.NewLogStateTracker(ctx, f.Fetch, hasher, cpRaw, logSigV, *origin, cons, client.WithTileWidth(uint64(*tileWidth)))
 	if err != nil {
 		klog.Exitf("Failed to create LogStateTracker: %v", err)
 	}
@@ -138,18 +261,50 @@ func main() {
 		klog.Exitf("Failed to get initial state of the log: %v", err)
 	}
 
-	addURL, err := rootURL.Parse("add")
+	addPath := "add"
+	if *writeBatchSize > 1 {
+		addPath = "add-batch"
+	}
+	addURL, err := rootURL.Parse(addPath)
 	if err != nil {
 		klog.Exitf("Failed to create add URL: %v", err)
 	}
-	hammer := NewHammer(&tracker, f.Fetch, addURL)
-	hammer.Run(ctx)
+	state := runState{Started: time.Now()}
+	if *stateFile != "" {
+		if state, err = loadState(*stateFile); err != nil {
+			klog.Exitf("Failed to load state: %v", err)
+		}
+	}
+	hammer := NewHammer(&tracker, f.Fetch, addURL, state)
+	hammer.Run(ctx, cancel)
+
+	if *stateFile != "" {
+		go persistState(ctx, hammer, *stateFile, *stateInterval)
+	}
+
+	if *statusFormat != "" {
+		go emitStatus(ctx, hammer, *statusFormat, *statusInterval, os.Stdout)
+	}
+
+	if *timeseriesFile != "" {
+		go writeTimeseries(ctx, hammer, traffic, *timeseriesFile, *timeseriesFormat, *timeseriesInterval)
+	}
+
+	if *metricsAddr != "" {
+		go serveMetrics(ctx, hammer, *metricsAddr)
+	}
 
 	if *showUI {
 		hostUI(ctx, hammer)
 	} else {
 		<-ctx.Done()
 	}
+
+	if err := context.Cause(ctx); errors.Is(err, errErrorRateExceeded) {
+		// Log output may have been redirected to the UI, so report directly.
+		fmt.Fprintf(os.Stderr, "Hammer failed: %v\n", err)
+		os.Exit(1)
+	}
 }
 
 func NewLeafConsumer() *LeafConsumer {
@@ -170,7 +325,7 @@ func NewLeafConsumer() *LeafConsumer {
 type LeafConsumer struct {
 	leafchan       chan Leaf
 	lookup         *lru.Cache[string, uint64]
-	duplicateCount uint64
+	duplicateCount atomic.Uint64
 }
 
 func (c *LeafConsumer) Run(ctx context.Context) {
@@ -183,7 +338,7 @@ func (c *LeafConsumer) Run(ctx context.Context) {
 			strData := string(l.Data)
 			if oIdx, found := c.lookup.Get(strData); found {
 				if oIdx != l.Index {
-					c.duplicateCount++
+					c.duplicateCount.Add(1)
 					klog.V(2).Infof("Found two indices for data %q: (%d, %d)", strData, oIdx, l.Index)
 				}
 			} else {
@@ -193,37 +348,74 @@ func (c *LeafConsumer) Run(ctx context.Context) {
 	}
 }
 
+// Duplicates returns the number of duplicate leaves seen so far.
+func (c *LeafConsumer) Duplicates() uint64 {
+	return c.duplicateCount.Load()
+}
+
 func (c *LeafConsumer) String() string {
-	return fmt.Sprintf("Duplicates: %d", c.duplicateCount)
+	return fmt.Sprintf("Duplicates: %d", c.Duplicates())
 }
 
-func NewHammer(tracker *client.LogStateTracker, f client.Fetcher, addURL *url.URL) *Hammer {
+// NewHammer creates a Hammer. Its counters and worker progress are initialised
+// from state, which should be the zero value for a fresh run.
+func NewHammer(tracker *client.LogStateTracker, f client.Fetcher, addURL *url.URL, state runState) *Hammer {
 	readThrottle := NewThrottle(*maxReadOpsPerSecond)
 	writeThrottle := NewThrottle(*maxWriteOpsPerSecond)
+	var readLatency, writeLatency *LatencyTracker
+	if *readLatencySLO > 0 {
+		readLatency = NewLatencyTracker()
+	}
+	if *writeLatencySLO > 0 {
+		writeLatency = NewLatencyTracker()
+	}
 	errChan := make(chan error, 20)
 	leafConsumer := NewLeafConsumer()
+	leafConsumer.duplicateCount.Store(state.Duplicates)
 	go leafConsumer.Run(context.Background())
 
-	gen := newLeafGenerator(tracker.LatestConsistent.Size, *leafMinSize)
+	gen := newLeafGenerator(tracker.LatestConsistent.Size, *leafMinSize, *dupChance, *dupMaxAge, *dupDist)
+	var dedupe *LeafDedupe
+	if *dedupeSize > 0 {
+		dedupe = NewLeafDedupe(*dedupeSize)
+		dedupe.skipped.Store(state.DedupeSkipped)
+	}
+	var sharedCache *SharedBundleCache
+	if *sharedCacheSize > 0 {
+		sharedCache = NewSharedBundleCache(*sharedCacheSize)
+		sharedCache.hits.Store(state.SharedCacheHits)
+		sharedCache.misses.Store(state.SharedCacheMisses)
+	}
+	fullReadProgress := &atomic.Uint64{}
+	fullReadProgress.Store(state.FullReaderProgress)
 	randomReaders := newWorkerPool(func() worker {
-		return NewLeafReader(tracker, f, RandomNextLeaf(), *leafBundleSize, readThrottle.tokenChan, errChan, leafConsumer.leafchan)
+		return NewLeafReader(tracker, f, RandomNextLeaf(), *leafBundleSize, *tileWidth, 1, sharedCache, *verifyInclusion, readThrottle.tokenChan, readLatency, errChan, leafConsumer.leafchan)
 	})
 	fullReaders := newWorkerPool(func() worker {
-		return NewLeafReader(tracker, f, MonotonicallyIncreasingNextLeaf(), *leafBundleSize, readThrottle.tokenChan, errChan, leafConsumer.leafchan)
+		return NewLeafReader(tracker, f, MonotonicallyIncreasingNextLeafFrom(state.FullReaderProgress, fullReadProgress), *leafBundleSize, *tileWidth, *fullReaderParallel, sharedCache, *verifyInclusion, readThrottle.tokenChan, readLatency, errChan, leafConsumer.leafchan)
 	})
 	writers := newWorkerPool(func() worker {
-		return NewLogWriter(hc, addURL, gen, writeThrottle.tokenChan, errChan, leafConsumer.leafchan)
+		return NewLogWriter(hc, addURL, *writeBatchSize, gen, dedupe, writeThrottle.tokenChan, writeLatency, errChan, leafConsumer.leafchan)
 	})
-	return &Hammer{
+	h := &Hammer{
 		randomReaders: randomReaders,
 		fullReaders:   fullReaders,
 		writers:       writers,
 		readThrottle:  readThrottle,
 		writeThrottle: writeThrottle,
+		readLatency:   readLatency,
+		writeLatency:  writeLatency,
 		tracker:       tracker,
 		leafConsumer:  leafConsumer,
+		dedupe:        dedupe,
+		sharedCache:   sharedCache,
 		errChan:       errChan,
+		started:       state.Started,
+
+		fullReadProgress: fullReadProgress,
 	}
+	h.errCount.Store(state.Errors)
+	return h
 }
 
 type Hammer struct {
@@ -232,12 +424,34 @@ type Hammer struct {
 	writers       workerPool
 	readThrottle  *Throttle
 	writeThrottle *Throttle
+	readLatency   *LatencyTracker
+	writeLatency  *LatencyTracker
 	tracker       *client.LogStateTracker
 	leafConsumer  *LeafConsumer
+	dedupe        *LeafDedupe
+	sharedCache   *SharedBundleCache
 	errChan       chan error
+	errCount      atomic.Uint64
+	// started is the time at which the first run began, including any runs
+	// restored from a state file.
+	started time.Time
+	// fullReadProgress is the furthest leaf index wanted by any full reader.
+	fullReadProgress *atomic.Uint64
+	// cpTime is the timestamp of the latest consistent checkpoint in seconds
+	// since the Unix epoch, or 0 if the log doesn't publish timestamps.
+	cpTime atomic.Int64
+	// cpStale is set while the latest checkpoint is older than --max_checkpoint_age.
+	cpStale atomic.Bool
 }
 
-func (h *Hammer) Run(ctx context.Context) {
+// errErrorRateExceeded is the cause with which Run cancels the hammer's context
+// when --max_error_rate is exceeded.
+var errErrorRateExceeded = errors.New("error rate exceeded --max_error_rate")
+
+// Run starts the hammer's workers, which run until ctx is done.
+// If --max_error_rate is set and exceeded, cancel is called with a cause
+// wrapping errErrorRateExceeded.
+func (h *Hammer) Run(ctx context.Context, cancel context.CancelCauseFunc) {
 	// Kick off readers & writers
 	for i := 0; i < *numReadersRandom; i++ {
 		h.randomReaders.Grow(ctx)
@@ -251,12 +465,22 @@ func (h *Hammer) Run(ctx context.Context) {
 
 	// Set up logging for any errors
 	go func() {
+		rate := errorRate{window: *errorWindow}
 		for {
 			select {
 			case <-ctx.Done(): //context cancelled
 				return
 			case err := <-h.errChan:
+				h.errCount.Add(1)
+				errorCounter.Inc()
 				klog.Warning(err)
+				if *maxErrorRate <= 0 {
+					continue
+				}
+				if r := rate.add(time.Now()); r > *maxErrorRate {
+					cancel(fmt.Errorf("%w: %.2f errors/s over the last %v, most recently: %v", errErrorRateExceeded, r, *errorWindow, err))
+					return
+				}
 			}
 		}
 	}()
@@ -264,6 +488,12 @@ func (h *Hammer) Run(ctx context.Context) {
 	// Start the throttles
 	go h.readThrottle.Run(ctx)
 	go h.writeThrottle.Run(ctx)
+	if h.readLatency != nil {
+		go h.readThrottle.RunAdaptive(ctx, h.readLatency, *readLatencySLO)
+	}
+	if h.writeLatency != nil {
+		go h.writeThrottle.RunAdaptive(ctx, h.writeLatency, *writeLatencySLO)
+	}
 
 	go func() {
 		tick := time.NewTicker(1 * time.Second)
@@ -285,11 +515,80 @@ func (h *Hammer) Run(ctx context.Context) {
 				if newSize > size {
 					klog.V(1).Infof("Updated checkpoint from %d to %d", size, newSize)
 				}
+				if err := h.updateCheckpointTime(); err != nil {
+					h.errChan <- err
+				}
 			}
 		}
 	}()
 }
 
+// errorRate tracks the rate at which errors occur over a sliding window.
+type errorRate struct {
+	window time.Duration
+	// times holds the times of the errors seen within the window, oldest first.
+	times []time.Time
+}
+
+// add records an error which occurred at t, and returns the rate of errors per
+// second over the window ending at t.
+func (e *errorRate) add(t time.Time) float64 {
+	e.times = append(e.times, t)
+	cutoff := t.Add(-e.window)
+	i := 0
+	for i < len(e.times) && !e.times[i].After(cutoff) {
+		i++
+	}
+	e.times = e.times[i:]
+	return float64(len(e.times)) / e.window.Seconds()
+}
+
+// updateCheckpointTime records the timestamp of the latest consistent checkpoint,
+// if it has one, and checks that checkpoint timestamps are plausible.
+func (h *Hammer) updateCheckpointTime() error {
+	if h.tracker.CheckpointNote == nil {
+		return nil
+	}
+	ext, err := (&log.Checkpoint{}).Unmarshal([]byte(h.tracker.CheckpointNote.Text))
+	if err != nil {
+		return fmt.Errorf("failed to parse checkpoint: %v", err)
+	}
+	ts, ok, err := client.CheckpointTimestamp(ext)
+	if err != nil || !ok {
+		return err
+	}
+	if prev := h.cpTime.Load(); ts.Unix() < prev {
+		return fmt.Errorf("checkpoint timestamp went backwards from %v to %v", time.Unix(prev, 0), ts)
+	}
+	if ts.After(time.Now().Add(time.Minute)) {
+		return fmt.Errorf("checkpoint timestamp %v is in the future", ts)
+	}
+	h.cpTime.Store(ts.Unix())
+
+	if *maxCPAge > 0 {
+		age := time.Since(ts)
+		stale := age > *maxCPAge
+		if h.cpStale.Swap(stale) != stale {
+			if stale {
+				klog.Warningf("Latest checkpoint is %v old, exceeding --max_checkpoint_age %v", age.Round(time.Second), *maxCPAge)
+			} else {
+				klog.Info("Latest checkpoint is no longer stale")
+			}
+		}
+	}
+	return nil
+}
+
+// checkpointAge returns the age of the latest consistent checkpoint, and
+// false if the log doesn't publish checkpoint timestamps.
+func (h *Hammer) checkpointAge() (time.Duration, bool) {
+	t := h.cpTime.Load()
+	if t == 0 {
+		return 0, false
+	}
+	return time.Since(time.Unix(t, 0)), true
+}
+
 func genLeaf(n uint64, minLeafSize int) []byte {
 	// Make a slice with half the number of requested bytes since we'll
 	// hex-encode them below which gets us back up to the full amount.
@@ -298,28 +597,61 @@ func genLeaf(n uint64, minLeafSize int) []byte {
 	return []byte(fmt.Sprintf("%x %d", filler, n))
 }
 
-func newLeafGenerator(n uint64, minLeafSize int) func() []byte {
-	const dupChance = 0.1
+// newLeafGenerator returns a function which generates leaves starting at index n.
+//
+// With probability dupChance, a generated leaf will be a duplicate of one generated
+// previously. If dupMaxAge is <= 1, duplicates are only ever of the most recently
+// generated leaf. Otherwise, the duplicated leaf is chosen from among the dupMaxAge most
+// recently generated leaves according to dupDist, which is either "uniform" or "recent"
+// (biased towards more recent leaves).
+func newLeafGenerator(n uint64, minLeafSize int, dupChance float64, dupMaxAge int, dupDist string) func() []byte {
 	nextLeaf := genLeaf(n, minLeafSize)
+	// history is a ring buffer of the most recently generated unique leaves, it's only
+	// used when duplicates may be older than the previous leaf.
+	var history [][]byte
+	var historyNext int
 	var safe sync.Mutex
 	return func() []byte {
 		safe.Lock()
 		defer safe.Unlock()
 		if rand.Float64() <= dupChance {
-			// This one will actually be unique, but the next iteration will
-			// duplicate it. In future, this duplication could be randomly
-			// selected to include really old leaves too, to test long-term
-			// deduplication in the log (if it supports  that).
-			return nextLeaf
+			if len(history) == 0 {
+				// This one will actually be unique, but the next iteration will
+				// duplicate it.
+				return nextLeaf
+			}
+			return history[dupIndex(len(history), historyNext, dupDist)]
 		}
 
 		n++
 		r := nextLeaf
 		nextLeaf = genLeaf(n, minLeafSize)
+		if dupMaxAge > 1 {
+			if len(history) < dupMaxAge {
+				history = append(history, r)
+			} else {
+				history[historyNext] = r
+			}
+			historyNext = (historyNext + 1) % dupMaxAge
+		}
 		return r
 	}
 }
 
+// dupIndex selects the index of a leaf to duplicate from a ring buffer holding l leaves,
+// where next is the index which will be overwritten next (i.e. the oldest leaf once the
+// buffer is full).
+func dupIndex(l, next int, dist string) int {
+	f := rand.Float64()
+	if dist == "recent" {
+		// Squaring skews selection towards small ages.
+		f = f * f
+	}
+	age := int(f * float64(l))
+	// The most recently added leaf is at next-1, so count backwards from there.
+	return ((next-1-age)%l + l) % l
+}
+
 func NewThrottle(opsPerSecond int) *Throttle {
 	return &Throttle{
 		opsPerSecond: opsPerSecond,
@@ -328,13 +660,37 @@ func NewThrottle(opsPerSecond int) *Throttle {
 }
 
 type Throttle struct {
+	tokenChan chan bool
+
+	// mu guards the fields below, which are updated by Run and RunAdaptive
+	// while being read by the UI, metrics, and status endpoint.
+	mu           sync.Mutex
 	opsPerSecond int
-	tokenChan    chan bool
+	oversupply   int
+	// latencyTarget is the p95 latency targeted by RunAdaptive, or 0 if the
+	// throttle isn't adaptive.
+	latencyTarget time.Duration
+	// p95 is the p95 latency observed in the last adaptive interval.
+	p95 time.Duration
+}
 
-	oversupply int
+// OpsPerSecond returns the current rate of the throttle.
+func (t *Throttle) OpsPerSecond() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.opsPerSecond
+}
+
+// Oversupply returns the number of tokens which went unused in the last second.
+func (t *Throttle) Oversupply() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.oversupply
 }
 
 func (t *Throttle) Increase() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	tokenCount := t.opsPerSecond
 	delta := float64(tokenCount) * 0.1
 	if delta < 1 {
@@ -344,6 +700,8 @@ func (t *Throttle) Increase() {
 }
 
 func (t *Throttle) Decrease() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	tokenCount := t.opsPerSecond
 	if tokenCount <= 1 {
 		return
@@ -362,10 +720,11 @@ func (t *Throttle) Run(ctx context.Context) {
 		case <-ctx.Done(): //context cancelled
 			return
 		case <-ticker.C:
-			tokenCount := t.opsPerSecond
+			ops := t.OpsPerSecond()
+			tokenCount := ops
 			timeout := time.After(1 * time.Second)
 		Loop:
-			for i := 0; i < t.opsPerSecond; i++ {
+			for i := 0; i < ops; i++ {
 				select {
 				case t.tokenChan <- true:
 					tokenCount--
@@ -373,18 +732,26 @@ func (t *Throttle) Run(ctx context.Context) {
 					break Loop
 				}
 			}
+			t.mu.Lock()
 			t.oversupply = tokenCount
+			t.mu.Unlock()
 		}
 	}
 }
 
 func (t *Throttle) String() string {
-	return fmt.Sprintf("Current max: %d/s. Oversupply in last second: %d", t.opsPerSecond, t.oversupply)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := fmt.Sprintf("Current max: %d/s. Oversupply in last second: %d", t.opsPerSecond, t.oversupply)
+	if t.latencyTarget > 0 {
+		s += fmt.Sprintf(". Adaptive: p95 %v (target %v)", t.p95.Round(time.Millisecond), t.latencyTarget)
+	}
+	return s
 }
 
 func hostUI(ctx context.Context, hammer *Hammer) {
 	grid := tview.NewGrid()
-	grid.SetRows(3, 0, 10).SetColumns(0).SetBorders(true)
+	grid.SetRows(4, 0, 10).SetColumns(0).SetBorders(true)
 	// Status box
 	statusView := tview.NewTextView()
 	grid.AddItem(statusView, 0, 0, 1, 1, 0, 0, false)
@@ -411,9 +778,25 @@ func hostUI(ctx context.Context, hammer *Hammer) {
 		for {
 			select {
 			case <-ctx.Done():
+				app.Stop()
 				return
 			case <-ticker.C:
-				text := fmt.Sprintf("Read: %s\nWrite: %s\nAnalysis: %s", hammer.readThrottle.String(), hammer.writeThrottle.String(), hammer.leafConsumer.String())
+				analysis := hammer.leafConsumer.String()
+				if hammer.dedupe != nil {
+					analysis = fmt.Sprintf("%s, %s", analysis, hammer.dedupe.String())
+				}
+				if hammer.sharedCache != nil {
+					analysis = fmt.Sprintf("%s, %s", analysis, hammer.sharedCache.String())
+				}
+				analysis = fmt.Sprintf("%s, %s", analysis, protocols.String())
+				cp := fmt.Sprintf("size %d", hammer.tracker.LatestConsistent.Size)
+				if age, ok := hammer.checkpointAge(); ok {
+					cp = fmt.Sprintf("%s, age %v", cp, age.Round(time.Second))
+					if hammer.cpStale.Load() {
+						cp += " (STALE)"
+					}
+				}
+				text := fmt.Sprintf("Read: %s\nWrite: %s\nAnalysis: %s\nCheckpoint: %s", hammer.readThrottle.String(), hammer.writeThrottle.String(), analysis, cp)
 				statusView.SetText(text)
 				app.Draw()
 			}
@@ -521,9 +904,9 @@ func readHTTP(ctx context.Context, u *url.URL) ([]byte, error) {
 			klog.Errorf("resp.Body.Close(): %v", err)
 		}
 	}()
-	body, err := io.ReadAll(resp.Body)
+	body, err := client.ReadAllLimited(resp.Body, *maxResponseSize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read body: %v", err)
+		return nil, fmt.Errorf("failed to read body: %w", err)
 	}
 
 	switch resp.StatusCode {
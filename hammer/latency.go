@@ -0,0 +1,118 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// adaptInterval is how often an adaptive throttle re-evaluates its rate.
+// It's longer than the throttle's 1s refill period so that each decision is
+// based on a reasonable number of samples.
+const adaptInterval = 5 * time.Second
+
+// LatencyTracker collects the latencies of operations made by readers or writers.
+type LatencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+// NewLatencyTracker creates a LatencyTracker.
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{}
+}
+
+// Observe records the latency of a single operation.
+// It is safe to call on a nil LatencyTracker, in which case it does nothing.
+func (l *LatencyTracker) Observe(d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples = append(l.samples, d)
+}
+
+// take returns the latencies observed since the last call, and resets the tracker.
+func (l *LatencyTracker) take() []time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.samples
+	l.samples = nil
+	return s
+}
+
+// percentile returns the p-th percentile (0 < p <= 1) of samples, which is sorted
+// in place. It returns 0 if there are no samples.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(float64(len(samples))*p+0.5) - 1
+	return samples[max(0, min(i, len(samples)-1))]
+}
+
+// RunAdaptive adjusts the throttle's rate to find the highest load at which the
+// p95 latency observed by l stays under target, until ctx is done.
+//
+// This uses AIMD: while the p95 latency is within target the rate is increased by
+// a fixed step, and as soon as it exceeds target the rate is halved. The rate isn't
+// increased while workers are failing to use all of the existing tokens, since more
+// tokens wouldn't result in more load. This should be run alongside Run.
+func (t *Throttle) RunAdaptive(ctx context.Context, l *LatencyTracker, target time.Duration) {
+	t.mu.Lock()
+	t.latencyTarget = target
+	step := max(1, t.opsPerSecond/10)
+	t.mu.Unlock()
+	ticker := time.NewTicker(adaptInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			samples := l.take()
+			p95 := percentile(samples, 0.95)
+			t.mu.Lock()
+			t.p95 = p95
+			switch {
+			case p95 > target:
+				t.opsPerSecond = max(1, t.opsPerSecond/2)
+				klog.V(1).Infof("p95 latency %v exceeds %v, backing off to %d/s", p95, target, t.opsPerSecond)
+			case t.oversupply == 0:
+				t.opsPerSecond += step
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// p95Millis returns the p95 latency seen in the last adaptive interval in
+// milliseconds, or nil if the throttle isn't adaptive.
+func (t *Throttle) p95Millis() *int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.latencyTarget == 0 {
+		return nil
+	}
+	ms := t.p95.Milliseconds()
+	return &ms
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+// These metrics are always recorded, but only exported if --metrics_addr is set.
+var (
+	readOpsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hammer_read_ops_total",
+		Help: "Number of leaves read by leaf readers.",
+	})
+	writeOpsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hammer_write_ops_total",
+		Help: "Number of write requests sent by log writers.",
+	})
+	errorCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hammer_errors_total",
+		Help: "Number of errors reported by readers, writers and the log state tracker.",
+	})
+	readLatencyHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "hammer_read_latency_seconds",
+		Help:    "Latency of leaf bundle fetches made by leaf readers.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	})
+	writeLatencyHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "hammer_write_latency_seconds",
+		Help:    "Latency of write requests sent by log writers.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	})
+)
+
+// throttleCollectors returns gauges reporting the current target rate and
+// oversupply of t, labelled with the kind of operation it throttles.
+func throttleCollectors(t *Throttle, op string) []prometheus.Collector {
+	labels := prometheus.Labels{"op": op}
+	return []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "hammer_throttle_ops_per_second",
+			Help:        "Current maximum number of operations per second allowed by the throttle.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(t.OpsPerSecond()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "hammer_throttle_oversupply",
+			Help:        "Number of throttle tokens which went unused in the last second.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(t.Oversupply()) }),
+	}
+}
+
+// serveMetrics exports the hammer's metrics for Prometheus to scrape from
+// addr, until ctx is done.
+func serveMetrics(ctx context.Context, h *Hammer, addr string) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(readOpsCounter, writeOpsCounter, errorCounter, readLatencyHistogram, writeLatencyHistogram)
+	reg.MustRegister(throttleCollectors(h.readThrottle, "read")...)
+	reg.MustRegister(throttleCollectors(h.writeThrottle, "write")...)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Exitf("Failed to serve metrics: %v", err)
+	}
+}
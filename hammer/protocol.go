@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// newTransport returns the transport used for all of the hammer's HTTP
+// requests. HTTP/2 is negotiated with servers which support it, unless
+// forceHTTP1 is set.
+func newTransport(forceHTTP1 bool) *http.Transport {
+	t := &http.Transport{
+		MaxIdleConns:        256,
+		MaxIdleConnsPerHost: 256,
+		DisableKeepAlives:   false,
+		ForceAttemptHTTP2:   !forceHTTP1,
+	}
+	if forceHTTP1 {
+		// A non-nil, empty, map disables HTTP/2.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+// ProtocolCounter is an http.RoundTripper which counts the new connections
+// made by the transport it wraps, by the protocol used on each connection.
+type ProtocolCounter struct {
+	rt http.RoundTripper
+
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// NewProtocolCounter returns a ProtocolCounter which wraps rt.
+func NewProtocolCounter(rt http.RoundTripper) *ProtocolCounter {
+	return &ProtocolCounter{
+		rt:     rt,
+		counts: make(map[string]uint64),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (p *ProtocolCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	var newConn atomic.Bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			newConn.Store(!info.Reused)
+		},
+	}
+	resp, err := p.rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err == nil && newConn.Load() {
+		p.mu.Lock()
+		p.counts[resp.Proto]++
+		p.mu.Unlock()
+	}
+	return resp, err
+}
+
+// Counts returns the number of connections made so far, keyed by protocol,
+// e.g. "HTTP/1.1" or "HTTP/2.0".
+func (p *ProtocolCounter) Counts() map[string]uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := make(map[string]uint64, len(p.counts))
+	for k, v := range p.counts {
+		c[k] = v
+	}
+	return c
+}
+
+func (p *ProtocolCounter) String() string {
+	counts := p.Counts()
+	protos := make([]string, 0, len(counts))
+	for k := range counts {
+		protos = append(protos, k)
+	}
+	sort.Strings(protos)
+	s := make([]string, 0, len(protos))
+	for _, k := range protos {
+		s = append(s, fmt.Sprintf("%s: %d", k, counts[k]))
+	}
+	if len(s) == 0 {
+		return "Connections: none"
+	}
+	return "Connections: " + strings.Join(s, ", ")
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// runState holds the aggregate counters and worker progress of a hammer run,
+// so that a restarted hammer can carry on from where it left off.
+type runState struct {
+	// Started is the time at which the first run began.
+	Started time.Time `json:"started"`
+
+	Duplicates        uint64 `json:"duplicates"`
+	DedupeSkipped     uint64 `json:"dedupeSkipped"`
+	SharedCacheHits   uint64 `json:"sharedCacheHits"`
+	SharedCacheMisses uint64 `json:"sharedCacheMisses"`
+	Errors            uint64 `json:"errors"`
+
+	// FullReaderProgress is the furthest leaf index reached by any full reader.
+	// Full readers resume from here.
+	FullReaderProgress uint64 `json:"fullReaderProgress"`
+}
+
+// loadState reads the run state from the file at path.
+// If the file does not exist, the state for a new run is returned.
+func loadState(path string) (runState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return runState{Started: time.Now()}, nil
+	} else if err != nil {
+		return runState{}, fmt.Errorf("failed to read state file: %v", err)
+	}
+	var s runState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return runState{}, fmt.Errorf("failed to parse state file %q: %v", path, err)
+	}
+	return s, nil
+}
+
+// saveState atomically replaces the file at path with s.
+func saveState(path string, s runState) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %v", err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write temporary state file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary state file: %v", err)
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// state returns a snapshot of the hammer's current run state.
+func (h *Hammer) state() runState {
+	s := runState{
+		Started:            h.started,
+		Duplicates:         h.leafConsumer.Duplicates(),
+		Errors:             h.errCount.Load(),
+		FullReaderProgress: h.fullReadProgress.Load(),
+	}
+	if h.dedupe != nil {
+		s.DedupeSkipped = h.dedupe.Skipped()
+	}
+	if h.sharedCache != nil {
+		s.SharedCacheHits, s.SharedCacheMisses = h.sharedCache.Hits(), h.sharedCache.Misses()
+	}
+	return s
+}
+
+// persistState saves the hammer's run state to path every interval, and once
+// more when ctx is done.
+func persistState(ctx context.Context, h *Hammer, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := saveState(path, h.state()); err != nil {
+				klog.Errorf("Failed to save state: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := saveState(path, h.state()); err != nil {
+				klog.Errorf("Failed to save state: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	want := runState{
+		Started:            time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Duplicates:         1,
+		DedupeSkipped:      2,
+		SharedCacheHits:    3,
+		SharedCacheMisses:  4,
+		Errors:             5,
+		FullReaderProgress: 6,
+	}
+	if err := saveState(path, want); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	got, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("loadState diff (-want +got):\n%s", diff)
+	}
+
+	// Saving again replaces the state, without leaving temporary files behind.
+	want.Duplicates = 10
+	if err := saveState(path, want); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	if got, err = loadState(path); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("loadState after second save diff (-want +got):\n%s", diff)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Got %d files in state directory, want 1", len(entries))
+	}
+}
+
+func TestLoadStateMissing(t *testing.T) {
+	before := time.Now()
+	got, err := loadState(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if got.Started.Before(before) || got.Started.After(time.Now()) {
+		t.Errorf("Started is %v, want the time of the call", got.Started)
+	}
+	if diff := cmp.Diff(runState{Started: got.Started}, got); diff != "" {
+		t.Errorf("loadState diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadStateCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"duplicates":`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := loadState(path); err == nil {
+		t.Error("loadState of corrupt state file succeeded, want error")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// status is a point-in-time summary of the hammer's progress, suitable for
+// emitting as a structured log line.
+type status struct {
+	Time time.Time `json:"time"`
+	// Started is the time at which the run began, including any earlier runs
+	// restored via --state_file.
+	Started time.Time `json:"started"`
+	// TreeSize is the size of the latest consistent checkpoint seen.
+	TreeSize uint64 `json:"treeSize"`
+	// TreeGrowth is the number of leaves the tree grew by since the last status.
+	TreeGrowth uint64 `json:"treeGrowth"`
+
+	ReadOpsPerSecond    int `json:"readOpsPerSecond"`
+	ReadOversupply      int `json:"readOversupply"`
+	WriteOpsPerSecond   int `json:"writeOpsPerSecond"`
+	WriteOversupply     int `json:"writeOversupply"`
+	RandomReaderWorkers int `json:"randomReaderWorkers"`
+	FullReaderWorkers   int `json:"fullReaderWorkers"`
+	WriterWorkers       int `json:"writerWorkers"`
+	// ReadP95Millis and WriteP95Millis are the p95 latencies seen in the last
+	// adaptive interval, if --read_latency_slo or --write_latency_slo are set.
+	ReadP95Millis  *int64 `json:"readP95Millis,omitempty"`
+	WriteP95Millis *int64 `json:"writeP95Millis,omitempty"`
+
+	Duplicates uint64 `json:"duplicates"`
+	// DedupeSkipped is the number of writes skipped by the client-side dedupe cache.
+	DedupeSkipped uint64 `json:"dedupeSkipped"`
+	// SharedCacheHits and SharedCacheMisses count lookups in the shared reader
+	// cache, if enabled.
+	SharedCacheHits   uint64 `json:"sharedCacheHits"`
+	SharedCacheMisses uint64 `json:"sharedCacheMisses"`
+	// CheckpointAgeSeconds is the age of the latest consistent checkpoint, if
+	// the log publishes checkpoint timestamps.
+	CheckpointAgeSeconds *float64 `json:"checkpointAgeSeconds,omitempty"`
+	// Errors is the total number of errors reported by workers so far.
+	Errors uint64 `json:"errors"`
+	// Connections is the number of HTTP connections made so far, keyed by the
+	// protocol negotiated on them, e.g. "HTTP/2.0".
+	Connections map[string]uint64 `json:"connections,omitempty"`
+}
+
+// newStatus returns a snapshot of the hammer's current state.
+// prevSize is the tree size from the previous snapshot, and is used to
+// calculate the growth of the tree.
+func newStatus(h *Hammer, prevSize uint64) status {
+	size := h.tracker.LatestConsistent.Size
+	growth := uint64(0)
+	if size > prevSize {
+		growth = size - prevSize
+	}
+	var skipped uint64
+	if h.dedupe != nil {
+		skipped = h.dedupe.Skipped()
+	}
+	var hits, misses uint64
+	if h.sharedCache != nil {
+		hits, misses = h.sharedCache.Hits(), h.sharedCache.Misses()
+	}
+	var cpAge *float64
+	if age, ok := h.checkpointAge(); ok {
+		secs := age.Seconds()
+		cpAge = &secs
+	}
+	return status{
+		Time:                 time.Now(),
+		Started:              h.started,
+		TreeSize:             size,
+		TreeGrowth:           growth,
+		ReadOpsPerSecond:     h.readThrottle.OpsPerSecond(),
+		ReadOversupply:       h.readThrottle.Oversupply(),
+		WriteOpsPerSecond:    h.writeThrottle.OpsPerSecond(),
+		WriteOversupply:      h.writeThrottle.Oversupply(),
+		RandomReaderWorkers:  h.randomReaders.Size(),
+		FullReaderWorkers:    h.fullReaders.Size(),
+		WriterWorkers:        h.writers.Size(),
+		Duplicates:           h.leafConsumer.Duplicates(),
+		DedupeSkipped:        skipped,
+		SharedCacheHits:      hits,
+		SharedCacheMisses:    misses,
+		CheckpointAgeSeconds: cpAge,
+		Errors:               h.errCount.Load(),
+		Connections:          protocols.Counts(),
+	}
+}
+
+// emitStatus writes a status line to w in the given format every interval,
+// until ctx is done.
+func emitStatus(ctx context.Context, h *Hammer, format string, interval time.Duration, w io.Writer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prevSize := h.tracker.LatestConsistent.Size
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s := newStatus(h, prevSize)
+			prevSize = s.TreeSize
+			switch format {
+			case "json":
+				b, err := json.Marshal(s)
+				if err != nil {
+					klog.Errorf("Failed to marshal status: %v", err)
+					continue
+				}
+				if _, err := fmt.Fprintln(w, string(b)); err != nil {
+					klog.Errorf("Failed to write status: %v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// timeseriesRow records the hammer's activity during a single interval.
+// Counts are of the operations made during the interval, not since the start
+// of the run.
+type timeseriesRow struct {
+	Time            time.Time `json:"time"`
+	IntervalSeconds float64   `json:"intervalSeconds"`
+	Reads           uint64    `json:"reads"`
+	Writes          uint64    `json:"writes"`
+	Errors          uint64    `json:"errors"`
+	BytesRead       uint64    `json:"bytesRead"`
+	BytesWritten    uint64    `json:"bytesWritten"`
+	// TreeSize is the size of the latest consistent checkpoint at the end of
+	// the interval.
+	TreeSize uint64 `json:"treeSize"`
+	// CheckpointAgeSeconds is the age of the latest consistent checkpoint, if
+	// the log publishes checkpoint timestamps.
+	CheckpointAgeSeconds *float64 `json:"checkpointAgeSeconds,omitempty"`
+}
+
+// timeseriesHeader is the header row of CSV timeseries files.
+var timeseriesHeader = []string{"time", "interval_seconds", "reads", "writes", "errors", "bytes_read", "bytes_written", "tree_size", "checkpoint_age_seconds"}
+
+func (r timeseriesRow) csv() []string {
+	age := ""
+	if r.CheckpointAgeSeconds != nil {
+		age = strconv.FormatFloat(*r.CheckpointAgeSeconds, 'f', 3, 64)
+	}
+	return []string{
+		r.Time.Format(time.RFC3339Nano),
+		strconv.FormatFloat(r.IntervalSeconds, 'f', 3, 64),
+		strconv.FormatUint(r.Reads, 10),
+		strconv.FormatUint(r.Writes, 10),
+		strconv.FormatUint(r.Errors, 10),
+		strconv.FormatUint(r.BytesRead, 10),
+		strconv.FormatUint(r.BytesWritten, 10),
+		strconv.FormatUint(r.TreeSize, 10),
+		age,
+	}
+}
+
+// openTimeseries opens the timeseries file at path for appending, creating it
+// if necessary. If the format is csv and the file is empty, the header row is
+// written to it.
+func openTimeseries(path, format string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if format == "csv" && fi.Size() == 0 {
+		w := csv.NewWriter(f)
+		if err := w.Write(timeseriesHeader); err != nil {
+			f.Close()
+			return nil, err
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// writeTimeseries appends a row describing the hammer's activity to the file
+// at path every interval, until ctx is done.
+func writeTimeseries(ctx context.Context, h *Hammer, tc *TrafficCounter, path, format string, interval time.Duration) {
+	f, err := openTimeseries(path, format)
+	if err != nil {
+		klog.Errorf("Failed to open timeseries file: %v", err)
+		return
+	}
+	defer f.Close()
+	cw := csv.NewWriter(f)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prevTime, prevTraffic, prevErrs := time.Now(), tc.Traffic(), h.errCount.Load()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t, errs := tc.Traffic(), h.errCount.Load()
+			r := timeseriesRow{
+				Time:            now,
+				IntervalSeconds: now.Sub(prevTime).Seconds(),
+				Reads:           t.Reads - prevTraffic.Reads,
+				Writes:          t.Writes - prevTraffic.Writes,
+				Errors:          errs - prevErrs,
+				BytesRead:       t.BytesRead - prevTraffic.BytesRead,
+				BytesWritten:    t.BytesWritten - prevTraffic.BytesWritten,
+				TreeSize:        h.tracker.LatestConsistent.Size,
+			}
+			if age, ok := h.checkpointAge(); ok {
+				secs := age.Seconds()
+				r.CheckpointAgeSeconds = &secs
+			}
+			prevTime, prevTraffic, prevErrs = now, t, errs
+
+			switch format {
+			case "csv":
+				if err := cw.Write(r.csv()); err != nil {
+					klog.Errorf("Failed to write timeseries row: %v", err)
+					continue
+				}
+				cw.Flush()
+				err = cw.Error()
+			case "json":
+				var b []byte
+				if b, err = json.Marshal(r); err == nil {
+					_, err = fmt.Fprintln(f, string(b))
+				}
+			}
+			if err != nil {
+				klog.Errorf("Failed to write timeseries row: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// TrafficCounter is an http.RoundTripper which counts the requests made via the
+// transport it wraps, and the bytes sent and received by them.
+// GET requests are counted as reads, and all others as writes.
+type TrafficCounter struct {
+	rt http.RoundTripper
+
+	reads        atomic.Uint64
+	writes       atomic.Uint64
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+}
+
+// NewTrafficCounter returns a TrafficCounter which wraps rt.
+func NewTrafficCounter(rt http.RoundTripper) *TrafficCounter {
+	return &TrafficCounter{rt: rt}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *TrafficCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet {
+		t.reads.Add(1)
+	} else {
+		t.writes.Add(1)
+	}
+	if req.ContentLength > 0 {
+		t.bytesWritten.Add(uint64(req.ContentLength))
+	}
+	resp, err := t.rt.RoundTrip(req)
+	if err == nil {
+		resp.Body = &countingReadCloser{ReadCloser: resp.Body, n: &t.bytesRead}
+	}
+	return resp, err
+}
+
+// trafficCounts is a snapshot of the counts held by a TrafficCounter.
+type trafficCounts struct {
+	Reads        uint64
+	Writes       uint64
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// Traffic returns the counts of requests made and bytes transferred so far.
+func (t *TrafficCounter) Traffic() trafficCounts {
+	return trafficCounts{
+		Reads:        t.reads.Load(),
+		Writes:       t.writes.Load(),
+		BytesRead:    t.bytesRead.Load(),
+		BytesWritten: t.bytesWritten.Load(),
+	}
+}
+
+// countingReadCloser adds the number of bytes read from the wrapped
+// ReadCloser to n.
+type countingReadCloser struct {
+	io.ReadCloser
+	n *atomic.Uint64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(uint64(n))
+	return n, err
+}
@@ -36,3 +36,8 @@ func (p *workerPool) Shrink(ctx context.Context) {
 	p.workers = p.workers[:len(p.workers)-1]
 	w.Kill()
 }
+
+// Size returns the number of running workers in the pool.
+func (p *workerPool) Size() int {
+	return len(p.workers)
+}
@@ -108,18 +108,24 @@ func RunIntegration(t *testing.T, s log.Storage, f client.Fetcher, lh *rfc6962.H
 			t.Fatalf("Failed to create ProofBuilder: %q", err)
 		}
 
+		hashes := make([][]byte, 0, len(leaves))
+		indices := make([]uint64, 0, len(leaves))
 		for _, l := range leaves {
 			h := lh.HashLeaf(l)
 			idx, err := client.LookupIndex(ctx, f, h)
 			if err != nil {
 				t.Fatalf("Failed to lookup leaf index: %v", err)
 			}
-			ip, err := pb.InclusionProof(ctx, idx)
-			if err != nil {
-				t.Fatalf("Failed to fetch inclusion proof for %d: %v", idx, err)
-			}
-			if err := proof.VerifyInclusion(lh, idx, newCheckpoint.Size, h, ip, newCheckpoint.Hash); err != nil {
-				t.Fatalf("Invalid inclusion proof for %d: %x", idx, ip)
+			hashes, indices = append(hashes, h), append(indices, idx)
+		}
+		proofs, err := pb.InclusionProofs(ctx, indices)
+		if err != nil {
+			t.Fatalf("Failed to fetch inclusion proofs: %v", err)
+		}
+		for i, idx := range indices {
+			ip := proofs[idx]
+			if err := proof.VerifyInclusion(lh, idx, newCheckpoint.Size, hashes[i], ip, newCheckpoint.Hash); err != nil {
+				t.Fatalf("Invalid inclusion proof for %d: %x (diagnosis: %v)", idx, ip, pb.DiagnoseInclusion(ctx, idx, hashes[i]))
 			}
 		}
 	}
@@ -127,7 +133,7 @@ func RunIntegration(t *testing.T, s log.Storage, f client.Fetcher, lh *rfc6962.H
 
 func InitialiseStorage(ctx context.Context, t *testing.T, st log.Storage) {
 	t.Helper()
-	cp := fmtlog.Checkpoint{}
+	cp := fmtlog.Checkpoint{Hash: rfc6962.DefaultHasher.EmptyRoot()}
 	cp.Origin = integrationOrigin
 	cpNote := note.Note{Text: string(cp.Marshal())}
 	s := mustGetSigner(t, privKey)
@@ -16,7 +16,10 @@
 package integration
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -24,12 +27,23 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/transparency-dev/merkle/proof"
 	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api"
+	"github.com/transparency-dev/serverless-log/api/layout"
 	"github.com/transparency-dev/serverless-log/client"
 	"github.com/transparency-dev/serverless-log/internal/storage/fs"
+	"github.com/transparency-dev/serverless-log/pkg/log"
+	"github.com/transparency-dev/serverless-log/testonly"
 	"golang.org/x/mod/sumdb/note"
+
+	fmtlog "github.com/transparency-dev/formats/log"
 )
 
 func TestServerlessViaFile(t *testing.T) {
@@ -46,21 +60,8 @@ func TestServerlessViaFile(t *testing.T) {
 	// Create empty checkpoint
 	st := mustCreateAndInitialiseStorage(context.Background(), t, root, s)
 
-	// Create file fetcher
-	rootURL, err := url.Parse(fmt.Sprintf("file://%s/", root))
-	if err != nil {
-		t.Fatalf("Failed to create root URL: %q", err)
-	}
-	f := func(_ context.Context, p string) ([]byte, error) {
-		u, err := rootURL.Parse(p)
-		if err != nil {
-			return nil, err
-		}
-		return os.ReadFile(u.Path)
-	}
-
 	// Run test
-	RunIntegration(t, st, f, h)
+	RunIntegration(t, st, st.Fetcher(), h)
 }
 
 func TestServerlessViaHTTP(t *testing.T) {
@@ -104,6 +105,558 @@ func TestServerlessViaHTTP(t *testing.T) {
 	RunIntegration(t, st, f, h)
 }
 
+func TestIntegrateWithCheckpointInterval(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+
+	root := filepath.Join(t.TempDir(), "log")
+	s := mustGetSigner(t, privKey)
+	st := mustCreateAndInitialiseStorage(ctx, t, root, s)
+
+	const numLeaves, interval = 1000, 300
+	sequenceNLeaves(ctx, t, st, h, 0, numLeaves)
+
+	var published []uint64
+	publish := func(ctx context.Context, cp *fmtlog.Checkpoint) error {
+		published = append(published, cp.Size)
+		return nil
+	}
+	cp, err := log.Integrate(ctx, 0, st, h, log.WithCheckpointInterval(interval, publish))
+	if err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	if got, want := cp.Size, uint64(numLeaves); got != want {
+		t.Errorf("Got final checkpoint size %d, want %d", got, want)
+	}
+	if diff := cmp.Diff([]uint64{300, 600, 900}, published); diff != "" {
+		t.Errorf("Published checkpoints diff (-want +got):\n%s", diff)
+	}
+
+	// The final checkpoint should match that produced by a single integration.
+	st2 := mustCreateAndInitialiseStorage(ctx, t, filepath.Join(t.TempDir(), "log2"), s)
+	sequenceNLeaves(ctx, t, st2, h, 0, numLeaves)
+	want, err := log.Integrate(ctx, 0, st2, h)
+	if err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	if diff := cmp.Diff(want, cp); diff != "" {
+		t.Errorf("Checkpoint diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestIntegrateEmptyLog(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+
+	st, err := fs.Create(filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+
+	// Integrating a log with nothing sequenced should succeed with nothing to do.
+	cp, err := log.Integrate(ctx, 0, st, h)
+	if err != nil {
+		t.Fatalf("Integrate empty log = %v", err)
+	}
+	if cp != nil {
+		t.Fatalf("Integrate empty log returned checkpoint %+v, want nil", cp)
+	}
+
+	// The first entries should integrate cleanly on top of the empty tree.
+	const numLeaves = 10
+	sequenceNLeaves(ctx, t, st, h, 0, numLeaves)
+	cp, err = log.Integrate(ctx, 0, st, h)
+	if err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	if got, want := cp.Size, uint64(numLeaves); got != want {
+		t.Errorf("Got checkpoint size %d, want %d", got, want)
+	}
+}
+
+func TestIntegrateWithFrontierValidation(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	st := testonly.NewMemStorage()
+
+	sequenceNLeaves(ctx, t, st, h, 0, 300)
+	cp, err := log.Integrate(ctx, 0, st, h, log.WithFrontierValidation(h.EmptyRoot()))
+	if err != nil {
+		t.Fatalf("Integrate from empty tree = %v", err)
+	}
+
+	sequenceNLeaves(ctx, t, st, h, 300, 100)
+	// A checkpoint root which the tiles don't commit to should be rejected.
+	if _, err := log.Integrate(ctx, cp.Size, st, h, log.WithFrontierValidation(h.EmptyRoot())); !errors.As(err, &log.ErrInvalidFrontier{}) {
+		t.Errorf("Integrate with wrong root = %v, want ErrInvalidFrontier", err)
+	}
+
+	// Corrupt a leaf hash in the partial tile on the frontier.
+	tile, err := st.GetTile(ctx, 0, 1, cp.Size)
+	if err != nil {
+		t.Fatalf("GetTile = %v", err)
+	}
+	good := tile.Nodes[api.TileNodeKey(0, 4)]
+	tile.Nodes[api.TileNodeKey(0, 4)] = h.HashLeaf([]byte("bad"))
+	if err := st.StoreTile(ctx, 0, 1, tile); err != nil {
+		t.Fatalf("StoreTile = %v", err)
+	}
+	if _, err := log.Integrate(ctx, cp.Size, st, h, log.WithFrontierValidation(cp.Hash)); !errors.As(err, &log.ErrInvalidFrontier{}) {
+		t.Errorf("Integrate with corrupt tile = %v, want ErrInvalidFrontier", err)
+	}
+
+	// Once repaired, integration should succeed.
+	tile.Nodes[api.TileNodeKey(0, 4)] = good
+	if err := st.StoreTile(ctx, 0, 1, tile); err != nil {
+		t.Fatalf("StoreTile = %v", err)
+	}
+	cp, err = log.Integrate(ctx, cp.Size, st, h, log.WithFrontierValidation(cp.Hash))
+	if err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	if got, want := cp.Size, uint64(400); got != want {
+		t.Errorf("Got checkpoint size %d, want %d", got, want)
+	}
+}
+
+func TestIntegrateWithMaxPending(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	st := testonly.NewMemStorage()
+
+	sequenceNLeaves(ctx, t, st, h, 0, 100)
+	if _, err := log.Integrate(ctx, 0, st, h, log.WithMaxPending(99)); !errors.As(err, &log.ErrTooManyPending{}) {
+		t.Fatalf("Integrate with too many pending = %v, want ErrTooManyPending", err)
+	}
+	if _, err := st.GetTile(ctx, 0, 0, 100); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetTile after refused Integrate = %v, want os.ErrNotExist", err)
+	}
+
+	cp, err := log.Integrate(ctx, 0, st, h, log.WithMaxPending(100))
+	if err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	if got, want := cp.Size, uint64(100); got != want {
+		t.Errorf("Got checkpoint size %d, want %d", got, want)
+	}
+}
+
+func TestIntegrateWithObserver(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	st := testonly.NewMemStorage()
+
+	var got []log.IntegrateStats
+	observer := log.WithObserver(log.IntegrateObserverFunc(func(_ context.Context, s log.IntegrateStats) {
+		if s.Duration < 0 {
+			t.Errorf("Observed negative duration %v", s.Duration)
+		}
+		s.Duration = 0
+		got = append(got, s)
+	}))
+
+	sequenceNLeaves(ctx, t, st, h, 0, 300)
+	if _, err := log.Integrate(ctx, 0, st, h, observer); err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	sequenceNLeaves(ctx, t, st, h, 300, 10)
+	if _, err := log.Integrate(ctx, 300, st, h, observer); err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	if _, err := log.Integrate(ctx, 310, st, h, observer); err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	sequenceNLeaves(ctx, t, st, h, 310, 2)
+	errPending := log.ErrTooManyPending{Max: 1}
+	if _, err := log.Integrate(ctx, 310, st, h, observer, log.WithMaxPending(1)); !errors.Is(err, errPending) {
+		t.Fatalf("Integrate with too many pending = %v, want %v", err, errPending)
+	}
+
+	want := []log.IntegrateStats{
+		// Two level 0 tiles, and the level 1 tile holding the first complete level 8 node.
+		{FromSize: 0, ToSize: 300, EntriesAdded: 300, TilesWritten: 3},
+		{FromSize: 300, ToSize: 310, EntriesAdded: 10, TilesWritten: 1},
+		{FromSize: 310, ToSize: 310},
+		{FromSize: 310, ToSize: 310, Err: errPending},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("Observed stats diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestIntegrateWithGapDetection(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	st, err := fs.Create(filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+
+	sequenceNLeaves(ctx, t, st, h, 0, 10)
+	if err := st.Assign(ctx, 20, []byte("Leaf 20")); err != nil {
+		t.Fatalf("Assign = %v", err)
+	}
+	_, err = log.Integrate(ctx, 0, st, h, log.WithGapDetection())
+	wantErr := log.ErrSequenceGap{Missing: 10, Highest: 20}
+	if gotErr := (log.ErrSequenceGap{}); !errors.As(err, &gotErr) || gotErr != wantErr {
+		t.Fatalf("Integrate with gap = %v, want %v", err, wantErr)
+	}
+	if _, err := st.GetTile(ctx, 0, 0, 10); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetTile after refused Integrate = %v, want os.ErrNotExist", err)
+	}
+
+	// Filling the gap allows everything to be integrated.
+	for i := 10; i < 20; i++ {
+		if err := st.Assign(ctx, uint64(i), []byte(fmt.Sprintf("Leaf %d", i))); err != nil {
+			t.Fatalf("Assign = %v", err)
+		}
+	}
+	cp, err := log.Integrate(ctx, 0, st, h, log.WithGapDetection())
+	if err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	if got, want := cp.Size, uint64(21); got != want {
+		t.Errorf("Got checkpoint size %d, want %d", got, want)
+	}
+
+	if _, err := log.Integrate(ctx, 0, testonly.NewMemStorage(), h, log.WithGapDetection()); err == nil {
+		t.Error("Integrate with gap detection on storage which doesn't support it succeeded, want error")
+	}
+}
+
+func TestConcurrentSequenceAndIntegrate(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	st := testonly.NewMemStorage()
+	InitialiseStorage(ctx, t, st)
+	s := mustGetSigner(t, privKey)
+
+	const numLeaves = 1000
+	// leaves holds each leaf at the index it was sequenced at.
+	leaves := make([][]byte, numLeaves)
+	seqErr := make(chan error, 1)
+	go func() {
+		defer close(seqErr)
+		for i := 0; i < numLeaves; i++ {
+			c := []byte(fmt.Sprintf("Leaf %d", i))
+			seq, err := st.Sequence(ctx, h.HashLeaf(c), c)
+			if err != nil {
+				seqErr <- fmt.Errorf("Sequence = %v", err)
+				return
+			}
+			if seq >= numLeaves {
+				seqErr <- fmt.Errorf("Sequence assigned %d, want < %d", seq, numLeaves)
+				return
+			}
+			leaves[seq] = c
+		}
+	}()
+
+	// Integrate repeatedly while sequencing is in progress, and once more
+	// afterwards to pick up any stragglers.
+	cps := []fmtlog.Checkpoint{{Hash: h.EmptyRoot()}}
+	sequencing := true
+	for {
+		if sequencing {
+			select {
+			case err, ok := <-seqErr:
+				if err != nil {
+					t.Fatal(err)
+				}
+				sequencing = ok
+			default:
+			}
+		}
+		cp, err := log.Integrate(ctx, cps[len(cps)-1].Size, st, h)
+		if err != nil {
+			t.Fatalf("Integrate = %v", err)
+		}
+		if cp == nil {
+			if !sequencing {
+				break
+			}
+			continue
+		}
+		cp.Origin = integrationOrigin
+		raw, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, s)
+		if err != nil {
+			t.Fatalf("Sign = %v", err)
+		}
+		if err := st.WriteCheckpoint(ctx, raw); err != nil {
+			t.Fatalf("WriteCheckpoint = %v", err)
+		}
+		cps = append(cps, *cp)
+	}
+
+	if got := cps[len(cps)-1].Size; got != numLeaves {
+		t.Fatalf("Got final checkpoint size %d, want %d", got, numLeaves)
+	}
+	if err := client.CheckConsistency(ctx, h, st.Fetcher(), cps); err != nil {
+		t.Errorf("Checkpoints are inconsistent: %v", err)
+	}
+	// Every leaf must be included in the final tree at the index it was sequenced at.
+	got := make([][]byte, 0, numLeaves)
+	if err := client.DownloadAllLeaves(ctx, st.Fetcher(), numLeaves, func(_ uint64, leaf []byte) error {
+		got = append(got, leaf)
+		return nil
+	}); err != nil {
+		t.Fatalf("DownloadAllLeaves = %v", err)
+	}
+	if diff := cmp.Diff(leaves, got); diff != "" {
+		t.Errorf("Leaves diff (-want +got):\n%s", diff)
+	}
+	pb, err := client.NewProofBuilder(ctx, cps[len(cps)-1], h.HashChildren, st.Fetcher())
+	if err != nil {
+		t.Fatalf("NewProofBuilder = %v", err)
+	}
+	for i := uint64(0); i < numLeaves; i += 97 {
+		p, err := pb.InclusionProof(ctx, i)
+		if err != nil {
+			t.Fatalf("InclusionProof(%d) = %v", i, err)
+		}
+		if err := proof.VerifyInclusion(h, i, numLeaves, h.HashLeaf(leaves[i]), p, cps[len(cps)-1].Hash); err != nil {
+			t.Errorf("VerifyInclusion(%d) = %v", i, err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	s := mustGetSigner(t, privKey)
+	v, err := note.NewVerifier(pubKey)
+	if err != nil {
+		t.Fatalf("NewVerifier = %v", err)
+	}
+
+	// populate sequences and integrates n leaves, and writes the checkpoint.
+	populate := func(st log.Storage, n int) {
+		t.Helper()
+		InitialiseStorage(ctx, t, st)
+		sequenceNLeaves(ctx, t, st, h, 0, n)
+		cp, err := log.Integrate(ctx, 0, st, h)
+		if err != nil {
+			t.Fatalf("Integrate = %v", err)
+		}
+		cp.Origin = integrationOrigin
+		raw, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, s)
+		if err != nil {
+			t.Fatalf("Sign = %v", err)
+		}
+		if err := st.WriteCheckpoint(ctx, raw); err != nil {
+			t.Fatalf("WriteCheckpoint = %v", err)
+		}
+	}
+
+	root := filepath.Join(t.TempDir(), "log")
+	fsStorage, err := fs.Create(root)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	populate(fsStorage, 10)
+	memStorage := testonly.NewMemStorage()
+	populate(memStorage, 10)
+	testonly.RegisterMemStorage("TestOpen", memStorage)
+
+	for _, u := range []string{"file://" + filepath.ToSlash(root), "mem://TestOpen"} {
+		st, cp, err := log.Open(ctx, u, v, integrationOrigin)
+		if err != nil {
+			t.Fatalf("Open(%q) = %v", u, err)
+		}
+		if cp.Size != 10 {
+			t.Errorf("Open(%q) returned checkpoint with size %d, want 10", u, cp.Size)
+		}
+		// The storage should be ready to extend the log.
+		leaf := []byte("another leaf")
+		seq, err := st.Sequence(ctx, h.HashLeaf(leaf), leaf)
+		if err != nil {
+			t.Fatalf("Sequence via %q = %v", u, err)
+		}
+		if seq != 10 {
+			t.Errorf("Sequence via %q = %d, want 10", u, seq)
+		}
+	}
+
+	for _, test := range []struct {
+		desc   string
+		url    string
+		origin string
+	}{
+		{desc: "unsupported scheme", url: "nope://log", origin: integrationOrigin},
+		{desc: "missing log", url: "file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "missing")), origin: integrationOrigin},
+		{desc: "unregistered mem log", url: "mem://missing", origin: integrationOrigin},
+		{desc: "wrong origin", url: "file://" + filepath.ToSlash(root), origin: "wrong origin"},
+	} {
+		if _, _, err := log.Open(ctx, test.url, v, test.origin); err == nil {
+			t.Errorf("%s: Open(%q) succeeded, want error", test.desc, test.url)
+		}
+	}
+}
+
+func TestVerifyHistory(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+
+	root := filepath.Join(t.TempDir(), "log")
+	st, err := fs.Create(root)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	if err := st.EnableCheckpointArchive(); err != nil {
+		t.Fatalf("EnableCheckpointArchive = %v", err)
+	}
+	InitialiseStorage(ctx, t, st)
+	s := mustGetSigner(t, privKey)
+	v, err := note.NewVerifier(pubKey)
+	if err != nil {
+		t.Fatalf("NewVerifier = %v", err)
+	}
+	sign := func(cp *fmtlog.Checkpoint) []byte {
+		t.Helper()
+		cp.Origin = integrationOrigin
+		raw, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, s)
+		if err != nil {
+			t.Fatalf("Sign = %v", err)
+		}
+		return raw
+	}
+
+	const loops, leavesPerLoop = 5, 100
+	size := uint64(0)
+	for i := 0; i < loops; i++ {
+		sequenceNLeaves(ctx, t, st, h, i*leavesPerLoop, leavesPerLoop)
+		cp, err := log.Integrate(ctx, size, st, h)
+		if err != nil {
+			t.Fatalf("Integrate = %v", err)
+		}
+		if err := st.WriteCheckpoint(ctx, sign(cp)); err != nil {
+			t.Fatalf("WriteCheckpoint = %v", err)
+		}
+		size = cp.Size
+	}
+
+	f := st.Fetcher()
+	sizes, err := client.ListCheckpoints(ctx, f)
+	if err != nil {
+		t.Fatalf("ListCheckpoints = %v", err)
+	}
+	if diff := cmp.Diff([]uint64{0, 100, 200, 300, 400, 500}, sizes); diff != "" {
+		t.Errorf("ListCheckpoints diff (-want +got):\n%s", diff)
+	}
+	if err := client.VerifyHistory(ctx, f, v, integrationOrigin); err != nil {
+		t.Fatalf("VerifyHistory = %v", err)
+	}
+
+	cp, raw, err := client.GetCheckpointAt(ctx, f, v, integrationOrigin, 200)
+	if err != nil {
+		t.Fatalf("GetCheckpointAt(200) = %v", err)
+	}
+	if cp.Size != 200 {
+		t.Errorf("GetCheckpointAt(200) returned checkpoint with size %d", cp.Size)
+	}
+	if _, _, err := client.GetCheckpointAt(ctx, f, v, integrationOrigin, 150); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetCheckpointAt(150) = %v, want os.ErrNotExist", err)
+	}
+	if _, _, err := client.GetCheckpointAt(ctx, f, v, "wrong origin", 200); err == nil {
+		t.Error("GetCheckpointAt with wrong origin succeeded")
+	}
+	// An archived checkpoint stored under the wrong size must be rejected.
+	p300 := filepath.Join(root, layout.CheckpointArchivePath(300))
+	orig, err := os.ReadFile(p300)
+	if err != nil {
+		t.Fatalf("ReadFile = %v", err)
+	}
+	if err := os.WriteFile(p300, raw, 0644); err != nil {
+		t.Fatalf("WriteFile = %v", err)
+	}
+	if _, _, err := client.GetCheckpointAt(ctx, f, v, integrationOrigin, 300); err == nil {
+		t.Error("GetCheckpointAt for misplaced checkpoint succeeded")
+	}
+	if err := os.WriteFile(p300, orig, 0644); err != nil {
+		t.Fatalf("WriteFile = %v", err)
+	}
+
+	// Replace an archived checkpoint with a validly signed, but inconsistent, one.
+	bad := sign(&fmtlog.Checkpoint{Size: 200, Hash: h.HashLeaf([]byte("bogus"))})
+	if err := os.WriteFile(filepath.Join(root, layout.CheckpointArchivePath(200)), bad, 0644); err != nil {
+		t.Fatalf("WriteFile = %v", err)
+	}
+	err = client.VerifyHistory(ctx, f, v, integrationOrigin)
+	if !errors.As(err, &client.ErrInconsistency{}) {
+		t.Fatalf("VerifyHistory with bad archive = %v, want ErrInconsistency", err)
+	}
+}
+
+// domainHasher is an RFC6962 hasher whose interior nodes are hashed with a
+// different domain separation prefix, so its trees have different root hashes.
+type domainHasher struct {
+	*rfc6962.Hasher
+}
+
+func (domainHasher) HashChildren(l, r []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x02})
+	h.Write(l)
+	h.Write(r)
+	return h.Sum(nil)
+}
+
+func TestVerifyHistoryHasher(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := domainHasher{rfc6962.DefaultHasher}
+
+	root := filepath.Join(t.TempDir(), "log")
+	st, err := fs.Create(root)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	if err := st.EnableCheckpointArchive(); err != nil {
+		t.Fatalf("EnableCheckpointArchive = %v", err)
+	}
+	InitialiseStorage(ctx, t, st)
+	s := mustGetSigner(t, privKey)
+	v, err := note.NewVerifier(pubKey)
+	if err != nil {
+		t.Fatalf("NewVerifier = %v", err)
+	}
+
+	size := uint64(0)
+	for i := 0; i < 3; i++ {
+		sequenceNLeaves(ctx, t, st, h, i*10, 10)
+		cp, err := log.Integrate(ctx, size, st, h)
+		if err != nil {
+			t.Fatalf("Integrate = %v", err)
+		}
+		cp.Origin = integrationOrigin
+		raw, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, s)
+		if err != nil {
+			t.Fatalf("Sign = %v", err)
+		}
+		if err := st.WriteCheckpoint(ctx, raw); err != nil {
+			t.Fatalf("WriteCheckpoint = %v", err)
+		}
+		size = cp.Size
+	}
+
+	f := st.Fetcher()
+	if err := client.VerifyHistory(ctx, f, v, integrationOrigin, client.WithHistoryHasher(h)); err != nil {
+		t.Fatalf("VerifyHistory with log's hasher = %v", err)
+	}
+	if err := client.VerifyHistory(ctx, f, v, integrationOrigin); err == nil {
+		t.Fatal("VerifyHistory with RFC6962 hasher succeeded, want error")
+	}
+}
+
 func httpFetcher(t *testing.T, u string) client.Fetcher {
 	t.Helper()
 	rootURL, err := url.Parse(u)
@@ -143,3 +696,202 @@ func mustCreateAndInitialiseStorage(ctx context.Context, t *testing.T, root stri
 
 	return st
 }
+
+// flakyCheckpointStorage fails the first failures calls to WriteCheckpoint.
+type flakyCheckpointStorage struct {
+	*testonly.MemStorage
+	mu       sync.Mutex
+	failures int
+}
+
+func (s *flakyCheckpointStorage) WriteCheckpoint(ctx context.Context, cpRaw []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("flaky storage")
+	}
+	return s.MemStorage.WriteCheckpoint(ctx, cpRaw)
+}
+
+func TestDriver(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := rfc6962.DefaultHasher
+	s := mustGetSigner(t, privKey)
+	v, err := note.NewVerifier(pubKey)
+	if err != nil {
+		t.Fatalf("NewVerifier = %v", err)
+	}
+	st := &flakyCheckpointStorage{MemStorage: testonly.NewMemStorage(), failures: 2}
+
+	d, err := log.NewDriver(st, h, fmtlog.Checkpoint{}, integrationOrigin, []note.Signer{s},
+		log.WithPollInterval(10*time.Millisecond),
+		log.WithBatchSize(100),
+		log.WithBackoff(time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewDriver = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(ctx)
+	}()
+
+	// waitForSize waits for the driver to publish a checkpoint of the given size.
+	waitForSize := func(size uint64) *fmtlog.Checkpoint {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			cpRaw, err := st.Fetcher()(ctx, layout.CheckpointPath)
+			if err == nil {
+				cp, _, _, err := client.ParseCheckpoint(cpRaw, integrationOrigin, v)
+				if err != nil {
+					t.Fatalf("ParseCheckpoint = %v", err)
+				}
+				if cp.Size == size {
+					return cp
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for checkpoint at size %d", size)
+		return nil
+	}
+
+	sequenceNLeaves(ctx, t, st, h, 0, 250)
+	waitForSize(250)
+	sequenceNLeaves(ctx, t, st, h, 250, 10)
+	got := waitForSize(260)
+
+	// Compare with the same leaves integrated in one go.
+	want := testonly.NewMemStorage()
+	sequenceNLeaves(ctx, t, want, h, 0, 260)
+	wantCP, err := log.Integrate(ctx, 0, want, h)
+	if err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	if !bytes.Equal(got.Hash, wantCP.Hash) {
+		t.Errorf("Driver published root hash %x, want %x", got.Hash, wantCP.Hash)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run = %v, want nil after cancellation", err)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	s := mustGetSigner(t, privKey)
+	v, err := note.NewVerifier(pubKey)
+	if err != nil {
+		t.Fatalf("NewVerifier = %v", err)
+	}
+	st := testonly.NewMemStorage()
+
+	sequenceNLeaves(ctx, t, st, h, 0, 10)
+	cp, err := log.Integrate(ctx, 0, st, h)
+	if err != nil {
+		t.Fatalf("Integrate = %v", err)
+	}
+	if err := st.WriteCheckpoint(ctx, testonly.SignCheckpoint(*cp, integrationOrigin, s)); err != nil {
+		t.Fatalf("WriteCheckpoint = %v", err)
+	}
+
+	if err := log.Freeze(ctx, st, s, "wrong origin"); err == nil {
+		t.Error("Freeze with wrong origin succeeded, want error")
+	}
+	if err := log.Freeze(ctx, st, s, integrationOrigin); err != nil {
+		t.Fatalf("Freeze = %v", err)
+	}
+	frozenRaw, err := st.ReadCheckpoint(ctx)
+	if err != nil {
+		t.Fatalf("ReadCheckpoint = %v", err)
+	}
+	frozen, ext, _, err := client.ParseCheckpoint(frozenRaw, integrationOrigin, v)
+	if err != nil {
+		t.Fatalf("ParseCheckpoint = %v", err)
+	}
+	if frozen.Size != cp.Size || !bytes.Equal(frozen.Hash, cp.Hash) {
+		t.Errorf("Frozen checkpoint is for size %d root %x, want size %d root %x", frozen.Size, frozen.Hash, cp.Size, cp.Hash)
+	}
+	if !client.CheckpointFrozen(ext) {
+		t.Errorf("Frozen checkpoint has extensions %q, want frozen extension", ext)
+	}
+
+	// Freezing again should leave the checkpoint untouched.
+	if err := log.Freeze(ctx, st, s, integrationOrigin); err != nil {
+		t.Fatalf("Freeze again = %v", err)
+	}
+	if again, err := st.ReadCheckpoint(ctx); err != nil || !bytes.Equal(again, frozenRaw) {
+		t.Errorf("Checkpoint after freezing again = %q, %v, want %q", again, err, frozenRaw)
+	}
+
+	sequenceNLeaves(ctx, t, st, h, 10, 5)
+	if _, err := log.Integrate(ctx, cp.Size, st, h); !errors.Is(err, log.ErrLogFrozen) {
+		t.Errorf("Integrate frozen log = %v, want ErrLogFrozen", err)
+	}
+}
+
+func TestBatchSequence(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	for _, test := range []struct {
+		name string
+		st   func(t *testing.T) log.Storage
+	}{
+		{
+			// MemStorage implements BatchSequencer.
+			name: "batch",
+			st: func(t *testing.T) log.Storage {
+				return testonly.NewMemStorage()
+			},
+		}, {
+			// fs.Storage doesn't, so leaves are sequenced one at a time.
+			name: "fallback",
+			st: func(t *testing.T) log.Storage {
+				st, err := fs.Create(filepath.Join(t.TempDir(), "log"))
+				if err != nil {
+					t.Fatalf("Create = %v", err)
+				}
+				return st
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			st := test.st(t)
+			if _, err := st.Sequence(ctx, h.HashLeaf([]byte("x")), []byte("x")); err != nil {
+				t.Fatalf("Sequence = %v", err)
+			}
+			var leaves []log.SequenceLeaf
+			for _, l := range []string{"a", "x", "b", "a"} {
+				leaves = append(leaves, log.SequenceLeaf{Hash: h.HashLeaf([]byte(l)), Data: []byte(l)})
+			}
+			seqs, dupes, err := log.BatchSequence(ctx, st, leaves)
+			if err != nil {
+				t.Fatalf("BatchSequence = %v", err)
+			}
+			if diff := cmp.Diff([]uint64{1, 0, 2, 1}, seqs); diff != "" {
+				t.Errorf("Sequence numbers diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff([]bool{false, true, false, true}, dupes); diff != "" {
+				t.Errorf("Dupes diff (-want +got):\n%s", diff)
+			}
+
+			var got []string
+			if _, err := st.ScanSequenced(ctx, 0, func(_ uint64, entry []byte) error {
+				got = append(got, string(entry))
+				return nil
+			}); err != nil {
+				t.Fatalf("ScanSequenced = %v", err)
+			}
+			if diff := cmp.Diff([]string{"x", "a", "b"}, got); diff != "" {
+				t.Errorf("Sequenced entries diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
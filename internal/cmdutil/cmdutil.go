@@ -0,0 +1,104 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmdutil holds helpers shared by the commands which read logs.
+package cmdutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/transparency-dev/serverless-log/client"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+// NewFetcher returns a Fetcher which retrieves resources relative to root,
+// which must be an http, https, or file URL. Objects read over HTTP which are
+// larger than maxResponseSize bytes are rejected.
+func NewFetcher(root *url.URL, maxResponseSize int64) (client.Fetcher, error) {
+	var get func(context.Context, *url.URL) ([]byte, error)
+	switch root.Scheme {
+	case "http", "https":
+		get = func(ctx context.Context, u *url.URL) ([]byte, error) {
+			return readHTTP(ctx, u, maxResponseSize)
+		}
+	case "file":
+		get = func(_ context.Context, u *url.URL) ([]byte, error) {
+			return os.ReadFile(filepath.FromSlash(u.Path))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %s", root.Scheme)
+	}
+
+	return func(ctx context.Context, p string) ([]byte, error) {
+		u, err := root.Parse(p)
+		if err != nil {
+			return nil, err
+		}
+		return get(ctx, u)
+	}, nil
+}
+
+func readHTTP(ctx context.Context, u *url.URL, maxResponseSize int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			klog.Errorf("resp.Body.Close(): %v", err)
+		}
+	}()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, os.ErrNotExist
+	default:
+		return nil, fmt.Errorf("unexpected http status %q", resp.Status)
+	}
+	body, err := client.ReadAllLimited(resp.Body, maxResponseSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", u.String(), err)
+	}
+	return body, nil
+}
+
+// LogSigVerifier returns a verifier for the log's checkpoint signatures.
+// The public key is read from the file f, or, if f is unset, from the
+// SERVERLESS_LOG_PUBLIC_KEY environment variable.
+func LogSigVerifier(f string) (note.Verifier, error) {
+	var pubKey []byte
+	var err error
+	if len(f) > 0 {
+		pubKey, err = os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key from file %q: %v", f, err)
+		}
+	} else {
+		pubKey = []byte(os.Getenv("SERVERLESS_LOG_PUBLIC_KEY"))
+		if len(pubKey) == 0 {
+			return nil, fmt.Errorf("supply public key file path using --log_public_key or set SERVERLESS_LOG_PUBLIC_KEY environment variable")
+		}
+	}
+	return note.NewVerifier(string(pubKey))
+}
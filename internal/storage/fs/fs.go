@@ -16,16 +16,21 @@
 package fs
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
-	"crypto/sha256"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 
+	fmtlog "github.com/transparency-dev/formats/log"
 	"github.com/transparency-dev/serverless-log/api"
 	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/client"
 	"github.com/transparency-dev/serverless-log/pkg/log"
 	"k8s.io/klog/v2"
 )
@@ -44,6 +49,11 @@ const (
 //	<rootDir>/seq/aa/bb/cc/ddeeff...
 //	<rootDir>/tile/<level>/aa/bb/ccddee...
 //	<rootDir>/checkpoint
+//	<rootDir>/checkpoints/index
+//	<rootDir>/checkpoints/<size>
+//
+// Every checkpoint written is also archived under the checkpoints directory,
+// if that directory is present (see EnableCheckpointArchive).
 //
 // The functions on this struct are not thread-safe.
 type Storage struct {
@@ -54,20 +64,55 @@ type Storage struct {
 	// Note that nextSeq may be <= than the actual next available number, but
 	// never greater.
 	nextSeq uint64
+	// readOnly is set for storage opened with OpenReadOnly, and causes all
+	// methods which would modify the log to fail with ErrReadOnly.
+	readOnly bool
+	// validateLeaf, if set, must accept each leaf before it's sequenced.
+	validateLeaf log.LeafValidator
+	// compressArchive causes checkpoints to be gzip compressed when archived.
+	compressArchive bool
+	// tileWidth, if non-zero, overrides the default width of the log's tiles.
+	tileWidth uint64
+}
+
+// ErrReadOnly is returned by methods which would modify a log opened with
+// OpenReadOnly.
+var ErrReadOnly = errors.New("storage is read-only")
+
+const (
+	leavesPendingPath    = "leaves/pending"
+	checkpointArchiveDir = "checkpoints"
+)
+
+func init() {
+	log.RegisterStorage("file", opener{})
 }
 
-const leavesPendingPathFmt = "leaves/pending/%0x"
+// opener opens logs with file URLs, e.g. file:///path/to/log, for log.Open.
+type opener struct{}
+
+func (opener) ReadCheckpoint(_ context.Context, u *url.URL) ([]byte, error) {
+	return ReadCheckpoint(fileURLPath(u))
+}
+
+func (opener) Open(_ context.Context, u *url.URL, size uint64) (log.Storage, error) {
+	return Load(fileURLPath(u), size)
+}
+
+// fileURLPath returns the local path referenced by a file URL.
+func fileURLPath(u *url.URL) string {
+	if u.Opaque != "" {
+		// A relative path, e.g. file:path/to/log.
+		return filepath.FromSlash(u.Opaque)
+	}
+	return filepath.FromSlash(u.Path)
+}
 
 // Load returns a Storage instance initialised from the filesystem at the provided location.
 // cpSize should be the Size of the checkpoint produced from the last `log.Integrate` call.
 func Load(rootDir string, cpSize uint64) (*Storage, error) {
-	fi, err := os.Stat(rootDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to stat %q: %w", rootDir, err)
-	}
-
-	if !fi.IsDir() {
-		return nil, fmt.Errorf("%q is not a directory", rootDir)
+	if err := checkDir(rootDir); err != nil {
+		return nil, err
 	}
 
 	return &Storage{
@@ -76,6 +121,35 @@ func Load(rootDir string, cpSize uint64) (*Storage, error) {
 	}, nil
 }
 
+// OpenReadOnly returns a Storage instance which only reads from the log at the
+// provided location, e.g. a read-only replica on a remote mount.
+// Only GetTile, ScanSequenced, HighestSequenced, ReadCheckpoint, and Fetcher
+// may be used; all methods which would modify the log return ErrReadOnly
+// without touching the filesystem.
+func OpenReadOnly(rootDir string) (*Storage, error) {
+	if err := checkDir(rootDir); err != nil {
+		return nil, err
+	}
+
+	return &Storage{
+		rootDir:  rootDir,
+		readOnly: true,
+	}, nil
+}
+
+// checkDir returns an error if rootDir is not an existing directory.
+func checkDir(rootDir string) error {
+	fi, err := os.Stat(rootDir)
+	if err != nil {
+		return fmt.Errorf("failed to stat %q: %w", rootDir, err)
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("%q is not a directory", rootDir)
+	}
+	return nil
+}
+
 // Create creates a new filesystem hierarchy and returns a Storage representation for it.
 func Create(rootDir string) (*Storage, error) {
 	_, err := os.Stat(rootDir)
@@ -102,12 +176,47 @@ func Create(rootDir string) (*Storage, error) {
 	return fs, nil
 }
 
+// SetLeafValidator configures Sequence to reject, with log.ErrInvalidLeaf, any
+// leaf which fails validation by v. Rejected leaves are not stored.
+func (fs *Storage) SetLeafValidator(v log.LeafValidator) {
+	fs.validateLeaf = v
+}
+
+// SetTileWidth configures the storage for a log whose tiles have the given
+// width, rather than layout.TileWidth. All tools accessing the log must use
+// the same width.
+func (fs *Storage) SetTileWidth(width uint64) error {
+	if err := layout.ValidateTileWidth(width); err != nil {
+		return err
+	}
+	fs.tileWidth = width
+	return nil
+}
+
+// width returns the width of the log's tiles.
+func (fs *Storage) width() uint64 {
+	if fs.tileWidth == 0 {
+		return layout.TileWidth
+	}
+	return fs.tileWidth
+}
+
 // Sequence assigns the given leaf entry to the next available sequence number.
 // This method will attempt to silently squash duplicate leaves, but it cannot
 // be guaranteed that no duplicate entries will exist.
 // Returns the sequence number assigned to this leaf (if the leaf has already
 // been sequenced it will return the original sequence number and ErrDupeLeaf).
+//
+// Both the sequence file and the leafhash file are written atomically, but a
+// crash between the two leaves an entry which is not represented in the
+// dedupe index. RebuildDedupeIndex can be used to recover from this.
 func (fs *Storage) Sequence(ctx context.Context, leafhash []byte, leaf []byte) (uint64, error) {
+	if fs.readOnly {
+		return 0, ErrReadOnly
+	}
+	if err := fs.validateLeaf.Validate(leaf); err != nil {
+		return 0, err
+	}
 	// 1. Check for dupe leafhash
 	// 2. Write temp file
 	// 3. Hard link temp -> seq file
@@ -146,22 +255,9 @@ func (fs *Storage) Sequence(ctx context.Context, leafhash []byte, leaf []byte) (
 		// Create a leafhash file containing the assigned sequence number.
 		// This isn't infallible though, if we crash after hardlinking the
 		// sequence file above, but before doing this a resubmission of the
-		// same leafhash would be permitted.
-		//
-		// First create a temp file
-		leafTmp := fmt.Sprintf("%s.tmp", leafFQ)
-		if err := createExclusive(leafTmp, []byte(strconv.FormatUint(seq, 16))); err != nil {
-			return 0, fmt.Errorf("couldn't create temporary leafhash file: %w", err)
-		}
-		defer func() {
-			if err := os.Remove(leafTmp); err != nil {
-				klog.Errorf("os.Remove(): %v", err)
-			}
-		}()
-		// Link the temporary file in place, if it already exists we likely crashed after
-		//creating the tmp file above.
-		if err := os.Link(leafTmp, leafFQ); err != nil && !errors.Is(err, os.ErrExist) {
-			return 0, fmt.Errorf("couldn't link temporary leafhash file in place: %w", err)
+		// same leafhash would be permitted until RebuildDedupeIndex is run.
+		if err := linkNew(leafDir, leafFQ, []byte(strconv.FormatUint(seq, 16))); err != nil && !errors.Is(err, os.ErrExist) {
+			return 0, fmt.Errorf("couldn't create leafhash file: %w", err)
 		}
 
 		// All done!
@@ -169,30 +265,54 @@ func (fs *Storage) Sequence(ctx context.Context, leafhash []byte, leaf []byte) (
 	}
 }
 
+// RebuildDedupeIndex scans the sequenced entries starting at begin, and
+// restores any leafhash files which are missing, e.g. due to a crash part way
+// through a call to Sequence.
+// hashLeaf must be the same leaf hash function used when sequencing entries.
+//
+// Entries which are duplicates of an earlier entry are left pointing at the
+// earlier sequence number.
+// Returns the number of leafhash files which were restored.
+func (fs *Storage) RebuildDedupeIndex(ctx context.Context, begin uint64, hashLeaf func([]byte) []byte) (uint64, error) {
+	if fs.readOnly {
+		return 0, ErrReadOnly
+	}
+	restored := uint64(0)
+	_, err := fs.ScanSequenced(ctx, begin, func(seq uint64, entry []byte) error {
+		leafDir, leafFile := layout.LeafPath(fs.rootDir, hashLeaf(entry))
+		if err := os.MkdirAll(leafDir, dirPerm); err != nil {
+			return fmt.Errorf("failed to make leaf directory structure: %w", err)
+		}
+		err := linkNew(leafDir, filepath.Join(leafDir, leafFile), []byte(strconv.FormatUint(seq, 16)))
+		switch {
+		case errors.Is(err, os.ErrExist):
+			return nil
+		case err != nil:
+			return fmt.Errorf("couldn't restore leafhash file for entry %d: %w", seq, err)
+		}
+		klog.V(1).Infof("Restored leafhash file for entry %d", seq)
+		restored++
+		return nil
+	})
+	return restored, err
+}
+
 // Assign directly associates the given leaf data with the provided sequence number.
 // It is an error to attempt to assign data to a previously assigned sequence number,
 // even if the data is identical.
 func (fs *Storage) Assign(_ context.Context, seq uint64, leaf []byte) error {
+	if fs.readOnly {
+		return ErrReadOnly
+	}
 	// Ensure the sequencing directory structure is present:
 	seqDir, seqFile := layout.SeqPath(fs.rootDir, seq)
 	if err := os.MkdirAll(seqDir, dirPerm); err != nil {
 		return fmt.Errorf("failed to make seq directory structure: %w", err)
 	}
 
-	// Write a temp file with the leaf data
-	tmp := filepath.Join(fs.rootDir, fmt.Sprintf(leavesPendingPathFmt, sha256.Sum256(leaf)))
-	if err := createExclusive(tmp, leaf); err != nil {
-		return fmt.Errorf("unable to write temporary file: %w", err)
-	}
-	defer func() {
-		if err := os.Remove(tmp); err != nil {
-			klog.Errorf("os.Remove(): %v", err)
-		}
-	}()
-
-	// Hardlink the sequence file to the temporary file
+	// Write a temp file with the leaf data, and hardlink the sequence file to it.
 	seqPath := filepath.Join(seqDir, seqFile)
-	if err := os.Link(tmp, seqPath); errors.Is(err, os.ErrExist) {
+	if err := linkNew(filepath.Join(fs.rootDir, leavesPendingPath), seqPath, leaf); errors.Is(err, os.ErrExist) {
 		return log.ErrSeqAlreadyAssigned
 	} else if err != nil {
 		return fmt.Errorf("failed to link seq file: %w", err)
@@ -200,6 +320,36 @@ func (fs *Storage) Assign(_ context.Context, seq uint64, leaf []byte) error {
 	return nil
 }
 
+// linkNew atomically creates the file f containing d, by writing d to a
+// uniquely named temporary file in tmpDir and hardlinking f to it.
+// tmpDir must be on the same filesystem as f.
+// It will return an error wrapping os.ErrExist if f already exists.
+// Since the temporary file is unique, a leftover temporary file from an
+// earlier crash does not prevent f from being created.
+func linkNew(tmpDir, f string, d []byte) error {
+	tmp, err := os.CreateTemp(tmpDir, filepath.Base(f)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %w", err)
+	}
+	defer func() {
+		if err := os.Remove(tmp.Name()); err != nil {
+			klog.Errorf("os.Remove(): %v", err)
+		}
+	}()
+	if _, err := tmp.Write(d); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write data to temporary file: %w", err)
+	}
+	if err := tmp.Chmod(filePerm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to set permissions on temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Link(tmp.Name(), f)
+}
+
 // createExclusive creates the named file before writing the data in d to it.
 // It will error if the file already exists, or it's unable to fully write the
 // data & close the file.
@@ -243,11 +393,49 @@ func (fs *Storage) ScanSequenced(_ context.Context, begin uint64, f func(seq uin
 	}
 }
 
+// HighestSequenced returns the highest sequence number which has an entry,
+// or false if there are no entries.
+//
+// This is found by following the highest numbered directory at each level of
+// the seq directory structure, so it isn't affected by gaps in the entries.
+func (fs *Storage) HighestSequenced(_ context.Context) (uint64, bool, error) {
+	dir := filepath.Join(fs.rootDir, "seq")
+	var seq uint64
+	// The first 4 levels are directories, the last is the entry files.
+	for level := 0; level < 5; level++ {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, false, nil
+		} else if err != nil {
+			return 0, false, fmt.Errorf("failed to read seq directory %q: %w", dir, err)
+		}
+		found, highest, name := false, uint64(0), ""
+		for _, e := range entries {
+			if e.IsDir() != (level < 4) {
+				continue
+			}
+			v, err := strconv.ParseUint(e.Name(), 16, 64)
+			if err != nil {
+				continue
+			}
+			if !found || v > highest {
+				found, highest, name = true, v, e.Name()
+			}
+		}
+		if !found {
+			return 0, false, nil
+		}
+		seq = seq<<8 | highest
+		dir = filepath.Join(dir, name)
+	}
+	return seq, true, nil
+}
+
 // GetTile returns the tile at the given tile-level and tile-index.
 // If no complete tile exists at that location, it will attempt to find a
 // partial tile for the given tree size at that location.
 func (fs *Storage) GetTile(_ context.Context, level, index, logSize uint64) (*api.Tile, error) {
-	tileSize := layout.PartialTileSize(level, index, logSize)
+	tileSize := layout.PartialTileSizeForWidth(level, index, logSize, fs.width())
 	p := filepath.Join(layout.TilePath(fs.rootDir, level, index, tileSize))
 	t, err := os.ReadFile(p)
 	if err != nil {
@@ -269,17 +457,21 @@ func (fs *Storage) GetTile(_ context.Context, level, index, logSize uint64) (*ap
 // index parameters, partially populated (i.e. right-hand edge) tiles are
 // stored with a .xx suffix where xx is the number of "tile leaves" in hex.
 func (fs *Storage) StoreTile(_ context.Context, level, index uint64, tile *api.Tile) error {
+	if fs.readOnly {
+		return ErrReadOnly
+	}
 	tileSize := uint64(tile.NumLeaves)
 	klog.V(2).Infof("StoreTile: level %d index %x ts: %x", level, index, tileSize)
-	if tileSize == 0 || tileSize > 256 {
-		return fmt.Errorf("tileSize %d must be > 0 and <= 256", tileSize)
+	width := fs.width()
+	if tileSize == 0 || tileSize > width {
+		return fmt.Errorf("tileSize %d must be > 0 and <= %d", tileSize, width)
 	}
 	t, err := tile.MarshalText()
 	if err != nil {
 		return fmt.Errorf("failed to marshal tile: %w", err)
 	}
 
-	tDir, tFile := layout.TilePath(fs.rootDir, level, index, tileSize%256)
+	tDir, tFile := layout.TilePath(fs.rootDir, level, index, tileSize%width)
 	tPath := filepath.Join(tDir, tFile)
 
 	if err := os.MkdirAll(tDir, dirPerm); err != nil {
@@ -295,7 +487,7 @@ func (fs *Storage) StoreTile(_ context.Context, level, index uint64, tile *api.T
 		return fmt.Errorf("failed to rename temporary tile file: %w", err)
 	}
 
-	if tileSize == 256 {
+	if tileSize == width {
 		partials, err := filepath.Glob(fmt.Sprintf("%s.*", tPath))
 		if err != nil {
 			return fmt.Errorf("failed to list partial tiles for clean up; %w", err)
@@ -320,13 +512,93 @@ func (fs *Storage) StoreTile(_ context.Context, level, index uint64, tile *api.T
 }
 
 // WriteCheckpoint stores a raw log checkpoint on disk.
-func (fs Storage) WriteCheckpoint(_ context.Context, newCPRaw []byte) error {
+// If the log has a checkpoint archive, the checkpoint is added to it before
+// the log's checkpoint is updated.
+func (fs Storage) WriteCheckpoint(ctx context.Context, newCPRaw []byte) error {
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	if err := fs.archiveCheckpoint(ctx, newCPRaw); err != nil {
+		return fmt.Errorf("failed to archive checkpoint: %w", err)
+	}
 	oPath := filepath.Join(fs.rootDir, layout.CheckpointPath)
-	tmp := fmt.Sprintf("%s.tmp", oPath)
-	if err := createExclusive(tmp, newCPRaw); err != nil {
-		return fmt.Errorf("failed to create temporary checkpoint file: %w", err)
+	return writeAtomic(oPath, newCPRaw)
+}
+
+// EnableCheckpointArchive causes all subsequently written checkpoints to also
+// be stored in the log's checkpoint archive. The setting is persisted with the
+// log, and it is safe to call this method on a log which already has an archive.
+func (fs *Storage) EnableCheckpointArchive() error {
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	return os.MkdirAll(filepath.Join(fs.rootDir, checkpointArchiveDir), dirPerm)
+}
+
+// SetCheckpointArchiveCompression configures whether checkpoints subsequently
+// added to the checkpoint archive, and the archive index, are gzip compressed.
+// The log's checkpoint itself is never compressed. Since client.ReadCheckpointAt
+// and client.ListCheckpoints detect compression, an archive may hold a mix of
+// compressed and uncompressed checkpoints.
+func (fs *Storage) SetCheckpointArchiveCompression(compress bool) {
+	fs.compressArchive = compress
+}
+
+// archiveCheckpoint stores a copy of the raw checkpoint in the checkpoint
+// archive, and adds its size to the archive index.
+// This is a no-op if the log has no checkpoint archive directory.
+func (fs Storage) archiveCheckpoint(ctx context.Context, cpRaw []byte) error {
+	if _, err := os.Stat(filepath.Join(fs.rootDir, checkpointArchiveDir)); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	var cp fmtlog.Checkpoint
+	if _, err := cp.Unmarshal(cpRaw); err != nil {
+		return fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	if err := fs.writeArchiveObject(layout.CheckpointArchivePath(cp.Size), cpRaw); err != nil {
+		return err
+	}
+
+	sizes, err := client.ListCheckpoints(ctx, fs.Fetcher())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read archive index: %w", err)
+	}
+	if l := len(sizes); l > 0 && sizes[l-1] >= cp.Size {
+		// Already indexed, e.g. a re-signed checkpoint for the same size.
+		return nil
 	}
-	return os.Rename(tmp, oPath)
+	var index []byte
+	for _, s := range append(sizes, cp.Size) {
+		index = append(index, []byte(strconv.FormatUint(s, 16)+"\n")...)
+	}
+	return fs.writeArchiveObject(layout.CheckpointArchiveIndexPath, index)
+}
+
+// writeArchiveObject atomically writes d to the path p in the checkpoint
+// archive, compressing it if archive compression is enabled.
+func (fs Storage) writeArchiveObject(p string, d []byte) error {
+	if fs.compressArchive {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(d); err != nil {
+			return fmt.Errorf("failed to compress %q: %w", p, err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("failed to compress %q: %w", p, err)
+		}
+		d = b.Bytes()
+	}
+	return writeAtomic(filepath.Join(fs.rootDir, p), d)
+}
+
+// writeAtomic writes d to the file f via a temporary file, so that readers
+// never see partially written contents.
+func writeAtomic(f string, d []byte) error {
+	tmp := fmt.Sprintf("%s.tmp", f)
+	if err := createExclusive(tmp, d); err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	return os.Rename(tmp, f)
 }
 
 // ReadCheckpoint reads and returns the contents of the log checkpoint file.
@@ -334,3 +606,21 @@ func ReadCheckpoint(rootDir string) ([]byte, error) {
 	s := filepath.Join(rootDir, layout.CheckpointPath)
 	return os.ReadFile(s)
 }
+
+// ReadCheckpoint reads and returns the contents of this log's checkpoint file.
+func (fs *Storage) ReadCheckpoint(_ context.Context) ([]byte, error) {
+	return ReadCheckpoint(fs.rootDir)
+}
+
+// Fetcher returns a client.Fetcher which reads objects from the log stored
+// under this storage's root directory.
+func (fs *Storage) Fetcher() client.Fetcher {
+	return func(_ context.Context, p string) ([]byte, error) {
+		// Cleaning the path relative to a root ensures that it can't escape rootDir.
+		cp := path.Clean("/" + p)[1:]
+		if cp == "" {
+			return nil, fmt.Errorf("invalid path %q", p)
+		}
+		return os.ReadFile(filepath.Join(fs.rootDir, filepath.FromSlash(cp)))
+	}
+}
@@ -20,9 +20,13 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/transparency-dev/serverless-log/api"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/client"
 	"github.com/transparency-dev/serverless-log/pkg/log"
 )
 
@@ -209,3 +213,380 @@ func TestAssign(t *testing.T) {
 	}
 
 }
+
+func TestRebuildDedupeIndex(t *testing.T) {
+	ctx := context.Background()
+	hashLeaf := func(l []byte) []byte {
+		h := sha256.Sum256(l)
+		return h[:]
+	}
+
+	d := filepath.Join(t.TempDir(), "storage")
+	s, err := Create(d)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	leaves := [][]byte{{0x00}, {0x01}, {0x02}, {0x03}}
+	for i, leaf := range leaves {
+		if _, err := s.Sequence(ctx, hashLeaf(leaf), leaf); err != nil {
+			t.Fatalf("Sequence %d = %v", i, err)
+		}
+	}
+	// Simulate a crash between writing the seq file and the leafhash file for an entry.
+	if err := os.Remove(filepath.Join(layout.LeafPath(d, hashLeaf(leaves[2])))); err != nil {
+		t.Fatalf("Remove = %v", err)
+	}
+
+	restored, err := s.RebuildDedupeIndex(ctx, 0, hashLeaf)
+	if err != nil {
+		t.Fatalf("RebuildDedupeIndex = %v", err)
+	}
+	if restored != 1 {
+		t.Errorf("RebuildDedupeIndex restored %d entries, want 1", restored)
+	}
+	seq, err := s.Sequence(ctx, hashLeaf(leaves[2]), leaves[2])
+	if !errors.Is(err, log.ErrDupeLeaf) {
+		t.Errorf("Sequence of restored leaf = %v, want ErrDupeLeaf", err)
+	}
+	if seq != 2 {
+		t.Errorf("Sequence of restored leaf returned seq %d, want 2", seq)
+	}
+
+	// A second rebuild should find nothing to do.
+	if restored, err := s.RebuildDedupeIndex(ctx, 0, hashLeaf); err != nil || restored != 0 {
+		t.Errorf("Second RebuildDedupeIndex = %d, %v, want 0, nil", restored, err)
+	}
+}
+
+func TestSequenceIgnoresLeftoverTempFiles(t *testing.T) {
+	ctx := context.Background()
+	d := filepath.Join(t.TempDir(), "storage")
+	s, err := Create(d)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	leaf := []byte("leaf")
+	h := sha256.Sum256(leaf)
+
+	// Simulate temp files left over from a crash part way through an earlier Sequence call.
+	leafDir, leafFile := layout.LeafPath(d, h[:])
+	if err := os.MkdirAll(leafDir, dirPerm); err != nil {
+		t.Fatalf("MkdirAll = %v", err)
+	}
+	for _, f := range []string{filepath.Join(leafDir, leafFile+".tmp"), filepath.Join(d, leavesPendingPath, "leftover.tmp")} {
+		if err := os.WriteFile(f, []byte("junk"), filePerm); err != nil {
+			t.Fatalf("WriteFile = %v", err)
+		}
+	}
+
+	if _, err := s.Sequence(ctx, h[:], leaf); err != nil {
+		t.Fatalf("Sequence = %v", err)
+	}
+	if _, err := s.Sequence(ctx, h[:], leaf); !errors.Is(err, log.ErrDupeLeaf) {
+		t.Fatalf("Sequence of dupe = %v, want ErrDupeLeaf", err)
+	}
+}
+
+func TestCheckpointArchive(t *testing.T) {
+	ctx := context.Background()
+	d := filepath.Join(t.TempDir(), "storage")
+	s, err := Create(d)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	if err := s.EnableCheckpointArchive(); err != nil {
+		t.Fatalf("EnableCheckpointArchive = %v", err)
+	}
+	cps := [][]byte{
+		[]byte("origin\n0\nAAAA\n"),
+		[]byte("origin\n10\nAAAA\n"),
+		// Re-signing the same checkpoint should not add a new index entry.
+		[]byte("origin\n10\nAAAA\n\n— sig\n"),
+		[]byte("origin\n20\nAAAA\n"),
+	}
+	for _, cp := range cps {
+		if err := s.WriteCheckpoint(ctx, cp); err != nil {
+			t.Fatalf("WriteCheckpoint = %v", err)
+		}
+	}
+
+	index, err := os.ReadFile(filepath.Join(d, layout.CheckpointArchiveIndexPath))
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	if got, want := string(index), "0\na\n14\n"; got != want {
+		t.Errorf("Got index %q, want %q", got, want)
+	}
+	got, err := os.ReadFile(filepath.Join(d, layout.CheckpointArchivePath(10)))
+	if err != nil {
+		t.Fatalf("Failed to read archived checkpoint: %v", err)
+	}
+	if diff := cmp.Diff(cps[2], got); diff != "" {
+		t.Errorf("Archived checkpoint diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckpointArchiveCompression(t *testing.T) {
+	ctx := context.Background()
+	d := filepath.Join(t.TempDir(), "storage")
+	s, err := Create(d)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	if err := s.EnableCheckpointArchive(); err != nil {
+		t.Fatalf("EnableCheckpointArchive = %v", err)
+	}
+	cps := map[uint64][]byte{
+		0:  []byte("origin\n0\nAAAA\n"),
+		10: []byte("origin\n10\nAAAA\n"),
+		20: []byte("origin\n20\nAAAA\n"),
+	}
+	// Archives may mix uncompressed and compressed checkpoints.
+	if err := s.WriteCheckpoint(ctx, cps[0]); err != nil {
+		t.Fatalf("WriteCheckpoint = %v", err)
+	}
+	s.SetCheckpointArchiveCompression(true)
+	for _, size := range []uint64{10, 20} {
+		if err := s.WriteCheckpoint(ctx, cps[size]); err != nil {
+			t.Fatalf("WriteCheckpoint = %v", err)
+		}
+	}
+
+	for _, test := range []struct {
+		path           string
+		wantCompressed bool
+	}{
+		{path: layout.CheckpointPath},
+		{path: layout.CheckpointArchivePath(0)},
+		{path: layout.CheckpointArchivePath(10), wantCompressed: true},
+		{path: layout.CheckpointArchiveIndexPath, wantCompressed: true},
+	} {
+		raw, err := os.ReadFile(filepath.Join(d, test.path))
+		if err != nil {
+			t.Fatalf("ReadFile(%q) = %v", test.path, err)
+		}
+		if got := len(raw) > 2 && raw[0] == 0x1f && raw[1] == 0x8b; got != test.wantCompressed {
+			t.Errorf("%q compressed = %t, want %t", test.path, got, test.wantCompressed)
+		}
+	}
+
+	f := s.Fetcher()
+	sizes, err := client.ListCheckpoints(ctx, f)
+	if err != nil {
+		t.Fatalf("ListCheckpoints = %v", err)
+	}
+	if diff := cmp.Diff([]uint64{0, 10, 20}, sizes); diff != "" {
+		t.Errorf("ListCheckpoints diff (-want +got):\n%s", diff)
+	}
+	for size, want := range cps {
+		got, err := client.ReadCheckpointAt(ctx, f, size)
+		if err != nil {
+			t.Fatalf("ReadCheckpointAt(%d) = %v", size, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("ReadCheckpointAt(%d) diff (-want +got):\n%s", size, diff)
+		}
+	}
+}
+
+func TestFetcher(t *testing.T) {
+	ctx := context.Background()
+	d := filepath.Join(t.TempDir(), "storage")
+	s, err := Create(d)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	cp := []byte("origin\n0\nAAAA\n")
+	if err := s.WriteCheckpoint(ctx, cp); err != nil {
+		t.Fatalf("WriteCheckpoint = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(d), "secret"), []byte("secret"), filePerm); err != nil {
+		t.Fatalf("WriteFile = %v", err)
+	}
+
+	f := s.Fetcher()
+	got, err := f(ctx, layout.CheckpointPath)
+	if err != nil {
+		t.Fatalf("Fetch(checkpoint) = %v", err)
+	}
+	if diff := cmp.Diff(cp, got); diff != "" {
+		t.Errorf("Fetched checkpoint diff (-want +got):\n%s", diff)
+	}
+	if _, err := f(ctx, "tile/00/000"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Fetch(missing) = %v, want os.ErrNotExist", err)
+	}
+	// Paths must not escape the storage root.
+	if _, err := f(ctx, "../secret"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Fetch(../secret) = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestHighestSequenced(t *testing.T) {
+	ctx := context.Background()
+	s, err := Create(filepath.Join(t.TempDir(), "storage"))
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	if _, ok, err := s.HighestSequenced(ctx); err != nil || ok {
+		t.Fatalf("HighestSequenced on empty storage = _, %t, %v, want false", ok, err)
+	}
+	for _, seq := range []uint64{0, 1, 0x1ff, 0x100000000, 0x42} {
+		if err := s.Assign(ctx, seq, []byte(strconv.FormatUint(seq, 10))); err != nil {
+			t.Fatalf("Assign(%d) = %v", seq, err)
+		}
+	}
+	got, ok, err := s.HighestSequenced(ctx)
+	if err != nil || !ok {
+		t.Fatalf("HighestSequenced = _, %t, %v", ok, err)
+	}
+	if want := uint64(0x100000000); got != want {
+		t.Errorf("HighestSequenced = %#x, want %#x", got, want)
+	}
+}
+
+func TestStoreTilePaths(t *testing.T) {
+	ctx := context.Background()
+	newTile := func(numLeaves uint) *api.Tile {
+		t := &api.Tile{NumLeaves: numLeaves}
+		for i := uint(0); i < numLeaves; i++ {
+			h := sha256.Sum256([]byte{byte(i)})
+			t.Nodes = append(t.Nodes, h[:])
+		}
+		return t
+	}
+	for _, test := range []struct {
+		level, index uint64
+		numLeaves    uint
+		// logSize is the size of a tree for which this is the tile at level, index.
+		logSize  uint64
+		wantPath string
+	}{
+		{level: 0, index: 0, numLeaves: 1, logSize: 1, wantPath: "tile/00/0000/00/00/00.01"},
+		{level: 0, index: 0, numLeaves: 255, logSize: 255, wantPath: "tile/00/0000/00/00/00.ff"},
+		{level: 0, index: 0, numLeaves: 256, logSize: 256, wantPath: "tile/00/0000/00/00/00"},
+		{level: 0, index: 0, numLeaves: 256, logSize: 257, wantPath: "tile/00/0000/00/00/00"},
+		{level: 0, index: 1, numLeaves: 1, logSize: 257, wantPath: "tile/00/0000/00/00/01.01"},
+		{level: 0, index: 1, numLeaves: 256, logSize: 512, wantPath: "tile/00/0000/00/00/01"},
+		{level: 0, index: 0x1234, numLeaves: 256, logSize: 0x1235 * 256, wantPath: "tile/00/0000/00/12/34"},
+		{level: 1, index: 0, numLeaves: 1, logSize: 257, wantPath: "tile/01/0000/00/00/00.01"},
+		{level: 1, index: 0, numLeaves: 256, logSize: 256 * 256, wantPath: "tile/01/0000/00/00/00"},
+	} {
+		t.Run(test.wantPath, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "storage")
+			s, err := Create(root)
+			if err != nil {
+				t.Fatalf("Create = %v", err)
+			}
+			tile := newTile(test.numLeaves)
+			if err := s.StoreTile(ctx, test.level, test.index, tile); err != nil {
+				t.Fatalf("StoreTile = %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(test.wantPath))); err != nil {
+				t.Errorf("Tile not stored at expected path: %v", err)
+			}
+			got, err := s.GetTile(ctx, test.level, test.index, test.logSize)
+			if err != nil {
+				t.Fatalf("GetTile(%d, %d, %d) = %v", test.level, test.index, test.logSize, err)
+			}
+			if diff := cmp.Diff(tile, got); diff != "" {
+				t.Errorf("GetTile diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSequenceWithLeafValidator(t *testing.T) {
+	ctx := context.Background()
+	s, err := Create(filepath.Join(t.TempDir(), "storage"))
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	s.SetLeafValidator(log.ValidateJSON)
+
+	bad := []byte("not json")
+	badHash := sha256.Sum256(bad)
+	if _, err := s.Sequence(ctx, badHash[:], bad); !errors.As(err, &log.ErrInvalidLeaf{}) {
+		t.Fatalf("Sequence(invalid) = %v, want ErrInvalidLeaf", err)
+	}
+	if _, ok, err := s.HighestSequenced(ctx); err != nil || ok {
+		t.Errorf("HighestSequenced after rejected leaf = _, %t, %v, want false", ok, err)
+	}
+
+	good := []byte(`{"ok": true}`)
+	goodHash := sha256.Sum256(good)
+	seq, err := s.Sequence(ctx, goodHash[:], good)
+	if err != nil {
+		t.Fatalf("Sequence(valid) = %v", err)
+	}
+	if seq != 0 {
+		t.Errorf("Sequence(valid) = %d, want 0", seq)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	ctx := context.Background()
+	d := filepath.Join(t.TempDir(), "storage")
+	s, err := Create(d)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	leaf := []byte("leaf")
+	if _, err := s.Sequence(ctx, sha256.New().Sum(leaf), leaf); err != nil {
+		t.Fatalf("Sequence = %v", err)
+	}
+	cp := []byte("origin\n1\nAAAA\n")
+	if err := s.WriteCheckpoint(ctx, cp); err != nil {
+		t.Fatalf("WriteCheckpoint = %v", err)
+	}
+
+	ro, err := OpenReadOnly(d)
+	if err != nil {
+		t.Fatalf("OpenReadOnly = %v", err)
+	}
+	got, err := ro.ReadCheckpoint(ctx)
+	if err != nil {
+		t.Fatalf("ReadCheckpoint = %v", err)
+	}
+	if diff := cmp.Diff(cp, got); diff != "" {
+		t.Errorf("Checkpoint diff (-want +got):\n%s", diff)
+	}
+	var scanned [][]byte
+	if _, err := ro.ScanSequenced(ctx, 0, func(_ uint64, e []byte) error {
+		scanned = append(scanned, e)
+		return nil
+	}); err != nil {
+		t.Fatalf("ScanSequenced = %v", err)
+	}
+	if diff := cmp.Diff([][]byte{leaf}, scanned); diff != "" {
+		t.Errorf("Scanned entries diff (-want +got):\n%s", diff)
+	}
+
+	for _, test := range []struct {
+		name string
+		f    func() error
+	}{
+		{name: "Sequence", f: func() error { _, err := ro.Sequence(ctx, []byte("hash"), []byte("new")); return err }},
+		{name: "Assign", f: func() error { return ro.Assign(ctx, 1, []byte("new")) }},
+		{name: "RebuildDedupeIndex", f: func() error {
+			_, err := ro.RebuildDedupeIndex(ctx, 0, func(b []byte) []byte { return b })
+			return err
+		}},
+		{name: "StoreTile", f: func() error { return ro.StoreTile(ctx, 0, 0, nil) }},
+		{name: "WriteCheckpoint", f: func() error { return ro.WriteCheckpoint(ctx, []byte("origin\n2\nAAAA\n")) }},
+		{name: "EnableCheckpointArchive", f: func() error { return ro.EnableCheckpointArchive() }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.f(); !errors.Is(err, ErrReadOnly) {
+				t.Errorf("%s = %v, want ErrReadOnly", test.name, err)
+			}
+		})
+	}
+
+	// Nothing should have been written by the read-only storage.
+	if got, err := ReadCheckpoint(d); err != nil || !cmp.Equal(got, cp) {
+		t.Errorf("ReadCheckpoint = %q, %v; want %q", got, err, cp)
+	}
+	if _, err := os.Stat(filepath.Join(d, checkpointArchiveDir)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Checkpoint archive was created: %v", err)
+	}
+}
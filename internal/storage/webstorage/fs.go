@@ -60,6 +60,8 @@ type Storage struct {
 	nextSeq uint64
 	// checkpoint is the latest known checkpoint of the log.
 	checkpoint fmtlog.Checkpoint
+	// tileWidth, if non-zero, overrides the default width of the log's tiles.
+	tileWidth uint64
 }
 
 const leavesPendingPathFmt = "leaves/pending/%0x"
@@ -236,11 +238,30 @@ func (fs *Storage) ScanSequenced(_ context.Context, begin uint64, f func(seq uin
 	}
 }
 
+// SetTileWidth configures the storage for a log whose tiles have the given
+// width, rather than layout.TileWidth. All tools accessing the log must use
+// the same width.
+func (fs *Storage) SetTileWidth(width uint64) error {
+	if err := layout.ValidateTileWidth(width); err != nil {
+		return err
+	}
+	fs.tileWidth = width
+	return nil
+}
+
+// width returns the width of the log's tiles.
+func (fs *Storage) width() uint64 {
+	if fs.tileWidth == 0 {
+		return layout.TileWidth
+	}
+	return fs.tileWidth
+}
+
 // GetTile returns the tile at the given tile-level and tile-index.
 // If no complete tile exists at that location, it will attempt to find a
 // partial tile for the given tree size at that location.
 func (fs *Storage) GetTile(_ context.Context, level, index, logSize uint64) (*api.Tile, error) {
-	tileSize := layout.PartialTileSize(level, index, logSize)
+	tileSize := layout.PartialTileSizeForWidth(level, index, logSize, fs.width())
 	p := filepath.Join(layout.TilePath(fs.root, level, index, tileSize))
 	t, err := get(p)
 	if err != nil {
@@ -263,15 +284,16 @@ func (fs *Storage) GetTile(_ context.Context, level, index, logSize uint64) (*ap
 // stored with a .xx suffix where xx is the number of "tile leaves" in hex.
 func (fs *Storage) StoreTile(_ context.Context, level, index uint64, tile *api.Tile) error {
 	tileSize := uint64(tile.NumLeaves)
-	if tileSize == 0 || tileSize > 256 {
-		return fmt.Errorf("tileSize %d must be > 0 and <= 256", tileSize)
+	width := fs.width()
+	if tileSize == 0 || tileSize > width {
+		return fmt.Errorf("tileSize %d must be > 0 and <= %d", tileSize, width)
 	}
 	t, err := tile.MarshalText()
 	if err != nil {
 		return fmt.Errorf("failed to marshal tile: %w", err)
 	}
 
-	tDir, tFile := layout.TilePath(fs.root, level, index, tileSize%256)
+	tDir, tFile := layout.TilePath(fs.root, level, index, tileSize%width)
 	tPath := filepath.Join(tDir, tFile)
 
 	if err := createExclusive(tPath, t); err != nil {
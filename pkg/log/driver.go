@@ -0,0 +1,190 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+// DriverOption configures optional behaviour of a Driver.
+type DriverOption func(*driverOpts)
+
+type driverOpts struct {
+	pollInterval time.Duration
+	batchSize    uint64
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	integrate    []IntegrateOption
+}
+
+// WithPollInterval sets how long the Driver waits before looking for new
+// sequenced entries, once it has integrated all of those it found.
+// The default is 1 second.
+func WithPollInterval(d time.Duration) DriverOption {
+	return func(o *driverOpts) {
+		o.pollInterval = d
+	}
+}
+
+// WithBatchSize causes the Driver to publish a checkpoint after integrating
+// each batch of at most n entries, rather than integrating all pending entries
+// before publishing a checkpoint.
+func WithBatchSize(n uint64) DriverOption {
+	return func(o *driverOpts) {
+		o.batchSize = n
+	}
+}
+
+// WithBackoff sets the bounds of the exponential backoff used by the Driver to
+// retry after a failed integration. The default is 1 second to 1 minute.
+func WithBackoff(min, max time.Duration) DriverOption {
+	return func(o *driverOpts) {
+		o.minBackoff = min
+		o.maxBackoff = max
+	}
+}
+
+// WithIntegrateOptions passes opts to each call the Driver makes to Integrate,
+// e.g. WithGapDetection or WithObserver. WithCheckpointInterval must not be
+// used, see WithBatchSize instead.
+func WithIntegrateOptions(opts ...IntegrateOption) DriverOption {
+	return func(o *driverOpts) {
+		o.integrate = append(o.integrate, opts...)
+	}
+}
+
+// Driver continuously integrates newly sequenced entries into a log, and
+// signs and publishes a checkpoint for the new tree after each integration.
+// This allows a log to be run by a long-lived process, rather than by
+// repeatedly invoking a tool or function.
+//
+// The Driver must be the only writer of the log's checkpoint while it runs.
+type Driver struct {
+	st      Storage
+	h       merkle.LogHasher
+	origin  string
+	signers []note.Signer
+	opts    driverOpts
+
+	// size is the size of the latest checkpoint published.
+	size uint64
+}
+
+// NewDriver returns a Driver which extends the log in st, whose latest
+// checkpoint is cp. New checkpoints are given the log's origin, and signed by
+// signers, the first of which must be the log's signer; any further signers,
+// e.g. witnesses, add their cosignatures.
+func NewDriver(st Storage, h merkle.LogHasher, cp log.Checkpoint, origin string, signers []note.Signer, opts ...DriverOption) (*Driver, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("at least one signer is required")
+	}
+	o := driverOpts{
+		pollInterval: time.Second,
+		minBackoff:   time.Second,
+		maxBackoff:   time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.pollInterval <= 0 || o.minBackoff <= 0 || o.maxBackoff < o.minBackoff {
+		return nil, fmt.Errorf("invalid poll interval %v or backoff %v-%v", o.pollInterval, o.minBackoff, o.maxBackoff)
+	}
+	return &Driver{
+		st:      st,
+		h:       h,
+		origin:  origin,
+		signers: signers,
+		opts:    o,
+		size:    cp.Size,
+	}, nil
+}
+
+// Run integrates and publishes new entries until ctx is done, and then
+// returns nil. Failures are logged, and retried with backoff, except that
+// ErrLogFrozen is returned if the log has been frozen.
+func (d *Driver) Run(ctx context.Context) error {
+	backoff := time.Duration(0)
+	for {
+		wait := d.opts.pollInterval
+		integrated, err := d.step(ctx)
+		switch {
+		case errors.Is(err, ErrLogFrozen):
+			return err
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil
+			}
+			backoff = min(max(2*backoff, d.opts.minBackoff), d.opts.maxBackoff)
+			klog.Warningf("Failed to integrate from size %d, retrying in %v: %v", d.size, backoff, err)
+			wait = backoff
+		case integrated:
+			// More entries may have been sequenced meanwhile, so look for them right away.
+			backoff = 0
+			wait = 0
+		default:
+			backoff = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// step integrates any pending entries, publishing a checkpoint for each batch.
+// Returns true if any entries were integrated.
+func (d *Driver) step(ctx context.Context) (bool, error) {
+	opts := d.opts.integrate
+	if d.opts.batchSize > 0 {
+		// Publish all but the final batch through the Integrate callback.
+		opts = append(opts[:len(opts):len(opts)], WithCheckpointInterval(d.opts.batchSize, d.publish))
+	}
+	cp, err := Integrate(ctx, d.size, d.st, d.h, opts...)
+	if err != nil {
+		return false, err
+	}
+	if cp == nil {
+		return false, nil
+	}
+	if err := d.publish(ctx, cp); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// publish signs and writes the checkpoint for a newly integrated tree.
+func (d *Driver) publish(ctx context.Context, cp *log.Checkpoint) error {
+	cp.Origin = d.origin
+	raw, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, d.signers...)
+	if err != nil {
+		return fmt.Errorf("failed to sign checkpoint: %w", err)
+	}
+	if err := d.st.WriteCheckpoint(ctx, raw); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	klog.Infof("Published checkpoint at size %d", cp.Size)
+	d.size = cp.Size
+	return nil
+}
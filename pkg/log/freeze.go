@@ -0,0 +1,125 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/client"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// ErrLogFrozen is returned by Integrate when the log's latest checkpoint marks
+// it as frozen, see Freeze.
+var ErrLogFrozen = errors.New("log is frozen")
+
+// CheckpointReader may be implemented by Storage implementations which can
+// read back the log's latest checkpoint. This is needed by Freeze.
+type CheckpointReader interface {
+	// ReadCheckpoint returns the raw latest checkpoint of the log. An error
+	// wrapping os.ErrNotExist is returned if the log has no checkpoint.
+	ReadCheckpoint(ctx context.Context) ([]byte, error)
+}
+
+// Freeze retires the log in st by replacing its latest checkpoint with one
+// for the same tree which carries the frozen extension line, signed by signer.
+// Clients seeing this checkpoint know to stop expecting the log to grow, and
+// Integrate will refuse to add any further entries.
+//
+// The Storage must implement CheckpointReader. The existing checkpoint must
+// have the given origin, and any cosignatures on it are dropped. Freezing an
+// already frozen log does nothing.
+func Freeze(ctx context.Context, st Storage, signer note.Signer, origin string) error {
+	cr, ok := st.(CheckpointReader)
+	if !ok {
+		return errors.New("storage cannot read checkpoints, so can't be frozen")
+	}
+	raw, err := cr.ReadCheckpoint(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	body, err := checkpointBody(raw)
+	if err != nil {
+		return err
+	}
+	cp := &log.Checkpoint{}
+	ext, err := cp.Unmarshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	if cp.Origin != origin {
+		return fmt.Errorf("checkpoint has origin %q, want %q", cp.Origin, origin)
+	}
+	if client.CheckpointFrozen(ext) {
+		return nil
+	}
+	text := string(body) + client.FrozenExtension()
+	frozen, err := note.Sign(&note.Note{Text: text}, signer)
+	if err != nil {
+		return fmt.Errorf("failed to sign checkpoint: %w", err)
+	}
+	if err := st.WriteCheckpoint(ctx, frozen); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// checkFrozen returns ErrLogFrozen if st can fetch the log's checkpoint, and
+// that checkpoint marks the log as frozen. The checkpoint's signatures are not
+// verified, since it was read directly from the log's own storage.
+//
+// The checkpoint is fetched rather than read with ReadCheckpoint, since some
+// storage implementations record the version of the checkpoint returned by
+// ReadCheckpoint as the one which the next WriteCheckpoint must replace.
+func checkFrozen(ctx context.Context, st Storage) error {
+	fs, ok := st.(interface{ Fetcher() client.Fetcher })
+	if !ok {
+		return nil
+	}
+	raw, err := fs.Fetcher()(ctx, layout.CheckpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to fetch checkpoint: %w", err)
+	}
+	body, err := checkpointBody(raw)
+	if err != nil {
+		return err
+	}
+	ext, err := (&log.Checkpoint{}).Unmarshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	if client.CheckpointFrozen(ext) {
+		return ErrLogFrozen
+	}
+	return nil
+}
+
+// checkpointBody returns the text of the signed checkpoint note raw, i.e.
+// everything up to the blank line which precedes the signatures.
+func checkpointBody(raw []byte) ([]byte, error) {
+	i := bytes.Index(raw, []byte("\n\n"))
+	if i < 0 {
+		return nil, errors.New("malformed checkpoint: no signature block")
+	}
+	return raw[:i+1], nil
+}
@@ -17,10 +17,12 @@
 package log
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/transparency-dev/formats/log"
 	"github.com/transparency-dev/merkle"
@@ -65,15 +67,335 @@ var (
 	ErrSeqAlreadyAssigned = errors.New("sequence number already assigned")
 )
 
+// HighestSequencer may be implemented by Storage implementations which can
+// report the highest sequence number assigned to any entry, regardless of
+// whether all lower sequence numbers have been assigned too. This is used to
+// detect gaps in the sequenced entries, see WithGapDetection.
+type HighestSequencer interface {
+	// HighestSequenced returns the highest assigned sequence number, or false
+	// if no sequence numbers have been assigned.
+	HighestSequenced(ctx context.Context) (uint64, bool, error)
+}
+
+// IntegrateOption configures optional behaviour of Integrate.
+type IntegrateOption func(*integrateOpts)
+
+type integrateOpts struct {
+	checkpointInterval uint64
+	publish            func(ctx context.Context, cp *log.Checkpoint) error
+	// validateRoot, if set, is the expected root hash of the tree at fromSize.
+	validateRoot []byte
+	// maxPending, if > 0, is the maximum number of entries Integrate will integrate.
+	maxPending uint64
+	// detectGaps causes Integrate to check for gaps in the sequenced entries.
+	detectGaps bool
+	// observer, if set, is told about the work done by Integrate.
+	observer IntegrateObserver
+	// tileWidth is the width of the log's tiles.
+	tileWidth uint64
+}
+
+// IntegrateStats describes the work done by a call to Integrate.
+type IntegrateStats struct {
+	// FromSize is the size of the tree before integration.
+	FromSize uint64
+	// ToSize is the size of the tree after integration, i.e. the size of the
+	// returned checkpoint. If Err is set, it is the size up to which entries
+	// were integrated before the failure, though these entries may not be
+	// committed to by any published checkpoint.
+	ToSize uint64
+	// EntriesAdded is the number of entries integrated, i.e. ToSize-FromSize.
+	EntriesAdded uint64
+	// TilesWritten is the number of tiles stored, including any stored by an
+	// integration which then failed.
+	TilesWritten uint64
+	// Duration is how long the call to Integrate took.
+	Duration time.Duration
+	// Err is the error returned by Integrate, if any.
+	Err error
+}
+
+// IntegrateObserver is told about each call to Integrate, e.g. so that it can
+// export metrics which allow alerting on integration falling behind.
+type IntegrateObserver interface {
+	// ObserveIntegrate is called once Integrate has finished, whether or not
+	// it was successful.
+	ObserveIntegrate(ctx context.Context, stats IntegrateStats)
+}
+
+// IntegrateObserverFunc is an adapter which allows a function to be used as an
+// IntegrateObserver.
+type IntegrateObserverFunc func(ctx context.Context, stats IntegrateStats)
+
+// ObserveIntegrate calls f(ctx, stats).
+func (f IntegrateObserverFunc) ObserveIntegrate(ctx context.Context, stats IntegrateStats) {
+	f(ctx, stats)
+}
+
+// ErrTooManyPending is returned by Integrate when a limit has been set on the
+// number of pending entries it may integrate, and more entries than that are
+// waiting to be integrated.
+type ErrTooManyPending struct {
+	// Max is the configured limit.
+	Max uint64
+}
+
+func (e ErrTooManyPending) Error() string {
+	return fmt.Sprintf("more than %d sequenced entries are pending integration, refusing to integrate without a higher limit", e.Max)
+}
+
+// ErrSequenceGap is returned by Integrate when gap detection is enabled, and
+// an entry is missing below the highest assigned sequence number. This
+// indicates a bug in, or a crash of, whatever sequenced the entries.
+type ErrSequenceGap struct {
+	// Missing is the lowest sequence number with no entry.
+	Missing uint64
+	// Highest is the highest sequence number which has an entry.
+	Highest uint64
+}
+
+func (e ErrSequenceGap) Error() string {
+	return fmt.Sprintf("sequenced entries have a gap: entry %d is missing, but entry %d exists", e.Missing, e.Highest)
+}
+
+// ErrInvalidFrontier is returned by Integrate when frontier validation is
+// enabled, and the stored tiles for the existing tree are corrupt.
+type ErrInvalidFrontier struct {
+	// Size is the size of the tree whose frontier was validated.
+	Size uint64
+	// Err describes the problem with the frontier.
+	Err error
+}
+
+func (e ErrInvalidFrontier) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrInvalidFrontier) Error() string {
+	return fmt.Sprintf("invalid frontier for tree size %d: %v", e.Size, e.Err)
+}
+
+// WithCheckpointInterval causes Integrate to integrate sequenced entries in
+// batches of at most n entries, calling publish with the checkpoint for each
+// intermediate tree state.
+//
+// This allows readers and witnesses to make progress during a very large
+// integration, and means that a failure part way through does not lose all
+// of the work done up to that point.
+// The publish function is expected to sign and write the checkpoint it is
+// passed; note that it is not called for the final checkpoint, which is
+// returned by Integrate as usual.
+func WithCheckpointInterval(n uint64, publish func(ctx context.Context, cp *log.Checkpoint) error) IntegrateOption {
+	return func(o *integrateOpts) {
+		o.checkpointInterval = n
+		o.publish = publish
+	}
+}
+
+// WithFrontierValidation causes Integrate to check, before appending any new
+// entries, that the tiles it reads for the frontier of the existing tree are
+// internally consistent, and that they commit to root, which should be the
+// root hash of the checkpoint for the existing tree.
+//
+// If these checks fail, Integrate returns ErrInvalidFrontier rather than
+// building on top of corrupt state.
+func WithFrontierValidation(root []byte) IntegrateOption {
+	return func(o *integrateOpts) {
+		o.validateRoot = root
+	}
+}
+
+// WithMaxPending causes Integrate to refuse to integrate anything, returning
+// ErrTooManyPending, if more than n sequenced entries are pending integration.
+//
+// This guards against unexpectedly integrating an enormous backlog, e.g. one
+// staged by a misbehaving sequencer. Checking the backlog requires reading up
+// to n+1 pending entries before integration begins.
+func WithMaxPending(n uint64) IntegrateOption {
+	return func(o *integrateOpts) {
+		o.maxPending = n
+	}
+}
+
+// WithGapDetection causes Integrate to refuse to integrate anything, returning
+// ErrSequenceGap, if any sequenced entry is missing below the highest assigned
+// sequence number. Without this, Integrate would silently integrate only the
+// entries before the gap.
+//
+// The Storage passed to Integrate must implement HighestSequencer. Checking for
+// gaps requires reading all pending entries before integration begins.
+func WithGapDetection() IntegrateOption {
+	return func(o *integrateOpts) {
+		o.detectGaps = true
+	}
+}
+
+// WithObserver causes Integrate to report the work it did to o when it
+// returns.
+func WithObserver(o IntegrateObserver) IntegrateOption {
+	return func(opts *integrateOpts) {
+		opts.observer = o
+	}
+}
+
+// WithTileWidth causes Integrate to build tiles of the given width, rather
+// than layout.TileWidth. The width must be valid according to
+// layout.ValidateTileWidth, and the Storage passed to Integrate must be
+// configured with the same width.
+func WithTileWidth(width uint64) IntegrateOption {
+	return func(o *integrateOpts) {
+		o.tileWidth = width
+	}
+}
+
+// errBatchFull is used to stop scanning sequenced entries once a batch is full.
+var errBatchFull = errors.New("batch full")
+
 // Integrate adds all sequenced entries greater than fromSize into the tree.
 // Returns an updated Checkpoint, or an error.
-func Integrate(ctx context.Context, fromSize uint64, st Storage, h merkle.LogHasher) (*log.Checkpoint, error) {
+// If there were no entries to integrate, a nil Checkpoint is returned.
+//
+// If the Storage has a Fetcher method which returns a client.Fetcher for the
+// log, as the file and in-memory storage implementations do, and the log's
+// checkpoint marks it as frozen, ErrLogFrozen is returned without integrating
+// anything.
+func Integrate(ctx context.Context, fromSize uint64, st Storage, h merkle.LogHasher, opts ...IntegrateOption) (*log.Checkpoint, error) {
+	o := &integrateOpts{tileWidth: layout.TileWidth}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := layout.ValidateTileWidth(o.tileWidth); err != nil {
+		return nil, err
+	}
+	if o.observer == nil {
+		return integrate(ctx, fromSize, st, h, o, &IntegrateStats{})
+	}
+
+	start := time.Now()
+	stats := IntegrateStats{FromSize: fromSize}
+	cp, err := integrate(ctx, fromSize, st, h, o, &stats)
+	stats.ToSize = fromSize + stats.EntriesAdded
+	stats.Duration = time.Since(start)
+	stats.Err = err
+	o.observer.ObserveIntegrate(ctx, stats)
+	return cp, err
+}
+
+// integrate implements Integrate, recording the work done in stats.
+func integrate(ctx context.Context, fromSize uint64, st Storage, h merkle.LogHasher, o *integrateOpts, stats *IntegrateStats) (*log.Checkpoint, error) {
+	if err := checkFrozen(ctx, st); err != nil {
+		return nil, err
+	}
+	if o.maxPending > 0 {
+		if err := checkPending(ctx, fromSize, o.maxPending, st); err != nil {
+			return nil, err
+		}
+	}
+	if o.detectGaps {
+		if err := checkGaps(ctx, fromSize, st); err != nil {
+			return nil, err
+		}
+	}
+	if o.checkpointInterval == 0 {
+		return integrateBatch(ctx, fromSize, 0, o.validateRoot, o.tileWidth, st, h, stats)
+	}
+
+	// Integrate in batches, publishing the checkpoint for a batch only once
+	// we know that there is a following batch - the final checkpoint is left
+	// to the caller to publish.
+	var latest *log.Checkpoint
+	wantRoot := o.validateRoot
+	for {
+		cp, err := integrateBatch(ctx, fromSize, o.checkpointInterval, wantRoot, o.tileWidth, st, h, stats)
+		if err != nil {
+			return nil, err
+		}
+		if cp == nil {
+			break
+		}
+		if latest != nil {
+			if err := o.publish(ctx, latest); err != nil {
+				return nil, fmt.Errorf("failed to publish intermediate checkpoint at size %d: %w", latest.Size, err)
+			}
+			klog.Infof("Published intermediate checkpoint at size %d", latest.Size)
+		}
+		latest = cp
+		if cp.Size-fromSize < o.checkpointInterval {
+			// Short batch, so there's nothing more to integrate.
+			break
+		}
+		fromSize = cp.Size
+		if wantRoot != nil {
+			wantRoot = cp.Hash
+		}
+	}
+	return latest, nil
+}
+
+// checkPending returns ErrTooManyPending if more than max sequenced entries
+// greater than fromSize are pending integration.
+func checkPending(ctx context.Context, fromSize, max uint64, st Storage) error {
+	n := uint64(0)
+	_, err := st.ScanSequenced(ctx, fromSize, func(uint64, []byte) error {
+		n++
+		if n > max {
+			return ErrTooManyPending{Max: max}
+		}
+		return nil
+	})
+	if errors.As(err, &ErrTooManyPending{}) {
+		return ErrTooManyPending{Max: max}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to count pending entries: %w", err)
+	}
+	return nil
+}
+
+// checkGaps returns ErrSequenceGap if there's a missing entry between fromSize
+// and the highest sequence number assigned in st.
+func checkGaps(ctx context.Context, fromSize uint64, st Storage) error {
+	hs, ok := st.(HighestSequencer)
+	if !ok {
+		return errors.New("storage does not support gap detection")
+	}
+	end := fromSize
+	if _, err := st.ScanSequenced(ctx, fromSize, func(seq uint64, _ []byte) error {
+		end = seq + 1
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to scan pending entries: %w", err)
+	}
+	highest, ok, err := hs.HighestSequenced(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get highest sequence number: %w", err)
+	}
+	if ok && highest >= end {
+		return ErrSequenceGap{Missing: end, Highest: highest}
+	}
+	return nil
+}
+
+// integrateBatch adds up to maxEntries sequenced entries greater than fromSize into the tree.
+// If maxEntries is zero, all available sequenced entries will be integrated.
+// If wantRoot is non-nil, the existing tree's frontier is validated against it first.
+// Tiles are built with the given width.
+// The number of entries integrated and tiles stored are added to stats.
+// Returns an updated Checkpoint, nil if there was nothing to integrate, or an error.
+func integrateBatch(ctx context.Context, fromSize, maxEntries uint64, wantRoot []byte, tileWidth uint64, st Storage, h merkle.LogHasher, stats *IntegrateStats) (*log.Checkpoint, error) {
 	getTile := func(l, i uint64) (*api.Tile, error) {
 		return st.GetTile(ctx, l, i, fromSize)
 	}
 
-	hashes, err := client.FetchRangeNodes(ctx, fromSize, func(_ context.Context, l, i uint64) (*api.Tile, error) {
-		return getTile(l, i)
+	hashes, err := client.FetchRangeNodesForWidth(ctx, fromSize, tileWidth, func(_ context.Context, l, i uint64) (*api.Tile, error) {
+		t, err := getTile(l, i)
+		if err != nil || wantRoot == nil {
+			return t, err
+		}
+		if err := client.VerifyTile(t, h); err != nil {
+			return nil, ErrInvalidFrontier{Size: fromSize, Err: fmt.Errorf("tile at level %d index %d: %v", l, i, err)}
+		}
+		return t, nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch compact range nodes: %w", err)
@@ -91,22 +413,35 @@ func Integrate(ctx context.Context, fromSize uint64, st Storage, h merkle.LogHas
 		return nil, fmt.Errorf("invalid log state, unable to recalculate root: %w", err)
 	}
 
+	if fromSize == 0 {
+		// The compact range has no root hash for the empty tree.
+		r = h.EmptyRoot()
+	}
+	if wantRoot != nil && !bytes.Equal(r, wantRoot) {
+		return nil, ErrInvalidFrontier{Size: fromSize, Err: fmt.Errorf("tiles have root hash %x, but checkpoint has %x", r, wantRoot)}
+	}
+
 	klog.Infof("Loaded state with roothash %x", r)
 
 	// Create a new compact range which represents the update to the tree
 	newRange := rf.NewEmptyRange(fromSize)
-	tc := tileCache{m: make(map[tileKey]*api.Tile), getTile: getTile}
-	n, err := st.ScanSequenced(ctx,
+	tc := tileCache{m: make(map[tileKey]*api.Tile), getTile: getTile, width: tileWidth}
+	n := uint64(0)
+	_, err = st.ScanSequenced(ctx,
 		fromSize,
 		func(seq uint64, entry []byte) error {
+			if maxEntries > 0 && n >= maxEntries {
+				return errBatchFull
+			}
 			lh := h.HashLeaf(entry)
 			// Update range and set nodes
 			if err := newRange.Append(lh, tc.Visit); err != nil {
 				return fmt.Errorf("newRange.Append(): %v", err)
 			}
+			n++
 			return nil
 		})
-	if err != nil {
+	if err != nil && !errors.Is(err, errBatchFull) {
 		return nil, fmt.Errorf("error while integrating: %w", err)
 	}
 	if n == 0 {
@@ -135,7 +470,9 @@ func Integrate(ctx context.Context, fromSize uint64, st Storage, h merkle.LogHas
 		if err := st.StoreTile(ctx, k.level, k.index, t); err != nil {
 			return nil, fmt.Errorf("failed to store tile at level %d index %d: %w", k.level, k.index, err)
 		}
+		stats.TilesWritten++
 	}
+	stats.EntriesAdded += n
 
 	// Finally, return a new checkpoint struct to the caller, so they can sign &
 	// persist it.
@@ -170,6 +507,8 @@ type tileCache struct {
 	m map[tileKey]*api.Tile
 
 	getTile func(level, index uint64) (*api.Tile, error)
+	// width is the width of the tiles.
+	width uint64
 }
 
 // Visit should be called once for each newly set non-ephemeral node in the
@@ -179,7 +518,7 @@ type tileCache struct {
 // it from disk (or create a new empty in-memory tile if it doesn't exist), and
 // update it by setting the node corresponding to id to the value hash.
 func (tc tileCache) Visit(id compact.NodeID, hash []byte) {
-	tileLevel, tileIndex, nodeLevel, nodeIndex := layout.NodeCoordsToTileAddress(uint64(id.Level), uint64(id.Index))
+	tileLevel, tileIndex, nodeLevel, nodeIndex := layout.NodeCoordsToTileAddressForWidth(uint64(id.Level), uint64(id.Index), tc.width)
 	tileKey := tileKey{level: tileLevel, index: tileIndex}
 	tile := tc.m[tileKey]
 	if tile == nil {
@@ -195,7 +534,7 @@ func (tc tileCache) Visit(id compact.NodeID, hash []byte) {
 			// This is a brand new tile.
 			created = true
 			tile = &api.Tile{
-				Nodes: make([][]byte, 0, 256*2),
+				Nodes: make([][]byte, 0, tc.width*2),
 			}
 		}
 		klog.V(2).Infof("GetTile: %v new: %v", tileKey, created)
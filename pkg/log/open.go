@@ -0,0 +1,103 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/serverless-log/client"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// StorageOpener opens the storage of existing logs located by URLs with a
+// particular scheme. Storage implementations make themselves available to
+// Open by registering an opener with RegisterStorage.
+type StorageOpener interface {
+	// ReadCheckpoint returns the raw current checkpoint of the log at u.
+	ReadCheckpoint(ctx context.Context, u *url.URL) ([]byte, error)
+	// Open returns the storage of the log at u, whose current checkpoint
+	// commits to size entries.
+	Open(ctx context.Context, u *url.URL, size uint64) (Storage, error)
+}
+
+var (
+	openersMu sync.RWMutex
+	openers   = make(map[string]StorageOpener)
+)
+
+// RegisterStorage makes the storage implementation opened by o available to
+// Open for log URLs with the given scheme.
+// It panics if an opener is already registered for the scheme.
+func RegisterStorage(scheme string, o StorageOpener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+	if _, ok := openers[scheme]; ok {
+		panic(fmt.Sprintf("storage already registered for scheme %q", scheme))
+	}
+	openers[scheme] = o
+}
+
+// Open opens the storage of the existing log at logURL, along with its current
+// checkpoint, which must verify with v and have the given origin.
+// The storage implementation is chosen by the scheme of logURL, e.g.
+// file:///path/to/log, and must have been registered with RegisterStorage.
+//
+// This is the counterpart of creating a new log, allowing tools to verify or
+// extend an existing log without regard to where it's stored.
+func Open(ctx context.Context, logURL string, v note.Verifier, origin string) (Storage, *log.Checkpoint, error) {
+	u, err := url.Parse(logURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid log URL %q: %w", logURL, err)
+	}
+	openersMu.RLock()
+	o, ok := openers[u.Scheme]
+	openersMu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported log URL scheme %q, registered schemes are: %s", u.Scheme, registeredSchemes())
+	}
+
+	cpRaw, err := o.ReadCheckpoint(ctx, u)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	cp, _, _, err := client.ParseCheckpoint(cpRaw, origin, v)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	st, err := o.Open(ctx, u, cp.Size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	return st, cp, nil
+}
+
+// registeredSchemes returns a human readable list of the schemes which have
+// registered storage.
+func registeredSchemes() string {
+	openersMu.RLock()
+	defer openersMu.RUnlock()
+	schemes := make([]string, 0, len(openers))
+	for s := range openers {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return strings.Join(schemes, ", ")
+}
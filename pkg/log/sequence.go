@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SequenceLeaf is a leaf to be sequenced by BatchSequence, along with its
+// leaf hash.
+//
+// It's an alias of an unnamed struct type, so that storage implementations can
+// implement BatchSequencer without needing to import this package.
+type SequenceLeaf = struct {
+	Hash []byte
+	Data []byte
+}
+
+// BatchSequencer may be implemented by Storage implementations which can
+// sequence many leaves with fewer round trips than calling Sequence for each.
+type BatchSequencer interface {
+	// BatchSequence assigns sequence numbers to the passed in leaves, and
+	// returns the sequence number assigned to each.
+	//
+	// If a leaf duplicates one which has already been sequenced, or one
+	// earlier in the batch, the storage implementation may return the sequence
+	// number associated with the earlier instance, along with true in dupes.
+	// The leaves which are newly sequenced should be assigned contiguous
+	// sequence numbers in the order given, unless another sequencer is
+	// concurrently adding to the log.
+	BatchSequence(ctx context.Context, leaves []SequenceLeaf) (seqs []uint64, dupes []bool, err error)
+}
+
+// BatchSequence sequences leaves in st, using its BatchSequence method if it
+// implements BatchSequencer, and otherwise by calling Sequence for each leaf in
+// turn. Returns the sequence number assigned to each leaf, and whether it was a
+// duplicate, as described by BatchSequencer.
+//
+// If an error is returned, some of the leaves may have been sequenced.
+func BatchSequence(ctx context.Context, st Storage, leaves []SequenceLeaf) ([]uint64, []bool, error) {
+	if bs, ok := st.(BatchSequencer); ok {
+		seqs, dupes, err := bs.BatchSequence(ctx, leaves)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(seqs) != len(leaves) || len(dupes) != len(leaves) {
+			return nil, nil, fmt.Errorf("BatchSequence returned %d sequence numbers and %d dupe indicators for %d leaves", len(seqs), len(dupes), len(leaves))
+		}
+		return seqs, dupes, nil
+	}
+
+	seqs := make([]uint64, len(leaves))
+	dupes := make([]bool, len(leaves))
+	for i, l := range leaves {
+		seq, err := st.Sequence(ctx, l.Hash, l.Data)
+		if errors.Is(err, ErrDupeLeaf) {
+			dupes[i] = true
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to sequence leaf %d: %w", i, err)
+		}
+		seqs[i] = seq
+	}
+	return seqs, dupes, nil
+}
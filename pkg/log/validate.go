@@ -0,0 +1,69 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// LeafValidator checks that a leaf is acceptable to the log before it is
+// sequenced, returning an error describing the problem if not.
+// Storage implementations which support validation reject leaves which fail
+// validation with ErrInvalidLeaf, and do not store them.
+type LeafValidator func(leaf []byte) error
+
+// ErrInvalidLeaf is returned by the Sequence method of storage implementations
+// when a leaf is rejected by the configured LeafValidator.
+type ErrInvalidLeaf struct {
+	// Err is the error returned by the validator.
+	Err error
+}
+
+func (e ErrInvalidLeaf) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrInvalidLeaf) Error() string {
+	return fmt.Sprintf("invalid leaf: %v", e.Err)
+}
+
+// Validate returns ErrInvalidLeaf if leaf fails validation by v.
+// A nil validator accepts all leaves.
+func (v LeafValidator) Validate(leaf []byte) error {
+	if v == nil {
+		return nil
+	}
+	if err := v(leaf); err != nil {
+		return ErrInvalidLeaf{Err: err}
+	}
+	return nil
+}
+
+// ValidateJSON is a LeafValidator which only accepts leaves which are valid
+// JSON documents.
+func ValidateJSON(leaf []byte) error {
+	if !json.Valid(leaf) {
+		return errors.New("leaf is not valid JSON")
+	}
+	return nil
+}
+
+// LeafValidators maps the names of the built-in leaf formats which can be
+// required of leaves to their validators.
+var LeafValidators = map[string]LeafValidator{
+	"json": ValidateJSON,
+}
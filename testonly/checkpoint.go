@@ -0,0 +1,37 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testonly
+
+import (
+	"fmt"
+
+	"github.com/transparency-dev/formats/log"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// SignCheckpoint returns a note holding cp, with its origin set to origin,
+// signed by each of signers in turn. The first signer is expected to be the
+// log's, and any others its witnesses, so that cosigned checkpoints can be
+// constructed for tests.
+//
+// SignCheckpoint panics if the checkpoint can't be signed.
+func SignCheckpoint(cp log.Checkpoint, origin string, signers ...note.Signer) []byte {
+	cp.Origin = origin
+	raw, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, signers...)
+	if err != nil {
+		panic(fmt.Sprintf("failed to sign checkpoint: %v", err))
+	}
+	return raw
+}
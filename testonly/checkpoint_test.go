@@ -0,0 +1,72 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testonly
+
+import (
+	"testing"
+
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/serverless-log/client"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestSignCheckpoint(t *testing.T) {
+	const origin = "example.com/signcheckpoint"
+	newKey := func(name string) (note.Signer, note.Verifier) {
+		t.Helper()
+		sKey, vKey, err := note.GenerateKey(nil, name)
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		s, err := note.NewSigner(sKey)
+		if err != nil {
+			t.Fatalf("NewSigner: %v", err)
+		}
+		v, err := note.NewVerifier(vKey)
+		if err != nil {
+			t.Fatalf("NewVerifier: %v", err)
+		}
+		return s, v
+	}
+	logS, logV := newKey("log")
+	wit1S, wit1V := newKey("w1")
+	wit2S, wit2V := newKey("w2")
+
+	raw := SignCheckpoint(log.Checkpoint{Origin: "ignored", Size: 42, Hash: []byte("banana")}, origin, logS, wit1S, wit2S)
+
+	cp, _, n, err := client.ParseCheckpoint(raw, origin, logV, wit1V, wit2V)
+	if err != nil {
+		t.Fatalf("ParseCheckpoint: %v", err)
+	}
+	if cp.Size != 42 || string(cp.Hash) != "banana" {
+		t.Errorf("got checkpoint %+v, want size 42 and hash banana", cp)
+	}
+	if got, want := len(n.Sigs), 3; got != want {
+		t.Errorf("got %d verified signatures, want %d", got, want)
+	}
+
+	// Checkpoints without a witness's cosignature only verify for that witness
+	// as an unverified signature.
+	raw = SignCheckpoint(log.Checkpoint{Size: 42, Hash: []byte("banana")}, origin, logS, wit1S)
+	if _, _, n, err = client.ParseCheckpoint(raw, origin, logV, wit2V); err != nil {
+		t.Fatalf("ParseCheckpoint: %v", err)
+	}
+	if got, want := len(n.Sigs), 1; got != want {
+		t.Errorf("got %d verified signatures, want %d", got, want)
+	}
+	if got, want := len(n.UnverifiedSigs), 1; got != want {
+		t.Errorf("got %d unverified signatures, want %d", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testonly
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/transparency-dev/merkle/compact"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/client"
+	"github.com/transparency-dev/serverless-log/pkg/log"
+
+	fmtlog "github.com/transparency-dev/formats/log"
+)
+
+// TestConsistencyProofsRandom builds logs of random sizes, and checks that
+// consistency proofs built from their tiles for random pairs of tree sizes
+// verify, and that tampered proofs do not.
+func TestConsistencyProofsRandom(t *testing.T) {
+	const (
+		numLogs  = 5
+		maxSize  = 2000
+		numPairs = 200
+	)
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+
+	seed := time.Now().UnixNano()
+	t.Logf("Using seed %d", seed)
+	rnd := rand.New(rand.NewSource(seed))
+
+	for i := 0; i < numLogs; i++ {
+		size := uint64(rnd.Int63n(maxSize) + 1)
+		t.Run(fmt.Sprintf("size %d", size), func(t *testing.T) {
+			ms := NewMemStorage()
+			// roots[n] holds the expected root hash of the tree of size n.
+			roots := make([][]byte, 0, size+1)
+			roots = append(roots, h.EmptyRoot())
+			cr := (&compact.RangeFactory{Hash: h.HashChildren}).NewEmptyRange(0)
+			for j := uint64(0); j < size; j++ {
+				leaf := []byte(fmt.Sprintf("leaf %d", j))
+				lh := h.HashLeaf(leaf)
+				if _, err := ms.Sequence(ctx, lh, leaf); err != nil {
+					t.Fatalf("Sequence: %v", err)
+				}
+				if err := cr.Append(lh, nil); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+				r, err := cr.GetRootHash(nil)
+				if err != nil {
+					t.Fatalf("GetRootHash: %v", err)
+				}
+				roots = append(roots, r)
+			}
+			cp, err := log.Integrate(ctx, 0, ms, h)
+			if err != nil {
+				t.Fatalf("Integrate: %v", err)
+			}
+			if !bytes.Equal(cp.Hash, roots[size]) {
+				t.Fatalf("Integrate produced root %x, want %x", cp.Hash, roots[size])
+			}
+
+			f := ms.Fetcher()
+			pb, err := client.NewProofBuilder(ctx, *cp, h.HashChildren, f)
+			if err != nil {
+				t.Fatalf("NewProofBuilder: %v", err)
+			}
+
+			for j := 0; j < numPairs; j++ {
+				a := uint64(rnd.Int63n(int64(size)) + 1)
+				b := a + uint64(rnd.Int63n(int64(size-a+1)))
+
+				p, err := pb.ConsistencyProof(ctx, a, b)
+				if err != nil {
+					t.Fatalf("ConsistencyProof(%d, %d): %v", a, b, err)
+				}
+				if err := proof.VerifyConsistency(h, a, b, p, roots[a], roots[b]); err != nil {
+					t.Fatalf("VerifyConsistency(%d, %d): %v", a, b, err)
+				}
+
+				cps := []fmtlog.Checkpoint{
+					{Size: a, Hash: roots[a]},
+					{Size: b, Hash: roots[b]},
+					*cp,
+				}
+				if err := client.CheckConsistency(ctx, h, f, cps); err != nil {
+					t.Fatalf("CheckConsistency(%d, %d, %d): %v", a, b, size, err)
+				}
+
+				// Now check that tampering is detected.
+				if len(p) > 0 {
+					k := rnd.Intn(len(p))
+					tampered := make([][]byte, len(p))
+					copy(tampered, p)
+					tampered[k] = append([]byte{}, p[k]...)
+					tampered[k][0] ^= 0x01
+					if err := proof.VerifyConsistency(h, a, b, tampered, roots[a], roots[b]); err == nil {
+						t.Fatalf("VerifyConsistency(%d, %d) succeeded with tampered proof element %d", a, b, k)
+					}
+				}
+				badRoot := append([]byte{}, roots[a]...)
+				badRoot[0] ^= 0x01
+				badCPs := []fmtlog.Checkpoint{
+					{Size: a, Hash: badRoot},
+					*cp,
+				}
+				if err := client.CheckConsistency(ctx, h, f, badCPs); err == nil {
+					t.Fatalf("CheckConsistency(%d, %d) succeeded with tampered root", a, size)
+				}
+			}
+		})
+	}
+}
@@ -16,11 +16,16 @@
 package testonly
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"time"
 
 	"k8s.io/klog/v2"
 
@@ -34,21 +39,205 @@ type MemStorage struct {
 	sync.Mutex
 	fs      map[string][]byte
 	nextSeq uint64
+
+	// readSkew is the number of reads via Fetcher for which a newly written
+	// tile or checkpoint remains invisible.
+	readSkew int
+	// stale holds the state of objects which have been written but which
+	// are not yet visible to readers.
+	stale map[string]*staleObject
+	// tileWidth is the width of the log's tiles.
+	tileWidth uint64
+	// sequenceLatency is how long each call to sequence entries takes.
+	sequenceLatency time.Duration
+	// leafBundleSize is the number of entries stored in each leaf bundle.
+	leafBundleSize uint64
+}
+
+// staleObject describes what readers will see for a recently written object.
+type staleObject struct {
+	// prev is the previous content of the object, or nil if it did not exist.
+	prev []byte
+	// reads is the number of remaining reads which will return prev.
+	reads int
+}
+
+var (
+	_ log.Storage        = &MemStorage{}
+	_ log.BatchSequencer = &MemStorage{}
+)
+
+// MemStorageOption configures optional behaviour of MemStorage.
+type MemStorageOption func(*MemStorage)
+
+// WithReadSkew causes newly written tiles and checkpoints to only become
+// visible via the storage's Fetcher after it has been asked for them n times.
+// Until then, reads will return the previous version of the object, or
+// os.ErrNotExist if it didn't previously exist.
+//
+// This allows tests to deterministically simulate the eventual consistency
+// which clients may observe when reading a log from object stores and CDNs.
+func WithReadSkew(n int) MemStorageOption {
+	return func(ms *MemStorage) {
+		ms.readSkew = n
+	}
+}
+
+// WithTileWidth configures the storage for a log whose tiles have the given
+// width, rather than layout.TileWidth. It panics unless the width is valid
+// according to layout.ValidateTileWidth.
+func WithTileWidth(width uint64) MemStorageOption {
+	if err := layout.ValidateTileWidth(width); err != nil {
+		panic(err)
+	}
+	return func(ms *MemStorage) {
+		ms.tileWidth = width
+	}
+}
+
+// WithSequenceLatency causes each call to Sequence or BatchSequence to take at
+// least d, simulating the round trips made by remote storage implementations.
+// This allows benchmarks to show the effect of making fewer calls, though not
+// the cost of the round trips which a real implementation's BatchSequence
+// still makes.
+func WithSequenceLatency(d time.Duration) MemStorageOption {
+	return func(ms *MemStorage) {
+		ms.sequenceLatency = d
+	}
+}
+
+// WithLeafBundleSize causes sequenced entries to be stored in leaf bundles of n
+// entries, rather than in an object each, with the layout expected by clients
+// using layout.BundlePath. NewMemStorage panics unless the size is valid for
+// the storage's tile width according to layout.ValidateLeafBundleSizeForWidth.
+//
+// Each entry sequenced into a bundle writes a new partial bundle holding it
+// and the entries before it, so that a partial bundle exists for every tree
+// size. Once full, the bundle is written without the partial suffix.
+func WithLeafBundleSize(n uint64) MemStorageOption {
+	return func(ms *MemStorage) {
+		ms.leafBundleSize = n
+	}
+}
+
+func init() {
+	log.RegisterStorage("mem", memOpener{})
+}
+
+var (
+	memLogsMu sync.Mutex
+	memLogs   = make(map[string]*MemStorage)
+)
+
+// RegisterMemStorage makes ms available to log.Open as mem://<name>.
+func RegisterMemStorage(name string, ms *MemStorage) {
+	memLogsMu.Lock()
+	defer memLogsMu.Unlock()
+	memLogs[name] = ms
+}
+
+// memOpener opens MemStorage registered with RegisterMemStorage for log.Open.
+type memOpener struct{}
+
+func (memOpener) get(u *url.URL) (*MemStorage, error) {
+	memLogsMu.Lock()
+	defer memLogsMu.Unlock()
+	ms, ok := memLogs[u.Host]
+	if !ok {
+		return nil, fmt.Errorf("no MemStorage registered as %q: %w", u.Host, os.ErrNotExist)
+	}
+	return ms, nil
+}
+
+func (o memOpener) ReadCheckpoint(ctx context.Context, u *url.URL) ([]byte, error) {
+	ms, err := o.get(u)
+	if err != nil {
+		return nil, err
+	}
+	return ms.ReadCheckpoint(ctx)
+}
+
+func (o memOpener) Open(_ context.Context, u *url.URL, _ uint64) (log.Storage, error) {
+	return o.get(u)
 }
 
-var _ log.Storage = &MemStorage{}
+func NewMemStorage(opts ...MemStorageOption) *MemStorage {
+	ms := &MemStorage{
+		fs:             make(map[string][]byte),
+		stale:          make(map[string]*staleObject),
+		tileWidth:      layout.TileWidth,
+		leafBundleSize: 1,
+	}
+	for _, opt := range opts {
+		opt(ms)
+	}
+	if err := layout.ValidateLeafBundleSizeForWidth(ms.leafBundleSize, ms.tileWidth); err != nil {
+		panic(err)
+	}
+	return ms
+}
 
-func NewMemStorage() *MemStorage {
-	return &MemStorage{
-		fs: make(map[string][]byte),
+// Snapshot returns a copy of every object held by the storage, keyed by path.
+// Objects are returned as written, regardless of any configured read skew.
+// This allows tests to assert the exact layout and content of a log.
+func (ms *MemStorage) Snapshot() map[string][]byte {
+	ms.Lock()
+	defer ms.Unlock()
+	snap := make(map[string][]byte, len(ms.fs))
+	for k, v := range ms.fs {
+		snap[k] = bytes.Clone(v)
+	}
+	return snap
+}
+
+// Load replaces the contents of the storage with a copy of objects, keyed by
+// path, e.g. as returned by Snapshot. This allows tests to preload fixtures.
+// Subsequent calls to Sequence assign the first sequence number which isn't
+// present in objects.
+func (ms *MemStorage) Load(objects map[string][]byte) {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.fs = make(map[string][]byte, len(objects))
+	for k, v := range objects {
+		ms.fs[k] = bytes.Clone(v)
+	}
+	ms.stale = make(map[string]*staleObject)
+	ms.nextSeq = 0
+	for {
+		if _, ok := ms.fs[ms.seqObject(ms.nextSeq)]; !ok {
+			break
+		}
+		ms.nextSeq++
 	}
 }
 
+// seqObject returns the path of the object which is created when the entry
+// with the given sequence number is sequenced.
+func (ms *MemStorage) seqObject(seq uint64) string {
+	return layout.BundlePath(seq, ms.leafBundleSize, seq+1)
+}
+
+// write stores data at path k, recording the previous state of the object if
+// read skew is configured.
+// Must be called with the lock held.
+func (ms *MemStorage) write(k string, data []byte) {
+	if ms.readSkew > 0 {
+		prev := ms.fs[k]
+		if so, ok := ms.stale[k]; ok {
+			// Readers haven't yet seen the last write, so they'll keep seeing
+			// whatever they saw before that.
+			prev = so.prev
+		}
+		ms.stale[k] = &staleObject{prev: prev, reads: ms.readSkew}
+	}
+	ms.fs[k] = data
+}
+
 // GetTile returns the tile at the given level & index.
 func (ms *MemStorage) GetTile(_ context.Context, level, index, logSize uint64) (*api.Tile, error) {
 	ms.Lock()
 	defer ms.Unlock()
-	tileSize := layout.PartialTileSize(level, index, logSize)
+	tileSize := layout.PartialTileSizeForWidth(level, index, logSize, ms.tileWidth)
 	d, k := layout.TilePath("", level, index, tileSize)
 	t, ok := ms.fs[filepath.Join(d, k)]
 	if !ok {
@@ -66,15 +255,18 @@ func (ms *MemStorage) StoreTile(_ context.Context, level, index uint64, tile *ap
 	ms.Lock()
 	defer ms.Unlock()
 
+	tileSize := uint64(tile.NumLeaves)
+	if tileSize == 0 || tileSize > ms.tileWidth {
+		return fmt.Errorf("tileSize %d must be > 0 and <= %d", tileSize, ms.tileWidth)
+	}
 	t, err := tile.MarshalText()
 	if err != nil {
 		return err
 	}
 
-	tileSize := uint64(tile.NumLeaves)
-	d, k := layout.TilePath("", level, index, tileSize%256)
+	d, k := layout.TilePath("", level, index, tileSize%ms.tileWidth)
 	klog.Infof("Store tile %s", filepath.Join(d, k))
-	ms.fs[filepath.Join(d, k)] = t
+	ms.write(filepath.Join(d, k), t)
 	return nil
 }
 
@@ -82,46 +274,170 @@ func (ms *MemStorage) StoreTile(_ context.Context, level, index uint64, tile *ap
 func (ms *MemStorage) WriteCheckpoint(_ context.Context, newCPRaw []byte) error {
 	ms.Lock()
 	defer ms.Unlock()
-	k := layout.CheckpointPath
-	ms.fs[k] = newCPRaw
+	ms.write(layout.CheckpointPath, newCPRaw)
 	return nil
 }
 
+// ReadCheckpoint returns the latest log checkpoint written to the storage.
+func (ms *MemStorage) ReadCheckpoint(_ context.Context) ([]byte, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	cp, ok := ms.fs[layout.CheckpointPath]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return cp, nil
+}
+
 // Sequence assigns sequence numbers to the passed in entry.
 // Returns the assigned sequence number for the leafhash.
 //
-// If a duplicate leaf is sequenced the storage implementation may return
-// the sequence number associated with an earlier instance, along with a
-// ErrDupeLeaf error.
+// If the leaf has already been sequenced, the sequence number of the earlier
+// instance is returned, along with an ErrDupeLeaf error.
 func (ms *MemStorage) Sequence(_ context.Context, leafhash []byte, leaf []byte) (uint64, error) {
+	time.Sleep(ms.sequenceLatency)
 	ms.Lock()
 	defer ms.Unlock()
 
+	if seq, ok, err := ms.sequencedLocked(leafhash); err != nil {
+		return 0, err
+	} else if ok {
+		return seq, log.ErrDupeLeaf
+	}
+	return ms.sequenceLocked(leafhash, leaf), nil
+}
+
+// BatchSequence assigns contiguous sequence numbers to the passed in leaves.
+// Returns the sequence number assigned to each leaf.
+//
+// As with Sequence, a leaf which has already been sequenced, or which
+// duplicates one earlier in the batch, is given the sequence number of the
+// earlier instance, and true in dupes.
+func (ms *MemStorage) BatchSequence(_ context.Context, leaves []log.SequenceLeaf) ([]uint64, []bool, error) {
+	time.Sleep(ms.sequenceLatency)
+	ms.Lock()
+	defer ms.Unlock()
+
+	seqs := make([]uint64, len(leaves))
+	dupes := make([]bool, len(leaves))
+	for i, l := range leaves {
+		seq, ok, err := ms.sequencedLocked(l.Hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("leaf %d: %v", i, err)
+		}
+		if ok {
+			seqs[i], dupes[i] = seq, true
+			continue
+		}
+		seqs[i] = ms.sequenceLocked(l.Hash, l.Data)
+	}
+	return seqs, dupes, nil
+}
+
+// sequencedLocked returns the sequence number of the leaf with the given hash,
+// and true, if it has already been sequenced.
+// The caller must hold the lock.
+func (ms *MemStorage) sequencedLocked(leafhash []byte) (uint64, bool, error) {
+	dl, kl := layout.LeafPath("", leafhash)
+	s, ok := ms.fs[filepath.Join(dl, kl)]
+	if !ok {
+		return 0, false, nil
+	}
+	seq, err := strconv.ParseUint(string(s), 16, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid sequence number %q: %v", s, err)
+	}
+	return seq, true, nil
+}
+
+// sequenceLocked assigns the next sequence number to leaf, and returns it.
+// The caller must hold the lock.
+func (ms *MemStorage) sequenceLocked(leafhash []byte, leaf []byte) uint64 {
 	seq := ms.nextSeq
 	ms.nextSeq++
 
-	ds, ks := layout.SeqPath("", seq)
-	ms.fs[filepath.Join(ds, ks)] = leaf
+	if ms.leafBundleSize == 1 {
+		ms.fs[ms.seqObject(seq)] = leaf
+	} else {
+		// Add the leaf to the entries already in its bundle, if any.
+		var b []byte
+		if seq%ms.leafBundleSize > 0 {
+			b = bytes.Clone(ms.fs[ms.seqObject(seq-1)])
+		}
+		b = append(b, base64.StdEncoding.EncodeToString(leaf)...)
+		ms.fs[ms.seqObject(seq)] = append(b, '\n')
+	}
 	dl, kl := layout.LeafPath("", leafhash)
 	ms.fs[filepath.Join(dl, kl)] = []byte(strconv.FormatUint(seq, 16))
-	return seq, nil
-
+	return seq
 }
 
 // ScanSequenced calls f for each contiguous sequenced log entry >= begin.
 // It should stop scanning if the call to f returns an error.
+// Returns the number of entries scanned.
+//
+// Sequence may safely be called concurrently with a scan, in which case the
+// scan may or may not see the newly sequenced entries.
 func (ms *MemStorage) ScanSequenced(_ context.Context, begin uint64, f func(seq uint64, entry []byte) error) (uint64, error) {
-	// No lock since we're only looking at immutable data
-	for i := begin; ; i++ {
-		ds, ks := layout.SeqPath("", i)
-		e, ok := ms.fs[filepath.Join(ds, ks)]
+	bs := ms.leafBundleSize
+	for i := begin; ; {
+		// Sequenced entries are immutable, but the map holding them isn't, so
+		// the lock is needed for the lookup. It mustn't be held while calling
+		// f, which may itself use the storage.
+		ms.Lock()
+		entries, err := ms.sequencedBundle(i)
+		ms.Unlock()
+		if err != nil {
+			return i - begin, err
+		}
+		start := i - i%bs
+		for ; i < start+uint64(len(entries)); i++ {
+			if err := f(i, entries[i-start]); err != nil {
+				return i - begin, err
+			}
+		}
+		if uint64(len(entries)) < bs {
+			return i - begin, nil
+		}
+	}
+}
+
+// sequencedBundle returns the entries which have been sequenced into the leaf
+// bundle holding seq so far, starting with the first entry in the bundle.
+// If seq hasn't been sequenced, it returns fewer entries than are needed to
+// reach it.
+// The caller must hold the lock.
+func (ms *MemStorage) sequencedBundle(seq uint64) ([][]byte, error) {
+	bs := ms.leafBundleSize
+	if bs == 1 {
+		e, ok := ms.fs[ms.seqObject(seq)]
 		if !ok {
-			return i, nil
+			return nil, nil
+		}
+		return [][]byte{e}, nil
+	}
+	// Find the most complete version of the bundle.
+	start := seq - seq%bs
+	var raw []byte
+	for n := start + bs; n > seq; n-- {
+		if b, ok := ms.fs[ms.seqObject(n-1)]; ok {
+			raw = b
+			break
 		}
-		if err := f(i, e); err != nil {
-			return i, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	lines := bytes.Split(bytes.TrimSuffix(raw, []byte("\n")), []byte("\n"))
+	entries := make([][]byte, 0, len(lines))
+	for i, l := range lines {
+		e, err := base64.StdEncoding.DecodeString(string(l))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode entry %d of leaf bundle %d: %v", i, seq/bs, err)
 		}
+		entries = append(entries, e)
 	}
+	return entries, nil
 }
 
 func (ms *MemStorage) Fetcher() client.Fetcher {
@@ -129,6 +445,16 @@ func (ms *MemStorage) Fetcher() client.Fetcher {
 		ms.Lock()
 		defer ms.Unlock()
 		klog.Infof("Fetch %s", path)
+		if so, ok := ms.stale[path]; ok {
+			so.reads--
+			if so.reads <= 0 {
+				delete(ms.stale, path)
+			}
+			if so.prev == nil {
+				return nil, os.ErrNotExist
+			}
+			return so.prev, nil
+		}
 		r, ok := ms.fs[path]
 		if !ok {
 			return nil, os.ErrNotExist
@@ -15,11 +15,33 @@
 package testonly
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/transparency-dev/merkle/proof"
 	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/client"
 	"github.com/transparency-dev/serverless-log/integration"
+	"github.com/transparency-dev/serverless-log/pkg/log"
+	"golang.org/x/mod/sumdb/note"
+
+	fmtlog "github.com/transparency-dev/formats/log"
+)
+
+const (
+	testPrivKey = "PRIVATE+KEY+astra+cad5a3d2+ASgwwenlc0uuYcdy7kI44pQvuz1fw8cS5NqS8RkZBXoy"
+	testPubKey  = "astra+cad5a3d2+AZJqeuyE/GnknsCNh1eCtDtwdAwKBddOlS8M2eI1Jt4b"
+	testOrigin  = "Serverless Integration Test Log"
 )
 
 func TestMemStorage(t *testing.T) {
@@ -28,3 +50,459 @@ func TestMemStorage(t *testing.T) {
 
 	integration.RunIntegration(t, ms, ms.Fetcher(), rfc6962.DefaultHasher)
 }
+
+func TestMemStorageDupes(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	ms := NewMemStorage()
+	leaf := func(s string) log.SequenceLeaf {
+		return log.SequenceLeaf{Hash: h.HashLeaf([]byte(s)), Data: []byte(s)}
+	}
+
+	a := leaf("a")
+	if seq, err := ms.Sequence(ctx, a.Hash, a.Data); err != nil || seq != 0 {
+		t.Fatalf("Sequence: got (%d, %v), want (0, nil)", seq, err)
+	}
+	// Duplicates are detected in the same way by Sequence and BatchSequence.
+	if seq, err := ms.Sequence(ctx, a.Hash, a.Data); !errors.Is(err, log.ErrDupeLeaf) || seq != 0 {
+		t.Errorf("Sequence of dupe: got (%d, %v), want (0, ErrDupeLeaf)", seq, err)
+	}
+	seqs, dupes, err := ms.BatchSequence(ctx, []log.SequenceLeaf{leaf("b"), a, leaf("b")})
+	if err != nil {
+		t.Fatalf("BatchSequence: %v", err)
+	}
+	if diff := cmp.Diff([]uint64{1, 0, 1}, seqs); diff != "" {
+		t.Errorf("BatchSequence numbers diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]bool{false, true, true}, dupes); diff != "" {
+		t.Errorf("BatchSequence dupes diff (-want +got):\n%s", diff)
+	}
+	b := leaf("b")
+	if seq, err := ms.Sequence(ctx, b.Hash, b.Data); !errors.Is(err, log.ErrDupeLeaf) || seq != 1 {
+		t.Errorf("Sequence of leaf from batch: got (%d, %v), want (1, ErrDupeLeaf)", seq, err)
+	}
+}
+
+func TestMemStorageSnapshot(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	ms := NewMemStorage()
+	for i := 0; i < 3; i++ {
+		leaf := []byte(fmt.Sprintf("leaf %d", i))
+		if _, err := ms.Sequence(ctx, h.HashLeaf(leaf), leaf); err != nil {
+			t.Fatalf("Sequence: %v", err)
+		}
+	}
+	cp, err := log.Integrate(ctx, 0, ms, h)
+	if err != nil {
+		t.Fatalf("Integrate: %v", err)
+	}
+	if err := ms.WriteCheckpoint(ctx, cp.Marshal()); err != nil {
+		t.Fatalf("WriteCheckpoint: %v", err)
+	}
+
+	snap := ms.Snapshot()
+	var got []string
+	for k := range snap {
+		got = append(got, k)
+	}
+	sort.Strings(got)
+	// This is the layout of a log with 3 entries, and should only change if
+	// the layout of logs is deliberately changed.
+	want := []string{
+		"checkpoint",
+		"leaves/1b/b9/7d/cc21635d47e2663efdfd0a174686d98dd701352dd2cd06e8b43fd3d305",
+		"leaves/ab/37/ba/34d1dfe29015de717a6d5764a8fb029c3a7a0f5b64b93b54351885bf7c",
+		"leaves/cb/5a/3c/e862c3e321f3f7df6d2690549e936a8e377135aae9f3d69f691f547d5b",
+		"seq/00/00/00/00/00",
+		"seq/00/00/00/00/01",
+		"seq/00/00/00/00/02",
+		"tile/00/0000/00/00/00.03",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Snapshot paths diff (-want +got):\n%s", diff)
+	}
+	if got, want := string(snap["seq/00/00/00/00/01"]), "leaf 1"; got != want {
+		t.Errorf("Got leaf %q, want %q", got, want)
+	}
+
+	// Modifying the snapshot must not modify the storage.
+	snap["checkpoint"][0] ^= 0xff
+	if got := ms.Snapshot()["checkpoint"]; !bytes.Equal(got, cp.Marshal()) {
+		t.Errorf("Modifying snapshot changed the stored checkpoint to %q", got)
+	}
+
+	// Storage loaded from the snapshot should carry on where it left off.
+	loaded := NewMemStorage()
+	loaded.Load(ms.Snapshot())
+	if diff := cmp.Diff(ms.Snapshot(), loaded.Snapshot()); diff != "" {
+		t.Errorf("Loaded storage diff (-want +got):\n%s", diff)
+	}
+	leaf := []byte("leaf 3")
+	if seq, err := loaded.Sequence(ctx, h.HashLeaf(leaf), leaf); err != nil || seq != 3 {
+		t.Errorf("Sequence after Load: got (%d, %v), want (3, nil)", seq, err)
+	}
+}
+
+func TestMemStorageTileWidth(t *testing.T) {
+	ctx := context.Background()
+	const width = 512
+	ms := NewMemStorage(WithTileWidth(width))
+
+	for _, numLeaves := range []uint64{300, width} {
+		tile := &api.Tile{NumLeaves: uint(numLeaves), Nodes: [][]byte{[]byte("node")}}
+		if err := ms.StoreTile(ctx, 0, 1, tile); err != nil {
+			t.Fatalf("StoreTile(%d leaves): %v", numLeaves, err)
+		}
+		got, err := ms.GetTile(ctx, 0, 1, width+numLeaves)
+		if err != nil {
+			t.Fatalf("GetTile(%d leaves): %v", numLeaves, err)
+		}
+		if diff := cmp.Diff(tile, got); diff != "" {
+			t.Errorf("GetTile(%d leaves) diff (-want +got):\n%s", numLeaves, diff)
+		}
+	}
+	snap := ms.Snapshot()
+	for _, p := range []string{"tile/00/0000/00/00/01.12c", "tile/00/0000/00/00/01"} {
+		if _, ok := snap[p]; !ok {
+			t.Errorf("Missing tile %q", p)
+		}
+	}
+	if err := ms.StoreTile(ctx, 0, 2, &api.Tile{NumLeaves: width + 1}); err == nil {
+		t.Error("StoreTile with more leaves than the tile width succeeded")
+	}
+	for _, bad := range []uint64{0, 1, 300} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("WithTileWidth(%d) didn't panic", bad)
+				}
+			}()
+			WithTileWidth(bad)
+		}()
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("NewMemStorage with a leaf bundle size of 768 and tile width %d didn't panic", width)
+			}
+		}()
+		NewMemStorage(WithTileWidth(width), WithLeafBundleSize(768))
+	}()
+
+	// A log built with wide tiles must have the same tree as one built with
+	// the default tile width, and be able to serve proofs.
+	h := rfc6962.DefaultHasher
+	wide, narrow := NewMemStorage(WithTileWidth(width)), NewMemStorage()
+	const numLeaves = 1000
+	for i := 0; i < numLeaves; i++ {
+		leaf := []byte(fmt.Sprintf("leaf %d", i))
+		for _, st := range []*MemStorage{wide, narrow} {
+			if _, err := st.Sequence(ctx, h.HashLeaf(leaf), leaf); err != nil {
+				t.Fatalf("Sequence: %v", err)
+			}
+		}
+	}
+	cp, err := log.Integrate(ctx, 0, wide, h, log.WithTileWidth(width))
+	if err != nil {
+		t.Fatalf("Integrate(width %d): %v", width, err)
+	}
+	narrowCP, err := log.Integrate(ctx, 0, narrow, h)
+	if err != nil {
+		t.Fatalf("Integrate: %v", err)
+	}
+	if !bytes.Equal(cp.Hash, narrowCP.Hash) {
+		t.Fatalf("Got root %x with width %d, want %x", cp.Hash, width, narrowCP.Hash)
+	}
+
+	pb, err := client.NewProofBuilder(ctx, *cp, h.HashChildren, wide.Fetcher(), client.WithTileWidth(width))
+	if err != nil {
+		t.Fatalf("NewProofBuilder: %v", err)
+	}
+	for _, i := range []uint64{0, 511, 512, numLeaves - 1} {
+		p, err := pb.InclusionProof(ctx, i)
+		if err != nil {
+			t.Fatalf("InclusionProof(%d): %v", i, err)
+		}
+		leaf := []byte(fmt.Sprintf("leaf %d", i))
+		if err := proof.VerifyInclusion(h, i, cp.Size, h.HashLeaf(leaf), p, cp.Hash); err != nil {
+			t.Errorf("VerifyInclusion(%d): %v", i, err)
+		}
+	}
+
+	// A LogStateTracker given the width must be able to follow the log as it
+	// grows.
+	s, err := note.NewSigner(testPrivKey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	v, err := note.NewVerifier(testPubKey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	sign := func(cp *fmtlog.Checkpoint) []byte {
+		t.Helper()
+		cp.Origin = testOrigin
+		raw, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, s)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return raw
+	}
+	lst, err := client.NewLogStateTracker(ctx, wide.Fetcher(), h, sign(cp), v, testOrigin, nil, client.WithTileWidth(width))
+	if err != nil {
+		t.Fatalf("NewLogStateTracker: %v", err)
+	}
+	for i := numLeaves; i < 2*numLeaves; i++ {
+		leaf := []byte(fmt.Sprintf("leaf %d", i))
+		if _, err := wide.Sequence(ctx, h.HashLeaf(leaf), leaf); err != nil {
+			t.Fatalf("Sequence: %v", err)
+		}
+	}
+	grown, err := log.Integrate(ctx, cp.Size, wide, h, log.WithTileWidth(width))
+	if err != nil {
+		t.Fatalf("Integrate(width %d): %v", width, err)
+	}
+	if err := wide.WriteCheckpoint(ctx, sign(grown)); err != nil {
+		t.Fatalf("WriteCheckpoint: %v", err)
+	}
+	if _, _, _, err := lst.Update(ctx); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got := lst.LatestConsistent.Size; got != 2*numLeaves {
+		t.Errorf("Tracker at size %d after Update, want %d", got, 2*numLeaves)
+	}
+}
+
+func TestMemStorageLeafBundles(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	const numLeaves = 10
+	var leaves [][]byte
+	for i := 0; i < numLeaves; i++ {
+		leaves = append(leaves, []byte(fmt.Sprintf("leaf %d", i)))
+	}
+	for _, test := range []struct {
+		bundleSize uint64
+		wantPaths  []string
+	}{
+		{
+			// Legacy layout, with each entry stored in its own object.
+			bundleSize: 1,
+			wantPaths:  []string{"seq/00/00/00/00/00", "seq/00/00/00/00/09"},
+		}, {
+			bundleSize: 4,
+			wantPaths: []string{
+				"seq/00/00/00/00/00.1", "seq/00/00/00/00/00.3", "seq/00/00/00/00/00",
+				"seq/00/00/00/00/01", "seq/00/00/00/00/02.1", "seq/00/00/00/00/02.2",
+			},
+		}, {
+			bundleSize: layout.TileWidth,
+			wantPaths:  []string{"seq/00/00/00/00/00.1", "seq/00/00/00/00/00.10"},
+		},
+	} {
+		t.Run(fmt.Sprintf("%d", test.bundleSize), func(t *testing.T) {
+			ms := NewMemStorage(WithLeafBundleSize(test.bundleSize))
+			for _, l := range leaves {
+				if _, err := ms.Sequence(ctx, h.HashLeaf(l), l); err != nil {
+					t.Fatalf("Sequence: %v", err)
+				}
+			}
+			snap := ms.Snapshot()
+			for _, p := range test.wantPaths {
+				if _, ok := snap[p]; !ok {
+					t.Errorf("Missing object %q", p)
+				}
+			}
+			if _, ok := snap[layout.BundlePath(numLeaves, test.bundleSize, numLeaves+1)]; ok {
+				t.Error("Found object for unsequenced entry")
+			}
+
+			for _, begin := range []uint64{0, 3, 5, numLeaves} {
+				var got [][]byte
+				n, err := ms.ScanSequenced(ctx, begin, func(seq uint64, entry []byte) error {
+					if want := begin + uint64(len(got)); seq != want {
+						return fmt.Errorf("got seq %d, want %d", seq, want)
+					}
+					got = append(got, entry)
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("ScanSequenced(%d): %v", begin, err)
+				}
+				if n != numLeaves-begin {
+					t.Errorf("ScanSequenced(%d) = %d, want %d", begin, n, numLeaves-begin)
+				}
+				if diff := cmp.Diff(leaves[begin:], got, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("ScanSequenced(%d) entries diff (-want +got):\n%s", begin, diff)
+				}
+			}
+
+			// Clients which understand bundles must be able to read the log.
+			cp, err := log.Integrate(ctx, 0, ms, h)
+			if err != nil {
+				t.Fatalf("Integrate: %v", err)
+			}
+			var got [][]byte
+			if err := client.DownloadAllLeaves(ctx, ms.Fetcher(), cp.Size, func(_ uint64, leaf []byte) error {
+				got = append(got, leaf)
+				return nil
+			}, client.WithDownloadBundleSize(test.bundleSize)); err != nil {
+				t.Fatalf("DownloadAllLeaves: %v", err)
+			}
+			if diff := cmp.Diff(leaves, got); diff != "" {
+				t.Errorf("DownloadAllLeaves diff (-want +got):\n%s", diff)
+			}
+
+			// Storage loaded from a snapshot should carry on where it left off.
+			loaded := NewMemStorage(WithLeafBundleSize(test.bundleSize))
+			loaded.Load(ms.Snapshot())
+			leaf := []byte("another leaf")
+			if seq, err := loaded.Sequence(ctx, h.HashLeaf(leaf), leaf); err != nil || seq != numLeaves {
+				t.Errorf("Sequence after Load: got (%d, %v), want (%d, nil)", seq, err, numLeaves)
+			}
+		})
+	}
+}
+
+// BenchmarkSequence compares sequencing batches of leaves one at a time with
+// sequencing them using BatchSequence. With no latency, this measures the
+// overhead of each in MemStorage itself. With latency, the results are
+// dominated by the simulated round trip made by each call, so they show the
+// most that batching can save, rather than what it saves for any real
+// storage implementation.
+func BenchmarkSequence(b *testing.B) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	const batchSize = 100
+	leaves := func(i int) []log.SequenceLeaf {
+		ls := make([]log.SequenceLeaf, batchSize)
+		for j := range ls {
+			data := []byte(fmt.Sprintf("leaf %d-%d", i, j))
+			ls[j] = log.SequenceLeaf{Hash: h.HashLeaf(data), Data: data}
+		}
+		return ls
+	}
+
+	for _, latency := range []time.Duration{0, 100 * time.Microsecond} {
+		b.Run(fmt.Sprintf("Sequence/latency=%v", latency), func(b *testing.B) {
+			ms := NewMemStorage(WithSequenceLatency(latency))
+			for i := 0; i < b.N; i++ {
+				for _, l := range leaves(i) {
+					if _, err := ms.Sequence(ctx, l.Hash, l.Data); err != nil {
+						b.Fatalf("Sequence: %v", err)
+					}
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("BatchSequence/latency=%v", latency), func(b *testing.B) {
+			ms := NewMemStorage(WithSequenceLatency(latency))
+			for i := 0; i < b.N; i++ {
+				if _, _, err := ms.BatchSequence(ctx, leaves(i)); err != nil {
+					b.Fatalf("BatchSequence: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemStorageReadSkew(t *testing.T) {
+	ctx := context.Background()
+	const skew = 2
+	ms := NewMemStorage(WithReadSkew(skew))
+	f := ms.Fetcher()
+
+	if err := ms.WriteCheckpoint(ctx, []byte("one")); err != nil {
+		t.Fatalf("WriteCheckpoint: %v", err)
+	}
+	for i := 0; i < skew; i++ {
+		if _, err := f(ctx, layout.CheckpointPath); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Read %d of new checkpoint got %v, want ErrNotExist", i, err)
+		}
+	}
+	if got, err := f(ctx, layout.CheckpointPath); err != nil || string(got) != "one" {
+		t.Fatalf("Got (%q, %v), want (\"one\", nil)", got, err)
+	}
+
+	if err := ms.WriteCheckpoint(ctx, []byte("two")); err != nil {
+		t.Fatalf("WriteCheckpoint: %v", err)
+	}
+	for i := 0; i < skew; i++ {
+		if got, err := f(ctx, layout.CheckpointPath); err != nil || string(got) != "one" {
+			t.Fatalf("Read %d got (%q, %v), want stale (\"one\", nil)", i, got, err)
+		}
+	}
+	if got, err := f(ctx, layout.CheckpointPath); err != nil || string(got) != "two" {
+		t.Fatalf("Got (%q, %v), want (\"two\", nil)", got, err)
+	}
+}
+
+func TestLogStateTrackerWithReadSkew(t *testing.T) {
+	ctx := context.Background()
+	h := rfc6962.DefaultHasher
+	ms := NewMemStorage(WithReadSkew(3))
+	f := ms.Fetcher()
+
+	s, err := note.NewSigner(testPrivKey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	v, err := note.NewVerifier(testPubKey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	signAndWrite := func(size uint64, hash []byte) []byte {
+		t.Helper()
+		cp := fmtlog.Checkpoint{Origin: testOrigin, Size: size, Hash: hash}
+		raw, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, s)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		if err := ms.WriteCheckpoint(ctx, raw); err != nil {
+			t.Fatalf("WriteCheckpoint: %v", err)
+		}
+		return raw
+	}
+	initial := signAndWrite(0, h.EmptyRoot())
+
+	lst, err := client.NewLogStateTracker(ctx, f, h, initial, v, testOrigin, nil)
+	if err != nil {
+		t.Fatalf("NewLogStateTracker: %v", err)
+	}
+
+	size := uint64(0)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 300; i++ {
+			leaf := []byte(fmt.Sprintf("round %d leaf %d", round, i))
+			if _, err := ms.Sequence(ctx, h.HashLeaf(leaf), leaf); err != nil {
+				t.Fatalf("Sequence: %v", err)
+			}
+		}
+		cp, err := log.Integrate(ctx, size, ms, h)
+		if err != nil {
+			t.Fatalf("Integrate: %v", err)
+		}
+		want := signAndWrite(cp.Size, cp.Hash)
+		size = cp.Size
+
+		// The tracker should eventually see the new checkpoint, and any errors
+		// caused by skew must not be reported as inconsistencies.
+		const maxAttempts = 20
+		for attempt := 0; ; attempt++ {
+			if attempt == maxAttempts {
+				t.Fatalf("Tracker didn't see checkpoint of size %d after %d attempts", size, maxAttempts)
+			}
+			_, _, _, err := lst.Update(ctx)
+			if err != nil {
+				if errors.As(err, &client.ErrInconsistency{}) {
+					t.Fatalf("Update reported inconsistency: %v", err)
+				}
+				t.Logf("Update: %v (retrying)", err)
+				continue
+			}
+			if bytes.Equal(lst.LatestConsistentRaw, want) {
+				break
+			}
+		}
+	}
+}